@@ -0,0 +1,82 @@
+// Package chain settles transfers on blockchains and finds deposits on them. Each network
+// is reached through a ChainAdapter; EVMAdapter talks to an Ethereum-compatible node and
+// SimulatedChain keeps an in-memory chain for development.
+package chain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+)
+
+// ErrUnknownTransaction is returned when a signed transaction can't be decoded by the adapter
+var ErrUnknownTransaction = errors.New("unknown transaction")
+
+// Transfer is an outgoing payment to build on a chain
+type Transfer struct {
+	To string
+	// Token is the token contract address, or empty for the chain's native coin
+	Token string
+	// Amount is in the asset's base units
+	Amount *big.Int
+}
+
+// UnsignedTx is an account-based transaction ready to be signed
+type UnsignedTx struct {
+	From     string
+	To       string
+	Value    *big.Int
+	Data     []byte
+	Nonce    uint64
+	GasPrice *big.Int
+	GasLimit uint64
+}
+
+// SignedTx is a signed transaction and its hash
+type SignedTx struct {
+	Hash string
+	Raw  []byte
+}
+
+// TxStatus is what a chain currently knows about a transaction
+type TxStatus struct {
+	// Found is false when the node has neither mined nor queued the transaction
+	Found bool
+	// Failed is set when the transaction was mined but reverted
+	Failed bool
+	// Confirmations counts the block including the transaction and every block after it
+	Confirmations int64
+}
+
+// ChainAdapter builds, signs, broadcasts and tracks transactions on one network
+type ChainAdapter interface {
+	// Network returns the network name, matching the asset registry
+	Network() string
+	BuildTransfer(ctx context.Context, transfer Transfer) (*UnsignedTx, error)
+	Sign(ctx context.Context, tx *UnsignedTx) (*SignedTx, error)
+	// Broadcast submits a signed transaction. Resubmitting a known transaction is not an error.
+	Broadcast(ctx context.Context, tx *SignedTx) error
+	TxStatus(ctx context.Context, hash string) (TxStatus, error)
+}
+
+// IncomingTransfer is a payment found on-chain. Index tells apart several payments made by
+// one transaction, such as token transfer logs.
+type IncomingTransfer struct {
+	TxHash string
+	Index  int
+	From   string
+	To     string
+	// Token is the token contract address, or empty for the chain's native coin
+	Token  string
+	Amount *big.Int
+	Block  int64
+}
+
+// DepositScanner is implemented by adapters that can find payments to a set of addresses
+type DepositScanner interface {
+	// Head returns the height of the latest block
+	Head(ctx context.Context) (int64, error)
+	// IncomingTransfers returns payments to any of addresses in blocks fromBlock through
+	// toBlock, in the native coin or any of tokens
+	IncomingTransfers(ctx context.Context, fromBlock int64, toBlock int64, addresses []string, tokens []string) ([]IncomingTransfer, error)
+}
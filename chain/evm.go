@@ -0,0 +1,340 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+
+	"crypto-sms/utils"
+)
+
+// erc20TransferSelector is the first four bytes of keccak256("transfer(address,uint256)")
+var erc20TransferSelector = []byte{0xa9, 0x05, 0x9c, 0xbb}
+
+// erc20TransferTopic is keccak256("Transfer(address,address,uint256)"), the event every
+// ERC-20 token logs when it moves
+const erc20TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// EVMAdapter settles transfers on an Ethereum-compatible chain through a node's JSON-RPC
+// API, signing legacy EIP-155 transactions with a single hot wallet key
+type EVMAdapter struct {
+	network string
+	rpcURL  string
+	chainID *big.Int
+	key     *secp256k1.PrivateKey
+	from    string
+	client  *http.Client
+}
+
+// NewEVMAdapter creates an adapter for network using the node at rpcURL and the hex-encoded
+// hot wallet private key
+func NewEVMAdapter(network string, rpcURL string, chainID int64, privateKeyHex string) (*EVMAdapter, error) {
+	keyBytes, err := hex.DecodeString(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil || len(keyBytes) != 32 {
+		return nil, errors.New("hot wallet key must be 32 hex-encoded bytes")
+	}
+	key := secp256k1.PrivKeyFromBytes(keyBytes)
+	return &EVMAdapter{
+		network: network,
+		rpcURL:  rpcURL,
+		chainID: big.NewInt(chainID),
+		key:     key,
+		from:    EVMAddress(key.PubKey()),
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// EVMAddress returns the checksummed address of a public key
+func EVMAddress(key *secp256k1.PublicKey) string {
+	return utils.ChecksumEVMAddress("0x" + hex.EncodeToString(keccak256(key.SerializeUncompressed()[1:])[12:]))
+}
+
+func (a *EVMAdapter) Network() string {
+	return a.network
+}
+
+// HotWallet returns the address withdrawals are paid from
+func (a *EVMAdapter) HotWallet() string {
+	return a.from
+}
+
+func (a *EVMAdapter) BuildTransfer(ctx context.Context, transfer Transfer) (*UnsignedTx, error) {
+	if err := utils.ValidateEVMAddress(transfer.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	tx := &UnsignedTx{From: a.from, To: transfer.To, Value: transfer.Amount}
+	if transfer.Token != "" {
+		to, _ := hex.DecodeString(transfer.To[2:])
+		tx.To, tx.Value = transfer.Token, new(big.Int)
+		tx.Data = append(append(append([]byte{}, erc20TransferSelector...), leftPad32(to)...), leftPad32(transfer.Amount.Bytes())...)
+	}
+
+	var nonce, gasPrice, gas hexBig
+	if err := a.call(ctx, "eth_getTransactionCount", []interface{}{a.from, "pending"}, &nonce); err != nil {
+		return nil, err
+	}
+	if err := a.call(ctx, "eth_gasPrice", nil, &gasPrice); err != nil {
+		return nil, err
+	}
+	estimate := map[string]string{
+		"from":  a.from,
+		"to":    tx.To,
+		"value": "0x" + tx.Value.Text(16),
+		"data":  "0x" + hex.EncodeToString(tx.Data),
+	}
+	if err := a.call(ctx, "eth_estimateGas", []interface{}{estimate}, &gas); err != nil {
+		return nil, err
+	}
+
+	tx.Nonce = nonce.Int().Uint64()
+	tx.GasPrice = gasPrice.Int()
+	// Leave headroom over the estimate; unused gas isn't charged
+	tx.GasLimit = gas.Int().Uint64() * 6 / 5
+	return tx, nil
+}
+
+func (a *EVMAdapter) Sign(ctx context.Context, tx *UnsignedTx) (*SignedTx, error) {
+	to, err := hex.DecodeString(strings.TrimPrefix(tx.To, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination: %w", err)
+	}
+	fields := [][]byte{
+		rlpUint(tx.Nonce),
+		rlpBig(tx.GasPrice),
+		rlpUint(tx.GasLimit),
+		rlpBytes(to),
+		rlpBig(tx.Value),
+		rlpBytes(tx.Data),
+	}
+
+	// EIP-155 signs over the chain ID so the transaction can't be replayed on other chains
+	hash := keccak256(rlpList(append(fields, rlpBig(a.chainID), rlpUint(0), rlpUint(0))...))
+	signature := ecdsa.SignCompact(a.key, hash, false)
+	recovery := int64(signature[0] - 27)
+	v := new(big.Int).Add(new(big.Int).Mul(a.chainID, big.NewInt(2)), big.NewInt(35+recovery))
+
+	raw := rlpList(append(fields, rlpBig(v), rlpBig(new(big.Int).SetBytes(signature[1:33])), rlpBig(new(big.Int).SetBytes(signature[33:65])))...)
+	return &SignedTx{Hash: "0x" + hex.EncodeToString(keccak256(raw)), Raw: raw}, nil
+}
+
+func (a *EVMAdapter) Broadcast(ctx context.Context, tx *SignedTx) error {
+	var hash string
+	err := a.call(ctx, "eth_sendRawTransaction", []interface{}{"0x" + hex.EncodeToString(tx.Raw)}, &hash)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "already known") {
+		return nil
+	}
+	return err
+}
+
+func (a *EVMAdapter) TxStatus(ctx context.Context, hash string) (TxStatus, error) {
+	var receipt *struct {
+		BlockNumber hexBig `json:"blockNumber"`
+		Status      hexBig `json:"status"`
+	}
+	if err := a.call(ctx, "eth_getTransactionReceipt", []interface{}{hash}, &receipt); err != nil {
+		return TxStatus{}, err
+	}
+	if receipt == nil {
+		// Not mined yet; check whether the node still holds it
+		var pending json.RawMessage
+		if err := a.call(ctx, "eth_getTransactionByHash", []interface{}{hash}, &pending); err != nil {
+			return TxStatus{}, err
+		}
+		return TxStatus{Found: len(pending) > 0 && string(pending) != "null"}, nil
+	}
+
+	var head hexBig
+	if err := a.call(ctx, "eth_blockNumber", nil, &head); err != nil {
+		return TxStatus{}, err
+	}
+	confirmations := new(big.Int).Sub(head.Int(), receipt.BlockNumber.Int()).Int64() + 1
+	return TxStatus{Found: true, Failed: receipt.Status.Int().Sign() == 0, Confirmations: confirmations}, nil
+}
+
+func (a *EVMAdapter) Head(ctx context.Context) (int64, error) {
+	var head hexBig
+	if err := a.call(ctx, "eth_blockNumber", nil, &head); err != nil {
+		return 0, err
+	}
+	return head.Int().Int64(), nil
+}
+
+// IncomingTransfers finds native coin payments by reading each block's transactions and token
+// payments from Transfer logs. Addresses and tokens are returned in lower case.
+func (a *EVMAdapter) IncomingTransfers(ctx context.Context, fromBlock int64, toBlock int64, addresses []string, tokens []string) ([]IncomingTransfer, error) {
+	watched := make(map[string]bool, len(addresses))
+	topics := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(address), "0x"))
+		if err != nil || len(decoded) != 20 {
+			continue
+		}
+		watched[strings.ToLower(address)] = true
+		topics = append(topics, "0x"+hex.EncodeToString(leftPad32(decoded)))
+	}
+	transfers := []IncomingTransfer{}
+	if len(watched) == 0 {
+		return transfers, nil
+	}
+
+	for height := fromBlock; height <= toBlock; height++ {
+		var block *struct {
+			Transactions []struct {
+				Hash  string `json:"hash"`
+				From  string `json:"from"`
+				To    string `json:"to"`
+				Value hexBig `json:"value"`
+			} `json:"transactions"`
+		}
+		if err := a.call(ctx, "eth_getBlockByNumber", []interface{}{hexQuantity(height), true}, &block); err != nil {
+			return nil, err
+		}
+		if block == nil {
+			break
+		}
+		for _, tx := range block.Transactions {
+			if !watched[strings.ToLower(tx.To)] || tx.Value.Int().Sign() == 0 {
+				continue
+			}
+			// A reverted transaction moves no value
+			status, err := a.TxStatus(ctx, tx.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if status.Failed {
+				continue
+			}
+			transfers = append(transfers, IncomingTransfer{
+				TxHash: tx.Hash,
+				From:   strings.ToLower(tx.From),
+				To:     strings.ToLower(tx.To),
+				Amount: tx.Value.Int(),
+				Block:  height,
+			})
+		}
+	}
+
+	if len(tokens) == 0 {
+		return transfers, nil
+	}
+	filter := map[string]interface{}{
+		"fromBlock": hexQuantity(fromBlock),
+		"toBlock":   hexQuantity(toBlock),
+		"address":   tokens,
+		"topics":    []interface{}{erc20TransferTopic, nil, topics},
+	}
+	var logs []struct {
+		Address         string   `json:"address"`
+		Topics          []string `json:"topics"`
+		Data            string   `json:"data"`
+		BlockNumber     hexBig   `json:"blockNumber"`
+		TransactionHash string   `json:"transactionHash"`
+		LogIndex        hexBig   `json:"logIndex"`
+		Removed         bool     `json:"removed"`
+	}
+	if err := a.call(ctx, "eth_getLogs", []interface{}{filter}, &logs); err != nil {
+		return nil, err
+	}
+	for _, entry := range logs {
+		if entry.Removed || len(entry.Topics) != 3 || len(entry.Topics[1]) != 66 || len(entry.Topics[2]) != 66 {
+			continue
+		}
+		amount, ok := new(big.Int).SetString(strings.TrimPrefix(entry.Data, "0x"), 16)
+		if !ok {
+			continue
+		}
+		transfers = append(transfers, IncomingTransfer{
+			TxHash: entry.TransactionHash,
+			// Offset log indexes so they never collide with the native payment of the same transaction
+			Index:  int(entry.LogIndex.Int().Int64()) + 1,
+			From:   "0x" + strings.ToLower(entry.Topics[1][26:]),
+			To:     "0x" + strings.ToLower(entry.Topics[2][26:]),
+			Token:  strings.ToLower(entry.Address),
+			Amount: amount,
+			Block:  entry.BlockNumber.Int().Int64(),
+		})
+	}
+	return transfers, nil
+}
+
+// call makes a JSON-RPC request and decodes its result into result
+func (a *EVMAdapter) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.rpcURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s: decoding response: %w", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s (code %d)", method, response.Error.Message, response.Error.Code)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// hexBig decodes the 0x-prefixed quantities returned by JSON-RPC
+type hexBig big.Int
+
+func (h *hexBig) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "0x"), 16)
+	if !ok {
+		return fmt.Errorf("invalid quantity %q", s)
+	}
+	*h = hexBig(*n)
+	return nil
+}
+
+func (h *hexBig) Int() *big.Int {
+	return (*big.Int)(h)
+}
+
+func hexQuantity(n int64) string {
+	return fmt.Sprintf("0x%x", n)
+}
+
+func keccak256(data []byte) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func leftPad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
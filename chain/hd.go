@@ -0,0 +1,95 @@
+package chain
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"crypto-sms/utils"
+)
+
+// hardenedOffset is the first hardened BIP-32 child index
+const hardenedOffset = 0x80000000
+
+// ExtendedPublicKey is a BIP-32 extended public key. Only non-hardened children can be
+// derived from it, so the matching private keys never need to be online.
+type ExtendedPublicKey struct {
+	// Version is the serialization prefix, which tells the network and address type (xpub, tpub, zpub, vpub)
+	Version   uint32
+	Depth     byte
+	ChainCode []byte
+	Key       *secp256k1.PublicKey
+}
+
+// ParseExtendedPublicKey decodes a base58check serialized extended public key
+func ParseExtendedPublicKey(s string) (*ExtendedPublicKey, error) {
+	first, rest, err := utils.DecodeBase58Check(s)
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte{first}, rest...)
+	if len(data) != 78 {
+		return nil, errors.New("extended key must be 78 bytes")
+	}
+	if data[45] != 0x02 && data[45] != 0x03 {
+		return nil, errors.New("extended key holds a private key; use the public key")
+	}
+	key, err := secp256k1.ParsePubKey(data[45:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return &ExtendedPublicKey{
+		Version:   binary.BigEndian.Uint32(data[:4]),
+		Depth:     data[4],
+		ChainCode: data[13:45],
+		Key:       key,
+	}, nil
+}
+
+// Child derives the non-hardened child at index (BIP-32 CKDpub)
+func (k *ExtendedPublicKey) Child(index uint32) (*ExtendedPublicKey, error) {
+	if index >= hardenedOffset {
+		return nil, errors.New("hardened children can't be derived from a public key")
+	}
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(k.Key.SerializeCompressed())
+	binary.Write(mac, binary.BigEndian, index)
+	sum := mac.Sum(nil)
+
+	// Indexes whose tweak is out of range or lands on infinity are invalid and must be skipped
+	var tweak secp256k1.ModNScalar
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, fmt.Errorf("child %d is invalid", index)
+	}
+	var tweakPoint, parent, child secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	k.Key.AsJacobian(&parent)
+	secp256k1.AddNonConst(&tweakPoint, &parent, &child)
+	if (child.X.IsZero() && child.Y.IsZero()) || child.Z.IsZero() {
+		return nil, fmt.Errorf("child %d is invalid", index)
+	}
+	child.ToAffine()
+
+	return &ExtendedPublicKey{
+		Version:   k.Version,
+		Depth:     k.Depth + 1,
+		ChainCode: sum[32:],
+		Key:       secp256k1.NewPublicKey(&child.X, &child.Y),
+	}, nil
+}
+
+// Derive follows a path of non-hardened child indexes
+func (k *ExtendedPublicKey) Derive(path ...uint32) (*ExtendedPublicKey, error) {
+	key := k
+	for _, index := range path {
+		var err error
+		if key, err = key.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
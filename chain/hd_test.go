@@ -0,0 +1,96 @@
+package chain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// BIP-32 test vectors 1 and 2, limited to the non-hardened steps CKDpub can take
+func TestExtendedPublicKeyChild(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+		index  uint32
+		child  string
+	}{
+		{
+			"vector 1 m/0H/1",
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+			1,
+			"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+		},
+		{
+			"vector 1 m/0H/1/2H/2",
+			"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+			2,
+			"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+		},
+		{
+			"vector 1 m/0H/1/2H/2/1000000000",
+			"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+			1000000000,
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+		},
+		{
+			"vector 2 m/0",
+			"xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB",
+			0,
+			"xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH",
+		},
+	}
+	for _, test := range tests {
+		parent, err := ParseExtendedPublicKey(test.parent)
+		if err != nil {
+			t.Fatalf("%s: parsing parent: %v", test.name, err)
+		}
+		want, err := ParseExtendedPublicKey(test.child)
+		if err != nil {
+			t.Fatalf("%s: parsing child: %v", test.name, err)
+		}
+		got, err := parent.Child(test.index)
+		if err != nil {
+			t.Fatalf("%s: deriving child: %v", test.name, err)
+		}
+		if got.Depth != want.Depth || got.Version != want.Version {
+			t.Errorf("%s: child has depth %d and version %08x, want %d and %08x", test.name, got.Depth, got.Version, want.Depth, want.Version)
+		}
+		if !bytes.Equal(got.ChainCode, want.ChainCode) {
+			t.Errorf("%s: chain code %x, want %x", test.name, got.ChainCode, want.ChainCode)
+		}
+		if !bytes.Equal(got.Key.SerializeCompressed(), want.Key.SerializeCompressed()) {
+			t.Errorf("%s: key %x, want %x", test.name, got.Key.SerializeCompressed(), want.Key.SerializeCompressed())
+		}
+	}
+}
+
+func TestExtendedPublicKeyDerive(t *testing.T) {
+	// Vector 1 m/0H/1/2H followed by the non-hardened path 2/1000000000
+	parent, err := ParseExtendedPublicKey("xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5")
+	if err != nil {
+		t.Fatalf("parsing parent: %v", err)
+	}
+	want, err := ParseExtendedPublicKey("xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy")
+	if err != nil {
+		t.Fatalf("parsing child: %v", err)
+	}
+	got, err := parent.Derive(2, 1000000000)
+	if err != nil {
+		t.Fatalf("deriving: %v", err)
+	}
+	if !bytes.Equal(got.Key.SerializeCompressed(), want.Key.SerializeCompressed()) || !bytes.Equal(got.ChainCode, want.ChainCode) {
+		t.Errorf("derived key %x with chain code %x, want %x with %x",
+			got.Key.SerializeCompressed(), got.ChainCode, want.Key.SerializeCompressed(), want.ChainCode)
+	}
+
+	if _, err := parent.Derive(2, hardenedOffset); err == nil {
+		t.Error("derived a hardened child from a public key")
+	}
+}
+
+func TestParseExtendedPublicKeyRefusesPrivateKeys(t *testing.T) {
+	// Vector 1 master private key
+	_, err := ParseExtendedPublicKey("xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi")
+	if err == nil {
+		t.Error("parsed an extended private key")
+	}
+}
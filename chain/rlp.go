@@ -0,0 +1,41 @@
+package chain
+
+import "math/big"
+
+// rlpBytes encodes a byte string in Ethereum's recursive length prefix encoding
+func rlpBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+	return append(rlpLength(len(b), 0x80), b...)
+}
+
+// rlpUint encodes an unsigned integer as its minimal big-endian bytes
+func rlpUint(n uint64) []byte {
+	return rlpBytes(new(big.Int).SetUint64(n).Bytes())
+}
+
+// rlpBig encodes a non-negative big integer as its minimal big-endian bytes
+func rlpBig(n *big.Int) []byte {
+	if n == nil {
+		return rlpBytes(nil)
+	}
+	return rlpBytes(n.Bytes())
+}
+
+// rlpList encodes already-encoded items as a list
+func rlpList(items ...[]byte) []byte {
+	var payload []byte
+	for _, item := range items {
+		payload = append(payload, item...)
+	}
+	return append(rlpLength(len(payload), 0xc0), payload...)
+}
+
+func rlpLength(length int, offset byte) []byte {
+	if length <= 55 {
+		return []byte{offset + byte(length)}
+	}
+	lengthBytes := big.NewInt(int64(length)).Bytes()
+	return append([]byte{offset + 55 + byte(len(lengthBytes))}, lengthBytes...)
+}
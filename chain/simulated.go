@@ -0,0 +1,214 @@
+package chain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// SimulatedChain is an in-memory chain for development and manual testing. Broadcast
+// transactions and simulated deposits wait in a mempool until a block is mined, either by
+// Mine or, when a block time is set, as time passes.
+type SimulatedChain struct {
+	mu        sync.Mutex
+	network   string
+	blockTime time.Duration
+	lastBlock time.Time
+	height    int64
+	nonce     uint64
+	mempool   []*simulatedTx
+	txs       map[string]*simulatedTx
+	failNext  bool
+}
+
+type simulatedTx struct {
+	Hash     string
+	Transfer IncomingTransfer
+	Block    int64
+	Failed   bool
+}
+
+// NewSimulatedChain creates a chain for network that mines a block every blockTime, or only
+// on Mine when blockTime is 0
+func NewSimulatedChain(network string, blockTime time.Duration) *SimulatedChain {
+	return &SimulatedChain{
+		network:   network,
+		blockTime: blockTime,
+		lastBlock: time.Now(),
+		txs:       make(map[string]*simulatedTx),
+	}
+}
+
+func (c *SimulatedChain) Network() string {
+	return c.network
+}
+
+func (c *SimulatedChain) BuildTransfer(ctx context.Context, transfer Transfer) (*UnsignedTx, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx := &UnsignedTx{From: "simulated-hot-wallet", To: transfer.To, Value: transfer.Amount, Nonce: c.nonce, GasPrice: big.NewInt(1), GasLimit: 21000}
+	if transfer.Token != "" {
+		tx.To, tx.Data = transfer.Token, []byte(transfer.To)
+	}
+	c.nonce++
+	return tx, nil
+}
+
+func (c *SimulatedChain) Sign(ctx context.Context, tx *UnsignedTx) (*SignedTx, error) {
+	raw, err := json.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(raw)
+	return &SignedTx{Hash: "0x" + hex.EncodeToString(hash[:]), Raw: raw}, nil
+}
+
+func (c *SimulatedChain) Broadcast(ctx context.Context, signed *SignedTx) error {
+	var tx UnsignedTx
+	if err := json.Unmarshal(signed.Raw, &tx); err != nil {
+		return ErrUnknownTransaction
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	if _, known := c.txs[signed.Hash]; known {
+		return nil
+	}
+	transfer := IncomingTransfer{TxHash: signed.Hash, From: tx.From, To: tx.To, Amount: tx.Value}
+	if len(tx.Data) > 0 {
+		transfer.To, transfer.Token = string(tx.Data), tx.To
+	}
+	c.submit(&simulatedTx{Hash: signed.Hash, Transfer: transfer, Failed: c.failNext})
+	c.failNext = false
+	return nil
+}
+
+// Deposit submits a payment from outside the service to an address, in the native coin or
+// a token, and returns its transaction hash
+func (c *SimulatedChain) Deposit(from string, to string, token string, amount *big.Int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+
+	c.nonce++
+	hash := sha256.Sum256([]byte(fmt.Sprintf("deposit:%d:%s:%s:%s:%s", c.nonce, from, to, token, amount)))
+	tx := &simulatedTx{Hash: "0x" + hex.EncodeToString(hash[:])}
+	tx.Transfer = IncomingTransfer{TxHash: tx.Hash, From: from, To: to, Token: token, Amount: amount}
+	c.submit(tx)
+	return tx.Hash
+}
+
+func (c *SimulatedChain) submit(tx *simulatedTx) {
+	c.mempool = append(c.mempool, tx)
+	c.txs[tx.Hash] = tx
+}
+
+func (c *SimulatedChain) Head(ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	return c.height, nil
+}
+
+func (c *SimulatedChain) IncomingTransfers(ctx context.Context, fromBlock int64, toBlock int64, addresses []string, tokens []string) ([]IncomingTransfer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+
+	watched := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		watched[address] = true
+	}
+	tokenSet := map[string]bool{"": true}
+	for _, token := range tokens {
+		tokenSet[token] = true
+	}
+
+	transfers := []IncomingTransfer{}
+	for _, tx := range c.txs {
+		if tx.Block < fromBlock || tx.Block > toBlock || tx.Block == 0 || tx.Failed {
+			continue
+		}
+		if watched[tx.Transfer.To] && tokenSet[tx.Transfer.Token] {
+			transfer := tx.Transfer
+			transfer.Block = tx.Block
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers, nil
+}
+
+func (c *SimulatedChain) TxStatus(ctx context.Context, hash string) (TxStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+
+	tx, known := c.txs[hash]
+	if !known {
+		return TxStatus{}, nil
+	}
+	if tx.Block == 0 {
+		return TxStatus{Found: true}, nil
+	}
+	return TxStatus{Found: true, Failed: tx.Failed, Confirmations: c.height - tx.Block + 1}, nil
+}
+
+// Mine mines n blocks, including every pending transaction in the first
+func (c *SimulatedChain) Mine(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mine(n)
+}
+
+// FailNext makes the next broadcast transaction revert when mined
+func (c *SimulatedChain) FailNext() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failNext = true
+}
+
+// Reorg drops the last depth blocks. Their transactions are forgotten, as if the
+// replacing blocks never included them.
+func (c *SimulatedChain) Reorg(depth int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(depth) > c.height {
+		return fmt.Errorf("chain only has %d blocks", c.height)
+	}
+	c.height -= int64(depth)
+	for hash, tx := range c.txs {
+		if tx.Block > c.height {
+			delete(c.txs, hash)
+		}
+	}
+	return nil
+}
+
+// advance mines the blocks due since the last one when a block time is set
+func (c *SimulatedChain) advance() {
+	if c.blockTime <= 0 {
+		return
+	}
+	if due := int(time.Since(c.lastBlock) / c.blockTime); due > 0 {
+		c.mine(due)
+	}
+}
+
+func (c *SimulatedChain) mine(n int) {
+	for i := 0; i < n; i++ {
+		c.height++
+		for _, tx := range c.mempool {
+			tx.Block = c.height
+		}
+		c.mempool = nil
+	}
+	c.lastBlock = time.Now()
+}
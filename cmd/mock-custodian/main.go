@@ -0,0 +1,47 @@
+// Command mock-custodian runs a simulated remote custodian for developing against
+// CUSTODY_PROVIDER=http
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"crypto-sms/custody"
+)
+
+func main() {
+	server := custody.NewMockServer()
+	server.APIKey = os.Getenv("CUSTODY_API_KEY")
+	server.WebhookSecret = os.Getenv("CUSTODY_WEBHOOK_SECRET")
+	server.CallbackURL = os.Getenv("MOCK_CUSTODIAN_CALLBACK_URL")
+	server.Latency = durationEnv("MOCK_CUSTODIAN_LATENCY", 200*time.Millisecond)
+	server.SettleAfter = durationEnv("MOCK_CUSTODIAN_SETTLE_AFTER", 30*time.Second)
+	server.FailureRate = floatEnv("MOCK_CUSTODIAN_FAILURE_RATE", 0.05)
+	server.WithdrawalFailureRate = floatEnv("MOCK_CUSTODIAN_WITHDRAWAL_FAILURE_RATE", 0.1)
+
+	addr := os.Getenv("MOCK_CUSTODIAN_ADDR")
+	if addr == "" {
+		addr = ":8090"
+	}
+	log.Printf("Mock custodian listening on %s", addr)
+	if err := http.ListenAndServe(addr, server); err != nil {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+}
+
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+func floatEnv(name string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && value >= 0 && value <= 1 {
+		return value
+	}
+	return fallback
+}
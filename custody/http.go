@@ -0,0 +1,132 @@
+package custody
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpAttempts is how many times a request is sent before giving up. Every request is
+// idempotent, so a retry after a lost response is safe.
+const httpAttempts = 3
+
+// HTTPProvider delegates balances to a remote custodian's JSON API and receives withdrawal
+// settlements through signed webhooks
+type HTTPProvider struct {
+	baseURL       string
+	apiKey        string
+	webhookSecret string
+	client        *http.Client
+}
+
+// NewHTTPProvider creates a provider for the custodian at baseURL
+func NewHTTPProvider(baseURL string, apiKey string, webhookSecret string) *HTTPProvider {
+	return &HTTPProvider{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return "http"
+}
+
+func (p *HTTPProvider) Balances(ctx context.Context, account string) (map[string]float64, error) {
+	var response struct {
+		Balances map[string]float64 `json:"balances"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/accounts/"+url.PathEscape(account)+"/balances", nil, &response); err != nil {
+		return nil, err
+	}
+	if response.Balances == nil {
+		response.Balances = map[string]float64{}
+	}
+	return response.Balances, nil
+}
+
+func (p *HTTPProvider) Transfer(ctx context.Context, transfer Transfer) error {
+	return p.do(ctx, http.MethodPost, "/transfers", transfer, nil)
+}
+
+func (p *HTTPProvider) Withdraw(ctx context.Context, withdrawal Withdrawal) error {
+	return p.do(ctx, http.MethodPost, "/withdrawals", withdrawal, nil)
+}
+
+func (p *HTTPProvider) ParseSettlement(r *http.Request) (*Settlement, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if !VerifySignature(p.webhookSecret, body, r.Header.Get(SignatureHeader)) {
+		return nil, ErrInvalidSignature
+	}
+	var settlement Settlement
+	if err := json.Unmarshal(body, &settlement); err != nil {
+		return nil, err
+	}
+	return &settlement, nil
+}
+
+// do sends a request, retrying network errors and server errors
+func (p *HTTPProvider) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= httpAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt-1) * 200 * time.Millisecond):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("%s %s: %w", method, path, err)
+			continue
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("%s %s: %w", method, path, err)
+			continue
+		}
+
+		switch {
+		case resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("%s %s: custodian returned %d", method, path, resp.StatusCode)
+			continue
+		case resp.StatusCode == http.StatusConflict:
+			return ErrInsufficientFunds
+		case resp.StatusCode >= 400:
+			return fmt.Errorf("%s %s: custodian returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+		}
+		if result != nil {
+			return json.Unmarshal(data, result)
+		}
+		return nil
+	}
+	return lastErr
+}
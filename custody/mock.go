@@ -0,0 +1,193 @@
+package custody
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MockServer simulates a remote custodian speaking the API HTTPProvider expects. Every
+// request waits a random latency up to Latency. FailureRate of requests fail with a 503,
+// half before they are applied and half after, as if the response were lost, so clients
+// must retry idempotently. Withdrawals settle after SettleAfter through a signed webhook
+// to CallbackURL, and WithdrawalFailureRate of them fail.
+type MockServer struct {
+	APIKey                string
+	WebhookSecret         string
+	CallbackURL           string
+	Latency               time.Duration
+	FailureRate           float64
+	SettleAfter           time.Duration
+	WithdrawalFailureRate float64
+
+	mu       sync.Mutex
+	balances map[string]map[string]float64
+	results  map[string]int
+	mux      *http.ServeMux
+}
+
+// NewMockServer creates a mock custodian with no accounts
+func NewMockServer() *MockServer {
+	s := &MockServer{
+		balances: map[string]map[string]float64{},
+		results:  map[string]int{},
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /accounts/{account}/balances", s.handleBalances)
+	s.mux.HandleFunc("POST /accounts/{account}/fund", s.handleFund)
+	s.mux.HandleFunc("POST /transfers", s.handleTransfer)
+	s.mux.HandleFunc("POST /withdrawals", s.handleWithdrawal)
+	return s
+}
+
+func (s *MockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.APIKey {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.Latency > 0 {
+		time.Sleep(time.Duration(mathrand.Int63n(int64(s.Latency))))
+	}
+	if mathrand.Float64() < s.FailureRate/2 {
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *MockServer) handleBalances(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	balances := map[string]float64{}
+	for asset, amount := range s.balances[r.PathValue("account")] {
+		balances[asset] = amount
+	}
+	s.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"balances": balances})
+}
+
+// handleFund credits an account directly, for seeding balances during development
+func (s *MockServer) handleFund(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Asset     string  `json:"asset"`
+		AmountUSD float64 `json:"amount_usd"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.credit(r.PathValue("account"), req.Asset, req.AmountUSD)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *MockServer) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	var transfer Transfer
+	if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil || transfer.Reference == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	toAsset := transfer.ToAsset
+	if toAsset == "" {
+		toAsset = transfer.Asset
+	}
+
+	s.respond(w, transfer.Reference, func() int {
+		if transfer.From != "" {
+			if !transfer.AllowOverdraft && s.balances[transfer.From][transfer.Asset] < transfer.AmountUSD {
+				return http.StatusConflict
+			}
+			s.credit(transfer.From, transfer.Asset, -transfer.AmountUSD)
+		}
+		if transfer.To != "" {
+			s.credit(transfer.To, toAsset, transfer.AmountUSD)
+		}
+		return http.StatusOK
+	})
+}
+
+func (s *MockServer) handleWithdrawal(w http.ResponseWriter, r *http.Request) {
+	var withdrawal Withdrawal
+	if err := json.NewDecoder(r.Body).Decode(&withdrawal); err != nil || withdrawal.Reference == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.respond(w, withdrawal.Reference, func() int {
+		if s.balances[withdrawal.From][withdrawal.Asset] < withdrawal.AmountUSD {
+			return http.StatusConflict
+		}
+		s.credit(withdrawal.From, withdrawal.Asset, -withdrawal.AmountUSD)
+		time.AfterFunc(s.SettleAfter, func() { s.settle(withdrawal) })
+		return http.StatusAccepted
+	})
+}
+
+// respond applies a request once per reference and replays its result to retries. The
+// response may be dropped after applying, to exercise client retries.
+func (s *MockServer) respond(w http.ResponseWriter, reference string, apply func() int) {
+	s.mu.Lock()
+	status, seen := s.results[reference]
+	if !seen {
+		status = apply()
+		s.results[reference] = status
+	}
+	s.mu.Unlock()
+
+	if mathrand.Float64() < s.FailureRate/2 {
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if status == http.StatusConflict {
+		http.Error(w, ErrInsufficientFunds.Error(), status)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+func (s *MockServer) credit(account string, asset string, amount float64) {
+	if s.balances[account] == nil {
+		s.balances[account] = map[string]float64{}
+	}
+	s.balances[account][asset] += amount
+}
+
+// settle decides a withdrawal's outcome, refunding failures, and reports it to CallbackURL
+func (s *MockServer) settle(withdrawal Withdrawal) {
+	settlement := Settlement{Reference: withdrawal.Reference, Status: SettlementConfirmed}
+	if mathrand.Float64() < s.WithdrawalFailureRate {
+		settlement.Status, settlement.Reason = SettlementFailed, "simulated network failure"
+		s.mu.Lock()
+		s.credit(withdrawal.From, withdrawal.Asset, withdrawal.AmountUSD)
+		s.mu.Unlock()
+	} else {
+		hash := make([]byte, 32)
+		rand.Read(hash)
+		settlement.TxHash = "0x" + hex.EncodeToString(hash)
+	}
+	if s.CallbackURL == "" {
+		return
+	}
+
+	body, _ := json.Marshal(settlement)
+	for attempt := 0; attempt < 5; attempt++ {
+		req, _ := http.NewRequest(http.MethodPost, s.CallbackURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SignatureHeader, Sign(s.WebhookSecret, body))
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+	log.Printf("Mock custodian gave up delivering settlement for %s", withdrawal.Reference)
+}
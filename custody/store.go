@@ -0,0 +1,56 @@
+package custody
+
+import (
+	"context"
+
+	"crypto-sms/storage"
+)
+
+// StoreProvider keeps balances in the store's custodian repository. Each transfer is a
+// conditional debit and a credit applied together, so a balance can't be spent twice, and
+// is recorded under its reference, so a retried transfer is applied once.
+type StoreProvider struct {
+	Custodians storage.CustodianRepository
+}
+
+func (StoreProvider) Name() string {
+	return "store"
+}
+
+func (p StoreProvider) Balances(ctx context.Context, account string) (map[string]float64, error) {
+	custodian, exists, err := p.Custodians.GetCustodianByWalletAddress(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	balances := map[string]float64{}
+	if exists {
+		for asset, amount := range custodian.Cryptocurrencies {
+			balances[asset] = amount
+		}
+	}
+	return balances, nil
+}
+
+func (p StoreProvider) Transfer(ctx context.Context, transfer Transfer) error {
+	toAsset := transfer.ToAsset
+	if toAsset == "" {
+		toAsset = transfer.Asset
+	}
+
+	moved, err := p.Custodians.TransferBalance(ctx, storage.BalanceTransfer{
+		Reference:      transfer.Reference,
+		From:           transfer.From,
+		FromAsset:      transfer.Asset,
+		To:             transfer.To,
+		ToAsset:        toAsset,
+		Amount:         transfer.AmountUSD,
+		AllowOverdraft: transfer.AllowOverdraft,
+	})
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
@@ -3,8 +3,11 @@ module crypto-sms
 go 1.23.1
 
 require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1
+	github.com/lib/pq v1.10.9
 	github.com/twilio/twilio-go v1.23.2
 	go.mongodb.org/mongo-driver v1.17.1
+	golang.org/x/crypto v0.26.0
 )
 
 require (
@@ -17,7 +20,7 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
-	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 )
@@ -3,15 +3,18 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 
+	"crypto-sms/services"
 	"crypto-sms/storage"
 	"crypto-sms/utils"
 )
 
-func Generate2FACode(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Generate2FACode(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		PhoneNumber   string `json:"phone_number"`
 		WalletAddress string `json:"wallet_address"`
@@ -21,9 +24,14 @@ func Generate2FACode(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
-	code := utils.Generate2FACode()
-	err := storage.Generate2FACodeAndStore(r.Context(), req.PhoneNumber, code)
+	code, err := utils.Generate2FACode()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	err = h.app.Store.Generate2FACodeAndStore(r.Context(), req.PhoneNumber, code)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -46,7 +54,7 @@ func Generate2FACode(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func Verify2FACode(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Verify2FACode(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		PhoneNumber   string `json:"phone_number"`
 		WalletAddress string `json:"wallet_address"`
@@ -57,8 +65,13 @@ func Verify2FACode(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
-	codeMatches, err := storage.Verify2FACode(r.Context(), req.PhoneNumber, req.Code)
+	codeMatches, err := h.app.Verify2FACode(r.Context(), req.PhoneNumber, req.Code)
+	if errors.Is(err, services.ErrTooManyAttempts) {
+		http.Error(w, "Too many failed 2FA attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -68,7 +81,7 @@ func Verify2FACode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, phoneExists, err := storage.CheckPhoneNumberExistsInSmsService(r.Context(), req.PhoneNumber)
+	_, phoneExists, err := h.app.Store.CheckPhoneNumberExistsInSmsService(r.Context(), req.PhoneNumber)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -78,11 +91,18 @@ func Verify2FACode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = storage.UpdatePhoneNumber(r.Context(), req.WalletAddress, req.PhoneNumber)
+	err = h.app.Store.UpdatePhoneNumber(r.Context(), req.WalletAddress, req.PhoneNumber)
+	if errors.Is(err, storage.ErrDuplicate) {
+		http.Error(w, "Phone number already linked to another account", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	if err := h.app.ReleaseEscrowsForWallet(r.Context(), req.WalletAddress, req.PhoneNumber); err != nil {
+		log.Printf("Error releasing escrows: %v", err)
+	}
 
 	response := struct {
 		Status  string `json:"status"`
@@ -95,7 +115,7 @@ func Verify2FACode(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func Generate2FAHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Generate2FAHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		PhoneNumber  string `json:"phone_number"`
 		WalletAddress string `json:"wallet_address"`
@@ -108,10 +128,14 @@ func Generate2FAHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate a 2FA code
-	code := utils.Generate2FACode()
+	code, err := utils.Generate2FACode()
+	if err != nil {
+		http.Error(w, "Failed to generate 2FA code", http.StatusInternalServerError)
+		return
+	}
 
 	// Store the 2FA code in the database
-	err = storage.Store2FACode(context.TODO(), req.PhoneNumber, code)
+	err = h.app.Store.Store2FACode(context.TODO(), req.PhoneNumber, code)
 	if err != nil {
 		http.Error(w, "Failed to store 2FA code", http.StatusInternalServerError)
 		return
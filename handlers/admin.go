@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminToken is an admin API credential and the operator it identifies
+type AdminToken struct {
+	Operator string
+	Token    string
+}
+
+// operatorHandler serves an admin route on behalf of an authenticated operator
+type operatorHandler func(w http.ResponseWriter, r *http.Request, operator string)
+
+// RequireAdmin serves next only for requests carrying a known admin token as a bearer
+// token, passing on the operator it belongs to. Without admin tokens every request is refused.
+func (h *Handler) RequireAdmin(next operatorHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operator, ok := h.authenticateAdmin(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, operator)
+	}
+}
+
+func (h *Handler) authenticateAdmin(r *http.Request) (string, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", false
+	}
+	// Every token is compared so the response time doesn't reveal which one matched
+	operator := ""
+	for _, admin := range h.adminTokens {
+		if subtle.ConstantTimeCompare([]byte(admin.Token), []byte(token)) == 1 {
+			operator = admin.Operator
+		}
+	}
+	return operator, operator != ""
+}
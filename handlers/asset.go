@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) ListAssets(w http.ResponseWriter, r *http.Request) {
+	assets, err := h.app.Store.ListAssets(r.Context())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Assets []storage.Asset `json:"assets"`
+	}{
+		Assets: assets,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) SaveAsset(w http.ResponseWriter, r *http.Request, operator string) {
+	var asset storage.Asset
+
+	if err := json.NewDecoder(r.Body).Decode(&asset); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateAsset(&asset); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid asset: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.app.Store.SaveAsset(r.Context(), &asset); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Asset %s saved by %s", asset.Symbol, operator)
+
+	json.NewEncoder(w).Encode(asset)
+}
+
+func (h *Handler) SetAssetEnabled(w http.ResponseWriter, r *http.Request, operator string) {
+	var req struct {
+		Symbol  string `json:"symbol"`
+		Enabled bool   `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.app.Store.SetAssetEnabled(r.Context(), req.Symbol, req.Enabled)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Asset not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Asset %s enabled=%t by %s", req.Symbol, req.Enabled, operator)
+
+	response := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: "Asset updated successfully",
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
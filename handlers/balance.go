@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"crypto-sms/services"
+)
+
+func (h *Handler) GetBalances(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		Passkey       string `json:"passkey"`
+		Asset         string `json:"asset"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	_, authenticated, err := h.app.AuthenticateWallet(r.Context(), req.WalletAddress, req.Passkey)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !authenticated {
+		http.Error(w, "Invalid wallet address or passkey", http.StatusUnauthorized)
+		return
+	}
+
+	balances, err := h.app.GetBalances(r.Context(), req.WalletAddress, req.Asset)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	total := 0.0
+	for _, balance := range balances {
+		total += balance.USDValue
+	}
+
+	response := struct {
+		WalletAddress string                  `json:"wallet_address"`
+		Balances      []services.AssetBalance `json:"balances"`
+		TotalUSD      float64                 `json:"total_usd"`
+	}{
+		WalletAddress: req.WalletAddress,
+		Balances:      balances,
+		TotalUSD:      total,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
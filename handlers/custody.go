@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"crypto-sms/custody"
+	"crypto-sms/services"
+)
+
+func (h *Handler) CustodyWebhook(w http.ResponseWriter, r *http.Request) {
+	err := h.app.HandleCustodySettlement(r)
+	if errors.Is(err, custody.ErrInvalidSignature) || errors.Is(err, services.ErrNoSettlementWebhooks) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling custody settlement: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) ListDeposits(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	deposits, err := h.app.Store.ListDepositsForWallet(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Deposits []storage.Deposit `json:"deposits"`
+	}{
+		Deposits: deposits,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) SimulateDeposit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Network   string  `json:"network"`
+		ToAddress string  `json:"to_address"`
+		Asset     string  `json:"asset"`
+		AmountUSD float64 `json:"amount_usd"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	txHash, err := h.app.SimulateDeposit(r.Context(), req.Network, req.ToAddress, req.Asset, req.AmountUSD)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+		TxHash string `json:"tx_hash"`
+	}{
+		Status: "success",
+		TxHash: txHash,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) CreateFeeSchedule(w http.ResponseWriter, r *http.Request, operator string) {
+	var req struct {
+		Rules []storage.FeeRule `json:"rules"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateFeeRules(req.Rules); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid fee rules: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := h.app.Store.CreateFeeSchedule(r.Context(), req.Rules)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Fee schedule version %d created by %s", schedule.Version, operator)
+
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func (h *Handler) ListFeeSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := h.app.Store.ListFeeSchedules(r.Context())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		FeeSchedules []storage.FeeSchedule `json:"fee_schedules"`
+	}{
+		FeeSchedules: schedules,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) QuoteFee(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Crypto          string  `json:"crypto"`
+		RecipientCrypto string  `json:"recipient_crypto"`
+		Network         string  `json:"network"`
+		AmountUSD       float64 `json:"amount_usd"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	fee, err := h.app.QuoteFee(r.Context(), req.Crypto, req.RecipientCrypto, req.Network, req.AmountUSD)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(fee)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) UpdateGuardians(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string                  `json:"wallet_address"`
+		Passkey       string                  `json:"passkey"`
+		Guardians     *storage.GuardianPolicy `json:"guardians"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	service, exists, err := h.app.Store.CheckWalletExistsInSmsService(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists || service.Passkey != req.Passkey {
+		http.Error(w, "Invalid wallet address or passkey", http.StatusUnauthorized)
+		return
+	}
+	if req.Guardians != nil {
+		if err := h.app.ValidateGuardianPolicy(r.Context(), service, req.Guardians); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid guardians: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Weakening or removing guardians waits out the cooling-off period; tightening applies
+	// right away and cancels pending weakening
+	if services.WeakensGuardianPolicy(service.Guardians, req.Guardians) {
+		change, err := h.app.ScheduleGuardianChange(r.Context(), service, req.Guardians)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(struct {
+			Status        string              `json:"status"`
+			Message       string              `json:"message"`
+			PendingChange storage.LimitChange `json:"pending_change"`
+		}{
+			Status:        "success",
+			Message:       "Guardian change pending",
+			PendingChange: *change,
+		})
+		return
+	}
+
+	err = h.app.Store.UpdateGuardians(r.Context(), req.WalletAddress, req.Guardians)
+	if err == nil && services.WeakensGuardianPolicy(req.Guardians, service.Guardians) {
+		err = h.app.CancelPendingLimitChanges(r.Context(), req.WalletAddress, storage.LimitChangeKindGuardians)
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: "Guardians updated successfully",
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) ListPendingTransfers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	transfers, err := h.app.Store.ListPendingTransfersForWallet(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		PendingTransfers []storage.PendingTransfer `json:"pending_transfers"`
+	}{
+		PendingTransfers: transfers,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"crypto-sms/services"
+)
+
+// Handler serves the HTTP API and the Twilio and custody webhooks from one app
+type Handler struct {
+	app         *services.App
+	smsCommands map[string]func(phoneNumber string, args []string) error
+	adminTokens []AdminToken
+}
+
+// NewHandler returns a handler running every request against app, with admin routes open
+// to the holders of adminTokens
+func NewHandler(app *services.App, adminTokens []AdminToken) *Handler {
+	return &Handler{app: app, smsCommands: newSmsCommands(app), adminTokens: adminTokens}
+}
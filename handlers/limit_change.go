@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) ListLimitChanges(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	changes, err := h.app.Store.ListPendingLimitChanges(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		PendingChanges []storage.LimitChange `json:"pending_changes"`
+	}{
+		PendingChanges: changes,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) CancelLimitChange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		CancelCode    string `json:"cancel_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	cancelled, err := h.app.Store.CancelLimitChangeByCode(r.Context(), req.WalletAddress, req.CancelCode)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "No pending limit change matches that code", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: "Limit change cancelled",
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
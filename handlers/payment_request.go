@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) CreatePaymentRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string  `json:"wallet_address"`
+		Passkey       string  `json:"passkey"`
+		PayerPhone    string  `json:"payer_phone"`
+		Asset         string  `json:"asset"`
+		AmountUSD     float64 `json:"amount_usd"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+	if req.AmountUSD <= 0 || req.Asset == "" || req.PayerPhone == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	requester, authenticated, err := h.app.AuthenticateWallet(r.Context(), req.WalletAddress, req.Passkey)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !authenticated {
+		http.Error(w, "Invalid wallet address or passkey", http.StatusUnauthorized)
+		return
+	}
+
+	request, err := h.app.CreatePaymentRequest(r.Context(), requester, req.PayerPhone, req.Asset, req.AmountUSD)
+	if errors.Is(err, services.ErrPayerNotRegistered) {
+		http.Error(w, "Payer phone number not registered", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrUnknownAsset) || errors.Is(err, services.ErrAssetDisabled) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(request)
+}
+
+func (h *Handler) RespondPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		Reference     string `json:"reference"`
+		Action        string `json:"action"`
+		Passkey       string `json:"passkey"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+	if req.Action != "approve" && req.Action != "decline" {
+		http.Error(w, "Action must be approve or decline", http.StatusBadRequest)
+		return
+	}
+
+	payer, authenticated, err := h.app.AuthenticateWallet(r.Context(), req.WalletAddress, req.Passkey)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !authenticated {
+		http.Error(w, "Invalid wallet address or passkey", http.StatusUnauthorized)
+		return
+	}
+	if payer.PhoneNumber == "" {
+		http.Error(w, "SMS service not found", http.StatusNotFound)
+		return
+	}
+
+	request, exists, err := h.app.Store.GetPendingPaymentRequest(r.Context(), payer.PhoneNumber, req.Reference)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "No open request matches that reference", http.StatusNotFound)
+		return
+	}
+
+	if req.Action == "approve" {
+		err = h.app.ApprovePaymentRequest(r.Context(), request, req.Passkey)
+	} else {
+		err = h.app.DeclinePaymentRequest(r.Context(), request)
+	}
+	if errors.Is(err, services.ErrTransferPending) {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "pending",
+			"message": "Payment is waiting for approval",
+		})
+		return
+	}
+	var transferErr *services.TransferError
+	if errors.As(err, &transferErr) {
+		http.Error(w, transferErr.Error(), transferErr.HTTPStatus())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	response := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: "Payment request " + req.Action + "d",
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		Passkey       string `json:"passkey"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	_, authenticated, err := h.app.AuthenticateWallet(r.Context(), req.WalletAddress, req.Passkey)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !authenticated {
+		http.Error(w, "Invalid wallet address or passkey", http.StatusUnauthorized)
+		return
+	}
+
+	transactions, err := h.app.Store.ListTransactionsForWallet(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	requests, err := h.app.Store.ListPaymentRequestsForWallet(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Transactions    []storage.Transaction    `json:"transactions"`
+		PaymentRequests []storage.PaymentRequest `json:"payment_requests"`
+	}{
+		Transactions:    transactions,
+		PaymentRequests: requests,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) GetReconciliationReport(w http.ResponseWriter, r *http.Request, operator string) {
+	var req struct {
+		ID     string `json:"id"`
+		Format string `json:"format"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	report, exists, err := h.app.Store.GetReconciliationReport(r.Context(), req.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Reconciliation report %s read by %s", report.ID.Hex(), operator)
+
+	if req.Format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=reconciliation-"+report.ID.Hex()+".csv")
+		services.WriteReconciliationCSV(w, report)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *Handler) RunReconciliation(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Printf("Reconciliation run by %s", operator)
+	if err := h.app.RunReconciliation(r.Context()); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	report, _, err := h.app.Store.GetReconciliationReport(r.Context(), "")
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *Handler) ListWalletFreezes(w http.ResponseWriter, r *http.Request, operator string) {
+	freezes, err := h.app.Store.ListActiveWalletFreezes(r.Context())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Freezes []storage.WalletFreeze `json:"freezes"`
+	}{
+		Freezes: freezes,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) ResolveReconciliation(w http.ResponseWriter, r *http.Request, operator string) {
+	var req services.ReconciliationResolution
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.Operator = operator
+
+	adjustments, err := h.app.ResolveReconciliation(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Adjustments []storage.Transaction `json:"adjustments"`
+	}{
+		Adjustments: adjustments,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) GetReserveSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	snapshot, exists, err := h.app.Store.GetReserveSnapshot(r.Context(), req.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (h *Handler) ListReserveSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := h.app.Store.ListReserveSnapshots(r.Context(), 30)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Snapshots []storage.ReserveSnapshot `json:"snapshots"`
+	}{
+		Snapshots: snapshots,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) GetReserveProof(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	proof, exists, err := h.app.Store.GetReserveProofByCode(r.Context(), strings.ToUpper(strings.TrimSpace(req.Code)))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Proof not found", http.StatusNotFound)
+		return
+	}
+	snapshot, exists, err := h.app.Store.GetReserveSnapshot(r.Context(), proof.SnapshotID.Hex())
+	if err != nil || !exists {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Snapshot *storage.ReserveSnapshot `json:"snapshot"`
+		Proof    *storage.ReserveProof    `json:"proof"`
+	}{
+		Snapshot: snapshot,
+		Proof:    proof,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) VerifyReserveProof(w http.ResponseWriter, r *http.Request) {
+	var proof storage.ReserveProof
+
+	if err := json.NewDecoder(r.Body).Decode(&proof); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	snapshot, exists, err := h.app.Store.GetReserveSnapshot(r.Context(), proof.SnapshotID.Hex())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Valid bool   `json:"valid"`
+		Root  string `json:"root"`
+	}{
+		Valid: services.VerifyReserveProof(&proof, snapshot),
+		Root:  snapshot.Root,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
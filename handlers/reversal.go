@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"crypto-sms/services"
+)
+
+func (h *Handler) ReverseTransaction(w http.ResponseWriter, r *http.Request, operator string) {
+	var req services.ReversalRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.Operator = operator
+
+	reversal, err := h.app.ReverseTransaction(r.Context(), req)
+	if errors.Is(err, services.ErrTransactionNotFound) {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrFundsSpent) {
+		http.Error(w, "Recipient has already spent the funds; set force to reverse anyway", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(reversal)
+}
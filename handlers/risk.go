@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) ListRiskDecisions(w http.ResponseWriter, r *http.Request, operator string) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		Outcome       string `json:"outcome"`
+		Limit         int64  `json:"limit"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+	if req.WalletAddress == "" {
+		http.Error(w, "wallet_address is required", http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 || req.Limit > 500 {
+		req.Limit = 100
+	}
+
+	decisions, err := h.app.Store.ListRiskDecisions(r.Context(), req.WalletAddress, req.Outcome, req.Limit)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Risk decisions of %s listed by %s", req.WalletAddress, operator)
+
+	response := struct {
+		RiskDecisions []storage.RiskDecision `json:"risk_decisions"`
+	}{
+		RiskDecisions: decisions,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
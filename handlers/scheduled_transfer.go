@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"crypto-sms/services"
+	"crypto-sms/storage"
+)
+
+func (h *Handler) CreateScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress   string       `json:"wallet_address"`
+		Passkey         string       `json:"passkey"`
+		Recipient       string       `json:"recipient"`
+		Crypto          string       `json:"crypto"`
+		RecipientCrypto string       `json:"recipient_crypto"`
+		Network         string       `json:"network"`
+		AmountUSD       float64      `json:"amount_usd"`
+		Frequency       string       `json:"frequency"`
+		Weekday         time.Weekday `json:"weekday"`
+		DayOfMonth      int          `json:"day_of_month"`
+		Hour            *int         `json:"hour"`
+		RunAt           time.Time    `json:"run_at"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	service, exists, err := h.app.Store.CheckWalletExistsInSmsService(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists || service.Passkey != req.Passkey {
+		http.Error(w, "Invalid wallet address or passkey", http.StatusUnauthorized)
+		return
+	}
+
+	transfer := &storage.ScheduledTransfer{
+		SenderAddress:   req.WalletAddress,
+		Recipient:       req.Recipient,
+		Crypto:          strings.ToUpper(req.Crypto),
+		RecipientCrypto: strings.ToUpper(req.RecipientCrypto),
+		Network:         strings.ToLower(req.Network),
+		AmountUSD:       req.AmountUSD,
+		Frequency:       req.Frequency,
+		Weekday:         req.Weekday,
+		DayOfMonth:      req.DayOfMonth,
+		Hour:            services.DefaultScheduleHour,
+		NextRunAt:       req.RunAt,
+	}
+	if req.Hour != nil {
+		transfer.Hour = *req.Hour
+	}
+	if transfer.RecipientCrypto == "" {
+		transfer.RecipientCrypto = transfer.Crypto
+	}
+
+	if err := h.app.ValidateScheduledTransfer(r.Context(), transfer); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid scheduled transfer: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.app.CreateScheduledTransfer(r.Context(), transfer); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(transfer)
+}
+
+func (h *Handler) ListScheduledTransfers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	transfers, err := h.app.Store.ListScheduledTransfers(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		ScheduledTransfers []storage.ScheduledTransfer `json:"scheduled_transfers"`
+	}{
+		ScheduledTransfers: transfers,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) SkipScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		Reference     string `json:"reference"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	transfer, exists, err := h.app.Store.GetActiveScheduledTransfer(r.Context(), req.WalletAddress, req.Reference)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Scheduled transfer not found", http.StatusNotFound)
+		return
+	}
+
+	skipped, err := h.app.SkipScheduledTransfer(r.Context(), transfer)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !skipped {
+		http.Error(w, "Scheduled run already started", http.StatusConflict)
+		return
+	}
+
+	json.NewEncoder(w).Encode(transfer)
+}
+
+func (h *Handler) CancelScheduledTransfer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress string `json:"wallet_address"`
+		Reference     string `json:"reference"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+
+	cancelled, err := h.app.Store.CancelScheduledTransfer(r.Context(), req.WalletAddress, req.Reference)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "Scheduled transfer not found", http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{
+		Status:  "success",
+		Message: "Scheduled transfer cancelled",
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
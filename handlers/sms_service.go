@@ -2,15 +2,22 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
     "context"
 	"os"
+	"regexp"
+	"strings"
+	"crypto-sms/services"
 	"crypto-sms/storage"
 	"crypto-sms/utils"
 	
 )
 
-func CheckSMSServiceExists(w http.ResponseWriter, r *http.Request) {
+var aliasPattern = regexp.MustCompile(`^[a-z0-9_]{3,20}$`)
+
+func (h *Handler) CheckSMSServiceExists(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		WalletAddress string `json:"wallet_address"`
 	}
@@ -19,8 +26,9 @@ func CheckSMSServiceExists(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
-	service, exists, err := storage.CheckWalletExistsInSmsService(r.Context(), req.WalletAddress)
+	service, exists, err := h.app.Store.CheckWalletExistsInSmsService(r.Context(), req.WalletAddress)
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -48,7 +56,7 @@ func CheckSMSServiceExists(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func CreateSMSService(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateSMSService(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		WalletAddress string `json:"wallet_address"`
 	}
@@ -57,6 +65,13 @@ func CreateSMSService(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	if strings.HasPrefix(req.WalletAddress, "0x") {
+		if err := utils.ValidateEVMAddress(req.WalletAddress); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid wallet address: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
 	privateKey, publicKey, err := utils.GenerateKeyPair()
 	if err != nil {
@@ -69,7 +84,11 @@ func CreateSMSService(w http.ResponseWriter, r *http.Request) {
 		PublicKey:     publicKey,
 		Limit:         1000,
 	}
-	err = storage.CreateSmsService(r.Context(), service)
+	err = h.app.Store.CreateSmsService(r.Context(), service)
+	if errors.Is(err, storage.ErrDuplicate) {
+		http.Error(w, "Wallet already registered", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -86,19 +105,178 @@ func CreateSMSService(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func UpdateSmsService(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdateSmsService(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WalletAddress  string                  `json:"wallet_address"`
+		Passkey        string                  `json:"passkey"`
+		Code           string                  `json:"code"`
+		Limit          *float64                `json:"limit"`
+		VelocityLimits []storage.VelocityLimit `json:"velocity_limits"`
+		Language       string                  `json:"language"`
+		Networks       map[string]string       `json:"preferred_networks"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
+	if err := services.ValidateVelocityLimits(req.VelocityLimits); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid velocity limits: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Language != "" && !services.IsSupportedLanguage(req.Language) {
+		http.Error(w, "Unsupported language", http.StatusBadRequest)
+		return
+	}
+	networks, err := h.app.ValidatePreferredNetworks(r.Context(), req.Networks)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid preferred networks: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	existing, exists, err := h.app.Store.CheckWalletExistsInSmsService(r.Context(), req.WalletAddress)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		existing = &storage.SmsService{WalletAddress: req.WalletAddress}
+	}
+
+	// Changing the passkey of a wallet with a phone on file takes a 2FA code sent to it
+	passkey := existing.Passkey
+	if req.Passkey != "" && req.Passkey != existing.Passkey {
+		if existing.PhoneNumber != "" {
+			verified, err := h.app.Verify2FACode(r.Context(), existing.PhoneNumber, req.Code)
+			if errors.Is(err, services.ErrTooManyAttempts) {
+				http.Error(w, "Too many failed 2FA attempts, try again later", http.StatusTooManyRequests)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !verified {
+				http.Error(w, "Invalid 2FA code", http.StatusUnauthorized)
+				return
+			}
+		}
+		passkey = req.Passkey
+	}
+
+	// Tightening applies right away and cancels pending loosening of the same limits;
+	// loosening an existing wallet's limits waits out the cooling-off period
+	limit := existing.Limit
+	pending := []storage.LimitChange{}
+	if req.Limit != nil {
+		switch {
+		case exists && *req.Limit > existing.Limit:
+			change, err := h.app.ScheduleLimitIncrease(r.Context(), existing, *req.Limit)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			pending = append(pending, *change)
+		case *req.Limit != existing.Limit:
+			limit = *req.Limit
+			if err := h.app.CancelPendingLimitChanges(r.Context(), req.WalletAddress, storage.LimitChangeKindLimit); err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	err = h.app.Store.UpdateSmsService(r.Context(), req.WalletAddress, passkey, limit)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Velocity limits are only replaced when the request includes them
+	if req.VelocityLimits != nil {
+		if exists && services.LoosensVelocityLimits(existing.VelocityLimits, req.VelocityLimits) {
+			change, err := h.app.ScheduleVelocityLimitChange(r.Context(), existing, req.VelocityLimits)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			pending = append(pending, *change)
+		} else {
+			err = h.app.Store.UpdateVelocityLimits(r.Context(), req.WalletAddress, req.VelocityLimits)
+			if err == nil && services.LoosensVelocityLimits(req.VelocityLimits, existing.VelocityLimits) {
+				err = h.app.CancelPendingLimitChanges(r.Context(), req.WalletAddress, storage.LimitChangeKindVelocity)
+			}
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if req.Language != "" {
+		err = h.app.Store.UpdateLanguage(r.Context(), req.WalletAddress, req.Language)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Networks != nil {
+		err = h.app.Store.UpdatePreferredNetworks(r.Context(), req.WalletAddress, networks)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := struct {
+		Status         string                `json:"status"`
+		Message        string                `json:"message"`
+		PendingChanges []storage.LimitChange `json:"pending_changes,omitempty"`
+	}{
+		Status:         "success",
+		Message:        "SMS service updated successfully",
+		PendingChanges: pending,
+	}
+	if len(pending) > 0 {
+		response.Message = "SMS service updated; limit changes pending"
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) UpdateAlias(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		WalletAddress string  `json:"wallet_address"`
-		Passkey       string  `json:"passkey"`
-		Limit         float64 `json:"limit"`
+		WalletAddress string `json:"wallet_address"`
+		Alias         string `json:"alias"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
-	err := storage.UpdateSmsService(r.Context(), req.WalletAddress, req.Passkey, req.Limit)
+	alias := strings.ToLower(strings.TrimPrefix(req.Alias, "@"))
+	if !aliasPattern.MatchString(alias) {
+		http.Error(w, "Alias must be 3-20 letters, digits or underscores", http.StatusBadRequest)
+		return
+	}
+
+	existing, exists, err := h.app.Store.CheckAliasExistsInSmsService(r.Context(), alias)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if exists && existing.WalletAddress != req.WalletAddress {
+		http.Error(w, "Alias already taken", http.StatusConflict)
+		return
+	}
+
+	err = h.app.Store.UpdateAlias(r.Context(), req.WalletAddress, alias)
+	if errors.Is(err, storage.ErrDuplicate) {
+		http.Error(w, "Alias already taken", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -109,13 +287,13 @@ func UpdateSmsService(w http.ResponseWriter, r *http.Request) {
 		Message string `json:"message"`
 	}{
 		Status:  "success",
-		Message: "SMS service updated successfully",
+		Message: "Alias updated successfully",
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
-func UpdatePhoneNumber(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdatePhoneNumber(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		PhoneNumber   string `json:"phone_number"`
 		WalletAddress string `json:"wallet_address"`
@@ -125,8 +303,13 @@ func UpdatePhoneNumber(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
-	err := storage.UpdatePhoneNumber(r.Context(), req.WalletAddress, req.PhoneNumber)
+	err := h.app.Store.UpdatePhoneNumber(r.Context(), req.WalletAddress, req.PhoneNumber)
+	if errors.Is(err, storage.ErrDuplicate) {
+		http.Error(w, "Phone number already linked to another account", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -169,9 +352,9 @@ func SendDummySMS(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(response)
 }
-func ListSmsServicesHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ListSmsServicesHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.TODO()
-	services, err := storage.ListSmsServices(ctx)
+	services, err := h.app.Store.ListSmsServices(ctx)
 	if err != nil {
 		http.Error(w, "Failed to list SMS services", http.StatusInternalServerError)
 		return
@@ -181,7 +364,7 @@ func ListSmsServicesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(services)
 }
 
-func UpdatePhoneNumberHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdatePhoneNumberHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		PhoneNumber  string `json:"phone_number"`
 		WalletAddress string `json:"wallet_address"`
@@ -192,9 +375,10 @@ func UpdatePhoneNumberHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	req.WalletAddress = services.CanonicalWalletAddress(req.WalletAddress)
 
 	// Update the phone number in the database
-	err = storage.UpdatePhoneNumber(context.TODO(), req.WalletAddress, req.PhoneNumber)
+	err = h.app.Store.UpdatePhoneNumber(context.TODO(), req.WalletAddress, req.PhoneNumber)
 	if err != nil {
 		http.Error(w, "Failed to update phone number", http.StatusInternalServerError)
 		return
@@ -2,26 +2,47 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"crypto-sms/services"
-	"crypto-sms/utils"
 )
 
 type ParsedSMS struct {
+	// RecipientAddress is a wallet address, an E.164 phone number or an @alias
 	RecipientAddress string  `json:"recipient_address"`
 	RecipientCrypto  string  `json:"recipient_crypto"`
 	AmountUSD        float64 `json:"amount_usd"`
 	Crypto           string  `json:"crypto"`
+	Network          string  `json:"network"`
 	Passkey          string  `json:"passkey"`
 	Checksum         string  `json:"checksum"`
 }
 
+// newSmsCommands maps the leading keyword of an SMS to the service that handles it.
+// Messages that don't start with a known keyword are parsed as transfers.
+func newSmsCommands(app *services.App) map[string]func(phoneNumber string, args []string) error {
+	return map[string]func(phoneNumber string, args []string) error{
+		"BAL":     app.ProcessBalanceInquiry,
+		"CANCEL":  app.ProcessCancelCommand,
+		"CLAIM":   app.ProcessClaimCommand,
+		"DEPOSIT": app.ProcessDepositCommand,
+		"REQ":     app.ProcessRequestCommand,
+		"PAY":     app.ProcessPayCommand,
+		"DECLINE": app.ProcessDeclineCommand,
+		"EVERY":   app.ProcessEveryCommand,
+		"SKIP":    app.ProcessSkipCommand,
+		"APPROVE": app.ProcessApproveCommand,
+		"DENY":    app.ProcessDenyCommand,
+		"PROOF":   app.ProcessProofCommand,
+		"VERIFY":  app.ProcessVerifyCommand,
+	}
+}
+
 // HandleTwilioWebhook handles incoming SMS messages from Twilio
-func HandleTwilioWebhook(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleTwilioWebhook(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, "Failed to parse form data", http.StatusInternalServerError)
@@ -41,29 +62,41 @@ func HandleTwilioWebhook(w http.ResponseWriter, r *http.Request) {
 		from = "+" + from
 	}
 
+	// Dispatch keyword commands before falling back to transfer parsing
+	if fields := strings.Fields(body); len(fields) > 0 {
+		if command, ok := h.smsCommands[strings.ToUpper(fields[0])]; ok {
+			if err := command(from, fields[1:]); err != nil {
+				http.Error(w, fmt.Sprintf("Command failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Message received"))
+			return
+		}
+	}
+
 	// Parse the SMS content
 	parsedSMS, err := ParseSMSContent(body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse SMS content: %v", err), http.StatusBadRequest)
-		utils.SendSMS(from, os.Getenv("TWILIO_PHONE_NUMBER"), "Failed to parse SMS content")
+		services.ReplyTransferError(from, services.DefaultLanguage, services.NewTransferError(services.ErrCodeMalformedRequest, err))
+		http.Error(w, fmt.Sprintf("%s: %v", services.ErrCodeMalformedRequest, err), http.StatusBadRequest)
 		return
 	}
 
-	// Add phone number to parsed details
-	transactionDetails := map[string]interface{}{
-		"phone_number":       from,
-		"recipient_address":  parsedSMS.RecipientAddress,
-		"recipient_crypto":   parsedSMS.RecipientCrypto,
-		"amount_usd":         parsedSMS.AmountUSD,
-		"crypto":             parsedSMS.Crypto,
-		"passkey":            parsedSMS.Passkey,
-		"checksum":           parsedSMS.Checksum,
-	}
-
 	// Process the transaction
-	err = services.ProcessTransaction(transactionDetails)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Transaction failed: %v", err), http.StatusInternalServerError)
+	err = h.app.ProcessTransaction(services.TransferRequest{
+		PhoneNumber:      from,
+		Passkey:          parsedSMS.Passkey,
+		AmountUSD:        parsedSMS.AmountUSD,
+		Crypto:           parsedSMS.Crypto,
+		RecipientAddress: parsedSMS.RecipientAddress,
+		RecipientCrypto:  parsedSMS.RecipientCrypto,
+		Network:          parsedSMS.Network,
+	})
+	// A parked transfer has already told the sender what to do next
+	if err != nil && !errors.Is(err, services.ErrTransferPending) {
+		transferErr := services.AsTransferError(err)
+		http.Error(w, fmt.Sprintf("Transaction failed: %v", transferErr), transferErr.HTTPStatus())
 		return
 	}
 
@@ -80,17 +113,18 @@ func ParseSMSContent(content string) (ParsedSMS, error) {
 	}
 
 	query := fmt.Sprintf(`Parse the following SMS content and extract the following information:
-    - Recipient Address
+    - Recipient Address (a wallet address, a phone number in E.164 format, or an @alias)
     - Recipient Crypto
     - Amount to send in USD
     - Cryptocurrency to use
+    - Network the recipient is paid on, if stated (for example "USDT on tron"), otherwise empty
     - Passkey
     - Checksum 
 
     SMS Content:
     %s
 
-    Return the result as a JSON object with keys: recipient_address, recipient_crypto, amount_usd, crypto, passkey, checksum`, content)
+    Return the result as a JSON object with keys: recipient_address, recipient_crypto, amount_usd, crypto, network, passkey, checksum`, content)
 
 	response, err := querySession(query, sessionId)
 	if err != nil {
@@ -0,0 +1,113 @@
+// Package kms holds the master keys that wrap the data keys encrypting sensitive fields.
+// KMS is the interface a key management service is reached through; FileKMS stands in for
+// one with master keys read from a local key file.
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// ErrUnknownKey is returned when a master key isn't held by the KMS, for example because it
+// was removed from the key file before the data keys it wrapped were rewrapped
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KMS encrypts and decrypts data keys under master keys that never leave it. New data keys
+// are wrapped under the current master key; older master keys only unwrap.
+type KMS interface {
+	Name() string
+	CurrentKeyID() string
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// keyIDPattern keeps key IDs safe to embed in the key IDs of the data keys they wrap
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// FileKMS wraps data keys with AES-256-GCM master keys read from a key file. The file is
+// JSON naming the current key and holding every key base64 encoded:
+//
+//	{"current": "2026-10", "keys": {"2026-10": "<32 bytes>", "2026-01": "<32 bytes>"}}
+type FileKMS struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// LoadKeyFile reads the master keys from a key file
+func LoadKeyFile(path string) (*FileKMS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing key file: %w", err)
+	}
+
+	keys := map[string]cipher.AEAD{}
+	for id, encoded := range file.Keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("key ID %q must be 1-64 letters, digits, dashes or underscores", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 base64-encoded bytes", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := keys[file.Current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the key file", file.Current)
+	}
+	return &FileKMS{current: file.Current, keys: keys}, nil
+}
+
+func (*FileKMS) Name() string {
+	return "file"
+}
+
+func (k *FileKMS) CurrentKeyID() string {
+	return k.current
+}
+
+// Encrypt seals plaintext under a master key, prefixed with its random nonce
+func (k *FileKMS) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, keyID)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(keyID)), nil
+}
+
+// Decrypt opens ciphertext sealed by Encrypt under the same master key
+func (k *FileKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, keyID)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(keyID))
+}
@@ -1,32 +1,337 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"crypto-sms/chain"
+	"crypto-sms/custody"
 	"crypto-sms/handlers"
+	"crypto-sms/kms"
+	"crypto-sms/services"
 	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+	"crypto-sms/storage/postgres"
 )
 
 func main() {
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		log.Fatal("MONGODB_URI environment variable is required")
-	}
-	storage.InitMongoDB(mongoURI)
-
-	http.HandleFunc("/twilio-webhook", handlers.HandleTwilioWebhook)
-	http.HandleFunc("/check-sms-service", handlers.CheckSMSServiceExists)
-	http.HandleFunc("/create-sms-service", handlers.CreateSMSService)
-	http.HandleFunc("/generate-2fa-code", handlers.Generate2FACode)
-	http.HandleFunc("/verify-2fa-code", handlers.Verify2FACode)
-	http.HandleFunc("/update-phone-number", handlers.UpdatePhoneNumber)
-	http.HandleFunc("/update-sms-service", handlers.UpdateSmsService)
+	store, err := openStore()
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(store, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if err := migrateOnStartup(store); err != nil {
+		log.Fatalf("Failed to migrate store: %v", err)
+	}
+
+	provider, err := custodyProvider()
+	if err != nil {
+		log.Fatalf("Failed to configure custody provider: %v", err)
+	}
+	app := services.NewApp(store, provider)
+	log.Printf("Holding balances with the %s custody provider", app.Custody().Name())
+
+	if err := app.SeedAssets(context.Background()); err != nil {
+		log.Fatalf("Failed to seed assets: %v", err)
+	}
+
+	if path := os.Getenv("RISK_RULES_FILE"); path != "" {
+		if err := app.LoadRiskConfig(path); err != nil {
+			log.Fatalf("Failed to load risk rules: %v", err)
+		}
+	}
+
+	if err := registerChainAdapters(app); err != nil {
+		log.Fatalf("Failed to configure chain adapters: %v", err)
+	}
+
+	// Background jobs only run on the replica holding each job's lease
+	ctx := context.Background()
+	go services.RunPeriodically(ctx, "escrow expiry", time.Minute, app.LeaderOnly("escrow-expiry", app.ExpireEscrows))
+	go services.RunPeriodically(ctx, "payment request expiry", time.Minute, app.LeaderOnly("payment-request-expiry", app.ExpirePaymentRequests))
+	go services.RunPeriodically(ctx, "scheduled transfers", time.Minute, app.LeaderOnly("scheduled-transfers", app.RunScheduledTransfers))
+	go services.RunPeriodically(ctx, "guardian approval expiry", time.Minute, app.LeaderOnly("guardian-approval-expiry", app.ExpirePendingTransfers))
+	go services.RunPeriodically(ctx, "risk challenge expiry", time.Minute, app.LeaderOnly("risk-challenge-expiry", app.ExpireRiskChallenges))
+	go services.RunPeriodically(ctx, "withdrawals", 15*time.Second, app.LeaderOnly("withdrawals", app.ProcessWithdrawals))
+	go services.RunPeriodically(ctx, "deposits", 15*time.Second, app.LeaderOnly("deposits", app.WatchDeposits))
+	go services.RunPeriodically(ctx, "reconciliation", reconciliationInterval(), app.LeaderOnly("reconciliation", app.RunReconciliation))
+	go services.RunPeriodically(ctx, "proof of reserves", reservesInterval(), app.LeaderOnly("proof-of-reserves", app.PublishReserveSnapshot))
+	go services.RunPeriodically(ctx, "re-encryption", reencryptionInterval(), app.LeaderOnly("re-encryption", app.ReencryptSecrets))
+
+	tokens, err := adminTokens()
+	if err != nil {
+		log.Fatalf("Failed to read admin tokens: %v", err)
+	}
+	if len(tokens) == 0 {
+		log.Printf("ADMIN_TOKENS is unset; admin routes refuse every request")
+	}
+	h := handlers.NewHandler(app, tokens)
+	http.HandleFunc("/twilio-webhook", h.HandleTwilioWebhook)
+	http.HandleFunc("/check-sms-service", h.CheckSMSServiceExists)
+	http.HandleFunc("/create-sms-service", h.CreateSMSService)
+	http.HandleFunc("/generate-2fa-code", h.Generate2FACode)
+	http.HandleFunc("/verify-2fa-code", h.Verify2FACode)
+	http.HandleFunc("/update-phone-number", h.UpdatePhoneNumber)
+	http.HandleFunc("/update-sms-service", h.UpdateSmsService)
+	http.HandleFunc("/update-alias", h.UpdateAlias)
 	http.HandleFunc("/send-dummy-sms", handlers.SendDummySMS)
+	http.HandleFunc("/get-balances", h.GetBalances)
+	http.HandleFunc("/list-limit-changes", h.ListLimitChanges)
+	http.HandleFunc("/cancel-limit-change", h.CancelLimitChange)
+	http.HandleFunc("/create-fee-schedule", h.RequireAdmin(h.CreateFeeSchedule))
+	http.HandleFunc("/list-fee-schedules", h.ListFeeSchedules)
+	http.HandleFunc("/quote-fee", h.QuoteFee)
+	http.HandleFunc("/create-payment-request", h.CreatePaymentRequest)
+	http.HandleFunc("/respond-payment-request", h.RespondPaymentRequest)
+	http.HandleFunc("/get-history", h.GetHistory)
+	http.HandleFunc("/create-scheduled-transfer", h.CreateScheduledTransfer)
+	http.HandleFunc("/list-scheduled-transfers", h.ListScheduledTransfers)
+	http.HandleFunc("/skip-scheduled-transfer", h.SkipScheduledTransfer)
+	http.HandleFunc("/cancel-scheduled-transfer", h.CancelScheduledTransfer)
+	http.HandleFunc("/update-guardians", h.UpdateGuardians)
+	http.HandleFunc("/list-pending-transfers", h.ListPendingTransfers)
+	http.HandleFunc("/list-risk-decisions", h.RequireAdmin(h.ListRiskDecisions))
+	http.HandleFunc("/reverse-transaction", h.RequireAdmin(h.ReverseTransaction))
+	http.HandleFunc("/list-assets", h.ListAssets)
+	http.HandleFunc("/save-asset", h.RequireAdmin(h.SaveAsset))
+	http.HandleFunc("/set-asset-enabled", h.RequireAdmin(h.SetAssetEnabled))
+	http.HandleFunc("/list-withdrawals", h.ListWithdrawals)
+	http.HandleFunc("/list-deposits", h.ListDeposits)
+	http.HandleFunc("/custody-webhook", h.CustodyWebhook)
+	http.HandleFunc("/get-reconciliation-report", h.RequireAdmin(h.GetReconciliationReport))
+	http.HandleFunc("/run-reconciliation", h.RequireAdmin(h.RunReconciliation))
+	http.HandleFunc("/list-wallet-freezes", h.RequireAdmin(h.ListWalletFreezes))
+	http.HandleFunc("/resolve-reconciliation", h.RequireAdmin(h.ResolveReconciliation))
+	http.HandleFunc("/get-reserve-snapshot", h.GetReserveSnapshot)
+	http.HandleFunc("/list-reserve-snapshots", h.ListReserveSnapshots)
+	http.HandleFunc("/get-reserve-proof", h.GetReserveProof)
+	http.HandleFunc("/verify-reserve-proof", h.VerifyReserveProof)
+	if os.Getenv("SIMULATED_CHAIN") != "" {
+		http.HandleFunc("/simulate-deposit", h.SimulateDeposit)
+	}
 
 	log.Println("HTTP server listening on port 8080")
 	if err := http.ListenAndServe(":8080", nil); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
 }
+
+// reconciliationInterval returns how often balances are reconciled, from
+// RECONCILIATION_INTERVAL (default one hour)
+func reconciliationInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("RECONCILIATION_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return time.Hour
+}
+
+// reservesInterval returns how often a proof of reserves is published, from
+// RESERVES_INTERVAL (default one day)
+func reservesInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("RESERVES_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 24 * time.Hour
+}
+
+// reencryptionInterval returns how often secrets sealed under retired keys are re-encrypted,
+// from REENCRYPTION_INTERVAL (default one hour)
+func reencryptionInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("REENCRYPTION_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return time.Hour
+}
+
+// openStore opens the store chosen with STORAGE_BACKEND: mongo, the default, keeps records
+// in the MongoDB at MONGODB_URI and postgres in the PostgreSQL database at POSTGRES_URL, both
+// encrypting sensitive fields under the master keys in ENCRYPTION_KEY_FILE, and memory keeps
+// them in process until it exits
+func openStore() (*storage.Store, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "mongo":
+		mongoURI := os.Getenv("MONGODB_URI")
+		if mongoURI == "" {
+			return nil, errors.New("MONGODB_URI environment variable is required")
+		}
+		masterKeys, err := masterKeys()
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewMongoStore(storage.InitMongoDB(mongoURI), masterKeys)
+	case "postgres":
+		postgresURL := os.Getenv("POSTGRES_URL")
+		if postgresURL == "" {
+			return nil, errors.New("POSTGRES_URL environment variable is required")
+		}
+		masterKeys, err := masterKeys()
+		if err != nil {
+			return nil, err
+		}
+		db, err := postgres.Open(postgresURL)
+		if err != nil {
+			return nil, err
+		}
+		return postgres.NewStore(db, masterKeys)
+	case "memory":
+		return memory.NewStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// masterKeys loads the master keys that wrap the field encryption keys from the key file at
+// ENCRYPTION_KEY_FILE
+func masterKeys() (kms.KMS, error) {
+	path := os.Getenv("ENCRYPTION_KEY_FILE")
+	if path == "" {
+		return nil, errors.New("ENCRYPTION_KEY_FILE environment variable is required")
+	}
+	keys, err := kms.LoadKeyFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading encryption keys: %w", err)
+	}
+	log.Printf("Encrypting fields under master key %s from the %s KMS", keys.CurrentKeyID(), keys.Name())
+	return keys, nil
+}
+
+// migrateOnStartup applies pending migrations before the service starts. With
+// MIGRATE_ON_STARTUP=false they are left to the migrate command, and the service refuses to
+// start until they have run.
+func migrateOnStartup(store *storage.Store) error {
+	ctx := context.Background()
+	if os.Getenv("MIGRATE_ON_STARTUP") != "false" {
+		applied, err := store.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			log.Printf("Applied %d migrations", len(applied))
+		}
+		return nil
+	}
+
+	migrations, err := store.ListMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.AppliedAt.IsZero() {
+			return fmt.Errorf("migration %d is pending; apply it with the migrate command", migration.Version)
+		}
+	}
+	return nil
+}
+
+// migrateCommand runs the migrate command: "migrate" applies pending migrations and
+// "migrate status" lists every migration with when it was applied
+func migrateCommand(store *storage.Store, args []string) error {
+	ctx := context.Background()
+	switch {
+	case len(args) == 0:
+		applied, err := store.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			fmt.Printf("Applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		return nil
+	case len(args) == 1 && args[0] == "status":
+		migrations, err := store.ListMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			state := "pending"
+			if !migration.AppliedAt.IsZero() {
+				state = "applied " + migration.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%3d  %-30s  %s\n", migration.Version, state, migration.Description)
+		}
+		return nil
+	default:
+		return errors.New("usage: migrate [status]")
+	}
+}
+
+// adminTokens reads the admin API credentials from ADMIN_TOKENS, a comma-separated list of
+// operator:token pairs. Each operator's name is recorded on the changes they make.
+func adminTokens() ([]handlers.AdminToken, error) {
+	tokens := []handlers.AdminToken{}
+	for _, entry := range strings.Split(os.Getenv("ADMIN_TOKENS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		operator, token, found := strings.Cut(entry, ":")
+		if !found || strings.TrimSpace(operator) == "" || len(token) < 16 {
+			return nil, fmt.Errorf("admin token entries must be operator:token with a token of at least 16 characters")
+		}
+		tokens = append(tokens, handlers.AdminToken{Operator: strings.TrimSpace(operator), Token: token})
+	}
+	return tokens, nil
+}
+
+// custodyProvider chooses where balances are held from CUSTODY_PROVIDER: store, the
+// default, keeps them in the store's custodian repository and http delegates them to the
+// remote custodian at CUSTODY_URL
+func custodyProvider() (custody.Provider, error) {
+	switch provider := os.Getenv("CUSTODY_PROVIDER"); provider {
+	case "", "store", "mongo":
+		return nil, nil
+	case "http":
+		baseURL := os.Getenv("CUSTODY_URL")
+		if baseURL == "" {
+			return nil, errors.New("CUSTODY_URL is required for the http custody provider")
+		}
+		webhookSecret := os.Getenv("CUSTODY_WEBHOOK_SECRET")
+		if webhookSecret == "" {
+			return nil, errors.New("CUSTODY_WEBHOOK_SECRET is required for the http custody provider")
+		}
+		return custody.NewHTTPProvider(baseURL, os.Getenv("CUSTODY_API_KEY"), webhookSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown custody provider %q", provider)
+	}
+}
+
+// registerChainAdapters sets up on-chain settlement and deposit watching from the environment.
+// EVM_RPC_URL, EVM_CHAIN_ID and EVM_HOT_WALLET_KEY reach Ethereum through a node, and
+// SIMULATED_CHAIN lists networks to run on an in-memory chain during development.
+func registerChainAdapters(app *services.App) error {
+	if rpcURL := os.Getenv("EVM_RPC_URL"); rpcURL != "" {
+		chainID, err := strconv.ParseInt(os.Getenv("EVM_CHAIN_ID"), 10, 64)
+		if err != nil {
+			return err
+		}
+		adapter, err := chain.NewEVMAdapter(services.ChainEthereum, rpcURL, chainID, os.Getenv("EVM_HOT_WALLET_KEY"))
+		if err != nil {
+			return err
+		}
+		app.RegisterChainAdapter(adapter)
+		log.Printf("Settling %s withdrawals from %s and watching deposits", services.ChainEthereum, adapter.HotWallet())
+	}
+
+	for _, network := range strings.Split(os.Getenv("SIMULATED_CHAIN"), ",") {
+		if network = strings.TrimSpace(network); network != "" {
+			app.RegisterChainAdapter(chain.NewSimulatedChain(network, 12*time.Second))
+			log.Printf("Running %s on a simulated chain", network)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"crypto-sms/utils"
+)
+
+// Networks whose wallet addresses are validated before transfers
+const (
+	ChainBitcoin  = "bitcoin"
+	ChainEthereum = "ethereum"
+	ChainTron     = "tron"
+)
+
+// tronVersion is the base58check version byte of Tron addresses, which start with T
+const tronVersion = 0x41
+
+// bitcoinVersions are the accepted base58check version bytes: P2PKH and P2SH on mainnet and testnet
+var bitcoinVersions = map[byte]bool{0x00: true, 0x05: true, 0x6f: true, 0xc4: true}
+
+// bitcoinHRPs are the accepted segwit human-readable parts: mainnet, testnet and regtest
+var bitcoinHRPs = map[string]bool{"bc": true, "tb": true, "bcrt": true}
+
+// NormalizeWalletAddress validates address for network and returns its canonical form.
+// EVM addresses are EIP-55 checksummed, as CanonicalWalletAddress stores them; bech32
+// addresses are lower-cased. Networks without a validator pass addresses through unchanged.
+func NormalizeWalletAddress(network string, address string) (string, error) {
+	switch network {
+	case ChainEthereum:
+		if err := utils.ValidateEVMAddress(address); err != nil {
+			return "", err
+		}
+		return utils.ChecksumEVMAddress(address), nil
+	case ChainBitcoin:
+		if lower := strings.ToLower(address); strings.HasPrefix(lower, "bc1") || strings.HasPrefix(lower, "tb1") || strings.HasPrefix(lower, "bcrt1") {
+			hrp, _, _, err := utils.DecodeSegwitAddress(address)
+			if err != nil {
+				return "", err
+			}
+			if !bitcoinHRPs[hrp] {
+				return "", fmt.Errorf("unknown network %q", hrp)
+			}
+			return lower, nil
+		}
+		version, payload, err := utils.DecodeBase58Check(address)
+		if err != nil {
+			return "", err
+		}
+		if !bitcoinVersions[version] || len(payload) != 20 {
+			return "", fmt.Errorf("not a bitcoin address")
+		}
+		return address, nil
+	case ChainTron:
+		version, payload, err := utils.DecodeBase58Check(address)
+		if err != nil {
+			return "", err
+		}
+		if version != tronVersion || len(payload) != 20 {
+			return "", fmt.Errorf("not a tron address")
+		}
+		return address, nil
+	}
+	return address, nil
+}
+
+// CanonicalWalletAddress returns the form wallet addresses are stored and looked up in, so
+// the same wallet typed in any case maps to one record: valid EVM addresses are EIP-55
+// checksummed and anything else is returned unchanged
+func CanonicalWalletAddress(address string) string {
+	if utils.ValidateEVMAddress(address) != nil {
+		return address
+	}
+	return utils.ChecksumEVMAddress(address)
+}
+
+// normalizeWalletAddressOnAny accepts address on the first of networks it is valid on,
+// returning the canonical address and that network. When none accept it, the first
+// network's error is returned.
+func normalizeWalletAddressOnAny(networks []string, address string) (string, string, error) {
+	var firstErr error
+	for _, network := range networks {
+		normalized, err := NormalizeWalletAddress(network, address)
+		if err == nil {
+			return normalized, network, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return "", "", fmt.Errorf("asset has no networks")
+	}
+	return "", "", firstErr
+}
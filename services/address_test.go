@@ -0,0 +1,47 @@
+package services
+
+import "testing"
+
+func TestNormalizeWalletAddress(t *testing.T) {
+	tests := []struct {
+		network string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{ChainEthereum, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{ChainEthereum, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{ChainEthereum, "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{ChainEthereum, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", "", true},
+		{ChainEthereum, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", "", true},
+		{ChainBitcoin, "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", false},
+		{ChainBitcoin, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", false},
+		{ChainBitcoin, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", "", true},
+	}
+	for _, test := range tests {
+		got, err := NormalizeWalletAddress(test.network, test.address)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("NormalizeWalletAddress(%s, %s) = %q, %v, want %q with error %v", test.network, test.address, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestCanonicalWalletAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"},
+		{"0xFB6916095CA1DF60BB79CE92CE3EA74C37C5D359", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"},
+		{"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"},
+		// Anything that isn't a valid EVM address is left for the lookup to miss
+		{"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d35A", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d35A"},
+		{"TLa2f6VPqDgRE67v1736s7bJ8Ray5wYjU7", "TLa2f6VPqDgRE67v1736s7bJ8Ray5wYjU7"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := CanonicalWalletAddress(test.address); got != test.want {
+			t.Errorf("CanonicalWalletAddress(%q) = %q, want %q", test.address, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"sync"
+
+	"crypto-sms/chain"
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+)
+
+// App runs the service's operations against a store, a custody provider, the chain adapters
+// registered with it and its risk rules. Handlers and background jobs share one App,
+// constructed at startup.
+type App struct {
+	Store   *storage.Store
+	custody custody.Provider
+
+	chainAdaptersMu sync.RWMutex
+	chainAdapters   map[string]chain.ChainAdapter
+
+	riskConfig RiskConfig
+
+	// Parsed DEPOSIT_XPUB_<NETWORK> account keys and the addresses derived from them
+	depositAddressesMu sync.Mutex
+	accountKeys        map[string]*chain.ExtendedPublicKey
+	derivedAddresses   map[string]string
+}
+
+// NewApp returns an app keeping records in store and balances with provider. A nil provider
+// keeps balances in the store's custodian repository.
+func NewApp(store *storage.Store, provider custody.Provider) *App {
+	if provider == nil {
+		provider = custody.StoreProvider{Custodians: store.CustodianRepository}
+	}
+	return &App{
+		Store:         store,
+		custody:       provider,
+		chainAdapters: map[string]chain.ChainAdapter{},
+		riskConfig:    DefaultRiskConfig(),
+
+		accountKeys:      map[string]*chain.ExtendedPublicKey{},
+		derivedAddresses: map[string]string{},
+	}
+}
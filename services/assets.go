@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"crypto-sms/storage"
+)
+
+var (
+	// ErrUnknownAsset is returned when a name matches no symbol or alias in the registry
+	ErrUnknownAsset = errors.New("unknown asset")
+	// ErrAssetDisabled is returned when an asset exists but transfers of it are switched off
+	ErrAssetDisabled = errors.New("asset disabled")
+)
+
+// defaultAssets seed the registry on first start. Existing entries are never overwritten,
+// so changes made through the admin API survive restarts.
+var defaultAssets = []storage.Asset{
+	{Symbol: "BTC", Name: "Bitcoin", Aliases: []string{"bitcoin", "xbt"}, Decimals: 8, Networks: []string{ChainBitcoin}, Enabled: true},
+	{Symbol: "ETH", Name: "Ether", Aliases: []string{"ether", "ethereum"}, Decimals: 18, Networks: []string{ChainEthereum}, Enabled: true},
+	{Symbol: "USDT", Name: "Tether", Aliases: []string{"tether"}, Decimals: 6, Networks: []string{ChainEthereum, ChainTron}, Enabled: true,
+		Contracts: map[string]string{ChainEthereum: "0xdAC17F958D2ee523a2206206994597C13D831ec7", ChainTron: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"}},
+	{Symbol: "USDC", Name: "USD Coin", Aliases: []string{"usd coin", "usdcoin"}, Decimals: 6, Networks: []string{ChainEthereum}, Enabled: true,
+		Contracts: map[string]string{ChainEthereum: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"}},
+}
+
+// SeedAssets adds any default asset missing from the registry
+func (app *App) SeedAssets(ctx context.Context) error {
+	for _, asset := range defaultAssets {
+		asset := asset
+		if err := app.Store.InsertAssetIfMissing(ctx, &asset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LookupAsset resolves a symbol or alias to its registry entry, enabled or not
+func (app *App) LookupAsset(ctx context.Context, name string) (*storage.Asset, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrUnknownAsset
+	}
+	asset, exists, err := app.Store.FindAsset(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrUnknownAsset
+	}
+	return asset, nil
+}
+
+// ResolveAsset resolves a symbol or alias to an enabled asset
+func (app *App) ResolveAsset(ctx context.Context, name string) (*storage.Asset, error) {
+	asset, err := app.LookupAsset(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !asset.Enabled {
+		return nil, ErrAssetDisabled
+	}
+	return asset, nil
+}
+
+// SupportsNetwork reports whether asset settles on network
+func SupportsNetwork(asset *storage.Asset, network string) bool {
+	for _, supported := range asset.Networks {
+		if supported == network {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultNetwork returns the network deposits and withdrawals of asset use for a user
+// with the given preferences, which may be nil
+func DefaultNetwork(asset *storage.Asset, preferred map[string]string) string {
+	if network := preferred[asset.Symbol]; network != "" && SupportsNetwork(asset, network) {
+		return network
+	}
+	if len(asset.Networks) == 0 {
+		return ""
+	}
+	return asset.Networks[0]
+}
+
+// ValidatePreferredNetworks resolves each asset in preferences to its registry symbol and
+// checks the network is one the asset settles on
+func (app *App) ValidatePreferredNetworks(ctx context.Context, preferences map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(preferences))
+	for name, network := range preferences {
+		asset, err := app.LookupAsset(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		network = strings.ToLower(strings.TrimSpace(network))
+		if !SupportsNetwork(asset, network) {
+			return nil, fmt.Errorf("%s is not available on %q", asset.Symbol, network)
+		}
+		normalized[asset.Symbol] = network
+	}
+	return normalized, nil
+}
+
+// ValidateAsset normalizes a registry entry from the admin API and checks its fields
+func ValidateAsset(asset *storage.Asset) error {
+	asset.Symbol = strings.ToUpper(strings.TrimSpace(asset.Symbol))
+	if asset.Symbol == "" {
+		return fmt.Errorf("symbol is required")
+	}
+	for i, alias := range asset.Aliases {
+		asset.Aliases[i] = strings.ToLower(strings.TrimSpace(alias))
+	}
+	if asset.Decimals < 0 || asset.Decimals > 36 {
+		return fmt.Errorf("decimals must be between 0 and 36")
+	}
+	if len(asset.Networks) == 0 {
+		return fmt.Errorf("at least one network is required")
+	}
+	for i, network := range asset.Networks {
+		asset.Networks[i] = strings.ToLower(strings.TrimSpace(network))
+	}
+	for network := range asset.Contracts {
+		if !SupportsNetwork(asset, network) {
+			return fmt.Errorf("contract given for unlisted network %q", network)
+		}
+	}
+	if asset.MinTransferUSD < 0 || asset.MaxTransferUSD < 0 {
+		return fmt.Errorf("transfer bounds can't be negative")
+	}
+	if asset.MaxTransferUSD > 0 && asset.MaxTransferUSD < asset.MinTransferUSD {
+		return fmt.Errorf("max_transfer_usd is below min_transfer_usd")
+	}
+	return nil
+}
+
+// assetTransferError maps a registry lookup failure for name to the error sent to the user
+func assetTransferError(name string, err error) *TransferError {
+	switch {
+	case errors.Is(err, ErrUnknownAsset):
+		return NewTransferError(ErrCodeUnknownAsset, err, name)
+	case errors.Is(err, ErrAssetDisabled):
+		return NewTransferError(ErrCodeAssetDisabled, err, strings.ToUpper(name))
+	}
+	return NewTransferError(ErrCodeInternal, err)
+}
+
+// checkTransferBounds rejects amounts outside the asset's minimum and maximum transfer
+func checkTransferBounds(asset *storage.Asset, amountUSD float64) *TransferError {
+	if amountUSD < asset.MinTransferUSD {
+		return NewTransferError(ErrCodeBelowMinimum, nil, asset.Symbol, asset.MinTransferUSD)
+	}
+	if asset.MaxTransferUSD > 0 && amountUSD > asset.MaxTransferUSD {
+		return NewTransferError(ErrCodeAboveMaximum, nil, asset.Symbol, asset.MaxTransferUSD)
+	}
+	return nil
+}
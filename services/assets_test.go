@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+func TestResolveAsset(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+	if _, err := app.Store.SetAssetEnabled(ctx, "BTC", false); err != nil {
+		t.Fatalf("disabling asset: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		asset      string
+		want       string
+		lookupErr  error
+		resolveErr error
+	}{
+		{"symbol", "USDC", "USDC", nil, nil},
+		{"lower-case symbol", "usdc", "USDC", nil, nil},
+		{"alias", "tether", "USDT", nil, nil},
+		{"upper-case alias", "ETHEREUM", "ETH", nil, nil},
+		{"alias with a space", "usd coin", "USDC", nil, nil},
+		{"surrounding space", "  eth ", "ETH", nil, nil},
+		{"disabled asset", "BTC", "BTC", nil, ErrAssetDisabled},
+		{"alias of a disabled asset", "bitcoin", "BTC", nil, ErrAssetDisabled},
+		{"unknown", "DOGE", "", ErrUnknownAsset, ErrUnknownAsset},
+		{"empty", " ", "", ErrUnknownAsset, ErrUnknownAsset},
+	}
+	for _, test := range tests {
+		asset, err := app.LookupAsset(ctx, test.asset)
+		if !errors.Is(err, test.lookupErr) {
+			t.Errorf("%s: looking up returned %v, want %v", test.name, err, test.lookupErr)
+		} else if err == nil && asset.Symbol != test.want {
+			t.Errorf("%s: looked up %s, want %s", test.name, asset.Symbol, test.want)
+		}
+
+		asset, err = app.ResolveAsset(ctx, test.asset)
+		if !errors.Is(err, test.resolveErr) {
+			t.Errorf("%s: resolving returned %v, want %v", test.name, err, test.resolveErr)
+		} else if err == nil && asset.Symbol != test.want {
+			t.Errorf("%s: resolved %s, want %s", test.name, asset.Symbol, test.want)
+		}
+	}
+}
+
+func TestSeedAssetsKeepsChanges(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+	if _, err := app.Store.SetAssetEnabled(ctx, "ETH", false); err != nil {
+		t.Fatalf("disabling asset: %v", err)
+	}
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets again: %v", err)
+	}
+	if _, err := app.ResolveAsset(ctx, "ETH"); !errors.Is(err, ErrAssetDisabled) {
+		t.Errorf("resolving ETH after a restart returned %v, want it still disabled", err)
+	}
+}
+
+func TestValidateAsset(t *testing.T) {
+	tests := []struct {
+		name    string
+		asset   storage.Asset
+		wantErr bool
+	}{
+		{"valid", storage.Asset{Symbol: "dai ", Aliases: []string{" Dai Stablecoin"}, Decimals: 18, Networks: []string{" Ethereum"},
+			Contracts: map[string]string{ChainEthereum: "0x6B175474E89094C44Da98b954EedeAC495271d0F"}}, false},
+		{"missing symbol", storage.Asset{Symbol: " ", Networks: []string{ChainEthereum}}, true},
+		{"negative decimals", storage.Asset{Symbol: "DAI", Decimals: -1, Networks: []string{ChainEthereum}}, true},
+		{"too many decimals", storage.Asset{Symbol: "DAI", Decimals: 37, Networks: []string{ChainEthereum}}, true},
+		{"no networks", storage.Asset{Symbol: "DAI", Decimals: 18}, true},
+		{"contract on an unlisted network", storage.Asset{Symbol: "DAI", Decimals: 18, Networks: []string{ChainEthereum},
+			Contracts: map[string]string{ChainTron: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"}}, true},
+		{"negative bound", storage.Asset{Symbol: "DAI", Networks: []string{ChainEthereum}, MinTransferUSD: -1}, true},
+		{"maximum below minimum", storage.Asset{Symbol: "DAI", Networks: []string{ChainEthereum}, MinTransferUSD: 10, MaxTransferUSD: 5}, true},
+		{"no maximum", storage.Asset{Symbol: "DAI", Networks: []string{ChainEthereum}, MinTransferUSD: 10}, false},
+	}
+	for _, test := range tests {
+		err := ValidateAsset(&test.asset)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+
+	asset := storage.Asset{Symbol: "dai ", Aliases: []string{" Dai Stablecoin"}, Decimals: 18, Networks: []string{" Ethereum"}}
+	if err := ValidateAsset(&asset); err != nil {
+		t.Fatalf("validating: %v", err)
+	}
+	if asset.Symbol != "DAI" || asset.Aliases[0] != "dai stablecoin" || asset.Networks[0] != ChainEthereum {
+		t.Errorf("normalized to %s %q on %q, want DAI \"dai stablecoin\" on %q", asset.Symbol, asset.Aliases[0], asset.Networks[0], ChainEthereum)
+	}
+}
+
+func TestDefaultNetwork(t *testing.T) {
+	usdt := &storage.Asset{Symbol: "USDT", Networks: []string{ChainEthereum, ChainTron}}
+	tests := []struct {
+		name      string
+		asset     *storage.Asset
+		preferred map[string]string
+		want      string
+	}{
+		{"no preferences", usdt, nil, ChainEthereum},
+		{"preference for another asset", usdt, map[string]string{"USDC": ChainTron}, ChainEthereum},
+		{"preferred network", usdt, map[string]string{"USDT": ChainTron}, ChainTron},
+		{"unsupported preference", usdt, map[string]string{"USDT": "solana"}, ChainEthereum},
+		{"asset without networks", &storage.Asset{Symbol: "DAI"}, nil, ""},
+	}
+	for _, test := range tests {
+		if got := DefaultNetwork(test.asset, test.preferred); got != test.want {
+			t.Errorf("%s: network %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidatePreferredNetworks(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+	if _, err := app.Store.SetAssetEnabled(ctx, "BTC", false); err != nil {
+		t.Fatalf("disabling asset: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		preferences map[string]string
+		want        map[string]string
+		wantErr     bool
+	}{
+		{"none", map[string]string{}, map[string]string{}, false},
+		{"symbol", map[string]string{"USDT": ChainTron}, map[string]string{"USDT": ChainTron}, false},
+		{"alias and case normalized", map[string]string{"tether": " TRON "}, map[string]string{"USDT": ChainTron}, false},
+		{"disabled assets can still be set", map[string]string{"BTC": ChainBitcoin}, map[string]string{"BTC": ChainBitcoin}, false},
+		{"unknown asset", map[string]string{"DOGE": ChainEthereum}, nil, true},
+		{"unsupported network", map[string]string{"USDC": ChainTron}, nil, true},
+	}
+	for _, test := range tests {
+		got, err := app.ValidatePreferredNetworks(ctx, test.preferences)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: normalized to %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// SmsSessionTTL is how long a successful passkey check authenticates follow-up commands
+const SmsSessionTTL = 10 * time.Minute
+
+// AssetBalance is a single asset balance with its USD value. Balances are held on the
+// ledger in USD across networks; Amount converts the balance to asset units at the current
+// price, and is only set when Priced. Network is where the wallet's deposits and
+// withdrawals of the asset go.
+type AssetBalance struct {
+	Asset    string  `json:"asset"`
+	Network  string  `json:"network,omitempty"`
+	Amount   float64 `json:"amount"`
+	USDValue float64 `json:"usd_value"`
+	Priced   bool    `json:"priced"`
+
+	// decimals is the asset's precision, or -1 when it isn't registered
+	decimals int
+}
+
+// GetBalances returns the custodian balances for a wallet, optionally filtered to one asset
+func (app *App) GetBalances(ctx context.Context, walletAddress string, asset string) ([]AssetBalance, error) {
+	held, err := app.Custody().Balances(ctx, walletAddress)
+	if err != nil {
+		return nil, err
+	}
+	if len(held) == 0 {
+		return []AssetBalance{}, nil
+	}
+
+	service, _, err := app.Store.CheckWalletExistsInSmsService(ctx, walletAddress)
+	if err != nil {
+		return nil, err
+	}
+	var preferred map[string]string
+	if service != nil {
+		preferred = service.Networks
+	}
+
+	balances := []AssetBalance{}
+	for name, amountUSD := range held {
+		if asset != "" && !strings.EqualFold(name, asset) {
+			continue
+		}
+		balance := AssetBalance{Asset: name, USDValue: amountUSD, decimals: -1}
+		if registered, err := app.LookupAsset(ctx, name); err == nil {
+			balance.Network = DefaultNetwork(registered, preferred)
+			balance.decimals = registered.Decimals
+		}
+		if price, ok := GetPriceUSD(name); ok && price > 0 {
+			balance.Amount = amountUSD / price
+			balance.Priced = true
+		}
+		balances = append(balances, balance)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Asset < balances[j].Asset })
+	return balances, nil
+}
+
+// formatAmount writes amount in plain decimal notation, rounded to the asset's decimals and
+// without trailing zeros. Negative decimals keep every significant digit.
+func formatAmount(amount float64, decimals int) string {
+	formatted := strconv.FormatFloat(amount, 'f', -1, 64)
+	dot := strings.IndexByte(formatted, '.')
+	if decimals < 0 || dot < 0 || len(formatted)-dot-1 <= decimals {
+		return formatted
+	}
+	formatted = strconv.FormatFloat(amount, 'f', decimals, 64)
+	if strings.Contains(formatted, ".") {
+		formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+	}
+	return formatted
+}
+
+// AuthenticateWallet returns the SMS service registered to a wallet when passkey is its passkey.
+// Wrong passkeys count as auth failures against the wallet's phone number.
+func (app *App) AuthenticateWallet(ctx context.Context, walletAddress string, passkey string) (*storage.SmsService, bool, error) {
+	service, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, walletAddress)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	if passkey == "" || service.Passkey != passkey {
+		if service.PhoneNumber != "" {
+			app.Store.RecordAuthFailure(ctx, service.PhoneNumber)
+		}
+		return nil, false, nil
+	}
+	return service, true, nil
+}
+
+// authenticateSmsCommand checks a read-only command's trailing passkey, or else the sender's
+// active session, and extends the session. It returns the arguments without the passkey.
+func (app *App) authenticateSmsCommand(ctx context.Context, service *storage.SmsService, args []string) ([]string, bool, error) {
+	// The passkey, when present, is always the last argument
+	authenticated := false
+	if len(args) > 0 && args[len(args)-1] == service.Passkey {
+		authenticated = true
+		args = args[:len(args)-1]
+	}
+	if !authenticated {
+		active, err := app.Store.HasActiveSmsSession(ctx, service.PhoneNumber)
+		if err != nil {
+			return args, false, fmt.Errorf("error checking session: %w", err)
+		}
+		authenticated = active
+	}
+	if !authenticated {
+		return args, false, nil
+	}
+	if err := app.Store.StartSmsSession(ctx, service.PhoneNumber, SmsSessionTTL); err != nil {
+		return args, false, fmt.Errorf("error starting session: %w", err)
+	}
+	return args, true, nil
+}
+
+// ProcessBalanceInquiry handles the BAL [asset] [passkey] SMS command
+func (app *App) ProcessBalanceInquiry(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("phone number not registered")
+	}
+
+	args, authenticated, err := app.authenticateSmsCommand(ctx, service, args)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return err
+	}
+	if !authenticated {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Invalid passkey. Reply BAL [asset] <passkey>")
+		return fmt.Errorf("invalid passkey")
+	}
+
+	asset := ""
+	if len(args) > 0 {
+		asset = args[0]
+	}
+	balances, err := app.GetBalances(ctx, service.WalletAddress, asset)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching balances: %w", err)
+	}
+	if len(balances) == 0 {
+		if asset != "" {
+			utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("No %s balance", strings.ToUpper(asset)))
+		} else {
+			utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No balances")
+		}
+		return nil
+	}
+
+	lines := make([]string, 0, len(balances)+1)
+	total := 0.0
+	for _, balance := range balances {
+		if balance.Priced {
+			lines = append(lines, fmt.Sprintf("%s %s ($%.2f)", balance.Asset, formatAmount(balance.Amount, balance.decimals), balance.USDValue))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s $%.2f", balance.Asset, balance.USDValue))
+		}
+		total += balance.USDValue
+	}
+	if len(balances) > 1 {
+		lines = append(lines, fmt.Sprintf("Total $%.2f", total))
+	}
+
+	for _, message := range utils.SplitSMS(lines) {
+		if err := utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), message); err != nil {
+			return fmt.Errorf("error sending balance SMS: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+func TestAuthenticateWalletChecksPasskey(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	err := app.Store.CreateSmsService(ctx, storage.SmsService{WalletAddress: testWallet, PhoneNumber: "+15550100", Passkey: "correct horse"})
+	if err != nil {
+		t.Fatalf("creating service: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		walletAddress string
+		passkey       string
+		want          bool
+		failures      int64
+	}{
+		{"right passkey", testWallet, "correct horse", true, 0},
+		{"wrong passkey", testWallet, "battery staple", false, 1},
+		{"empty passkey", testWallet, "", false, 2},
+		{"unknown wallet", "0x2222222222222222222222222222222222222222", "correct horse", false, 2},
+	}
+	for _, test := range tests {
+		service, authenticated, err := app.AuthenticateWallet(ctx, test.walletAddress, test.passkey)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if authenticated != test.want || (service != nil) != test.want {
+			t.Errorf("%s: authenticated %v with service %+v, want %v", test.name, authenticated, service, test.want)
+		}
+		failures, err := app.Store.CountAuthFailuresSince(ctx, "+15550100", time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("%s: counting failures: %v", test.name, err)
+		}
+		if failures != test.failures {
+			t.Errorf("%s: %d failures recorded, want %d", test.name, failures, test.failures)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		decimals int
+		want     string
+	}{
+		{0.5, 18, "0.5"},
+		{0.0000001, 18, "0.0000001"},
+		{25, 6, "25"},
+		{1.23456789, 6, "1.234568"},
+		{0.1234567891, 8, "0.12345679"},
+		{0.0000000001, 8, "0"},
+		{12.6, 0, "13"},
+		{100, 0, "100"},
+		{1.23456789, -1, "1.23456789"},
+	}
+	for _, test := range tests {
+		if got := formatAmount(test.amount, test.decimals); got != test.want {
+			t.Errorf("formatAmount(%v, %d) = %q, want %q", test.amount, test.decimals, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"sort"
+
+	"crypto-sms/chain"
+)
+
+// RegisterChainAdapter makes an adapter settle withdrawals and, when it can scan for them,
+// watch deposits on its network
+func (app *App) RegisterChainAdapter(adapter chain.ChainAdapter) {
+	app.chainAdaptersMu.Lock()
+	defer app.chainAdaptersMu.Unlock()
+	app.chainAdapters[adapter.Network()] = adapter
+}
+
+// ChainAdapterFor returns the adapter registered for a network
+func (app *App) ChainAdapterFor(network string) (chain.ChainAdapter, bool) {
+	app.chainAdaptersMu.RLock()
+	defer app.chainAdaptersMu.RUnlock()
+	adapter, ok := app.chainAdapters[network]
+	return adapter, ok
+}
+
+// ChainAdapters returns every registered adapter, ordered by network
+func (app *App) ChainAdapters() []chain.ChainAdapter {
+	app.chainAdaptersMu.RLock()
+	defer app.chainAdaptersMu.RUnlock()
+	adapters := make([]chain.ChainAdapter, 0, len(app.chainAdapters))
+	for _, adapter := range app.chainAdapters {
+		adapters = append(adapters, adapter)
+	}
+	sort.Slice(adapters, func(i, j int) bool { return adapters[i].Network() < adapters[j].Network() })
+	return adapters
+}
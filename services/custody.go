@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+)
+
+// ErrNoSettlementWebhooks is returned for settlement webhooks when the custody provider
+// doesn't pay withdrawals out itself
+var ErrNoSettlementWebhooks = errors.New("custody provider does not send settlements")
+
+// Custody returns the provider holding balances
+func (app *App) Custody() custody.Provider {
+	return app.custody
+}
+
+// custodyWithdrawer returns the provider when it pays withdrawals out itself
+func (app *App) custodyWithdrawer() (custody.Withdrawer, bool) {
+	withdrawer, ok := app.custody.(custody.Withdrawer)
+	return withdrawer, ok
+}
+
+// reference names a custody transfer after the record it belongs to, so retrying the
+// same step never moves funds twice
+func reference(kind string, id primitive.ObjectID) string {
+	return kind + ":" + id.Hex()
+}
+
+// HandleCustodySettlement applies a withdrawal settlement webhook from the custody provider
+func (app *App) HandleCustodySettlement(r *http.Request) error {
+	withdrawer, ok := app.custodyWithdrawer()
+	if !ok {
+		return ErrNoSettlementWebhooks
+	}
+	settlement, err := withdrawer.ParseSettlement(r)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	withdrawal, exists, err := app.Store.GetWithdrawalByID(ctx, settlement.Reference)
+	if err != nil {
+		return err
+	}
+	if !exists || withdrawal.Custodian == "" {
+		return fmt.Errorf("no custodian withdrawal %s", settlement.Reference)
+	}
+	if withdrawal.Status != storage.WithdrawalBroadcast {
+		// Settlements may be delivered more than once
+		return nil
+	}
+
+	switch settlement.Status {
+	case custody.SettlementConfirmed:
+		withdrawal.TxHash = settlement.TxHash
+		moved, err := app.Store.TransitionWithdrawal(ctx, withdrawal.ID, storage.WithdrawalBroadcast, storage.WithdrawalBroadcast, storage.WithdrawalFields{TxHash: &settlement.TxHash})
+		if err != nil || !moved {
+			return err
+		}
+		return app.confirmWithdrawal(ctx, withdrawal, 0)
+	case custody.SettlementFailed:
+		return app.refundWithdrawal(ctx, withdrawal, settlement.Reason)
+	}
+	return fmt.Errorf("unknown settlement status %q", settlement.Status)
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/chain"
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+const (
+	// defaultDepositConfirmations applies when DEPOSIT_CONFIRMATIONS is unset or invalid
+	defaultDepositConfirmations = 12
+	// maxScanBlocks bounds how many blocks one poll scans, so a watcher that fell behind
+	// catches up gradually
+	maxScanBlocks = 100
+)
+
+// ErrNotSimulated is returned when simulating a deposit on a network settled by a real chain
+var ErrNotSimulated = errors.New("network is not simulated")
+
+// DepositConfirmations returns how many confirmations a deposit needs before it is credited.
+// Credits stay watched for twice as many, and are reversed if a reorg drops them meanwhile.
+func DepositConfirmations() int64 {
+	if confirmations, err := strconv.ParseInt(os.Getenv("DEPOSIT_CONFIRMATIONS"), 10, 64); err == nil && confirmations > 0 {
+		return confirmations
+	}
+	return defaultDepositConfirmations
+}
+
+// WatchDeposits scans every network whose adapter can find deposits for payments to
+// registered wallets, and credits or reverses them as they gain or lose confirmations
+func (app *App) WatchDeposits(ctx context.Context) error {
+	for _, adapter := range app.ChainAdapters() {
+		scanner, ok := adapter.(chain.DepositScanner)
+		if !ok {
+			continue
+		}
+		if err := app.watchNetwork(ctx, adapter, scanner); err != nil {
+			log.Printf("Error watching %s deposits: %v", adapter.Network(), err)
+		}
+	}
+	return nil
+}
+
+func (app *App) watchNetwork(ctx context.Context, adapter chain.ChainAdapter, scanner chain.DepositScanner) error {
+	network := adapter.Network()
+	confirmations := DepositConfirmations()
+
+	head, err := scanner.Head(ctx)
+	if err != nil {
+		return err
+	}
+	next, exists, err := app.Store.GetScanCursor(ctx, network)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// Start near the tip rather than replaying the whole chain
+		next = max(head-confirmations, 0)
+	}
+
+	// Rescan the blocks a reorg could have replaced, so deposits moved into new blocks are found again
+	from, to := max(next-confirmations, 0), min(head, next+maxScanBlocks-1)
+	if from <= to {
+		if err := app.scanDeposits(ctx, network, scanner, from, to); err != nil {
+			return err
+		}
+		if to >= next {
+			if err := app.Store.SetScanCursor(ctx, network, to+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return app.trackDeposits(ctx, adapter, confirmations)
+}
+
+func (app *App) scanDeposits(ctx context.Context, network string, scanner chain.DepositScanner, from int64, to int64) error {
+	wallets, addresses, err := app.depositWallets(ctx, network)
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+	assets, err := app.depositAssets(ctx, network)
+	if err != nil {
+		return err
+	}
+
+	tokens := []string{}
+	for _, asset := range assets {
+		if contract := asset.Contracts[network]; contract != "" {
+			tokens = append(tokens, contract)
+		}
+	}
+
+	transfers, err := scanner.IncomingTransfers(ctx, from, to, addresses, tokens)
+	if err != nil {
+		return err
+	}
+	for _, transfer := range transfers {
+		wallet, asset := wallets[depositKey(network, transfer.To)], assets[depositKey(network, transfer.Token)]
+		if wallet == "" || asset == nil {
+			continue
+		}
+		if err := app.recordDeposit(ctx, network, wallet, asset, transfer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depositWallets returns each wallet's derived deposit address on network, and maps them to
+// the wallets, keyed for matching against scanned payments. Registered wallet addresses are
+// the users' own wallets, not custodial ones, so payments to them are never credited.
+func (app *App) depositWallets(ctx context.Context, network string) (map[string]string, []string, error) {
+	services, err := app.Store.ListSmsServices(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	wallets := make(map[string]string, len(services))
+	addresses := []string{}
+	for _, service := range services {
+		if service.DepositIndex == nil {
+			continue
+		}
+		if address, err := app.deriveDepositAddress(network, *service.DepositIndex); err == nil {
+			wallets[depositKey(network, address)] = service.WalletAddress
+			addresses = append(addresses, address)
+		}
+	}
+	return wallets, addresses, nil
+}
+
+// depositAssets maps the token contracts on network to their assets. The empty key holds
+// the asset paid in the network's native coin.
+func (app *App) depositAssets(ctx context.Context, network string) (map[string]*storage.Asset, error) {
+	list, err := app.Store.ListAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assets := map[string]*storage.Asset{}
+	for i := range list {
+		asset := &list[i]
+		if !SupportsNetwork(asset, network) {
+			continue
+		}
+		key := depositKey(network, asset.Contracts[network])
+		if _, taken := assets[key]; !taken {
+			assets[key] = asset
+		}
+	}
+	return assets, nil
+}
+
+// depositKey folds the case of EVM addresses, which nodes report in lower case
+func depositKey(network string, address string) string {
+	if network == ChainEthereum {
+		return strings.ToLower(address)
+	}
+	return address
+}
+
+func (app *App) recordDeposit(ctx context.Context, network string, wallet string, asset *storage.Asset, transfer chain.IncomingTransfer) error {
+	now := time.Now()
+	deposit := &storage.Deposit{
+		Network:       network,
+		TxHash:        transfer.TxHash,
+		Index:         transfer.Index,
+		FromAddress:   transfer.From,
+		ToAddress:     transfer.To,
+		WalletAddress: wallet,
+		Asset:         asset.Symbol,
+		Amount:        transfer.Amount.String(),
+		AmountUSD:     fromBaseUnits(asset, transfer.Amount),
+		Block:         transfer.Block,
+		Status:        storage.DepositPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	inserted, err := app.Store.InsertDepositIfMissing(ctx, deposit)
+	if err != nil || inserted {
+		return err
+	}
+	_, err = app.Store.ReviveDeposit(ctx, network, transfer.TxHash, transfer.Index, transfer.Block)
+	return err
+}
+
+// fromBaseUnits converts an amount in the asset's smallest unit to USD at the current price
+func fromBaseUnits(asset *storage.Asset, amount *big.Int) float64 {
+	price, _ := GetPriceUSD(asset.Symbol)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(asset.Decimals)), nil)
+	units, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), new(big.Float).SetInt(scale)).Float64()
+	return units * price
+}
+
+// trackDeposits credits pending deposits that have enough confirmations and undoes those a
+// reorg dropped from the chain
+func (app *App) trackDeposits(ctx context.Context, adapter chain.ChainAdapter, confirmations int64) error {
+	deposits, err := app.Store.ListUnsettledDeposits(ctx, adapter.Network(), 2*confirmations)
+	if err != nil {
+		return err
+	}
+	for i := range deposits {
+		deposit := &deposits[i]
+		status, err := adapter.TxStatus(ctx, deposit.TxHash)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !status.Found || status.Failed || status.Confirmations == 0:
+			// The deposit was seen in a block, so it has been reorged out
+			err = app.orphanDeposit(ctx, deposit)
+		case deposit.Status == storage.DepositPending && status.Confirmations >= confirmations:
+			err = app.creditDeposit(ctx, deposit, status.Confirmations)
+		case status.Confirmations != deposit.Confirmations:
+			_, err = app.Store.TransitionDeposit(ctx, deposit.ID, deposit.Status, deposit.Status, storage.DepositFields{Confirmations: &status.Confirmations})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) creditDeposit(ctx context.Context, deposit *storage.Deposit, confirmations int64) error {
+	now := time.Now()
+	moved, err := app.Store.TransitionDeposit(ctx, deposit.ID, storage.DepositPending, storage.DepositCredited, storage.DepositFields{Confirmations: &confirmations, CreditedAt: &now})
+	if err != nil || !moved {
+		return err
+	}
+	transactionID := primitive.NewObjectID()
+	err = app.Custody().Transfer(ctx, custody.Transfer{
+		Reference: reference("deposit", transactionID),
+		To:        deposit.WalletAddress,
+		Asset:     deposit.Asset,
+		AmountUSD: deposit.AmountUSD,
+	})
+	if err != nil {
+		return err
+	}
+
+	recipientPhone := ""
+	if service, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, deposit.WalletAddress); err == nil && exists {
+		recipientPhone = service.PhoneNumber
+	}
+	err = app.Store.CreateTransaction(ctx, &storage.Transaction{
+		ID:               transactionID,
+		Kind:             storage.TransactionDeposit,
+		SenderAddress:    deposit.FromAddress,
+		RecipientAddress: deposit.WalletAddress,
+		RecipientPhone:   recipientPhone,
+		Crypto:           deposit.Asset,
+		RecipientCrypto:  deposit.Asset,
+		Network:          deposit.Network,
+		AmountUSD:        deposit.AmountUSD,
+		CreatedAt:        now,
+	})
+	if err != nil {
+		return err
+	}
+
+	if recipientPhone != "" {
+		utils.SendSMS(recipientPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"Your $%.2f %s deposit on %s has arrived and been added to your account", deposit.AmountUSD, deposit.Asset, deposit.Network))
+	}
+	return nil
+}
+
+// orphanDeposit marks a deposit dropped by a reorg. Credited deposits are reversed, which may
+// leave the wallet with a negative balance if the funds were already spent.
+func (app *App) orphanDeposit(ctx context.Context, deposit *storage.Deposit) error {
+	if deposit.Status == storage.DepositPending {
+		_, err := app.Store.TransitionDeposit(ctx, deposit.ID, storage.DepositPending, storage.DepositOrphaned, storage.DepositFields{})
+		return err
+	}
+
+	moved, err := app.Store.TransitionDeposit(ctx, deposit.ID, storage.DepositCredited, storage.DepositReversed, storage.DepositFields{})
+	if err != nil || !moved {
+		return err
+	}
+	reversalID := primitive.NewObjectID()
+	err = app.Custody().Transfer(ctx, custody.Transfer{
+		Reference:      reference("reversal", reversalID),
+		From:           deposit.WalletAddress,
+		Asset:          deposit.Asset,
+		AmountUSD:      deposit.AmountUSD,
+		AllowOverdraft: true,
+	})
+	if err != nil {
+		return err
+	}
+	err = app.Store.CreateTransaction(ctx, &storage.Transaction{
+		ID:              reversalID,
+		Kind:            storage.TransactionReversal,
+		SenderAddress:   deposit.WalletAddress,
+		Crypto:          deposit.Asset,
+		RecipientCrypto: deposit.Asset,
+		Network:         deposit.Network,
+		AmountUSD:       deposit.AmountUSD,
+		Reason:          "deposit " + deposit.TxHash + " dropped by a chain reorganization",
+		Operator:        "deposit watcher",
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if service, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, deposit.WalletAddress); err == nil && exists && service.PhoneNumber != "" {
+		utils.SendSMS(service.PhoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"Your $%.2f %s deposit was dropped by the %s network and has been removed from your balance. It will be credited again if it confirms",
+			deposit.AmountUSD, deposit.Asset, deposit.Network))
+	}
+	return nil
+}
+
+// SimulateDeposit pays a wallet on a simulated chain from outside the service, for
+// development. It returns the transaction hash.
+func (app *App) SimulateDeposit(ctx context.Context, network string, toAddress string, assetName string, amountUSD float64) (string, error) {
+	adapter, _ := app.ChainAdapterFor(network)
+	simulated, ok := adapter.(*chain.SimulatedChain)
+	if !ok {
+		return "", ErrNotSimulated
+	}
+	asset, err := app.LookupAsset(ctx, assetName)
+	if err != nil {
+		return "", err
+	}
+	if !SupportsNetwork(asset, network) {
+		return "", fmt.Errorf("%s is not available on %s", asset.Symbol, network)
+	}
+	amount, err := toBaseUnits(asset, amountUSD)
+	if err != nil {
+		return "", err
+	}
+	return simulated.Deposit("external", toAddress, asset.Contracts[network], amount), nil
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+
+	"crypto-sms/chain"
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// Extended public key versions, which choose the bitcoin address type
+const (
+	xpubVersion = 0x0488b21e // mainnet P2PKH (BIP-44)
+	tpubVersion = 0x043587cf // testnet P2PKH
+	zpubVersion = 0x04b24746 // mainnet P2WPKH (BIP-84)
+	vpubVersion = 0x045f1cf6 // testnet P2WPKH
+)
+
+// depositIndexCounter names the counter deposit derivation indexes are allocated from
+const depositIndexCounter = "deposit_index"
+
+// ErrNoDepositAddresses is returned for networks without a DEPOSIT_XPUB_<NETWORK> account key
+var ErrNoDepositAddresses = errors.New("deposit addresses are not configured for this network")
+
+// depositAccountKey returns the BIP-44 account-level extended public key of a network, such
+// as m/44'/60'/0' for ethereum, from DEPOSIT_XPUB_<NETWORK>
+func (app *App) depositAccountKey(network string) (*chain.ExtendedPublicKey, error) {
+	value := os.Getenv("DEPOSIT_XPUB_" + strings.ToUpper(network))
+	if value == "" {
+		return nil, ErrNoDepositAddresses
+	}
+	if key, ok := app.accountKeys[value]; ok {
+		return key, nil
+	}
+	key, err := chain.ParseExtendedPublicKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("DEPOSIT_XPUB_%s: %w", strings.ToUpper(network), err)
+	}
+	app.accountKeys[value] = key
+	return key, nil
+}
+
+// deriveDepositAddress returns the address at index on the external chain of a network's
+// account key, m/44'/coin'/account'/0/index
+func (app *App) deriveDepositAddress(network string, index uint32) (string, error) {
+	app.depositAddressesMu.Lock()
+	defer app.depositAddressesMu.Unlock()
+
+	cacheKey := fmt.Sprintf("%s/%d", network, index)
+	if address, ok := app.derivedAddresses[cacheKey]; ok {
+		return address, nil
+	}
+	account, err := app.depositAccountKey(network)
+	if err != nil {
+		return "", err
+	}
+	key, err := account.Derive(0, index)
+	if err != nil {
+		return "", err
+	}
+
+	var address string
+	switch network {
+	case ChainEthereum:
+		address = chain.EVMAddress(key.Key)
+	case ChainTron:
+		// Tron addresses carry the same key hash as EVM addresses under their own version byte
+		payload, _ := hex.DecodeString(chain.EVMAddress(key.Key)[2:])
+		address = utils.EncodeBase58Check(tronVersion, payload)
+	case ChainBitcoin:
+		sha := sha256.Sum256(key.Key.SerializeCompressed())
+		hasher := ripemd160.New()
+		hasher.Write(sha[:])
+		hash := hasher.Sum(nil)
+		switch account.Version {
+		case xpubVersion:
+			address = utils.EncodeBase58Check(0x00, hash)
+		case tpubVersion:
+			address = utils.EncodeBase58Check(0x6f, hash)
+		case zpubVersion:
+			address = utils.EncodeSegwitAddress("bc", 0, hash)
+		case vpubVersion:
+			address = utils.EncodeSegwitAddress("tb", 0, hash)
+		default:
+			return "", fmt.Errorf("unsupported extended key version %08x", account.Version)
+		}
+	default:
+		return "", fmt.Errorf("no deposit address format for %s", network)
+	}
+	app.derivedAddresses[cacheKey] = address
+	return address, nil
+}
+
+// DepositAddressFor returns a wallet's deposit address on network. The wallet is allocated
+// a derivation index the first time, which it then uses on every network.
+func (app *App) DepositAddressFor(ctx context.Context, service *storage.SmsService, network string) (string, error) {
+	if _, err := app.deriveDepositAddress(network, 0); err != nil {
+		return "", err
+	}
+	index, err := app.allocateDepositIndex(ctx, service)
+	if err != nil {
+		return "", err
+	}
+	return app.deriveDepositAddress(network, index)
+}
+
+func (app *App) allocateDepositIndex(ctx context.Context, service *storage.SmsService) (uint32, error) {
+	if service.DepositIndex != nil {
+		return *service.DepositIndex, nil
+	}
+	next, err := app.Store.NextSequence(ctx, depositIndexCounter)
+	if err != nil {
+		return 0, err
+	}
+	if next >= 1<<31 {
+		return 0, errors.New("deposit derivation indexes are exhausted")
+	}
+	index := uint32(next)
+
+	set, err := app.Store.SetDepositIndex(ctx, service.WalletAddress, index)
+	if err != nil {
+		return 0, err
+	}
+	if !set {
+		// A concurrent request allocated the wallet an index first; the one drawn here is skipped
+		current, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, service.WalletAddress)
+		if err != nil {
+			return 0, err
+		}
+		if !exists || current.DepositIndex == nil {
+			return 0, errors.New("wallet has no SMS service")
+		}
+		index = *current.DepositIndex
+	}
+	service.DepositIndex = &index
+	return index, nil
+}
+
+// depositNetwork picks the network to receive an asset on: the wallet's preferred network
+// when deposit addresses exist there, otherwise the first of the asset's networks that has them
+func (app *App) depositNetwork(asset *storage.Asset, preferred map[string]string) (string, bool) {
+	networks := asset.Networks
+	if network := preferred[asset.Symbol]; network != "" && SupportsNetwork(asset, network) {
+		networks = append([]string{network}, networks...)
+	}
+	for _, network := range networks {
+		if _, err := app.deriveDepositAddress(network, 0); err == nil {
+			return network, true
+		}
+	}
+	return "", false
+}
+
+// ProcessDepositCommand handles the DEPOSIT <asset> [ON <network>] SMS command
+func (app *App) ProcessDepositCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 && !(len(args) == 3 && strings.EqualFold(args[1], "ON")) {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply DEPOSIT <asset> or DEPOSIT <asset> ON <network>")
+		return fmt.Errorf("invalid deposit command")
+	}
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		return ReplyTransferError(phoneNumber, DefaultLanguage, NewTransferError(ErrCodeInternal, fmt.Errorf("error checking phone number: %w", err)))
+	}
+	if !exists {
+		return ReplyTransferError(phoneNumber, DefaultLanguage, NewTransferError(ErrCodePhoneNotRegistered, nil))
+	}
+	language := LanguageOf(service)
+
+	asset, err := app.ResolveAsset(ctx, args[0])
+	if err != nil {
+		return ReplyTransferError(phoneNumber, language, assetTransferError(args[0], err))
+	}
+	network, ok := "", false
+	if len(args) == 3 {
+		network = strings.ToLower(args[2])
+		if !SupportsNetwork(asset, network) {
+			return ReplyTransferError(phoneNumber, language, NewTransferError(ErrCodeUnsupportedNetwork, nil, asset.Symbol, network))
+		}
+	} else if network, ok = app.depositNetwork(asset, service.Networks); !ok {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Deposits of %s are not available yet", asset.Symbol))
+		return ErrNoDepositAddresses
+	}
+
+	address, err := app.DepositAddressFor(ctx, service, network)
+	if errors.Is(err, ErrNoDepositAddresses) {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Deposits of %s on %s are not available yet", asset.Symbol, network))
+		return err
+	}
+	if err != nil {
+		return ReplyTransferError(phoneNumber, language, NewTransferError(ErrCodeInternal, fmt.Errorf("error deriving deposit address: %w", err)))
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Send %s on %s to %s. It is credited after %d confirmations", asset.Symbol, network, address, DepositConfirmations()))
+	return nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"crypto-sms/storage/memory"
+)
+
+// Addresses derived from the BIP-84 test vector's account key, m/84'/0'/0', and from the
+// m/44'/60'/0' account key of the "abandon ... about" test mnemonic, which wallets agree on
+func TestDeriveDepositAddress(t *testing.T) {
+	tests := []struct {
+		network    string
+		accountKey string
+		index      uint32
+		want       string
+	}{
+		{ChainBitcoin, "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs", 0, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+		{ChainBitcoin, "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs", 1, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+		{ChainEthereum, "xpub6DCoCpSuQZB2jawqnGMEPS63ePKWkwWPH4TU45Q7LPXWuNd8TMtVxRrgjtEshuqpK3mdhaWHPFsBngh5GFZaM6si3yZdUsT8ddYM3PwnATt", 0, "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+	}
+	for _, test := range tests {
+		t.Setenv("DEPOSIT_XPUB_"+strings.ToUpper(test.network), test.accountKey)
+		app := NewApp(memory.NewStore(), nil)
+		got, err := app.deriveDepositAddress(test.network, test.index)
+		if err != nil {
+			t.Fatalf("deriving %s address %d: %v", test.network, test.index, err)
+		}
+		if got != test.want {
+			t.Errorf("%s address %d is %s, want %s", test.network, test.index, got, test.want)
+		}
+	}
+}
+
+func TestDeriveDepositAddressCachesPerApp(t *testing.T) {
+	t.Setenv("DEPOSIT_XPUB_ETHEREUM", "xpub6DCoCpSuQZB2jawqnGMEPS63ePKWkwWPH4TU45Q7LPXWuNd8TMtVxRrgjtEshuqpK3mdhaWHPFsBngh5GFZaM6si3yZdUsT8ddYM3PwnATt")
+	first := NewApp(memory.NewStore(), nil)
+	if _, err := first.deriveDepositAddress(ChainEthereum, 0); err != nil {
+		t.Fatalf("deriving address: %v", err)
+	}
+
+	// Another app reads its own account key instead of the first app's cached address
+	t.Setenv("DEPOSIT_XPUB_ETHEREUM", "")
+	second := NewApp(memory.NewStore(), nil)
+	if _, err := second.deriveDepositAddress(ChainEthereum, 0); err != ErrNoDepositAddresses {
+		t.Errorf("app without an account key derived with error %v, want ErrNoDepositAddresses", err)
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"crypto-sms/chain"
+	"crypto-sms/storage"
+)
+
+// testAccountKey is the extended public key of BIP-32 test vector 1's master key, standing
+// in for an account key deposit addresses are derived from
+const testAccountKey = "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+
+// newDepositApp returns a simulated app with a registered test wallet, crediting deposits
+// after 3 confirmations, and the wallet's ethereum deposit address
+func newDepositApp(t *testing.T) (*App, *chain.SimulatedChain, string) {
+	t.Helper()
+	t.Setenv("DEPOSIT_CONFIRMATIONS", "3")
+	t.Setenv("DEPOSIT_XPUB_ETHEREUM", testAccountKey)
+	app, simulated := newSimulatedApp(t)
+	ctx := context.Background()
+	service := storage.SmsService{WalletAddress: testWallet}
+	if err := app.Store.CreateSmsService(ctx, service); err != nil {
+		t.Fatalf("registering wallet: %v", err)
+	}
+	address, err := app.DepositAddressFor(ctx, &service, ChainEthereum)
+	if err != nil {
+		t.Fatalf("deriving deposit address: %v", err)
+	}
+	return app, simulated, address
+}
+
+// watchDeposit runs the deposit watcher once and returns the wallet's only deposit
+func watchDeposit(t *testing.T, app *App) storage.Deposit {
+	t.Helper()
+	ctx := context.Background()
+	if err := app.WatchDeposits(ctx); err != nil {
+		t.Fatalf("watching deposits: %v", err)
+	}
+	deposits, err := app.Store.ListDepositsForWallet(ctx, testWallet)
+	if err != nil {
+		t.Fatalf("listing deposits: %v", err)
+	}
+	if len(deposits) != 1 {
+		t.Fatalf("wallet has %d deposits, want 1", len(deposits))
+	}
+	return deposits[0]
+}
+
+func TestDepositCreditedAfterConfirmations(t *testing.T) {
+	app, simulated, address := newDepositApp(t)
+	ctx := context.Background()
+	if _, err := app.SimulateDeposit(ctx, ChainEthereum, address, "USDC", 40); err != nil {
+		t.Fatalf("simulating deposit: %v", err)
+	}
+
+	simulated.Mine(1)
+	deposit := watchDeposit(t, app)
+	if deposit.Status != storage.DepositPending || deposit.Confirmations != 1 || deposit.AmountUSD != 40 {
+		t.Fatalf("with 1 block the deposit is %s with %d confirmations for $%v, want pending with 1 for $40",
+			deposit.Status, deposit.Confirmations, deposit.AmountUSD)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 0 {
+		t.Errorf("wallet holds %v before the deposit confirms, want 0", got)
+	}
+
+	simulated.Mine(2)
+	deposit = watchDeposit(t, app)
+	if deposit.Status != storage.DepositCredited || deposit.Confirmations != 3 || deposit.CreditedAt.IsZero() {
+		t.Fatalf("with 3 blocks the deposit is %s with %d confirmations, want credited with 3", deposit.Status, deposit.Confirmations)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 40 {
+		t.Errorf("wallet holds %v after the deposit is credited, want 40", got)
+	}
+
+	// Further confirmations are tracked without crediting the deposit again
+	simulated.Mine(1)
+	if deposit = watchDeposit(t, app); deposit.Status != storage.DepositCredited || deposit.Confirmations != 4 {
+		t.Errorf("with 4 blocks the deposit is %s with %d confirmations, want credited with 4", deposit.Status, deposit.Confirmations)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 40 {
+		t.Errorf("wallet holds %v after another run, want 40", got)
+	}
+}
+
+func TestPendingDepositOrphanedByReorg(t *testing.T) {
+	app, simulated, address := newDepositApp(t)
+	if _, err := app.SimulateDeposit(context.Background(), ChainEthereum, address, "USDC", 40); err != nil {
+		t.Fatalf("simulating deposit: %v", err)
+	}
+	simulated.Mine(1)
+	watchDeposit(t, app)
+
+	if err := simulated.Reorg(1); err != nil {
+		t.Fatalf("reorganizing chain: %v", err)
+	}
+	if deposit := watchDeposit(t, app); deposit.Status != storage.DepositOrphaned {
+		t.Fatalf("reorged pending deposit is %s, want orphaned", deposit.Status)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 0 {
+		t.Errorf("wallet holds %v after the deposit was orphaned, want 0", got)
+	}
+}
+
+func TestCreditedDepositReversedByReorg(t *testing.T) {
+	app, simulated, address := newDepositApp(t)
+	ctx := context.Background()
+	if _, err := app.SimulateDeposit(ctx, ChainEthereum, address, "USDC", 40); err != nil {
+		t.Fatalf("simulating deposit: %v", err)
+	}
+	simulated.Mine(3)
+	if deposit := watchDeposit(t, app); deposit.Status != storage.DepositCredited {
+		t.Fatalf("deposit with 3 blocks is %s, want credited", deposit.Status)
+	}
+
+	if err := simulated.Reorg(3); err != nil {
+		t.Fatalf("reorganizing chain: %v", err)
+	}
+	if deposit := watchDeposit(t, app); deposit.Status != storage.DepositReversed {
+		t.Fatalf("reorged credited deposit is %s, want reversed", deposit.Status)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 0 {
+		t.Errorf("wallet holds %v after the deposit was reversed, want 0", got)
+	}
+
+	transactions, err := app.Store.ListTransactionsForWallet(ctx, testWallet)
+	if err != nil {
+		t.Fatalf("listing transactions: %v", err)
+	}
+	kinds := map[string]int{}
+	for _, transaction := range transactions {
+		kinds[transaction.Kind]++
+	}
+	if kinds[storage.TransactionDeposit] != 1 || kinds[storage.TransactionReversal] != 1 {
+		t.Errorf("wallet has transactions %+v, want a deposit and its reversal", transactions)
+	}
+}
+
+func TestPaymentToRegisteredWalletIsNotCredited(t *testing.T) {
+	app, simulated, _ := newDepositApp(t)
+	ctx := context.Background()
+	// The registered wallet is the user's own, so paying it moves nothing on the ledger
+	if _, err := app.SimulateDeposit(ctx, ChainEthereum, testWallet, "USDC", 40); err != nil {
+		t.Fatalf("simulating payment: %v", err)
+	}
+	simulated.Mine(5)
+	if err := app.WatchDeposits(ctx); err != nil {
+		t.Fatalf("watching deposits: %v", err)
+	}
+	deposits, err := app.Store.ListDepositsForWallet(ctx, testWallet)
+	if err != nil {
+		t.Fatalf("listing deposits: %v", err)
+	}
+	if len(deposits) != 0 {
+		t.Errorf("payment to the registered wallet recorded deposits %+v, want none", deposits)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 0 {
+		t.Errorf("wallet holds %v after a payment to its own address, want 0", got)
+	}
+}
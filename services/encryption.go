@@ -0,0 +1,16 @@
+package services
+
+import (
+	"context"
+	"log"
+)
+
+// ReencryptSecrets reseals the phone numbers, passkeys and 2FA codes sealed under a retired
+// key, so master keys can be rotated without downtime
+func (app *App) ReencryptSecrets(ctx context.Context) error {
+	resealed, err := app.Store.ReencryptSecrets(ctx)
+	if resealed > 0 {
+		log.Printf("Re-encrypted %d records under the current key", resealed)
+	}
+	return err
+}
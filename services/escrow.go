@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+const (
+	// defaultEscrowAddress is the custodian account holding escrowed funds when
+	// ESCROW_ADDRESS is unset
+	defaultEscrowAddress = "escrow"
+	// defaultEscrowExpiry applies when ESCROW_EXPIRY is unset or invalid
+	defaultEscrowExpiry = 7 * 24 * time.Hour
+)
+
+// EscrowAddress returns the custodian wallet that holds escrowed funds
+func EscrowAddress() string {
+	if address := os.Getenv("ESCROW_ADDRESS"); address != "" {
+		return address
+	}
+	return defaultEscrowAddress
+}
+
+// EscrowExpiry returns how long unclaimed funds are held before being refunded
+func EscrowExpiry() time.Duration {
+	if expiry, err := time.ParseDuration(os.Getenv("ESCROW_EXPIRY")); err == nil && expiry > 0 {
+		return expiry
+	}
+	return defaultEscrowExpiry
+}
+
+// IsRegisteredWallet reports whether a wallet address has a custodian or SMS service record
+func (app *App) IsRegisteredWallet(ctx context.Context, walletAddress string) (bool, error) {
+	_, exists, err := app.Store.GetCustodianByWalletAddress(ctx, walletAddress)
+	if err != nil || exists {
+		return exists, err
+	}
+	_, exists, err = app.Store.CheckWalletExistsInSmsService(ctx, walletAddress)
+	return exists, err
+}
+
+// HoldInEscrow records a transfer whose funds are already in the escrow account and invites
+// the recipient to claim it when they are addressed by phone number
+func (app *App) HoldInEscrow(ctx context.Context, escrow *storage.Escrow) error {
+	claimCode, err := utils.GenerateNumericCode(6)
+	if err != nil {
+		return fmt.Errorf("error generating claim code: %w", err)
+	}
+	now := time.Now()
+	escrow.ClaimCode = claimCode
+	escrow.Status = storage.EscrowHeld
+	escrow.CreatedAt = now
+	escrow.ExpiresAt = now.Add(EscrowExpiry())
+
+	if err := app.Store.CreateEscrow(ctx, escrow); err != nil {
+		return err
+	}
+
+	if escrow.RecipientPhone != "" {
+		utils.SendSMS(escrow.RecipientPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"%s sent you $%.2f in %s via CryptoSMS. Register this number and reply CLAIM %s before %s UTC",
+			MaskPhoneNumber(escrow.SenderPhone), escrow.AmountUSD, escrow.RecipientCrypto, claimCode,
+			escrow.ExpiresAt.UTC().Format("Jan 2")))
+	}
+	return nil
+}
+
+// ReleaseEscrow credits held funds to the recipient's wallet and notifies both parties
+func (app *App) ReleaseEscrow(ctx context.Context, escrow *storage.Escrow, walletAddress string) error {
+	settled, err := app.settleEscrow(ctx, escrow, storage.EscrowClaimed, walletAddress, custody.Transfer{
+		Reference:      reference("escrow-release", escrow.ID),
+		From:           EscrowAddress(),
+		To:             walletAddress,
+		Asset:          escrow.Crypto,
+		ToAsset:        escrow.RecipientCrypto,
+		AmountUSD:      escrow.AmountUSD,
+		AllowOverdraft: true,
+	})
+	if err != nil || !settled {
+		return err
+	}
+
+	recipientPhone := escrow.RecipientPhone
+	if recipientPhone == "" {
+		if service, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, walletAddress); err == nil && exists {
+			recipientPhone = service.PhoneNumber
+		}
+	}
+	if recipientPhone != "" {
+		utils.SendSMS(recipientPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("$%.2f has been added into your %s account", escrow.AmountUSD, escrow.RecipientCrypto))
+	}
+	utils.SendSMS(escrow.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Your $%.2f %s transfer has been claimed", escrow.AmountUSD, escrow.Crypto))
+	return nil
+}
+
+// RefundEscrow returns held funds to the sender and notifies both parties
+func (app *App) RefundEscrow(ctx context.Context, escrow *storage.Escrow) error {
+	settled, err := app.settleEscrow(ctx, escrow, storage.EscrowRefunded, "", custody.Transfer{
+		Reference:      reference("escrow-refund", escrow.ID),
+		From:           EscrowAddress(),
+		To:             escrow.SenderAddress,
+		Asset:          escrow.Crypto,
+		AmountUSD:      escrow.AmountUSD,
+		AllowOverdraft: true,
+	})
+	if err != nil || !settled {
+		return err
+	}
+
+	utils.SendSMS(escrow.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Your unclaimed $%.2f %s transfer has been refunded", escrow.AmountUSD, escrow.Crypto))
+	if escrow.RecipientPhone != "" {
+		utils.SendSMS(escrow.RecipientPhone, os.Getenv("TWILIO_PHONE_NUMBER"), "A CryptoSMS transfer to you has expired and was returned to the sender")
+	}
+	return nil
+}
+
+// settleEscrow resolves a held escrow to status and moves its funds out of the escrow account.
+// Resolving first keeps a concurrent claim and refund from both paying out. If the transfer
+// fails the escrow is held again, so a later claim or the expiry job retries it; the
+// transfer's reference keeps a retry from paying twice. It reports false when the escrow was
+// no longer held.
+func (app *App) settleEscrow(ctx context.Context, escrow *storage.Escrow, status string, claimedBy string, transfer custody.Transfer) (bool, error) {
+	resolved, err := app.Store.ResolveEscrow(ctx, escrow.ID, status, claimedBy)
+	if err != nil || !resolved {
+		return false, err
+	}
+	if err := app.Custody().Transfer(ctx, transfer); err != nil {
+		if _, reopenErr := app.Store.ReopenEscrow(ctx, escrow.ID, status); reopenErr != nil {
+			log.Printf("Error reopening escrow %s after a failed transfer: %v", escrow.ID.Hex(), reopenErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseEscrowsForWallet releases funds held for a wallet address and, when given, its
+// newly linked phone number. It is only called once the phone number is verified with a
+// 2FA code, since anyone can register a wallet or link an unverified number.
+func (app *App) ReleaseEscrowsForWallet(ctx context.Context, walletAddress string, phoneNumber string) error {
+	escrows, err := app.Store.ListHeldEscrowsForAddress(ctx, walletAddress)
+	if err != nil {
+		return err
+	}
+	if phoneNumber != "" {
+		byPhone, err := app.Store.ListHeldEscrowsForPhone(ctx, phoneNumber)
+		if err != nil {
+			return err
+		}
+		escrows = append(escrows, byPhone...)
+	}
+
+	for i := range escrows {
+		if err := app.ReleaseEscrow(ctx, &escrows[i], walletAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireEscrows refunds every held escrow past its expiry
+func (app *App) ExpireEscrows(ctx context.Context) error {
+	escrows, err := app.Store.ListExpiredEscrows(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	// An escrow whose refund fails stays held and is retried on the next run
+	for i := range escrows {
+		if err := app.RefundEscrow(ctx, &escrows[i]); err != nil {
+			log.Printf("Error refunding escrow %s: %v", escrows[i].ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// ProcessClaimCommand handles the CLAIM <code> SMS command
+func (app *App) ProcessClaimCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply CLAIM <code>")
+		return fmt.Errorf("missing claim code")
+	}
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Register this number with CryptoSMS to claim your funds")
+		return fmt.Errorf("phone number not registered")
+	}
+
+	escrow, exists, err := app.Store.GetHeldEscrowByClaimCode(ctx, phoneNumber, args[0])
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching escrow: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No unclaimed transfer matches that code")
+		return fmt.Errorf("unknown claim code")
+	}
+
+	if err := app.ReleaseEscrow(ctx, escrow, service.WalletAddress); err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error releasing escrow: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+// flakyProvider keeps balances in the store but fails the next failures transfers
+type flakyProvider struct {
+	custody.StoreProvider
+	failures int
+}
+
+func (p *flakyProvider) Transfer(ctx context.Context, transfer custody.Transfer) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("custody unavailable")
+	}
+	return p.StoreProvider.Transfer(ctx, transfer)
+}
+
+// heldTestEscrow escrows $30 USDC for testWallet, with the funds already in the escrow account
+func heldTestEscrow(t *testing.T, app *App) *storage.Escrow {
+	t.Helper()
+	ctx := context.Background()
+	escrow := &storage.Escrow{
+		SenderAddress:    "0x3333333333333333333333333333333333333333",
+		SenderPhone:      "+15550100",
+		RecipientAddress: testWallet,
+		Crypto:           "USDC",
+		RecipientCrypto:  "USDC",
+		AmountUSD:        30,
+	}
+	if err := app.Custody().Transfer(ctx, custody.Transfer{To: EscrowAddress(), Asset: "USDC", AmountUSD: 30}); err != nil {
+		t.Fatalf("funding escrow account: %v", err)
+	}
+	if err := app.HoldInEscrow(ctx, escrow); err != nil {
+		t.Fatalf("holding escrow: %v", err)
+	}
+	return escrow
+}
+
+func TestEscrowReleaseRetriedAfterFailedTransfer(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	provider := &flakyProvider{StoreProvider: custody.StoreProvider{Custodians: store.CustodianRepository}}
+	app := NewApp(store, provider)
+	escrow := heldTestEscrow(t, app)
+
+	provider.failures = 1
+	if err := app.ReleaseEscrowsForWallet(ctx, testWallet, ""); err == nil {
+		t.Fatal("releasing with custody down succeeded")
+	}
+	held, err := app.Store.ListHeldEscrowsForAddress(ctx, testWallet)
+	if err != nil || len(held) != 1 || held[0].ClaimedBy != "" {
+		t.Fatalf("after a failed release the held escrows are %+v (%v), want the escrow held and unclaimed", held, err)
+	}
+	if got := balance(t, app, EscrowAddress(), "USDC"); got != 30 {
+		t.Fatalf("escrow account holds %v after a failed release, want 30", got)
+	}
+
+	if err := app.ReleaseEscrowsForWallet(ctx, testWallet, ""); err != nil {
+		t.Fatalf("retrying release: %v", err)
+	}
+	if held, _ := app.Store.ListHeldEscrowsForAddress(ctx, testWallet); len(held) != 0 {
+		t.Errorf("escrow still held after release: %+v", held)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 30 {
+		t.Errorf("recipient holds %v after release, want 30", got)
+	}
+	if got := balance(t, app, EscrowAddress(), "USDC"); got != 0 {
+		t.Errorf("escrow account holds %v after release, want 0", got)
+	}
+
+	// A released escrow can't be refunded as well
+	if err := app.RefundEscrow(ctx, escrow); err != nil {
+		t.Fatalf("refunding released escrow: %v", err)
+	}
+	if got := balance(t, app, escrow.SenderAddress, "USDC"); got != 0 {
+		t.Errorf("sender holds %v after refunding a released escrow, want 0", got)
+	}
+}
+
+func TestEscrowRefundRetriedAfterFailedTransfer(t *testing.T) {
+	t.Setenv("ESCROW_EXPIRY", "1ns")
+	ctx := context.Background()
+	store := memory.NewStore()
+	provider := &flakyProvider{StoreProvider: custody.StoreProvider{Custodians: store.CustodianRepository}}
+	app := NewApp(store, provider)
+	escrow := heldTestEscrow(t, app)
+
+	provider.failures = 1
+	if err := app.ExpireEscrows(ctx); err != nil {
+		t.Fatalf("expiring escrows: %v", err)
+	}
+	if got := balance(t, app, escrow.SenderAddress, "USDC"); got != 0 {
+		t.Fatalf("sender holds %v after a failed refund, want 0", got)
+	}
+
+	if err := app.ExpireEscrows(ctx); err != nil {
+		t.Fatalf("expiring escrows again: %v", err)
+	}
+	if got := balance(t, app, escrow.SenderAddress, "USDC"); got != 30 {
+		t.Errorf("sender holds %v after the refund is retried, want 30", got)
+	}
+	if got := balance(t, app, EscrowAddress(), "USDC"); got != 0 {
+		t.Errorf("escrow account holds %v after the refund, want 0", got)
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"crypto-sms/storage"
+)
+
+// defaultFeeRevenueAddress is the custodian account fees are booked to when
+// FEE_REVENUE_ADDRESS is unset
+const defaultFeeRevenueAddress = "fee_revenue"
+
+// Fee is the fee charged on a transfer and the schedule version that produced it
+type Fee struct {
+	AmountUSD float64 `json:"amount_usd"`
+	Version   int     `json:"version"`
+}
+
+// FeeRevenueAddress returns the custodian wallet that collects transfer fees
+func FeeRevenueAddress() string {
+	if address := os.Getenv("FEE_REVENUE_ADDRESS"); address != "" {
+		return address
+	}
+	return defaultFeeRevenueAddress
+}
+
+// ValidateFeeRules rejects rules with negative amounts, percentages above 100, caps below
+// minimums or unordered tiers
+func ValidateFeeRules(rules []storage.FeeRule) error {
+	for i, rule := range rules {
+		if rule.FlatUSD < 0 || rule.Percent < 0 || rule.MinUSD < 0 || rule.MaxUSD < 0 {
+			return fmt.Errorf("rule %d: amounts must not be negative", i)
+		}
+		if rule.Percent > 100 {
+			return fmt.Errorf("rule %d: percent must be at most 100", i)
+		}
+		if rule.MaxUSD > 0 && rule.MaxUSD < rule.MinUSD {
+			return fmt.Errorf("rule %d: max_usd is below min_usd", i)
+		}
+		for j, tier := range rule.Tiers {
+			if tier.UpToUSD <= 0 || tier.FlatUSD < 0 || tier.Percent < 0 || tier.Percent > 100 {
+				return fmt.Errorf("rule %d tier %d: invalid amounts", i, j)
+			}
+			if j > 0 && tier.UpToUSD <= rule.Tiers[j-1].UpToUSD {
+				return fmt.Errorf("rule %d: tiers must be in ascending up_to_usd order", i)
+			}
+		}
+	}
+	return nil
+}
+
+// QuoteFee evaluates the active fee schedule for a transfer. Without a schedule transfers are free.
+// network is empty for transfers that stay on the ledger.
+func (app *App) QuoteFee(ctx context.Context, crypto string, recipientCrypto string, network string, amountUSD float64) (Fee, error) {
+	schedule, exists, err := app.Store.GetActiveFeeSchedule(ctx)
+	if err != nil {
+		return Fee{}, err
+	}
+	if !exists {
+		return Fee{}, nil
+	}
+
+	rule, ok := matchFeeRule(schedule.Rules, crypto, recipientCrypto, network)
+	if !ok {
+		return Fee{Version: schedule.Version}, nil
+	}
+	return Fee{AmountUSD: evaluateFeeRule(rule, amountUSD), Version: schedule.Version}, nil
+}
+
+// matchFeeRule picks the most specific rule: network, then corridor, then asset, then
+// catch-all. Network rules never match ledger transfers, which have no network.
+func matchFeeRule(rules []storage.FeeRule, crypto string, recipientCrypto string, network string) (storage.FeeRule, bool) {
+	best, bestScore := storage.FeeRule{}, -1
+	for _, rule := range rules {
+		score := 0
+		if rule.Network != "" {
+			if !strings.EqualFold(rule.Network, network) {
+				continue
+			}
+			score += 4
+		}
+		if rule.Asset != "" {
+			if !strings.EqualFold(rule.Asset, crypto) {
+				continue
+			}
+			score += 2
+		}
+		if rule.RecipientAsset != "" {
+			if !strings.EqualFold(rule.RecipientAsset, recipientCrypto) {
+				continue
+			}
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = rule, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+func evaluateFeeRule(rule storage.FeeRule, amountUSD float64) float64 {
+	flat, percent := rule.FlatUSD, rule.Percent
+
+	tiers := append([]storage.FeeTier(nil), rule.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].UpToUSD < tiers[j].UpToUSD })
+	for _, tier := range tiers {
+		if amountUSD <= tier.UpToUSD {
+			flat, percent = tier.FlatUSD, tier.Percent
+			break
+		}
+	}
+
+	fee := flat + amountUSD*percent/100
+	if fee < rule.MinUSD {
+		fee = rule.MinUSD
+	}
+	if rule.MaxUSD > 0 && fee > rule.MaxUSD {
+		fee = rule.MaxUSD
+	}
+	return math.Round(fee*100) / 100
+}
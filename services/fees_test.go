@@ -0,0 +1,71 @@
+package services
+
+import (
+	"testing"
+
+	"crypto-sms/storage"
+)
+
+func TestMatchFeeRule(t *testing.T) {
+	catchAll := storage.FeeRule{FlatUSD: 1}
+	asset := storage.FeeRule{Asset: "USDT", FlatUSD: 2}
+	corridor := storage.FeeRule{Asset: "USDT", RecipientAsset: "ETH", FlatUSD: 3}
+	network := storage.FeeRule{Network: "tron", FlatUSD: 4}
+	rules := []storage.FeeRule{catchAll, asset, corridor, network}
+
+	tests := []struct {
+		name            string
+		rules           []storage.FeeRule
+		crypto          string
+		recipientCrypto string
+		network         string
+		want            storage.FeeRule
+		found           bool
+	}{
+		{"no rules", nil, "USDT", "USDT", "", storage.FeeRule{}, false},
+		{"catch-all", rules, "BTC", "BTC", "", catchAll, true},
+		{"asset beats catch-all", rules, "USDT", "USDT", "", asset, true},
+		{"corridor beats asset", rules, "USDT", "ETH", "", corridor, true},
+		{"network beats corridor", rules, "USDT", "ETH", "tron", network, true},
+		{"assets match case-insensitively", rules, "usdt", "eth", "", corridor, true},
+		{"networks match case-insensitively", rules, "BTC", "BTC", "TRON", network, true},
+		{"network rules skip ledger transfers", []storage.FeeRule{network}, "USDT", "USDT", "", storage.FeeRule{}, false},
+		{"unmatched asset rules are skipped", []storage.FeeRule{asset, corridor}, "BTC", "ETH", "", storage.FeeRule{}, false},
+	}
+	for _, test := range tests {
+		rule, found := matchFeeRule(test.rules, test.crypto, test.recipientCrypto, test.network)
+		if found != test.found || rule.FlatUSD != test.want.FlatUSD {
+			t.Errorf("%s: got rule with $%.2f flat (found %v), want $%.2f (found %v)", test.name, rule.FlatUSD, found, test.want.FlatUSD, test.found)
+		}
+	}
+}
+
+func TestEvaluateFeeRule(t *testing.T) {
+	tiered := storage.FeeRule{FlatUSD: 5, Percent: 1, Tiers: []storage.FeeTier{
+		{UpToUSD: 1000, FlatUSD: 1, Percent: 0.5},
+		{UpToUSD: 100, FlatUSD: 0.25},
+	}}
+
+	tests := []struct {
+		name      string
+		rule      storage.FeeRule
+		amountUSD float64
+		want      float64
+	}{
+		{"flat and percent", storage.FeeRule{FlatUSD: 0.5, Percent: 1}, 50, 1},
+		{"free", storage.FeeRule{}, 50, 0},
+		{"lowest tier", tiered, 40, 0.25},
+		{"tier bound is inclusive", tiered, 100, 0.25},
+		{"middle tier", tiered, 500, 3.5},
+		{"above every tier uses the base rule", tiered, 2000, 25},
+		{"minimum", storage.FeeRule{Percent: 1, MinUSD: 2}, 50, 2},
+		{"maximum", storage.FeeRule{Percent: 1, MaxUSD: 10}, 5000, 10},
+		{"zero maximum means uncapped", storage.FeeRule{Percent: 1}, 5000, 50},
+		{"rounded to cents", storage.FeeRule{Percent: 1.5}, 3.33, 0.05},
+	}
+	for _, test := range tests {
+		if got := evaluateFeeRule(test.rule, test.amountUSD); got != test.want {
+			t.Errorf("%s: $%.2f on $%.2f, want $%.2f", test.name, got, test.amountUSD, test.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// defaultGuardianApprovalTimeout applies when GUARDIAN_APPROVAL_TIMEOUT is unset or invalid
+const defaultGuardianApprovalTimeout = time.Hour
+
+// GuardianApprovalTimeout returns how long guardians have to approve a transfer
+func GuardianApprovalTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("GUARDIAN_APPROVAL_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultGuardianApprovalTimeout
+}
+
+// ValidateGuardianPolicy checks that every guardian is a registered phone other than the
+// owner's and that the approval count can be met
+func (app *App) ValidateGuardianPolicy(ctx context.Context, owner *storage.SmsService, policy *storage.GuardianPolicy) error {
+	if len(policy.Phones) == 0 {
+		return fmt.Errorf("at least one guardian is required")
+	}
+	if policy.RequiredApprovals < 1 || policy.RequiredApprovals > len(policy.Phones) {
+		return fmt.Errorf("required_approvals must be between 1 and the number of guardians")
+	}
+	if policy.ThresholdUSD < 0 {
+		return fmt.Errorf("threshold_usd must not be negative")
+	}
+
+	seen := map[string]bool{}
+	for i, phone := range policy.Phones {
+		if !strings.HasPrefix(phone, "+") {
+			phone = "+" + phone
+			policy.Phones[i] = phone
+		}
+		if seen[phone] {
+			return fmt.Errorf("guardian %s is listed twice", phone)
+		}
+		seen[phone] = true
+		if phone == owner.PhoneNumber {
+			return fmt.Errorf("the account's own phone cannot be a guardian")
+		}
+		_, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phone)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("guardian %s is not registered", phone)
+		}
+	}
+	return nil
+}
+
+// WeakensGuardianPolicy reports whether replacing current with next would let transfers
+// through with fewer or different guardians: removing the policy or a guardian, adding a
+// guardian, lowering the approval count or raising the threshold
+func WeakensGuardianPolicy(current, next *storage.GuardianPolicy) bool {
+	if current == nil || len(current.Phones) == 0 {
+		return false
+	}
+	if next == nil || len(next.Phones) != len(current.Phones) {
+		return true
+	}
+	if next.RequiredApprovals < current.RequiredApprovals || next.ThresholdUSD > current.ThresholdUSD {
+		return true
+	}
+	phones := map[string]bool{}
+	for _, phone := range current.Phones {
+		phones[phone] = true
+	}
+	for _, phone := range next.Phones {
+		if !phones[phone] {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresGuardianApproval reports whether a transfer is above the sender's guardian threshold
+func RequiresGuardianApproval(service *storage.SmsService, amountUSD float64) bool {
+	return service.Guardians != nil && len(service.Guardians.Phones) > 0 && amountUSD > service.Guardians.ThresholdUSD
+}
+
+// RequestGuardianApproval parks a transfer until enough guardians approve it and returns
+// ErrTransferPending once the guardians have been asked
+func (app *App) RequestGuardianApproval(ctx context.Context, service *storage.SmsService, phoneNumber string, order transferOrder) error {
+	code, err := utils.GenerateNumericCode(5)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error generating reference: %w", err)
+	}
+
+	now := time.Now()
+	pending := &storage.PendingTransfer{
+		Reference:         "G" + code,
+		SenderAddress:     service.WalletAddress,
+		SenderPhone:       phoneNumber,
+		Recipient:         order.Recipient,
+		Crypto:            order.Crypto,
+		RecipientCrypto:   order.RecipientCrypto,
+		Network:           order.Network,
+		AmountUSD:         order.AmountUSD,
+		Guardians:         service.Guardians.Phones,
+		RequiredApprovals: service.Guardians.RequiredApprovals,
+		Decisions:         []storage.GuardianDecision{},
+		PaymentRequestID:  order.PaymentRequestID,
+		Status:            storage.PendingTransferPending,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(GuardianApprovalTimeout()),
+	}
+	if err := app.Store.CreatePendingTransfer(ctx, pending); err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error creating pending transfer: %w", err)
+	}
+
+	for _, guardian := range pending.Guardians {
+		utils.SendSMS(guardian, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"%s wants to send $%.2f %s to %s. Reply APPROVE %s or DENY %s",
+			MaskPhoneNumber(phoneNumber), pending.AmountUSD, pending.Crypto, pending.Recipient, pending.Reference, pending.Reference))
+	}
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Transfer %s is waiting for guardian approval", pending.Reference))
+	return ErrTransferPending
+}
+
+// DecidePendingTransfer records a guardian's decision and, once enough guardians agree,
+// runs or denies the transfer. It returns the transfer's resulting status.
+func (app *App) DecidePendingTransfer(ctx context.Context, pending *storage.PendingTransfer, guardianPhone string, decision string) (string, error) {
+	updated, recorded, err := app.Store.RecordGuardianDecision(ctx, pending.ID, storage.GuardianDecision{
+		PhoneNumber: guardianPhone,
+		Decision:    decision,
+		DecidedAt:   time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if !recorded {
+		return "", fmt.Errorf("decision already recorded")
+	}
+
+	approvals, denials := 0, 0
+	for _, d := range updated.Decisions {
+		if d.Decision == storage.GuardianApprove {
+			approvals++
+		} else {
+			denials++
+		}
+	}
+
+	switch {
+	case approvals >= updated.RequiredApprovals:
+		approved, err := app.Store.TransitionPendingTransfer(ctx, updated.ID, storage.PendingTransferPending, storage.PendingTransferApproved)
+		if err != nil || !approved {
+			return updated.Status, err
+		}
+		sender, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, updated.SenderAddress)
+		if err != nil {
+			return storage.PendingTransferApproved, err
+		}
+		if !exists {
+			return storage.PendingTransferApproved, fmt.Errorf("sender no longer registered")
+		}
+		// Limits and balances are re-checked because they may have moved while waiting.
+		// The risk engine already ran before the transfer was parked.
+		err = app.executeTransfer(ctx, sender, updated.SenderPhone, transferOrder{
+			Recipient:        updated.Recipient,
+			Crypto:           updated.Crypto,
+			RecipientCrypto:  updated.RecipientCrypto,
+			Network:          updated.Network,
+			AmountUSD:        updated.AmountUSD,
+			RiskCleared:      true,
+			GuardianApproved: true,
+		})
+		app.settlePaymentRequest(ctx, updated.PaymentRequestID, err == nil)
+		return storage.PendingTransferApproved, err
+
+	case denials > len(updated.Guardians)-updated.RequiredApprovals:
+		denied, err := app.Store.TransitionPendingTransfer(ctx, updated.ID, storage.PendingTransferPending, storage.PendingTransferDenied)
+		if err != nil || !denied {
+			return updated.Status, err
+		}
+		utils.SendSMS(updated.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Transfer %s was denied by your guardians", updated.Reference))
+		app.settlePaymentRequest(ctx, updated.PaymentRequestID, false)
+		return storage.PendingTransferDenied, nil
+	}
+	return storage.PendingTransferPending, nil
+}
+
+// ExpirePendingTransfers closes guardian-gated transfers nobody decided on in time
+func (app *App) ExpirePendingTransfers(ctx context.Context) error {
+	transfers, err := app.Store.ListExpiredPendingTransfers(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, transfer := range transfers {
+		expired, err := app.Store.TransitionPendingTransfer(ctx, transfer.ID, storage.PendingTransferPending, storage.PendingTransferExpired)
+		if err != nil {
+			return err
+		}
+		if expired {
+			utils.SendSMS(transfer.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Transfer %s expired without guardian approval", transfer.Reference))
+			app.settlePaymentRequest(ctx, transfer.PaymentRequestID, false)
+		}
+	}
+	return nil
+}
+
+// ProcessApproveCommand handles the APPROVE <reference> SMS command sent by a guardian
+func (app *App) ProcessApproveCommand(phoneNumber string, args []string) error {
+	return app.processGuardianCommand(phoneNumber, args, storage.GuardianApprove)
+}
+
+// ProcessDenyCommand handles the DENY <reference> SMS command sent by a guardian
+func (app *App) ProcessDenyCommand(phoneNumber string, args []string) error {
+	return app.processGuardianCommand(phoneNumber, args, storage.GuardianDeny)
+}
+
+func (app *App) processGuardianCommand(phoneNumber string, args []string, decision string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply APPROVE <reference> or DENY <reference>")
+		return fmt.Errorf("missing reference")
+	}
+
+	pending, exists, err := app.Store.GetPendingTransferForGuardian(ctx, strings.ToUpper(args[0]), phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching pending transfer: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No pending transfer matches that reference")
+		return fmt.Errorf("unknown pending transfer reference")
+	}
+
+	status, err := app.DecidePendingTransfer(ctx, pending, phoneNumber, decision)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Your decision could not be recorded")
+		return fmt.Errorf("error deciding pending transfer: %w", err)
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Recorded. Transfer %s is %s", pending.Reference, status))
+	return nil
+}
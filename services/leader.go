@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"crypto-sms/utils"
+)
+
+// leaseTTL must comfortably exceed the interval of any job wrapped in LeaderOnly, so the
+// leader renews its lease before it lapses
+const leaseTTL = 3 * time.Minute
+
+// instanceID identifies this replica when competing for leases
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	hostname, _ := os.Hostname()
+	suffix, _ := utils.GenerateNumericCode(6)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), suffix)
+}
+
+// LeaderOnly wraps a periodic job so it only runs on the replica holding the named lease
+func (app *App) LeaderOnly(lease string, job func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		leader, err := app.Store.AcquireLease(ctx, lease, instanceID, leaseTTL)
+		if err != nil || !leader {
+			return err
+		}
+		return job(ctx)
+	}
+}
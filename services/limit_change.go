@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// defaultLimitIncreaseDelay applies when LIMIT_INCREASE_DELAY is unset or invalid
+const defaultLimitIncreaseDelay = 24 * time.Hour
+
+// LimitIncreaseDelay returns how long a limit increase or loosening of the rolling limits
+// waits before taking effect
+func LimitIncreaseDelay() time.Duration {
+	if delay, err := time.ParseDuration(os.Getenv("LIMIT_INCREASE_DELAY")); err == nil && delay >= 0 {
+		return delay
+	}
+	return defaultLimitIncreaseDelay
+}
+
+// ScheduleLimitIncrease records a limit increase that takes effect after the cooling-off
+// delay and notifies the phone on file with a code that cancels it
+func (app *App) ScheduleLimitIncrease(ctx context.Context, service *storage.SmsService, newLimit float64) (*storage.LimitChange, error) {
+	change := &storage.LimitChange{
+		Kind:     storage.LimitChangeKindLimit,
+		OldLimit: service.Limit,
+		NewLimit: newLimit,
+	}
+	notice := fmt.Sprintf("Your limit will rise from $%.2f to $%.2f", service.Limit, newLimit)
+	if err := app.scheduleLimitChange(ctx, service, change, notice); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// ScheduleVelocityLimitChange records a replacement of the rolling limits that raises or
+// removes one of them. Like a limit increase, it takes effect after the cooling-off delay
+// and can be cancelled from the phone on file.
+func (app *App) ScheduleVelocityLimitChange(ctx context.Context, service *storage.SmsService, limits []storage.VelocityLimit) (*storage.LimitChange, error) {
+	change := &storage.LimitChange{
+		Kind:           storage.LimitChangeKindVelocity,
+		OldLimit:       service.Limit,
+		NewLimit:       service.Limit,
+		VelocityLimits: limits,
+	}
+	if err := app.scheduleLimitChange(ctx, service, change, "Your rolling limits will be loosened"); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// ScheduleGuardianChange records a guardian policy that weakens or removes the current one.
+// It takes effect after the cooling-off delay so a stolen passkey can't switch off guardians
+// before the owner notices, and can be cancelled from the phone on file.
+func (app *App) ScheduleGuardianChange(ctx context.Context, service *storage.SmsService, policy *storage.GuardianPolicy) (*storage.LimitChange, error) {
+	change := &storage.LimitChange{
+		Kind:      storage.LimitChangeKindGuardians,
+		OldLimit:  service.Limit,
+		NewLimit:  service.Limit,
+		Guardians: policy,
+	}
+	notice := "Your guardians will be changed"
+	if policy == nil {
+		notice = "Your guardians will be removed"
+	}
+	if err := app.scheduleLimitChange(ctx, service, change, notice); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (app *App) scheduleLimitChange(ctx context.Context, service *storage.SmsService, change *storage.LimitChange, notice string) error {
+	cancelCode, err := utils.GenerateNumericCode(6)
+	if err != nil {
+		return fmt.Errorf("error generating cancel code: %w", err)
+	}
+
+	now := time.Now()
+	change.WalletAddress = service.WalletAddress
+	change.CancelCode = cancelCode
+	change.Status = storage.LimitChangePending
+	change.CreatedAt = now
+	change.EffectiveAt = now.Add(LimitIncreaseDelay())
+	if err := app.Store.CreateLimitChange(ctx, change); err != nil {
+		return err
+	}
+
+	if service.PhoneNumber != "" {
+		utils.SendSMS(service.PhoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"%s on %s UTC. Not you? Reply CANCEL %s", notice, change.EffectiveAt.UTC().Format("Jan 2 15:04"), cancelCode))
+	}
+	return nil
+}
+
+// CancelPendingLimitChanges cancels a wallet's pending changes of one kind, such as pending
+// increases once the user lowers the limit
+func (app *App) CancelPendingLimitChanges(ctx context.Context, walletAddress string, kind string) error {
+	changes, err := app.Store.ListPendingLimitChanges(ctx, walletAddress)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.ChangeKind() != kind {
+			continue
+		}
+		if err := app.Store.SetLimitChangeStatus(ctx, change.ID, storage.LimitChangeCancelled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyDueLimitChanges applies any pending limit changes whose cooling-off period has
+// passed and updates service's limits to match
+func (app *App) ApplyDueLimitChanges(ctx context.Context, service *storage.SmsService) error {
+	changes, err := app.Store.ListPendingLimitChanges(ctx, service.WalletAddress)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, change := range changes {
+		if change.EffectiveAt.After(now) {
+			break
+		}
+		switch change.ChangeKind() {
+		case storage.LimitChangeKindVelocity:
+			if err := app.Store.UpdateVelocityLimits(ctx, service.WalletAddress, change.VelocityLimits); err != nil {
+				return err
+			}
+			service.VelocityLimits = change.VelocityLimits
+		case storage.LimitChangeKindGuardians:
+			if err := app.Store.UpdateGuardians(ctx, service.WalletAddress, change.Guardians); err != nil {
+				return err
+			}
+			service.Guardians = change.Guardians
+		default:
+			if err := app.Store.UpdateLimit(ctx, service.WalletAddress, change.NewLimit); err != nil {
+				return err
+			}
+			service.Limit = change.NewLimit
+		}
+		if err := app.Store.SetLimitChangeStatus(ctx, change.ID, storage.LimitChangeApplied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProcessCancelCommand handles the CANCEL <code> SMS command for limit changes and scheduled transfers
+func (app *App) ProcessCancelCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply CANCEL <code> or CANCEL <reference>")
+		return fmt.Errorf("missing cancel code")
+	}
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("phone number not registered")
+	}
+
+	if reference := strings.ToUpper(args[0]); strings.HasPrefix(reference, "S") {
+		return app.cancelScheduledTransferBySMS(ctx, phoneNumber, service, reference)
+	}
+
+	cancelled, err := app.Store.CancelLimitChangeByCode(ctx, service.WalletAddress, args[0])
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error cancelling limit change: %w", err)
+	}
+	if !cancelled {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No pending change matches that code")
+		return fmt.Errorf("unknown cancel code")
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Limit change cancelled")
+	return nil
+}
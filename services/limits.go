@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// velocityWindows are the rolling windows a VelocityLimit may use
+var velocityWindows = map[string]time.Duration{
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+}
+
+// walletLockTTL bounds how long a replica that dies mid-transfer keeps the sender's wallet
+// locked. It must comfortably exceed the time a transfer takes.
+const walletLockTTL = time.Minute
+
+// walletLockWait is how long a transfer waits for the sender's previous transfer to finish
+const walletLockWait = 10 * time.Second
+
+// walletLockRetry is how often a waiting transfer tries the lock again
+const walletLockRetry = 50 * time.Millisecond
+
+// ErrWalletBusy is returned when another transfer from the same wallet holds the lock for
+// longer than walletLockWait
+var ErrWalletBusy = errors.New("another transfer from this wallet is in progress")
+
+// VelocityLimitError describes a transfer rejected by a rolling spending limit
+type VelocityLimitError struct {
+	Limit     storage.VelocityLimit
+	Remaining float64
+	ResetsAt  time.Time
+}
+
+func (e *VelocityLimitError) Error() string {
+	return fmt.Sprintf("%s limit of $%.2f exceeded", e.Limit.Window, e.Limit.AmountUSD)
+}
+
+// TransferError converts the rejection into a VELOCITY_LIMIT TransferError
+func (e *VelocityLimitError) TransferError() *TransferError {
+	scope := ""
+	if e.Limit.Asset != "" {
+		scope = " " + strings.ToUpper(e.Limit.Asset)
+	}
+	window := strings.ToUpper(e.Limit.Window[:1]) + e.Limit.Window[1:]
+	return NewTransferError(ErrCodeVelocityLimit, e, window+scope, e.Remaining, e.ResetsAt.UTC().Format("Jan 2 15:04"))
+}
+
+// ValidateVelocityLimits checks that every limit uses a known window and a positive amount
+func ValidateVelocityLimits(limits []storage.VelocityLimit) error {
+	for _, limit := range limits {
+		if _, ok := velocityWindows[limit.Window]; !ok {
+			return fmt.Errorf("unknown window %q", limit.Window)
+		}
+		if limit.AmountUSD <= 0 {
+			return fmt.Errorf("%s limit must be positive", limit.Window)
+		}
+	}
+	return nil
+}
+
+// LoosensVelocityLimits reports whether replacing the current rolling limits with next
+// raises or removes any of them. Adding or lowering limits only tightens them.
+func LoosensVelocityLimits(current []storage.VelocityLimit, next []storage.VelocityLimit) bool {
+	for _, limit := range current {
+		kept := false
+		for _, replacement := range next {
+			if replacement.Window == limit.Window && strings.EqualFold(replacement.Asset, limit.Asset) && replacement.AmountUSD <= limit.AmountUSD {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckVelocityLimits verifies that sending amountUSD of crypto stays within every
+// rolling limit configured on the sender's service. Callers hold the sender's wallet lock
+// until the transfer is recorded.
+func (app *App) CheckVelocityLimits(ctx context.Context, service *storage.SmsService, crypto string, amountUSD float64) error {
+	if len(service.VelocityLimits) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	longest := time.Duration(0)
+	for _, limit := range service.VelocityLimits {
+		if window := velocityWindows[limit.Window]; window > longest {
+			longest = window
+		}
+	}
+	history, err := app.Store.ListTransactionsSince(ctx, service.WalletAddress, now.Add(-longest))
+	if err != nil {
+		return err
+	}
+
+	for _, limit := range service.VelocityLimits {
+		window, ok := velocityWindows[limit.Window]
+		if !ok {
+			continue
+		}
+		if limit.Asset != "" && !strings.EqualFold(limit.Asset, crypto) {
+			continue
+		}
+
+		var counted []storage.Transaction
+		spent := 0.0
+		for _, transaction := range history {
+			if transaction.CreatedAt.Before(now.Add(-window)) {
+				continue
+			}
+			if limit.Asset != "" && !strings.EqualFold(transaction.Crypto, limit.Asset) {
+				continue
+			}
+			counted = append(counted, transaction)
+			spent += outstandingUSD(transaction)
+		}
+		if spent+amountUSD <= limit.AmountUSD {
+			continue
+		}
+
+		// Transfers age out oldest first, so find the one whose expiry frees enough headroom
+		resetsAt := now.Add(window)
+		for _, transaction := range counted {
+			spent -= outstandingUSD(transaction)
+			if spent+amountUSD <= limit.AmountUSD {
+				resetsAt = transaction.CreatedAt.Add(window)
+				break
+			}
+		}
+
+		remaining := limit.AmountUSD
+		for _, transaction := range counted {
+			remaining -= outstandingUSD(transaction)
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+		return &VelocityLimitError{Limit: limit, Remaining: remaining, ResetsAt: resetsAt}
+	}
+	return nil
+}
+
+// outstandingUSD is the part of a transfer that counts towards velocity limits, which
+// excludes whatever was refunded by a reversal
+func outstandingUSD(transaction storage.Transaction) float64 {
+	return transaction.AmountUSD - transaction.RefundedUSD
+}
+
+// lockWallet serializes transfers from a wallet across replicas, so a transfer admitted by
+// the velocity limits is recorded before the next transfer from the wallet is checked. It
+// waits up to walletLockWait for the previous transfer and returns a function releasing
+// the lock.
+func (app *App) lockWallet(ctx context.Context, walletAddress string) (func(), error) {
+	name := "transfer:" + walletAddress
+	holder := instanceID + "/" + primitive.NewObjectID().Hex()
+	deadline := time.Now().Add(walletLockWait)
+	for {
+		acquired, err := app.Store.AcquireLease(ctx, name, holder, walletLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return func() { app.Store.ReleaseLease(context.Background(), name, holder) }, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrWalletBusy
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(walletLockRetry):
+		}
+	}
+}
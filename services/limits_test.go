@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+func TestCheckVelocityLimits(t *testing.T) {
+	now := time.Now()
+	daily := []storage.VelocityLimit{{Window: "daily", AmountUSD: 100}}
+	tests := []struct {
+		name      string
+		limits    []storage.VelocityLimit
+		history   []storage.Transaction
+		crypto    string
+		amountUSD float64
+		want      bool
+		remaining float64
+	}{
+		{"no limits", nil, []storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "USDC", AmountUSD: 500}}, "USDC", 500, true, 0},
+		{"within the limit", daily, []storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "USDC", AmountUSD: 60}}, "USDC", 40, true, 0},
+		{"over the limit", daily, []storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "USDC", AmountUSD: 60}}, "USDC", 41, false, 40},
+		{"outside the window", daily, []storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "USDC", AmountUSD: 90, CreatedAt: now.Add(-25 * time.Hour)}}, "USDC", 100, true, 0},
+		{"adjustments don't count", daily, []storage.Transaction{{Kind: storage.TransactionAdjustment, Crypto: "USDC", AmountUSD: 90}}, "USDC", 100, true, 0},
+		{"reversals don't count", daily, []storage.Transaction{{Kind: storage.TransactionReversal, Crypto: "USDC", AmountUSD: 90}}, "USDC", 100, true, 0},
+		{"reversed transfers don't count", daily, []storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "USDC", AmountUSD: 90, RefundedUSD: 90}}, "USDC", 100, true, 0},
+		{"partly refunded transfers count the rest", daily, []storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "USDC", AmountUSD: 90, RefundedUSD: 30}}, "USDC", 41, false, 40},
+		{"other assets don't count towards an asset limit", []storage.VelocityLimit{{Window: "daily", Asset: "USDC", AmountUSD: 100}},
+			[]storage.Transaction{{Kind: storage.TransactionTransfer, Crypto: "ETH", AmountUSD: 90}}, "USDC", 100, true, 0},
+		{"asset limits ignore other assets", []storage.VelocityLimit{{Window: "daily", Asset: "USDC", AmountUSD: 100}}, nil, "ETH", 500, true, 0},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		app := NewApp(memory.NewStore(), nil)
+		for _, transaction := range test.history {
+			transaction.SenderAddress = testWallet
+			if transaction.CreatedAt.IsZero() {
+				transaction.CreatedAt = now.Add(-time.Hour)
+			}
+			if err := app.Store.CreateTransaction(ctx, &transaction); err != nil {
+				t.Fatalf("%s: recording history: %v", test.name, err)
+			}
+		}
+		service := &storage.SmsService{WalletAddress: testWallet, VelocityLimits: test.limits}
+
+		err := app.CheckVelocityLimits(ctx, service, test.crypto, test.amountUSD)
+		var limitErr *VelocityLimitError
+		if test.want {
+			if err != nil {
+				t.Errorf("%s: %v, want the transfer allowed", test.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: %v, want a VelocityLimitError", test.name, err)
+			continue
+		}
+		if limitErr.Remaining != test.remaining {
+			t.Errorf("%s: $%.2f remaining, want $%.2f", test.name, limitErr.Remaining, test.remaining)
+		}
+	}
+}
+
+func TestLockWalletSerializesTransfers(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	unlock, err := app.lockWallet(ctx, testWallet)
+	if err != nil {
+		t.Fatalf("locking the wallet: %v", err)
+	}
+
+	// Another wallet is never held up by this one
+	unlockOther, err := app.lockWallet(ctx, "0x2222222222222222222222222222222222222222")
+	if err != nil {
+		t.Fatalf("locking another wallet: %v", err)
+	}
+	unlockOther()
+
+	waiting, cancel := context.WithTimeout(ctx, 3*walletLockRetry)
+	defer cancel()
+	if _, err := app.lockWallet(waiting, testWallet); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("locking a locked wallet returned %v, want it to wait", err)
+	}
+
+	locked := make(chan error)
+	go func() {
+		unlock, err := app.lockWallet(ctx, testWallet)
+		if err == nil {
+			unlock()
+		}
+		locked <- err
+	}()
+	unlock()
+	if err := <-locked; err != nil {
+		t.Errorf("locking the wallet once it was released: %v", err)
+	}
+}
+
+func TestConcurrentTransfersStayWithinVelocityLimits(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	service := &storage.SmsService{WalletAddress: testWallet, VelocityLimits: []storage.VelocityLimit{{Window: "daily", AmountUSD: 100}}}
+
+	// Each transfer checks the limits and records itself under the wallet lock, as
+	// executeTransfer does
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := app.lockWallet(ctx, testWallet)
+			if err != nil {
+				t.Errorf("locking the wallet: %v", err)
+				return
+			}
+			defer unlock()
+			if err := app.CheckVelocityLimits(ctx, service, "USDC", 30); err != nil {
+				return
+			}
+			transaction := &storage.Transaction{Kind: storage.TransactionTransfer, SenderAddress: testWallet, Crypto: "USDC", AmountUSD: 30, CreatedAt: time.Now()}
+			if err := app.Store.CreateTransaction(ctx, transaction); err != nil {
+				t.Errorf("recording the transfer: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	transactions, err := app.Store.ListTransactionsSince(ctx, testWallet, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("listing transactions: %v", err)
+	}
+	if len(transactions) != 3 {
+		t.Errorf("%d concurrent $30 transfers were admitted under a $100 daily limit, want 3", len(transactions))
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// defaultPaymentRequestExpiry applies when PAYMENT_REQUEST_EXPIRY is unset or invalid
+const defaultPaymentRequestExpiry = 72 * time.Hour
+
+// ErrPayerNotRegistered is returned when a payment request names an unknown phone number
+var ErrPayerNotRegistered = errors.New("payer not registered")
+
+// PaymentRequestExpiry returns how long a payment request stays open
+func PaymentRequestExpiry() time.Duration {
+	if expiry, err := time.ParseDuration(os.Getenv("PAYMENT_REQUEST_EXPIRY")); err == nil && expiry > 0 {
+		return expiry
+	}
+	return defaultPaymentRequestExpiry
+}
+
+// CreatePaymentRequest records a request for payerPhone to pay the requester and texts
+// the payer a reference to approve or decline it with
+func (app *App) CreatePaymentRequest(ctx context.Context, requester *storage.SmsService, payerPhone string, asset string, amountUSD float64) (*storage.PaymentRequest, error) {
+	if !strings.HasPrefix(payerPhone, "+") {
+		payerPhone = "+" + payerPhone
+	}
+	resolved, err := app.ResolveAsset(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	payer, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, payerPhone)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPayerNotRegistered
+	}
+
+	reference, err := utils.GenerateNumericCode(6)
+	if err != nil {
+		return nil, fmt.Errorf("error generating reference: %w", err)
+	}
+	now := time.Now()
+	request := &storage.PaymentRequest{
+		Reference:        reference,
+		RequesterAddress: requester.WalletAddress,
+		RequesterPhone:   requester.PhoneNumber,
+		PayerAddress:     payer.WalletAddress,
+		PayerPhone:       payerPhone,
+		Asset:            resolved.Symbol,
+		AmountUSD:        amountUSD,
+		Status:           storage.PaymentRequestPending,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(PaymentRequestExpiry()),
+	}
+	if err := app.Store.CreatePaymentRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	utils.SendSMS(payerPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"%s requests $%.2f in %s. Reply PAY %s <passkey> to pay or DECLINE %s",
+		MaskPhoneNumber(requester.PhoneNumber), amountUSD, request.Asset, reference, reference))
+	return request, nil
+}
+
+// ApprovePaymentRequest pays a pending request from the payer's wallet and tells the
+// requester. When the payer's transfer is parked the request stays approving and
+// ErrTransferPending is returned; the request is settled once the transfer is decided.
+func (app *App) ApprovePaymentRequest(ctx context.Context, request *storage.PaymentRequest, passkey string) error {
+	// Claim the request first so two approvals can't both pay it
+	claimed, err := app.Store.TransitionPaymentRequest(ctx, request.ID, storage.PaymentRequestPending, storage.PaymentRequestApproving)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return fmt.Errorf("payment request is no longer pending")
+	}
+
+	err = app.ProcessTransaction(TransferRequest{
+		PhoneNumber:      request.PayerPhone,
+		Passkey:          passkey,
+		AmountUSD:        request.AmountUSD,
+		Crypto:           request.Asset,
+		RecipientAddress: request.RequesterAddress,
+		RecipientCrypto:  request.Asset,
+		PaymentRequestID: request.ID,
+	})
+	if errors.Is(err, ErrTransferPending) {
+		return err
+	}
+	app.settlePaymentRequest(ctx, request.ID, err == nil)
+	return err
+}
+
+// settlePaymentRequest closes an approving request once the transfer paying it has run or
+// failed. Paid requests are announced to the requester; failed ones reopen for the payer.
+func (app *App) settlePaymentRequest(ctx context.Context, id primitive.ObjectID, paid bool) {
+	if id.IsZero() {
+		return
+	}
+	if !paid {
+		if _, err := app.Store.TransitionPaymentRequest(ctx, id, storage.PaymentRequestApproving, storage.PaymentRequestPending); err != nil {
+			log.Printf("Error reopening payment request %s: %v", id.Hex(), err)
+		}
+		return
+	}
+
+	// The request may have expired while its transfer waited, but the funds have moved
+	settled, err := app.Store.TransitionPaymentRequest(ctx, id, storage.PaymentRequestApproving, storage.PaymentRequestPaid)
+	if err == nil && !settled {
+		settled, err = app.Store.TransitionPaymentRequest(ctx, id, storage.PaymentRequestExpired, storage.PaymentRequestPaid)
+	}
+	if err != nil || !settled {
+		log.Printf("Error marking payment request %s paid: %v", id.Hex(), err)
+		return
+	}
+	request, exists, err := app.Store.GetPaymentRequest(ctx, id)
+	if err != nil || !exists {
+		log.Printf("Error fetching payment request %s: %v", id.Hex(), err)
+		return
+	}
+	utils.SendSMS(request.RequesterPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"%s paid your $%.2f %s request %s", MaskPhoneNumber(request.PayerPhone), request.AmountUSD, request.Asset, request.Reference))
+}
+
+// DeclinePaymentRequest declines a pending request and tells the requester
+func (app *App) DeclinePaymentRequest(ctx context.Context, request *storage.PaymentRequest) error {
+	declined, err := app.Store.TransitionPaymentRequest(ctx, request.ID, storage.PaymentRequestPending, storage.PaymentRequestDeclined)
+	if err != nil {
+		return err
+	}
+	if !declined {
+		return fmt.Errorf("payment request is no longer pending")
+	}
+	utils.SendSMS(request.RequesterPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"%s declined your $%.2f %s request", MaskPhoneNumber(request.PayerPhone), request.AmountUSD, request.Asset))
+	return nil
+}
+
+// ExpirePaymentRequests closes open requests past their expiry and tells the requester
+func (app *App) ExpirePaymentRequests(ctx context.Context) error {
+	requests, err := app.Store.ListExpiredPaymentRequests(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		expired, err := app.Store.TransitionPaymentRequest(ctx, request.ID, request.Status, storage.PaymentRequestExpired)
+		if err != nil {
+			return err
+		}
+		if expired {
+			utils.SendSMS(request.RequesterPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+				"Your $%.2f %s request to %s expired", request.AmountUSD, request.Asset, MaskPhoneNumber(request.PayerPhone)))
+		}
+	}
+	return nil
+}
+
+// ProcessRequestCommand handles the REQ <amount> <asset> FROM <phone> SMS command
+func (app *App) ProcessRequestCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 4 || !strings.EqualFold(args[2], "FROM") {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply REQ <amount> <asset> FROM <phone>")
+		return fmt.Errorf("malformed request command")
+	}
+	amountUSD, err := strconv.ParseFloat(strings.TrimPrefix(args[0], "$"), 64)
+	if err != nil || amountUSD <= 0 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Invalid amount")
+		return fmt.Errorf("invalid amount %q", args[0])
+	}
+
+	requester, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("phone number not registered")
+	}
+
+	request, err := app.CreatePaymentRequest(ctx, requester, args[3], args[1], amountUSD)
+	if errors.Is(err, ErrPayerNotRegistered) {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "That phone number is not registered")
+		return err
+	}
+	if errors.Is(err, ErrUnknownAsset) || errors.Is(err, ErrAssetDisabled) {
+		return ReplyTransferError(phoneNumber, LanguageOf(requester), assetTransferError(args[1], err))
+	}
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error creating payment request: %w", err)
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Request %s for $%.2f %s sent to %s",
+		request.Reference, request.AmountUSD, request.Asset, MaskPhoneNumber(request.PayerPhone)))
+	return nil
+}
+
+// ProcessPayCommand handles the PAY <reference> <passkey> SMS command
+func (app *App) ProcessPayCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 2 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply PAY <reference> <passkey>")
+		return fmt.Errorf("malformed pay command")
+	}
+
+	request, exists, err := app.Store.GetPendingPaymentRequest(ctx, phoneNumber, args[0])
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching payment request: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No open request matches that reference")
+		return fmt.Errorf("unknown payment request reference")
+	}
+
+	// ProcessTransaction replies to the payer itself, including when the transfer is parked
+	err = app.ApprovePaymentRequest(ctx, request, args[1])
+	if errors.Is(err, ErrTransferPending) {
+		return nil
+	}
+	return err
+}
+
+// ProcessDeclineCommand handles the DECLINE <reference> SMS command
+func (app *App) ProcessDeclineCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply DECLINE <reference>")
+		return fmt.Errorf("malformed decline command")
+	}
+
+	request, exists, err := app.Store.GetPendingPaymentRequest(ctx, phoneNumber, args[0])
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching payment request: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No open request matches that reference")
+		return fmt.Errorf("unknown payment request reference")
+	}
+
+	if err := app.DeclinePaymentRequest(ctx, request); err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error declining payment request: %w", err)
+	}
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Request %s declined", request.Reference))
+	return nil
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultPricesUSD are used when no PRICE_USD_<ASSET> override is configured
+var defaultPricesUSD = map[string]float64{
+	"BTC":  60000,
+	"ETH":  2500,
+	"USDT": 1,
+	"USDC": 1,
+}
+
+// GetPriceUSD returns the USD price of one unit of the given asset
+func GetPriceUSD(asset string) (float64, bool) {
+	asset = strings.ToUpper(asset)
+	if value := os.Getenv("PRICE_USD_" + asset); value != "" {
+		price, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return price, true
+		}
+	}
+	price, ok := defaultPricesUSD[asset]
+	return price, ok
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// ErrRecipientNotFound is returned when an alias isn't linked to a wallet
+var ErrRecipientNotFound = errors.New("recipient not registered")
+
+// Recipient is a resolved transfer destination
+type Recipient struct {
+	// WalletAddress is empty when the recipient is an unregistered phone number
+	WalletAddress string
+	PhoneNumber   string
+	// Label is shown to the sender in confirmations; phone numbers are masked
+	Label string
+}
+
+// IsPhoneNumber reports whether s looks like a phone number, with or without a leading plus
+func IsPhoneNumber(s string) bool {
+	if !strings.HasPrefix(s, "+") {
+		s = "+" + s
+	}
+	return e164Pattern.MatchString(s)
+}
+
+// MaskPhoneNumber hides all but the country prefix and last four digits of a phone number
+func MaskPhoneNumber(phoneNumber string) string {
+	if len(phoneNumber) <= 6 {
+		return phoneNumber
+	}
+	return phoneNumber[:2] + strings.Repeat("*", len(phoneNumber)-6) + phoneNumber[len(phoneNumber)-4:]
+}
+
+// ResolveRecipient turns a wallet address, E.164 phone number or @alias into a wallet address.
+// Unregistered phone numbers resolve without a wallet so the transfer can be escrowed.
+func (app *App) ResolveRecipient(ctx context.Context, recipient string) (*Recipient, error) {
+	recipient = strings.TrimSpace(recipient)
+
+	switch {
+	case strings.HasPrefix(recipient, "@"):
+		service, exists, err := app.Store.CheckAliasExistsInSmsService(ctx, strings.ToLower(recipient[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrRecipientNotFound
+		}
+		label := recipient
+		if service.PhoneNumber != "" {
+			label += " (" + MaskPhoneNumber(service.PhoneNumber) + ")"
+		}
+		return &Recipient{WalletAddress: service.WalletAddress, PhoneNumber: service.PhoneNumber, Label: label}, nil
+
+	case IsPhoneNumber(recipient):
+		if !strings.HasPrefix(recipient, "+") {
+			recipient = "+" + recipient
+		}
+		service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, recipient)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return &Recipient{PhoneNumber: recipient, Label: MaskPhoneNumber(recipient)}, nil
+		}
+		return &Recipient{WalletAddress: service.WalletAddress, PhoneNumber: recipient, Label: MaskPhoneNumber(recipient)}, nil
+	}
+
+	label := recipient
+	if len(label) > 12 {
+		label = label[:6] + "..." + label[len(label)-4:]
+	}
+	return &Recipient{WalletAddress: recipient, Label: label}, nil
+}
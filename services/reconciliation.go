@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+const (
+	// defaultReconciliationTolerance applies when RECONCILIATION_TOLERANCE is unset or invalid.
+	// Balances are floats, so differences below it are rounding rather than drift.
+	defaultReconciliationTolerance = 0.01
+	// defaultReconciliationAlertThreshold applies when RECONCILIATION_ALERT_THRESHOLD is unset or invalid
+	defaultReconciliationAlertThreshold = 10.0
+)
+
+// ReconciliationTolerance returns the USD difference below which balances are considered equal
+func ReconciliationTolerance() float64 {
+	return envFloat("RECONCILIATION_TOLERANCE", defaultReconciliationTolerance)
+}
+
+// ReconciliationAlertThreshold returns the USD difference from which persistent
+// discrepancies raise an alert and, when enabled, freeze the wallet
+func ReconciliationAlertThreshold() float64 {
+	return envFloat("RECONCILIATION_ALERT_THRESHOLD", defaultReconciliationAlertThreshold)
+}
+
+func envFloat(name string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+// ledger accumulates the balance every account should hold according to the records of
+// each movement of funds, per account and asset
+type ledger map[string]map[string]float64
+
+func (l ledger) post(account string, asset string, amount float64) {
+	if account == "" || amount == 0 {
+		return
+	}
+	if l[account] == nil {
+		l[account] = map[string]float64{}
+	}
+	l[account][asset] += amount
+}
+
+// buildLedger replays transactions, resolved escrows and settled withdrawals into the
+// balances they should have produced
+func (app *App) buildLedger(ctx context.Context) (ledger, error) {
+	l := ledger{}
+	err := app.Store.EachTransaction(ctx, func(transaction *storage.Transaction) error {
+		amount, fee := transaction.AmountUSD, transaction.FeeUSD
+		switch transaction.Kind {
+		case storage.TransactionDeposit:
+			// The sender is the external address the deposit came from
+			l.post(transaction.RecipientAddress, transaction.RecipientCrypto, amount)
+		case storage.TransactionWithdrawal:
+			l.post(transaction.SenderAddress, transaction.Crypto, -amount-fee)
+			l.post(WithdrawalPendingAddress(), transaction.Crypto, amount)
+			l.post(FeeRevenueAddress(), transaction.Crypto, fee)
+		case storage.TransactionReversal, storage.TransactionAdjustment:
+			// Only withdrawal refunds carry a fee, which comes back out of fee revenue
+			l.post(transaction.SenderAddress, transaction.Crypto, -(amount - fee))
+			l.post(FeeRevenueAddress(), transaction.Crypto, -fee)
+			l.post(transaction.RecipientAddress, transaction.RecipientCrypto, amount)
+		default:
+			l.post(transaction.SenderAddress, transaction.Crypto, -amount-fee)
+			l.post(FeeRevenueAddress(), transaction.Crypto, fee)
+			if transaction.Escrowed {
+				l.post(EscrowAddress(), transaction.Crypto, amount)
+			} else {
+				l.post(transaction.RecipientAddress, transaction.RecipientCrypto, amount)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	escrows, err := app.Store.ListResolvedEscrows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, escrow := range escrows {
+		l.post(EscrowAddress(), escrow.Crypto, -escrow.AmountUSD)
+		if escrow.Status == storage.EscrowClaimed {
+			l.post(escrow.ClaimedBy, escrow.RecipientCrypto, escrow.AmountUSD)
+		} else {
+			l.post(escrow.SenderAddress, escrow.Crypto, escrow.AmountUSD)
+		}
+	}
+
+	// Confirmed withdrawals have left the pending account, and so have withdrawals a custody
+	// provider has accepted; refunds are recorded as reversals
+	withdrawals, err := app.Store.ListWithdrawalsByStatus(ctx, storage.WithdrawalConfirmed, storage.WithdrawalBroadcast)
+	if err != nil {
+		return nil, err
+	}
+	for _, withdrawal := range withdrawals {
+		if withdrawal.Status == storage.WithdrawalConfirmed || withdrawal.Custodian != "" {
+			l.post(WithdrawalPendingAddress(), withdrawal.Crypto, -withdrawal.AmountUSD)
+		}
+	}
+	return l, nil
+}
+
+// custodyAccounts lists every account with ledger postings or a custodian record, sorted
+func (app *App) custodyAccounts(ctx context.Context, l ledger) ([]string, error) {
+	seen := map[string]bool{}
+	for account := range l {
+		seen[account] = true
+	}
+	addresses, err := app.Store.ListCustodianAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		seen[address] = true
+	}
+
+	accounts := make([]string, 0, len(seen))
+	for account := range seen {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// reconcile compares every account's balance at the custody provider against the ledger and
+// reports the discrepancies it finds
+func (app *App) reconcile(ctx context.Context) (*storage.ReconciliationReport, error) {
+	l, err := app.buildLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := app.custodyAccounts(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[string]float64{}
+	if last, exists, err := app.Store.GetReconciliationReport(ctx, ""); err != nil {
+		return nil, err
+	} else if exists {
+		for _, discrepancy := range last.Discrepancies {
+			previous[discrepancy.Account+"/"+discrepancy.Asset] = discrepancy.DifferenceUSD
+		}
+	}
+
+	tolerance := ReconciliationTolerance()
+	report := &storage.ReconciliationReport{
+		Provider:      app.Custody().Name(),
+		Accounts:      len(accounts),
+		Discrepancies: []storage.Discrepancy{},
+		Totals:        []storage.AssetTotal{},
+		CreatedAt:     time.Now(),
+	}
+	totals := map[string]*storage.AssetTotal{}
+	for _, account := range accounts {
+		balances, err := app.Custody().Balances(ctx, account)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching balances of %s: %w", account, err)
+		}
+		assets := map[string]bool{}
+		for asset := range balances {
+			assets[asset] = true
+		}
+		for asset := range l[account] {
+			assets[asset] = true
+		}
+
+		for asset := range assets {
+			balance, expected := balances[asset], l[account][asset]
+			if totals[asset] == nil {
+				totals[asset] = &storage.AssetTotal{Asset: asset}
+			}
+			totals[asset].BalanceUSD += balance
+			totals[asset].LedgerUSD += expected
+
+			difference := balance - expected
+			if math.Abs(difference) <= tolerance {
+				continue
+			}
+			last, seen := previous[account+"/"+asset]
+			report.Discrepancies = append(report.Discrepancies, storage.Discrepancy{
+				Account:       account,
+				Asset:         asset,
+				BalanceUSD:    balance,
+				LedgerUSD:     expected,
+				DifferenceUSD: difference,
+				Persistent:    seen && math.Abs(last-difference) <= tolerance,
+			})
+		}
+	}
+	for _, total := range totals {
+		total.DifferenceUSD = total.BalanceUSD - total.LedgerUSD
+		report.Totals = append(report.Totals, *total)
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return math.Abs(report.Discrepancies[i].DifferenceUSD) > math.Abs(report.Discrepancies[j].DifferenceUSD)
+	})
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Asset < report.Totals[j].Asset })
+	return report, nil
+}
+
+// RunReconciliation reconciles all balances and stores the report. Accounts with persistent
+// discrepancies above the alert threshold are alerted on, texted to
+// RECONCILIATION_ALERT_PHONE, and with RECONCILIATION_FREEZE=true their wallets are frozen.
+func (app *App) RunReconciliation(ctx context.Context) error {
+	report, err := app.reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	previous, _, err := app.Store.GetReconciliationReport(ctx, "")
+	if err != nil {
+		return err
+	}
+	alerted := map[string]bool{}
+	if previous != nil {
+		for _, account := range previous.Alerts {
+			alerted[account] = true
+		}
+	}
+
+	threshold := ReconciliationAlertThreshold()
+	seen := map[string]bool{}
+	var fresh []string
+	for _, discrepancy := range report.Discrepancies {
+		account := discrepancy.Account
+		if !discrepancy.Persistent || math.Abs(discrepancy.DifferenceUSD) < threshold || seen[account] {
+			continue
+		}
+		seen[account] = true
+		report.Alerts = append(report.Alerts, account)
+		if !alerted[account] {
+			fresh = append(fresh, account)
+		}
+	}
+	sort.Strings(report.Alerts)
+
+	// Freezes point at the report that caused them, so its ID is chosen before it is stored
+	report.ID = primitive.NewObjectID()
+	if os.Getenv("RECONCILIATION_FREEZE") == "true" {
+		system := map[string]bool{EscrowAddress(): true, WithdrawalPendingAddress(): true, FeeRevenueAddress(): true}
+		for _, account := range report.Alerts {
+			if system[account] {
+				continue
+			}
+			frozen, err := app.Store.FreezeWallet(ctx, &storage.WalletFreeze{
+				WalletAddress: account,
+				ReportID:      report.ID,
+				Reason:        "balance does not match the ledger",
+				CreatedAt:     time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			if frozen {
+				report.Frozen = append(report.Frozen, account)
+			}
+		}
+	}
+	if err := app.Store.CreateReconciliationReport(ctx, report); err != nil {
+		return err
+	}
+
+	// Accounts stay in the alerts of every report until resolved, but are only announced once
+	if len(fresh) == 0 && len(report.Frozen) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("Reconciliation report %s: %d accounts newly out of balance by $%.2f or more", report.ID.Hex(), len(fresh), threshold)
+	if len(report.Frozen) > 0 {
+		message += fmt.Sprintf(", %d wallets frozen", len(report.Frozen))
+	}
+	log.Print(message)
+	if phone := os.Getenv("RECONCILIATION_ALERT_PHONE"); phone != "" {
+		utils.SendSMS(phone, os.Getenv("TWILIO_PHONE_NUMBER"), message)
+	}
+	return nil
+}
+
+// WriteReconciliationCSV writes a report's discrepancies as CSV
+func WriteReconciliationCSV(w io.Writer, report *storage.ReconciliationReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"account", "asset", "balance_usd", "ledger_usd", "difference_usd", "persistent"})
+	for _, discrepancy := range report.Discrepancies {
+		writer.Write([]string{
+			discrepancy.Account,
+			discrepancy.Asset,
+			strconv.FormatFloat(discrepancy.BalanceUSD, 'f', 2, 64),
+			strconv.FormatFloat(discrepancy.LedgerUSD, 'f', 2, 64),
+			strconv.FormatFloat(discrepancy.DifferenceUSD, 'f', 2, 64),
+			strconv.FormatBool(discrepancy.Persistent),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReconciliationResolution describes an operator's resolution of a wallet's discrepancies.
+// AdjustLedger records adjustments that bring the ledger in line with the wallet's current
+// balances, for drift the operator has confirmed to be legitimate.
+type ReconciliationResolution struct {
+	WalletAddress string `json:"wallet_address"`
+	// Operator is taken from the admin credential, never from the request body
+	Operator     string `json:"-"`
+	Reason       string `json:"reason"`
+	AdjustLedger bool   `json:"adjust_ledger"`
+}
+
+// ResolveReconciliation applies an operator's resolution and lifts the wallet's freeze. It
+// returns the adjustments recorded.
+func (app *App) ResolveReconciliation(ctx context.Context, req ReconciliationResolution) ([]storage.Transaction, error) {
+	if req.WalletAddress == "" || req.Operator == "" || req.Reason == "" {
+		return nil, errors.New("wallet address, operator and reason are required")
+	}
+
+	adjustments := []storage.Transaction{}
+	if req.AdjustLedger {
+		l, err := app.buildLedger(ctx)
+		if err != nil {
+			return nil, err
+		}
+		balances, err := app.Custody().Balances(ctx, req.WalletAddress)
+		if err != nil {
+			return nil, err
+		}
+		assets := map[string]bool{}
+		for asset := range balances {
+			assets[asset] = true
+		}
+		for asset := range l[req.WalletAddress] {
+			assets[asset] = true
+		}
+
+		for asset := range assets {
+			difference := balances[asset] - l[req.WalletAddress][asset]
+			if math.Abs(difference) <= ReconciliationTolerance() {
+				continue
+			}
+			adjustment := storage.Transaction{
+				Kind:            storage.TransactionAdjustment,
+				Crypto:          asset,
+				RecipientCrypto: asset,
+				AmountUSD:       math.Abs(difference),
+				Reason:          req.Reason,
+				Operator:        req.Operator,
+				CreatedAt:       time.Now(),
+			}
+			if difference > 0 {
+				adjustment.RecipientAddress = req.WalletAddress
+			} else {
+				adjustment.SenderAddress = req.WalletAddress
+			}
+			if err := app.Store.CreateTransaction(ctx, &adjustment); err != nil {
+				return nil, err
+			}
+			adjustments = append(adjustments, adjustment)
+		}
+	}
+
+	if _, err := app.Store.ResolveWalletFreeze(ctx, req.WalletAddress, req.Operator, req.Reason); err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// verificationCodeEncoding spells reserve verification codes without padding or lookalike
+// characters, so they survive being read out of an SMS
+var verificationCodeEncoding = base32.NewEncoding("ABCDEFGHJKLMNPQRSTUVWXYZ23456789").WithPadding(base32.NoPadding)
+
+// ReserveAccountHash commits to a wallet address as SHA-256(salt || wallet address)
+func ReserveAccountHash(salt []byte, walletAddress string) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, salt...), walletAddress...))
+	return hash[:]
+}
+
+// ReserveLeaf hashes an account's leaf. Its data is the account hash followed by the balances
+// in cents as "ASSET=cents" pairs, sorted by asset and joined with ";", and its sum is the
+// total of the balances.
+func ReserveLeaf(accountHash []byte, balancesCents map[string]uint64) (utils.MerkleSumNode, error) {
+	assets := make([]string, 0, len(balancesCents))
+	for asset := range balancesCents {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	pairs := make([]string, len(assets))
+	var sum uint64
+	for i, asset := range assets {
+		pairs[i] = asset + "=" + strconv.FormatUint(balancesCents[asset], 10)
+		if sum+balancesCents[asset] < sum {
+			return utils.MerkleSumNode{}, fmt.Errorf("balance sum overflows")
+		}
+		sum += balancesCents[asset]
+	}
+	data := append(append([]byte{}, accountHash...), strings.Join(pairs, ";")...)
+	return utils.MerkleSumLeaf(data, sum), nil
+}
+
+// PublishReserveSnapshot commits every account's balances at the custody provider to a Merkle
+// sum tree and publishes its root with the total liabilities. Fee revenue is the service's
+// own and isn't a liability. Negative balances count as zero, since they can't lower what
+// is owed to everyone else.
+func (app *App) PublishReserveSnapshot(ctx context.Context) error {
+	l, err := app.buildLedger(ctx)
+	if err != nil {
+		return err
+	}
+	accounts, err := app.custodyAccounts(ctx, l)
+	if err != nil {
+		return err
+	}
+
+	// Leaves are shuffled so their position doesn't hint at the wallet address
+	mathrand.Shuffle(len(accounts), func(i, j int) { accounts[i], accounts[j] = accounts[j], accounts[i] })
+
+	snapshot := &storage.ReserveSnapshot{AssetTotalsCents: map[string]uint64{}, CreatedAt: time.Now()}
+	var proofs []storage.ReserveProof
+	var leaves []utils.MerkleSumNode
+	for _, account := range accounts {
+		if account == FeeRevenueAddress() {
+			continue
+		}
+		balances, err := app.Custody().Balances(ctx, account)
+		if err != nil {
+			return fmt.Errorf("error fetching balances of %s: %w", account, err)
+		}
+		cents := map[string]uint64{}
+		for asset, amount := range balances {
+			if amount = math.Round(amount * 100); amount > 0 {
+				cents[asset] = uint64(amount)
+				snapshot.AssetTotalsCents[asset] += uint64(amount)
+			}
+		}
+		if len(cents) == 0 {
+			continue
+		}
+
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		accountHash := ReserveAccountHash(salt, account)
+		leaf, err := ReserveLeaf(accountHash, cents)
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, leaf)
+		proofs = append(proofs, storage.ReserveProof{
+			WalletAddress: account,
+			Code:          verificationCodeEncoding.EncodeToString(leaf.Hash[:5]),
+			Salt:          hex.EncodeToString(salt),
+			AccountHash:   hex.EncodeToString(accountHash),
+			BalancesCents: cents,
+			SumCents:      leaf.Sum,
+			Leaf:          hex.EncodeToString(leaf.Hash),
+		})
+	}
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	root, paths, err := utils.BuildMerkleSumTree(leaves)
+	if err != nil {
+		return err
+	}
+	for i := range proofs {
+		proofs[i].Proof = paths[i]
+	}
+	snapshot.Root = hex.EncodeToString(root.Hash)
+	snapshot.TotalLiabilitiesCents = root.Sum
+	snapshot.Accounts = len(leaves)
+	return app.Store.CreateReserveSnapshot(ctx, snapshot, proofs)
+}
+
+// VerifyReserveProof checks that a proof's balances produce its leaf and that the leaf is
+// included in the snapshot's root
+func VerifyReserveProof(proof *storage.ReserveProof, snapshot *storage.ReserveSnapshot) bool {
+	accountHash, err := hex.DecodeString(proof.AccountHash)
+	if err != nil {
+		return false
+	}
+	root, err := hex.DecodeString(snapshot.Root)
+	if err != nil {
+		return false
+	}
+	leaf, err := ReserveLeaf(accountHash, proof.BalancesCents)
+	if err != nil || hex.EncodeToString(leaf.Hash) != proof.Leaf || leaf.Sum != proof.SumCents {
+		return false
+	}
+	return utils.VerifyMerkleSumProof(leaf, proof.Proof, root, snapshot.TotalLiabilitiesCents)
+}
+
+// ProcessProofCommand handles the PROOF [passkey] SMS command, replying with the code of the
+// wallet's inclusion proof in the latest reserves snapshot
+func (app *App) ProcessProofCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("phone number not registered")
+	}
+	_, authenticated, err := app.authenticateSmsCommand(ctx, service, args)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return err
+	}
+	if !authenticated {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Invalid passkey. Reply PROOF <passkey>")
+		return fmt.Errorf("invalid passkey")
+	}
+
+	snapshot, exists, err := app.Store.GetReserveSnapshot(ctx, "")
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching reserve snapshot: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No proof of reserves has been published yet")
+		return nil
+	}
+	proof, exists, err := app.Store.GetReserveProofForWallet(ctx, snapshot.ID, service.WalletAddress)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching reserve proof: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"You held no balance in the %s UTC proof of reserves", snapshot.CreatedAt.UTC().Format("Jan 2 15:04")))
+		return nil
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Your $%.2f is included in the %s UTC proof of reserves, root %s. Verification code %s",
+		float64(proof.SumCents)/100, snapshot.CreatedAt.UTC().Format("Jan 2 15:04"), snapshot.Root[:12], proof.Code))
+	return nil
+}
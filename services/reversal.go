@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+var (
+	// ErrTransactionNotFound is returned when the transaction to reverse doesn't exist
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrFundsSpent is returned when the recipient no longer holds the funds to reverse
+	ErrFundsSpent = errors.New("recipient has already spent the funds")
+)
+
+// ReversalRequest describes an operator's reversal or partial refund of a transfer
+type ReversalRequest struct {
+	TransactionID string `json:"transaction_id"`
+	// AmountUSD defaults to everything not yet refunded
+	AmountUSD float64 `json:"amount_usd"`
+	Reason    string  `json:"reason"`
+	// Operator is taken from the admin credential, never from the request body
+	Operator string `json:"-"`
+	// Force reverses even when the recipient's balance no longer covers the amount
+	Force bool `json:"force"`
+}
+
+// ReverseTransaction moves funds back from a transfer's recipient to its sender and
+// records a compensating transaction linked to the original. Fees are kept, so the
+// reversal's FeeUSD stays zero.
+func (app *App) ReverseTransaction(ctx context.Context, req ReversalRequest) (*storage.Transaction, error) {
+	if req.Reason == "" || req.Operator == "" {
+		return nil, fmt.Errorf("reason and operator are required")
+	}
+
+	original, exists, err := app.Store.GetTransactionByID(ctx, req.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrTransactionNotFound
+	}
+	if original.Kind == storage.TransactionReversal {
+		return nil, fmt.Errorf("reversals cannot be reversed")
+	}
+	if original.Kind == storage.TransactionWithdrawal {
+		return nil, fmt.Errorf("withdrawals are refunded by settlement when they fail on-chain")
+	}
+	if original.Escrowed {
+		return nil, fmt.Errorf("escrowed transfers are refunded through escrow")
+	}
+
+	remaining := original.AmountUSD - original.RefundedUSD
+	amount := req.AmountUSD
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return nil, fmt.Errorf("amount must be between 0 and the $%.2f not yet refunded", remaining)
+	}
+
+	// Claim the refund on the original first so concurrent reversals can't over-refund
+	claimed, err := app.Store.AddRefundedAmount(ctx, original.ID, original.RefundedUSD, amount)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("transaction was refunded concurrently, retry")
+	}
+
+	reversalID := primitive.NewObjectID()
+	err = app.Custody().Transfer(ctx, custody.Transfer{
+		Reference:      reference("reversal", reversalID),
+		From:           original.RecipientAddress,
+		To:             original.SenderAddress,
+		Asset:          original.RecipientCrypto,
+		ToAsset:        original.Crypto,
+		AmountUSD:      amount,
+		AllowOverdraft: req.Force,
+	})
+	if errors.Is(err, custody.ErrInsufficientFunds) {
+		err = ErrFundsSpent
+	}
+	if err != nil {
+		app.Store.AddRefundedAmount(ctx, original.ID, original.RefundedUSD+amount, -amount)
+		return nil, err
+	}
+
+	reversal := &storage.Transaction{
+		ID:               reversalID,
+		Kind:             storage.TransactionReversal,
+		SenderAddress:    original.RecipientAddress,
+		RecipientAddress: original.SenderAddress,
+		RecipientPhone:   original.SenderPhone,
+		Crypto:           original.RecipientCrypto,
+		RecipientCrypto:  original.Crypto,
+		AmountUSD:        amount,
+		ReversalOf:       original.ID,
+		Reason:           req.Reason,
+		Operator:         req.Operator,
+		CreatedAt:        time.Now(),
+	}
+	if err := app.Store.CreateTransaction(ctx, reversal); err != nil {
+		return nil, err
+	}
+
+	if original.SenderPhone != "" {
+		utils.SendSMS(original.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"$%.2f %s from your %s transfer has been refunded", amount, original.Crypto, original.CreatedAt.UTC().Format("Jan 2")))
+	}
+	if recipient, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, original.RecipientAddress); err == nil && exists && recipient.PhoneNumber != "" {
+		utils.SendSMS(recipient.PhoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+			"$%.2f %s received on %s was reversed by support: %s", amount, original.RecipientCrypto, original.CreatedAt.UTC().Format("Jan 2"), req.Reason))
+	}
+	return reversal, nil
+}
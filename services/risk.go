@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// Risk signals the engine computes for every transfer. A signal missing from a
+// transfer's signal set never fires a rule.
+const (
+	SignalNewRecipient          = "new_recipient"
+	SignalHoursSincePhoneChange = "hours_since_phone_change"
+	SignalTransfersLastHour     = "transfers_last_hour"
+	SignalAmountToAverage       = "amount_to_average"
+	SignalHourOfDay             = "hour_of_day"
+	SignalFailedPasskeys24h     = "failed_passkeys_24h"
+)
+
+// riskChallengeTTL is how long a challenged transfer waits for its VERIFY reply
+const riskChallengeTTL = 10 * time.Minute
+
+// maxRiskChallengeFailures is how many wrong codes the sender may reply after a challenge
+// is issued before the challenged transfer is cancelled
+const maxRiskChallengeFailures = 3
+
+// RiskRule adds Score when its signal lies within [Min, Max]. Unset bounds are open.
+// Action, when set, forces at least that outcome whenever the rule fires.
+type RiskRule struct {
+	Name   string   `json:"name"`
+	Signal string   `json:"signal"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Score  float64  `json:"score"`
+	Action string   `json:"action,omitempty"`
+}
+
+// RiskConfig is the rule set loaded from RISK_RULES_FILE
+type RiskConfig struct {
+	ChallengeScore float64    `json:"challenge_score"`
+	BlockScore     float64    `json:"block_score"`
+	Rules          []RiskRule `json:"rules"`
+}
+
+func bound(v float64) *float64 { return &v }
+
+// DefaultRiskConfig returns the built-in rule set used until LoadRiskConfig replaces it
+func DefaultRiskConfig() RiskConfig {
+	return RiskConfig{
+		ChallengeScore: 40,
+		BlockScore:     80,
+		Rules: []RiskRule{
+			{Name: "new recipient", Signal: SignalNewRecipient, Min: bound(1), Score: 15},
+			{Name: "phone changed in last 3 days", Signal: SignalHoursSincePhoneChange, Max: bound(72), Score: 40},
+			{Name: "burst of transfers", Signal: SignalTransfersLastHour, Min: bound(5), Score: 30},
+			{Name: "amount far above average", Signal: SignalAmountToAverage, Min: bound(5), Score: 30},
+			{Name: "overnight", Signal: SignalHourOfDay, Min: bound(0), Max: bound(4), Score: 10},
+			{Name: "repeated wrong passkeys", Signal: SignalFailedPasskeys24h, Min: bound(3), Score: 50},
+		},
+	}
+}
+
+// LoadRiskConfig replaces the app's risk rules with the JSON rule file at path. It runs at
+// startup, before the app serves transfers.
+func (app *App) LoadRiskConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading risk rules: %w", err)
+	}
+	var config RiskConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("error parsing risk rules: %w", err)
+	}
+	for _, rule := range config.Rules {
+		switch rule.Action {
+		case "", storage.RiskOutcomeChallenge, storage.RiskOutcomeBlock:
+		default:
+			return fmt.Errorf("rule %q has unknown action %q", rule.Name, rule.Action)
+		}
+	}
+	app.riskConfig = config
+	return nil
+}
+
+// collectRiskSignals computes every risk signal for a transfer
+func (app *App) collectRiskSignals(ctx context.Context, service *storage.SmsService, phoneNumber string, recipient *Recipient, amountUSD float64) (map[string]float64, error) {
+	now := time.Now()
+	signals := map[string]float64{
+		SignalHourOfDay: float64(now.UTC().Hour()),
+	}
+
+	sentBefore, err := app.Store.HasTransactionTo(ctx, service.WalletAddress, recipient.WalletAddress, recipient.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	signals[SignalNewRecipient] = 0
+	if !sentBefore {
+		signals[SignalNewRecipient] = 1
+	}
+
+	if !service.PhoneUpdatedAt.IsZero() {
+		signals[SignalHoursSincePhoneChange] = now.Sub(service.PhoneUpdatedAt).Hours()
+	}
+
+	history, err := app.Store.ListTransactionsSince(ctx, service.WalletAddress, now.AddDate(0, 0, -30))
+	if err != nil {
+		return nil, err
+	}
+	lastHour, total := 0, 0.0
+	for _, transaction := range history {
+		total += transaction.AmountUSD
+		if transaction.CreatedAt.After(now.Add(-time.Hour)) {
+			lastHour++
+		}
+	}
+	signals[SignalTransfersLastHour] = float64(lastHour)
+	if len(history) > 0 && total > 0 {
+		signals[SignalAmountToAverage] = amountUSD / (total / float64(len(history)))
+	}
+
+	failures, err := app.Store.CountAuthFailuresSince(ctx, phoneNumber, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	signals[SignalFailedPasskeys24h] = float64(failures)
+	return signals, nil
+}
+
+// scoreRisk applies the rule set to a signal set
+func scoreRisk(config RiskConfig, signals map[string]float64) (float64, string, []storage.FiredRiskRule) {
+	score, outcome := 0.0, storage.RiskOutcomeAllow
+	fired := []storage.FiredRiskRule{}
+	for _, rule := range config.Rules {
+		value, ok := signals[rule.Signal]
+		if !ok || (rule.Min != nil && value < *rule.Min) || (rule.Max != nil && value > *rule.Max) {
+			continue
+		}
+		score += rule.Score
+		fired = append(fired, storage.FiredRiskRule{Name: rule.Name, Signal: rule.Signal, Value: value, Score: rule.Score, Action: rule.Action})
+		if rule.Action == storage.RiskOutcomeBlock || (rule.Action == storage.RiskOutcomeChallenge && outcome == storage.RiskOutcomeAllow) {
+			outcome = rule.Action
+		}
+	}
+
+	switch {
+	case score >= config.BlockScore:
+		outcome = storage.RiskOutcomeBlock
+	case score >= config.ChallengeScore && outcome == storage.RiskOutcomeAllow:
+		outcome = storage.RiskOutcomeChallenge
+	}
+	return score, outcome, fired
+}
+
+// AssessRisk scores a transfer and stores the decision for review
+func (app *App) AssessRisk(ctx context.Context, service *storage.SmsService, phoneNumber string, recipient *Recipient, order transferOrder) (*storage.RiskDecision, error) {
+	signals, err := app.collectRiskSignals(ctx, service, phoneNumber, recipient, order.AmountUSD)
+	if err != nil {
+		return nil, err
+	}
+	score, outcome, fired := scoreRisk(app.riskConfig, signals)
+
+	decision := &storage.RiskDecision{
+		SenderAddress: service.WalletAddress,
+		SenderPhone:   phoneNumber,
+		Recipient:     order.Recipient,
+		Crypto:        order.Crypto,
+		AmountUSD:     order.AmountUSD,
+		Signals:       signals,
+		FiredRules:    fired,
+		Score:         score,
+		Outcome:       outcome,
+		CreatedAt:     time.Now(),
+	}
+	if err := app.Store.CreateRiskDecision(ctx, decision); err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+// ChallengeTransfer parks a transfer, texts the sender a one-time code to confirm it and
+// returns ErrTransferPending
+func (app *App) ChallengeTransfer(ctx context.Context, service *storage.SmsService, phoneNumber string, order transferOrder, decision *storage.RiskDecision) error {
+	code, err := utils.GenerateNumericCode(6)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error generating challenge code: %w", err)
+	}
+
+	// The code lives on the challenge rather than in the 2FA slot, which a new
+	// /generate-2fa-code request would overwrite
+	now := time.Now()
+	id := primitive.NewObjectID()
+	err = app.Store.CreateRiskChallenge(ctx, &storage.RiskChallenge{
+		ID:               id,
+		DecisionID:       decision.ID,
+		CodeHash:         challengeCodeHash(id, code),
+		PaymentRequestID: order.PaymentRequestID,
+		SenderAddress:    service.WalletAddress,
+		SenderPhone:      phoneNumber,
+		Recipient:        order.Recipient,
+		Crypto:           order.Crypto,
+		RecipientCrypto:  order.RecipientCrypto,
+		Network:          order.Network,
+		AmountUSD:        order.AmountUSD,
+		Status:           storage.RiskChallengePending,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(riskChallengeTTL),
+	})
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error creating risk challenge: %w", err)
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Confirm sending $%.2f %s to %s: reply VERIFY %s within 10 minutes", order.AmountUSD, order.Crypto, order.Recipient, code))
+	return ErrTransferPending
+}
+
+// challengeCodeHash hashes a challenge code with the challenge's ID, so equal codes on
+// different challenges don't share a hash
+func challengeCodeHash(id primitive.ObjectID, code string) string {
+	hash := sha256.Sum256([]byte(id.Hex() + ":" + code))
+	return hex.EncodeToString(hash[:])
+}
+
+// ExpireRiskChallenges closes challenged transfers nobody verified in time
+func (app *App) ExpireRiskChallenges(ctx context.Context) error {
+	challenges, err := app.Store.ListExpiredRiskChallenges(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, challenge := range challenges {
+		expired, err := app.Store.TransitionRiskChallenge(ctx, challenge.ID, storage.RiskChallengePending, storage.RiskChallengeExpired)
+		if err != nil {
+			return err
+		}
+		if expired {
+			app.settlePaymentRequest(ctx, challenge.PaymentRequestID, false)
+		}
+	}
+	return nil
+}
+
+// rejectVerifyCode records a wrong VERIFY code as an auth failure. Once the phone number has
+// maxRiskChallengeFailures failures since the challenge was issued, the challenge expires so
+// its code can't be guessed.
+func (app *App) rejectVerifyCode(ctx context.Context, phoneNumber string, challenge *storage.RiskChallenge) error {
+	app.Store.RecordAuthFailure(ctx, phoneNumber)
+	failures, err := app.Store.CountAuthFailuresSince(ctx, phoneNumber, challenge.CreatedAt)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error counting auth failures: %w", err)
+	}
+	if failures < maxRiskChallengeFailures {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Invalid code")
+		return fmt.Errorf("invalid verification code")
+	}
+
+	expired, err := app.Store.TransitionRiskChallenge(ctx, challenge.ID, storage.RiskChallengePending, storage.RiskChallengeExpired)
+	if err != nil {
+		return fmt.Errorf("error expiring risk challenge: %w", err)
+	}
+	if expired {
+		app.settlePaymentRequest(ctx, challenge.PaymentRequestID, false)
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Too many invalid codes. The transfer was cancelled")
+	}
+	return ErrTooManyAttempts
+}
+
+// ProcessVerifyCommand handles the VERIFY <code> SMS command that confirms a challenged transfer
+func (app *App) ProcessVerifyCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply VERIFY <code>")
+		return fmt.Errorf("missing verification code")
+	}
+
+	challenge, exists, err := app.Store.GetLatestRiskChallenge(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching risk challenge: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No transfer is waiting for verification")
+		return fmt.Errorf("no pending risk challenge")
+	}
+
+	hash := challengeCodeHash(challenge.ID, args[0])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(challenge.CodeHash)) != 1 {
+		return app.rejectVerifyCode(ctx, phoneNumber, challenge)
+	}
+
+	verified, err := app.Store.TransitionRiskChallenge(ctx, challenge.ID, storage.RiskChallengePending, storage.RiskChallengeVerified)
+	if err != nil || !verified {
+		return err
+	}
+
+	sender, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, challenge.SenderAddress)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching sender: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("sender no longer registered")
+	}
+	err = app.executeTransfer(ctx, sender, phoneNumber, transferOrder{
+		Recipient:        challenge.Recipient,
+		Crypto:           challenge.Crypto,
+		RecipientCrypto:  challenge.RecipientCrypto,
+		Network:          challenge.Network,
+		AmountUSD:        challenge.AmountUSD,
+		RiskCleared:      true,
+		PaymentRequestID: challenge.PaymentRequestID,
+	})
+	// A verified transfer may still wait for guardians, which settle the payment request
+	if errors.Is(err, ErrTransferPending) {
+		return nil
+	}
+	app.settlePaymentRequest(ctx, challenge.PaymentRequestID, err == nil)
+	return err
+}
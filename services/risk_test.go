@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+func TestWrongVerifyCodesExpireTheChallenge(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	now := time.Now()
+
+	// A failure before the challenge was issued doesn't count against it
+	if err := app.Store.RecordAuthFailure(ctx, "+15550100"); err != nil {
+		t.Fatalf("recording an earlier failure: %v", err)
+	}
+	id := primitive.NewObjectID()
+	challenge := &storage.RiskChallenge{ID: id, CodeHash: challengeCodeHash(id, "123456"), SenderAddress: testWallet, SenderPhone: "+15550100",
+		Recipient: "+15550101", Crypto: "USDC", AmountUSD: 900, Status: storage.RiskChallengePending, CreatedAt: now.Add(time.Millisecond), ExpiresAt: now.Add(riskChallengeTTL)}
+	if err := app.Store.CreateRiskChallenge(ctx, challenge); err != nil {
+		t.Fatalf("creating challenge: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	for attempt := 1; attempt < maxRiskChallengeFailures; attempt++ {
+		if err := app.ProcessVerifyCommand("+15550100", []string{"000000"}); err == nil || errors.Is(err, ErrTooManyAttempts) {
+			t.Fatalf("wrong code %d returned %v, want it refused", attempt, err)
+		}
+		if _, exists, err := app.Store.GetLatestRiskChallenge(ctx, "+15550100"); err != nil || !exists {
+			t.Fatalf("challenge after %d wrong codes: %v, exists %v, want it still pending", attempt, err, exists)
+		}
+	}
+	if err := app.ProcessVerifyCommand("+15550100", []string{"000000"}); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("wrong code %d returned %v, want ErrTooManyAttempts", maxRiskChallengeFailures, err)
+	}
+	if _, exists, err := app.Store.GetLatestRiskChallenge(ctx, "+15550100"); err != nil || exists {
+		t.Fatalf("challenge after %d wrong codes: %v, exists %v, want it expired", maxRiskChallengeFailures, err, exists)
+	}
+
+	// The right code no longer confirms the transfer
+	if err := app.ProcessVerifyCommand("+15550100", []string{"123456"}); err == nil {
+		t.Fatal("the right code confirmed an expired challenge")
+	}
+}
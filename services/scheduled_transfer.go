@@ -0,0 +1,393 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+const (
+	// DefaultScheduleHour is the UTC hour scheduled transfers run at unless told otherwise
+	DefaultScheduleHour = 9
+	// defaultReminderLead applies when SCHEDULE_REMINDER_LEAD is unset or invalid
+	defaultReminderLead = 24 * time.Hour
+)
+
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday, "MON": time.Monday, "TUE": time.Tuesday, "WED": time.Wednesday,
+	"THU": time.Thursday, "FRI": time.Friday, "SAT": time.Saturday,
+}
+
+// ReminderLead returns how long before a scheduled run the sender is reminded
+func ReminderLead() time.Duration {
+	if lead, err := time.ParseDuration(os.Getenv("SCHEDULE_REMINDER_LEAD")); err == nil && lead >= 0 {
+		return lead
+	}
+	return defaultReminderLead
+}
+
+// NextOccurrence returns the first run of a recurring schedule strictly after the given time
+func NextOccurrence(transfer *storage.ScheduledTransfer, after time.Time) time.Time {
+	after = after.UTC()
+	day := time.Date(after.Year(), after.Month(), after.Day(), transfer.Hour, 0, 0, 0, time.UTC)
+
+	switch transfer.Frequency {
+	case storage.FrequencyDaily:
+		if !day.After(after) {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day
+	case storage.FrequencyWeekly:
+		for !day.After(after) || day.Weekday() != transfer.Weekday {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day
+	case storage.FrequencyMonthly:
+		for offset := 0; ; offset++ {
+			first := time.Date(after.Year(), after.Month()+time.Month(offset), 1, transfer.Hour, 0, 0, 0, time.UTC)
+			// Days past the end of a short month run on its last day
+			lastDay := first.AddDate(0, 1, -1).Day()
+			dayOfMonth := transfer.DayOfMonth
+			if dayOfMonth > lastDay {
+				dayOfMonth = lastDay
+			}
+			run := first.AddDate(0, 0, dayOfMonth-1)
+			if run.After(after) {
+				return run
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// ValidateScheduledTransfer checks the assets, recipient and frequency fields of a new
+// scheduled transfer, resolving assets to their registry symbols
+func (app *App) ValidateScheduledTransfer(ctx context.Context, transfer *storage.ScheduledTransfer) error {
+	if transfer.AmountUSD <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	terms, terr := app.resolveTransferTerms(ctx, transferOrder{
+		Recipient:       transfer.Recipient,
+		Crypto:          transfer.Crypto,
+		RecipientCrypto: transfer.RecipientCrypto,
+		Network:         transfer.Network,
+		AmountUSD:       transfer.AmountUSD,
+	}, nil)
+	if terr != nil {
+		return errors.New(terr.Message(DefaultLanguage))
+	}
+	transfer.Crypto, transfer.RecipientCrypto, transfer.Recipient = terms.Asset.Symbol, terms.RecipientAsset.Symbol, terms.Recipient
+	if transfer.Hour < 0 || transfer.Hour > 23 {
+		return fmt.Errorf("hour must be between 0 and 23")
+	}
+	switch transfer.Frequency {
+	case storage.FrequencyOnce:
+		if !transfer.NextRunAt.After(time.Now()) {
+			return fmt.Errorf("one-off transfers need a future next_run_at")
+		}
+	case storage.FrequencyDaily:
+	case storage.FrequencyWeekly:
+		if transfer.Weekday < time.Sunday || transfer.Weekday > time.Saturday {
+			return fmt.Errorf("weekday must be between 0 (Sunday) and 6")
+		}
+	case storage.FrequencyMonthly:
+		if transfer.DayOfMonth < 1 || transfer.DayOfMonth > 31 {
+			return fmt.Errorf("day_of_month must be between 1 and 31")
+		}
+	default:
+		return fmt.Errorf("unknown frequency %q", transfer.Frequency)
+	}
+	return nil
+}
+
+// CreateScheduledTransfer validates and stores a scheduled transfer for the sender,
+// assigning its reference and first run
+func (app *App) CreateScheduledTransfer(ctx context.Context, transfer *storage.ScheduledTransfer) error {
+	if err := app.ValidateScheduledTransfer(ctx, transfer); err != nil {
+		return err
+	}
+	code, err := utils.GenerateNumericCode(5)
+	if err != nil {
+		return fmt.Errorf("error generating reference: %w", err)
+	}
+
+	// References start with S so CANCEL can tell them apart from limit change codes
+	transfer.Reference = "S" + code
+	transfer.Status = storage.ScheduledTransferActive
+	transfer.CreatedAt = time.Now()
+	if transfer.Frequency != storage.FrequencyOnce {
+		transfer.NextRunAt = NextOccurrence(transfer, transfer.CreatedAt)
+	}
+	// The confirmation already names the first run, so don't remind about it separately
+	if transfer.NextRunAt.Before(transfer.CreatedAt.Add(ReminderLead())) {
+		transfer.RemindedFor = transfer.NextRunAt
+	}
+	return app.Store.CreateScheduledTransfer(ctx, transfer)
+}
+
+// DescribeSchedule renders a scheduled transfer's frequency for SMS
+func DescribeSchedule(transfer *storage.ScheduledTransfer) string {
+	switch transfer.Frequency {
+	case storage.FrequencyDaily:
+		return "daily"
+	case storage.FrequencyWeekly:
+		return "every " + transfer.Weekday.String()
+	case storage.FrequencyMonthly:
+		return fmt.Sprintf("monthly on day %d", transfer.DayOfMonth)
+	}
+	return "once"
+}
+
+// SkipScheduledTransfer moves a scheduled transfer past its next run. One-off transfers are cancelled.
+func (app *App) SkipScheduledTransfer(ctx context.Context, transfer *storage.ScheduledTransfer) (bool, error) {
+	if transfer.Frequency == storage.FrequencyOnce {
+		return app.Store.AdvanceScheduledTransfer(ctx, transfer.ID, transfer.NextRunAt, transfer.NextRunAt, storage.ScheduledTransferCancelled)
+	}
+	next := NextOccurrence(transfer, transfer.NextRunAt)
+	skipped, err := app.Store.AdvanceScheduledTransfer(ctx, transfer.ID, transfer.NextRunAt, next, storage.ScheduledTransferActive)
+	if skipped {
+		transfer.NextRunAt = next
+	}
+	return skipped, err
+}
+
+// RunScheduledTransfers sends reminders for upcoming runs and executes due runs
+func (app *App) RunScheduledTransfers(ctx context.Context) error {
+	now := time.Now()
+
+	upcoming, err := app.Store.ListUnremindedScheduledTransfers(ctx, now.Add(ReminderLead()))
+	if err != nil {
+		return err
+	}
+	for i := range upcoming {
+		transfer := &upcoming[i]
+		if transfer.NextRunAt.After(now) {
+			app.sendScheduleReminder(ctx, transfer)
+		}
+		if err := app.Store.MarkScheduledTransferReminded(ctx, transfer.ID, transfer.NextRunAt); err != nil {
+			return err
+		}
+	}
+
+	due, err := app.Store.ListDueScheduledTransfers(ctx, now)
+	if err != nil {
+		return err
+	}
+	for i := range due {
+		if err := app.runScheduledTransfer(ctx, &due[i], now); err != nil {
+			log.Printf("Error running scheduled transfer %s: %v", due[i].Reference, err)
+		}
+	}
+	return nil
+}
+
+func (app *App) sendScheduleReminder(ctx context.Context, transfer *storage.ScheduledTransfer) {
+	sender, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, transfer.SenderAddress)
+	if err != nil || !exists || sender.PhoneNumber == "" {
+		return
+	}
+	utils.SendSMS(sender.PhoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Reminder: $%.2f %s to %s runs %s UTC. Reply SKIP %s to skip or CANCEL %s to stop",
+		transfer.AmountUSD, transfer.Crypto, transfer.Recipient, transfer.NextRunAt.Format("Jan 2 15:04"),
+		transfer.Reference, transfer.Reference))
+}
+
+func (app *App) runScheduledTransfer(ctx context.Context, transfer *storage.ScheduledTransfer, now time.Time) error {
+	// Claim the run by advancing it first, so a run is never executed twice
+	next, status := transfer.NextRunAt, storage.ScheduledTransferCompleted
+	if transfer.Frequency != storage.FrequencyOnce {
+		next, status = NextOccurrence(transfer, now), storage.ScheduledTransferActive
+	}
+	claimed, err := app.Store.AdvanceScheduledTransfer(ctx, transfer.ID, transfer.NextRunAt, next, status)
+	if err != nil || !claimed {
+		return err
+	}
+
+	sender, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, transfer.SenderAddress)
+	if err == nil && (!exists || sender.PhoneNumber == "") {
+		err = fmt.Errorf("sender has no SMS service")
+	}
+	if err == nil {
+		// executeTransfer applies the same limit, fee and recipient checks as an SMS transfer
+		err = app.executeTransfer(ctx, sender, sender.PhoneNumber, transferOrder{
+			Recipient:       transfer.Recipient,
+			Crypto:          transfer.Crypto,
+			RecipientCrypto: transfer.RecipientCrypto,
+			Network:         transfer.Network,
+			AmountUSD:       transfer.AmountUSD,
+		})
+	}
+
+	runErr := ""
+	if err != nil {
+		runErr = err.Error()
+	}
+	if recordErr := app.Store.RecordScheduledTransferRun(ctx, transfer.ID, now, runErr); recordErr != nil {
+		return recordErr
+	}
+	// A parked run is recorded as pending and carries on once it is approved
+	if errors.Is(err, ErrTransferPending) {
+		return nil
+	}
+	return err
+}
+
+// ProcessEveryCommand handles the EVERY <DAY|WEEK <weekday>|MONTH <day>> SEND <amount> <asset> TO <recipient> <passkey> SMS command
+func (app *App) ProcessEveryCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+	usage := "Reply EVERY MONTH 1ST SEND <amount> <asset> TO <recipient> <passkey>"
+
+	transfer, passkey, err := app.parseEveryCommand(ctx, args)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), usage)
+		return err
+	}
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("phone number not registered")
+	}
+	if service.Passkey != passkey {
+		app.Store.RecordAuthFailure(ctx, phoneNumber)
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Invalid passkey")
+		return fmt.Errorf("invalid passkey")
+	}
+
+	transfer.SenderAddress = service.WalletAddress
+	if err := app.CreateScheduledTransfer(ctx, transfer); err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error creating scheduled transfer: %w", err)
+	}
+
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Scheduled %s: $%.2f %s to %s %s. First run %s UTC. Reply CANCEL %s to stop",
+		transfer.Reference, transfer.AmountUSD, transfer.Crypto, transfer.Recipient, DescribeSchedule(transfer),
+		transfer.NextRunAt.Format("Jan 2"), transfer.Reference))
+	return nil
+}
+
+func (app *App) parseEveryCommand(ctx context.Context, args []string) (*storage.ScheduledTransfer, string, error) {
+	transfer := &storage.ScheduledTransfer{Hour: DefaultScheduleHour}
+	if len(args) == 0 {
+		return nil, "", fmt.Errorf("missing frequency")
+	}
+
+	rest := args[1:]
+	switch strings.ToUpper(args[0]) {
+	case "DAY":
+		transfer.Frequency = storage.FrequencyDaily
+	case "WEEK":
+		if len(rest) == 0 {
+			return nil, "", fmt.Errorf("missing weekday")
+		}
+		weekday, ok := weekdays[strings.ToUpper(rest[0])[:min(3, len(rest[0]))]]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown weekday %q", rest[0])
+		}
+		transfer.Frequency, transfer.Weekday, rest = storage.FrequencyWeekly, weekday, rest[1:]
+	case "MONTH":
+		if len(rest) == 0 {
+			return nil, "", fmt.Errorf("missing day of month")
+		}
+		day, err := strconv.Atoi(strings.TrimRight(strings.ToUpper(rest[0]), "STNDRH"))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid day of month %q", rest[0])
+		}
+		transfer.Frequency, transfer.DayOfMonth, rest = storage.FrequencyMonthly, day, rest[1:]
+	default:
+		return nil, "", fmt.Errorf("unknown frequency %q", args[0])
+	}
+
+	// SEND <amount> <asset> [ON <network>] TO <recipient> <passkey>
+	if len(rest) == 8 && strings.EqualFold(rest[3], "ON") {
+		transfer.Network = strings.ToLower(rest[4])
+		rest = append(rest[:3:3], rest[5:]...)
+	}
+	if len(rest) != 6 || !strings.EqualFold(rest[0], "SEND") || !strings.EqualFold(rest[3], "TO") {
+		return nil, "", fmt.Errorf("malformed transfer")
+	}
+	amountUSD, err := strconv.ParseFloat(strings.TrimPrefix(rest[1], "$"), 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid amount %q", rest[1])
+	}
+	transfer.AmountUSD = amountUSD
+	transfer.Crypto = strings.ToUpper(rest[2])
+	transfer.RecipientCrypto = transfer.Crypto
+	transfer.Recipient = rest[4]
+	return transfer, rest[5], app.ValidateScheduledTransfer(ctx, transfer)
+}
+
+// ProcessSkipCommand handles the SKIP <reference> SMS command
+func (app *App) ProcessSkipCommand(phoneNumber string, args []string) error {
+	ctx := context.TODO()
+
+	if len(args) != 1 {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Reply SKIP <reference>")
+		return fmt.Errorf("missing reference")
+	}
+
+	service, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error checking phone number: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
+		return fmt.Errorf("phone number not registered")
+	}
+
+	transfer, exists, err := app.Store.GetActiveScheduledTransfer(ctx, service.WalletAddress, strings.ToUpper(args[0]))
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error fetching scheduled transfer: %w", err)
+	}
+	if !exists {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No scheduled transfer matches that reference")
+		return fmt.Errorf("unknown scheduled transfer reference")
+	}
+
+	skipped, err := app.SkipScheduledTransfer(ctx, transfer)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error skipping scheduled transfer: %w", err)
+	}
+	if !skipped {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "That run has already started")
+		return fmt.Errorf("scheduled run already claimed")
+	}
+
+	if transfer.Frequency == storage.FrequencyOnce {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Scheduled transfer %s cancelled", transfer.Reference))
+	} else {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Skipped. Next run %s UTC", transfer.NextRunAt.Format("Jan 2")))
+	}
+	return nil
+}
+
+// cancelScheduledTransferBySMS handles CANCEL for scheduled transfer references
+func (app *App) cancelScheduledTransferBySMS(ctx context.Context, phoneNumber string, service *storage.SmsService, reference string) error {
+	cancelled, err := app.Store.CancelScheduledTransfer(ctx, service.WalletAddress, reference)
+	if err != nil {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
+		return fmt.Errorf("error cancelling scheduled transfer: %w", err)
+	}
+	if !cancelled {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "No scheduled transfer matches that reference")
+		return fmt.Errorf("unknown scheduled transfer reference")
+	}
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Scheduled transfer %s cancelled", reference))
+	return nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	// A Friday, after the default run hour
+	after := time.Date(2026, time.January, 30, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		transfer storage.ScheduledTransfer
+		after    time.Time
+		want     time.Time
+	}{
+		{"daily, hour passed", storage.ScheduledTransfer{Frequency: storage.FrequencyDaily, Hour: 9}, after, time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)},
+		{"daily, hour to come", storage.ScheduledTransfer{Frequency: storage.FrequencyDaily, Hour: 11}, after, time.Date(2026, time.January, 30, 11, 0, 0, 0, time.UTC)},
+		{"daily, exactly on the hour", storage.ScheduledTransfer{Frequency: storage.FrequencyDaily, Hour: 10}, after, time.Date(2026, time.January, 31, 10, 0, 0, 0, time.UTC)},
+		{"daily, other time zone", storage.ScheduledTransfer{Frequency: storage.FrequencyDaily, Hour: 9}, after.In(time.FixedZone("EST", -5*60*60)), time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)},
+		{"weekly, same day, hour passed", storage.ScheduledTransfer{Frequency: storage.FrequencyWeekly, Weekday: time.Friday, Hour: 9}, after, time.Date(2026, time.February, 6, 9, 0, 0, 0, time.UTC)},
+		{"weekly, same day, hour to come", storage.ScheduledTransfer{Frequency: storage.FrequencyWeekly, Weekday: time.Friday, Hour: 11}, after, time.Date(2026, time.January, 30, 11, 0, 0, 0, time.UTC)},
+		{"weekly, later weekday", storage.ScheduledTransfer{Frequency: storage.FrequencyWeekly, Weekday: time.Monday, Hour: 9}, after, time.Date(2026, time.February, 2, 9, 0, 0, 0, time.UTC)},
+		{"monthly, later this month", storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 31, Hour: 9}, after, time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)},
+		{"monthly, next month", storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 1, Hour: 9}, after, time.Date(2026, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{"monthly, short month runs on its last day", storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 30, Hour: 9}, after, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC)},
+		{"monthly, leap year", storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 31, Hour: 9}, time.Date(2028, time.February, 15, 0, 0, 0, 0, time.UTC), time.Date(2028, time.February, 29, 9, 0, 0, 0, time.UTC)},
+		{"monthly, across the year end", storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 5, Hour: 9}, time.Date(2026, time.December, 20, 0, 0, 0, 0, time.UTC), time.Date(2027, time.January, 5, 9, 0, 0, 0, time.UTC)},
+		{"one-off transfers don't recur", storage.ScheduledTransfer{Frequency: storage.FrequencyOnce, Hour: 9}, after, time.Time{}},
+	}
+	for _, test := range tests {
+		if got := NextOccurrence(&test.transfer, test.after); !got.Equal(test.want) {
+			t.Errorf("%s: next run %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseEveryCommand(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		want    storage.ScheduledTransfer
+		wantErr string
+	}{
+		{"daily", "DAY SEND 25 USDC TO +15550101 1234",
+			storage.ScheduledTransfer{Frequency: storage.FrequencyDaily, Hour: DefaultScheduleHour, AmountUSD: 25, Crypto: "USDC", RecipientCrypto: "USDC", Recipient: "+15550101"}, ""},
+		{"weekly by full weekday name", "week friday SEND $10 eth TO +15550101 1234",
+			storage.ScheduledTransfer{Frequency: storage.FrequencyWeekly, Weekday: time.Friday, Hour: DefaultScheduleHour, AmountUSD: 10, Crypto: "ETH", RecipientCrypto: "ETH", Recipient: "+15550101"}, ""},
+		{"weekly by short weekday name", "WEEK TUE SEND 10 ETH TO +15550101 1234",
+			storage.ScheduledTransfer{Frequency: storage.FrequencyWeekly, Weekday: time.Tuesday, Hour: DefaultScheduleHour, AmountUSD: 10, Crypto: "ETH", RecipientCrypto: "ETH", Recipient: "+15550101"}, ""},
+		{"monthly by ordinal, alias resolved", "MONTH 1ST SEND 50 tether TO +15550101 1234",
+			storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 1, Hour: DefaultScheduleHour, AmountUSD: 50, Crypto: "USDT", RecipientCrypto: "USDT", Recipient: "+15550101"}, ""},
+		{"monthly by number", "MONTH 22 SEND 50 USDT TO +15550101 1234",
+			storage.ScheduledTransfer{Frequency: storage.FrequencyMonthly, DayOfMonth: 22, Hour: DefaultScheduleHour, AmountUSD: 50, Crypto: "USDT", RecipientCrypto: "USDT", Recipient: "+15550101"}, ""},
+		{"stated network", "DAY SEND 5 USDT ON TRON TO TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t 1234",
+			storage.ScheduledTransfer{Frequency: storage.FrequencyDaily, Hour: DefaultScheduleHour, AmountUSD: 5, Crypto: "USDT", RecipientCrypto: "USDT", Network: ChainTron, Recipient: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"}, ""},
+		{"missing frequency", "", storage.ScheduledTransfer{}, "missing frequency"},
+		{"unknown frequency", "YEAR SEND 25 USDC TO +15550101 1234", storage.ScheduledTransfer{}, "unknown frequency"},
+		{"missing weekday", "WEEK", storage.ScheduledTransfer{}, "missing weekday"},
+		{"unknown weekday", "WEEK FUNDAY SEND 25 USDC TO +15550101 1234", storage.ScheduledTransfer{}, "unknown weekday"},
+		{"missing day of month", "MONTH", storage.ScheduledTransfer{}, "missing day of month"},
+		{"day of month in words", "MONTH FIRST SEND 25 USDC TO +15550101 1234", storage.ScheduledTransfer{}, "invalid day of month"},
+		{"day of month out of range", "MONTH 32ND SEND 25 USDC TO +15550101 1234", storage.ScheduledTransfer{}, "day_of_month must be between 1 and 31"},
+		{"missing TO", "DAY SEND 25 USDC +15550101 1234", storage.ScheduledTransfer{}, "malformed transfer"},
+		{"missing passkey", "DAY SEND 25 USDC TO +15550101", storage.ScheduledTransfer{}, "malformed transfer"},
+		{"invalid amount", "DAY SEND lots USDC TO +15550101 1234", storage.ScheduledTransfer{}, "invalid amount"},
+		{"zero amount", "DAY SEND 0 USDC TO +15550101 1234", storage.ScheduledTransfer{}, "amount must be positive"},
+	}
+	for _, test := range tests {
+		transfer, passkey, err := app.parseEveryCommand(ctx, strings.Fields(test.command))
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if *transfer != test.want || passkey != "1234" {
+			t.Errorf("%s: parsed %+v with passkey %q, want %+v with passkey 1234", test.name, *transfer, passkey, test.want)
+		}
+	}
+}
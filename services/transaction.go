@@ -2,100 +2,306 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/custody"
 	"crypto-sms/storage"
 	"crypto-sms/utils"
 )
 
-// ProcessTransaction handles the transaction logic
-func ProcessTransaction(details map[string]interface{}) error {
-	ctx := context.TODO()
-	phoneNumber := details["phone_number"].(string)
-	passkey := details["passkey"].(string)
-	amountUSD := details["amount_usd"].(float64)
-	crypto := details["crypto"].(string)
-	recipientAddress := details["recipient_address"].(string)
-	recipientCrypto := details["recipient_crypto"].(string)
+// ErrTransferPending is returned when a transfer was parked for guardian approval or a risk
+// challenge instead of running. The sender has already been told what to do next.
+var ErrTransferPending = errors.New("transfer is waiting for approval")
 
-	// Ensure phone number has a plus sign
-	if !strings.HasPrefix(phoneNumber, "+") {
-		phoneNumber = "+" + phoneNumber
+// ProcessTransaction authenticates and runs a transfer request. Rejections are texted to the
+// sender in their language and returned as a *TransferError. A parked transfer returns
+// ErrTransferPending.
+func (app *App) ProcessTransaction(req TransferRequest) error {
+	ctx := context.TODO()
+	req.Normalize()
+	if req.PhoneNumber == "" {
+		return NewTransferError(ErrCodeMalformedRequest, errors.New("missing phone number"))
 	}
+	phoneNumber := req.PhoneNumber
 
 	// Fetch sender's wallet address from sms_service
-	senderService, exists, err := storage.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	senderService, exists, err := app.Store.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
 	if err != nil {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
-		return fmt.Errorf("error checking phone number: %w", err)
+		return ReplyTransferError(phoneNumber, DefaultLanguage, NewTransferError(ErrCodeInternal, fmt.Errorf("error checking phone number: %w", err)))
 	}
 	if !exists {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Phone number not registered")
-		return fmt.Errorf("phone number not registered")
+		return ReplyTransferError(phoneNumber, DefaultLanguage, NewTransferError(ErrCodePhoneNotRegistered, nil))
 	}
-	if senderService.Passkey != passkey {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Invalid passkey")
-		return fmt.Errorf("invalid passkey")
+	language := LanguageOf(senderService)
+	if verr := app.ValidateTransferRequest(ctx, &req); verr != nil {
+		return ReplyTransferError(phoneNumber, language, verr)
 	}
-	if amountUSD > senderService.Limit {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Transaction amount exceeds limit")
-		return fmt.Errorf("transaction amount exceeds limit")
+	if senderService.Passkey != req.Passkey {
+		app.Store.RecordAuthFailure(ctx, phoneNumber)
+		return ReplyTransferError(phoneNumber, language, NewTransferError(ErrCodeInvalidPasskey, nil))
+	}
+	if err := app.Store.StartSmsSession(ctx, phoneNumber, SmsSessionTTL); err != nil {
+		return NewTransferError(ErrCodeInternal, fmt.Errorf("error starting session: %w", err))
+	}
+
+	return app.executeTransfer(ctx, senderService, phoneNumber, transferOrder{
+		Recipient:        req.RecipientAddress,
+		Crypto:           req.Crypto,
+		RecipientCrypto:  req.RecipientCrypto,
+		Network:          req.Network,
+		AmountUSD:        req.AmountUSD,
+		PaymentRequestID: req.PaymentRequestID,
+	})
+}
+
+// transferOrder is an authenticated transfer waiting to run through executeTransfer
+type transferOrder struct {
+	Recipient       string
+	Crypto          string
+	RecipientCrypto string
+	// Network is the chain an external recipient is paid on; empty lets executeTransfer pick
+	Network   string
+	AmountUSD float64
+	// RiskCleared skips the risk engine for transfers that already passed it
+	RiskCleared bool
+	// GuardianApproved skips guardian co-approval for transfers the guardians already approved
+	GuardianApproved bool
+	// PaymentRequestID is the payment request a parked transfer settles once it runs
+	PaymentRequestID primitive.ObjectID
+}
+
+// executeTransfer runs every check after authentication and moves the funds. Replies go
+// to phoneNumber, which is the sender's phone on file.
+func (app *App) executeTransfer(ctx context.Context, senderService *storage.SmsService, phoneNumber string, order transferOrder) error {
+	recipientInput, crypto, recipientCrypto, amountUSD := order.Recipient, order.Crypto, order.RecipientCrypto, order.AmountUSD
+	language := LanguageOf(senderService)
+	reject := func(code ErrorCode, err error, args ...interface{}) error {
+		return ReplyTransferError(phoneNumber, language, NewTransferError(code, err, args...))
+	}
+
+	// Scheduled, approved and challenged transfers reach here without TransferRequest.Validate,
+	// so assets and recipient are checked again before any balance changes
+	terms, terr := app.resolveTransferTerms(ctx, order, senderService.Networks)
+	if terr != nil {
+		return ReplyTransferError(phoneNumber, language, terr)
+	}
+	crypto, recipientCrypto, recipientInput = terms.Asset.Symbol, terms.RecipientAsset.Symbol, terms.Recipient
+	order.Crypto, order.RecipientCrypto, order.Recipient, order.Network = crypto, recipientCrypto, recipientInput, terms.Network
+
+	// Wallets frozen by reconciliation can't send until an operator resolves them
+	frozen, err := app.Store.IsWalletFrozen(ctx, senderService.WalletAddress)
+	if err != nil {
+		return reject(ErrCodeInternal, fmt.Errorf("error checking wallet freeze: %w", err))
+	}
+	if frozen {
+		return reject(ErrCodeWalletFrozen, nil)
 	}
 
-	// Fetch sender's crypto balance from custodian
-	senderCustodian, exists, err := storage.GetCustodianByWalletAddress(ctx, senderService.WalletAddress)
+	// Resolve phone numbers and aliases to the linked wallet
+	recipient, err := app.ResolveRecipient(ctx, recipientInput)
+	if errors.Is(err, ErrRecipientNotFound) {
+		return reject(ErrCodeRecipientNotRegistered, err)
+	}
 	if err != nil {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
-		return fmt.Errorf("error fetching custodian data: %w", err)
+		return reject(ErrCodeInternal, fmt.Errorf("error resolving recipient: %w", err))
 	}
-	if !exists || senderCustodian.Cryptocurrencies[crypto] < amountUSD {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("Insufficient %s balance", crypto))
-		return fmt.Errorf("insufficient %s balance", crypto)
+	recipientAddress := recipient.WalletAddress
+
+	// Transfers from one wallet run one at a time, so concurrent transfers can't each pass
+	// the velocity limits before either is recorded
+	unlock, err := app.lockWallet(ctx, senderService.WalletAddress)
+	if err != nil {
+		return reject(ErrCodeInternal, fmt.Errorf("error locking wallet: %w", err))
 	}
+	defer unlock()
 
-	// Fetch recipient's custodian data
-	recipientCustodian, exists, err := storage.GetCustodianByWalletAddress(ctx, recipientAddress)
+	if err := app.ApplyDueLimitChanges(ctx, senderService); err != nil {
+		return reject(ErrCodeInternal, fmt.Errorf("error applying limit changes: %w", err))
+	}
+	if amountUSD > senderService.Limit {
+		return reject(ErrCodeLimitExceeded, nil, senderService.Limit)
+	}
+	err = app.CheckVelocityLimits(ctx, senderService, crypto, amountUSD)
+	if limitErr, ok := err.(*VelocityLimitError); ok {
+		return ReplyTransferError(phoneNumber, language, limitErr.TransferError())
+	}
 	if err != nil {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
-		return fmt.Errorf("error fetching recipient custodian data: %w", err)
+		return reject(ErrCodeInternal, fmt.Errorf("error checking velocity limits: %w", err))
 	}
-	if !exists {
-		recipientCustodian = &storage.Custodian{
-			WalletAddress:    recipientAddress,
-			Cryptocurrencies: make(map[string]float64),
+
+	// Score the transfer; risky transfers are challenged with an extra 2FA step or blocked
+	if !order.RiskCleared {
+		decision, err := app.AssessRisk(ctx, senderService, phoneNumber, recipient, order)
+		if err != nil {
+			return reject(ErrCodeInternal, fmt.Errorf("error assessing risk: %w", err))
+		}
+		switch decision.Outcome {
+		case storage.RiskOutcomeBlock:
+			return reject(ErrCodeTransferBlocked, fmt.Errorf("blocked by risk engine (score %.0f)", decision.Score))
+		case storage.RiskOutcomeChallenge:
+			return app.ChallengeTransfer(ctx, senderService, phoneNumber, order, decision)
+		}
+	}
+
+	// Large transfers wait for the sender's guardians before any funds move
+	if !order.GuardianApproved && RequiresGuardianApproval(senderService, amountUSD) {
+		return app.RequestGuardianApproval(ctx, senderService, phoneNumber, order)
+	}
+
+	// Recipients without a custodian or SMS service record can't receive funds yet,
+	// so their transfer is held in escrow until they register
+	escrowed := recipientAddress == ""
+	if !escrowed {
+		registered, err := app.IsRegisteredWallet(ctx, recipientAddress)
+		if err != nil {
+			return reject(ErrCodeInternal, fmt.Errorf("error fetching recipient custodian data: %w", err))
 		}
+		escrowed = !registered
+	}
+
+	// Transfers between registered wallets stay on the ledger, so only transfers to
+	// external addresses carry a network and its fees
+	network := order.Network
+	if !escrowed {
+		network = ""
+	}
+
+	// Price the transfer against the active fee schedule
+	fee, err := app.QuoteFee(ctx, crypto, recipientCrypto, network, amountUSD)
+	if err != nil {
+		return reject(ErrCodeInternal, fmt.Errorf("error quoting fee: %w", err))
 	}
 
-	// Perform the transaction
-	senderCustodian.Cryptocurrencies[crypto] -= amountUSD
-	recipientCustodian.Cryptocurrencies[recipientCrypto] += amountUSD
+	// Transfers to external addresses settle on-chain when an adapter serves the network,
+	// and wait in escrow otherwise
+	var withdrawal *storage.Withdrawal
+	if escrowed && recipientAddress != "" {
+		withdrawal, err = app.prepareWithdrawal(terms.RecipientAsset, network, recipientAddress, amountUSD)
+		if err != nil {
+			return reject(ErrCodeInvalidAmount, err)
+		}
+	}
 
-	// Update custodian data in the database
-	err = storage.UpdateCustodian(ctx, senderCustodian)
+	// Fetch sender's crypto balance from the custody provider
+	senderBalances, err := app.Custody().Balances(ctx, senderService.WalletAddress)
 	if err != nil {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
-		return fmt.Errorf("error updating sender custodian data: %w", err)
+		return reject(ErrCodeInternal, fmt.Errorf("error fetching custodian data: %w", err))
+	}
+	if senderBalances[crypto] < amountUSD+fee.AmountUSD {
+		return reject(ErrCodeInsufficientBalance, nil, crypto)
+	}
+
+	// Perform the transaction. Transfers are referenced by the transaction recording them,
+	// so a retried transfer is applied once.
+	// Escrowed and pending funds stay in the sender's asset until they are released
+	transactionID := primitive.NewObjectID()
+	destination, destinationCrypto := recipientAddress, recipientCrypto
+	switch {
+	case withdrawal != nil:
+		destination, destinationCrypto = WithdrawalPendingAddress(), crypto
+	case escrowed:
+		destination, destinationCrypto = EscrowAddress(), crypto
+	}
+	err = app.Custody().Transfer(ctx, custody.Transfer{
+		Reference: reference("transfer", transactionID),
+		From:      senderService.WalletAddress,
+		To:        destination,
+		Asset:     crypto,
+		ToAsset:   destinationCrypto,
+		AmountUSD: amountUSD,
+	})
+	if errors.Is(err, custody.ErrInsufficientFunds) {
+		return reject(ErrCodeInsufficientBalance, nil, crypto)
 	}
-	err = storage.UpdateCustodian(ctx, recipientCustodian)
 	if err != nil {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
-		return fmt.Errorf("error updating recipient custodian data: %w", err)
+		return reject(ErrCodeInternal, fmt.Errorf("error moving funds: %w", err))
+	}
+	if fee.AmountUSD > 0 {
+		// The balance check above covered the fee, so it is booked even if a concurrent
+		// transfer spent the balance in between
+		err = app.Custody().Transfer(ctx, custody.Transfer{
+			Reference:      reference("fee", transactionID),
+			From:           senderService.WalletAddress,
+			To:             FeeRevenueAddress(),
+			Asset:          crypto,
+			AmountUSD:      fee.AmountUSD,
+			AllowOverdraft: true,
+		})
+		if err != nil {
+			return NewTransferError(ErrCodeInternal, fmt.Errorf("error booking fee: %w", err))
+		}
+	}
+	if escrowed && withdrawal == nil {
+		err = app.HoldInEscrow(ctx, &storage.Escrow{
+			SenderAddress:    senderService.WalletAddress,
+			SenderPhone:      phoneNumber,
+			RecipientPhone:   recipient.PhoneNumber,
+			RecipientAddress: recipientAddress,
+			Crypto:           crypto,
+			RecipientCrypto:  recipientCrypto,
+			AmountUSD:        amountUSD,
+		})
+		if err != nil {
+			return NewTransferError(ErrCodeInternal, fmt.Errorf("error holding funds in escrow: %w", err))
+		}
+	}
+
+	// Record the transfer for velocity limits and fee history
+	transaction := &storage.Transaction{
+		ID:               transactionID,
+		Kind:             storage.TransactionTransfer,
+		SenderAddress:    senderService.WalletAddress,
+		SenderPhone:      phoneNumber,
+		RecipientAddress: recipientAddress,
+		RecipientPhone:   recipient.PhoneNumber,
+		Crypto:           crypto,
+		RecipientCrypto:  recipientCrypto,
+		Network:          network,
+		AmountUSD:        amountUSD,
+		Escrowed:         escrowed && withdrawal == nil,
+		FeeUSD:           fee.AmountUSD,
+		FeeVersion:       fee.Version,
+		CreatedAt:        time.Now(),
+	}
+	if withdrawal != nil {
+		transaction.Kind = storage.TransactionWithdrawal
+	}
+	if err := app.Store.CreateTransaction(ctx, transaction); err != nil {
+		return NewTransferError(ErrCodeInternal, fmt.Errorf("error recording transaction: %w", err))
+	}
+
+	if withdrawal != nil {
+		withdrawal.TransactionID = transaction.ID
+		withdrawal.SenderAddress, withdrawal.SenderPhone = senderService.WalletAddress, phoneNumber
+		withdrawal.Crypto, withdrawal.FeeUSD = crypto, fee.AmountUSD
+		if err := app.QueueWithdrawal(ctx, withdrawal); err != nil {
+			return NewTransferError(ErrCodeInternal, fmt.Errorf("error queueing withdrawal: %w", err))
+		}
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("%s to %s is being sent on %s. Fee $%.2f", recipientCrypto, shortAddress(recipientAddress), network, fee.AmountUSD))
+		return nil
+	}
+
+	if escrowed {
+		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("%s is held for %s until they register. Fee $%.2f", crypto, recipient.Label, fee.AmountUSD))
+		return nil
 	}
 
 	// Fetch recipient's phone number from sms_service
-	recipientService, exists, err := storage.CheckWalletExistsInSmsService(ctx, recipientAddress)
+	recipientService, exists, err := app.Store.CheckWalletExistsInSmsService(ctx, recipientAddress)
 	if err != nil {
-		utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), "Internal server error")
-		return fmt.Errorf("error fetching recipient phone number: %w", err)
+		return reject(ErrCodeInternal, fmt.Errorf("error fetching recipient phone number: %w", err))
 	}
 	if exists && recipientService.PhoneNumber != "" {
 		utils.SendSMS(recipientService.PhoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("$%.2f has been added into your %s account", amountUSD, recipientCrypto))
 	}
 
 	// Send confirmation message to the sender
-	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("%s has been sent successfully", crypto))
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf("%s has been sent successfully to %s. Fee $%.2f", crypto, recipient.Label, fee.AmountUSD))
 
 	return nil
 }
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+// DefaultLanguage is used for users without a language preference
+const DefaultLanguage = "en"
+
+// ErrorCode identifies why a transfer was rejected. Codes are stable and safe to show clients.
+type ErrorCode string
+
+const (
+	ErrCodeMalformedRequest       ErrorCode = "MALFORMED_REQUEST"
+	ErrCodeInvalidAmount          ErrorCode = "INVALID_AMOUNT"
+	ErrCodeUnknownAsset           ErrorCode = "UNKNOWN_ASSET"
+	ErrCodeAssetDisabled          ErrorCode = "ASSET_DISABLED"
+	ErrCodeBelowMinimum           ErrorCode = "AMOUNT_BELOW_MINIMUM"
+	ErrCodeAboveMaximum           ErrorCode = "AMOUNT_ABOVE_MAXIMUM"
+	ErrCodeInvalidRecipient       ErrorCode = "INVALID_RECIPIENT"
+	ErrCodeAddressChecksum        ErrorCode = "ADDRESS_CHECKSUM"
+	ErrCodeUnsupportedNetwork     ErrorCode = "UNSUPPORTED_NETWORK"
+	ErrCodeMissingPasskey         ErrorCode = "MISSING_PASSKEY"
+	ErrCodePhoneNotRegistered     ErrorCode = "PHONE_NOT_REGISTERED"
+	ErrCodeInvalidPasskey         ErrorCode = "INVALID_PASSKEY"
+	ErrCodeRecipientNotRegistered ErrorCode = "RECIPIENT_NOT_REGISTERED"
+	ErrCodeLimitExceeded          ErrorCode = "LIMIT_EXCEEDED"
+	ErrCodeVelocityLimit          ErrorCode = "VELOCITY_LIMIT"
+	ErrCodeInsufficientBalance    ErrorCode = "INSUFFICIENT_BALANCE"
+	ErrCodeTransferBlocked        ErrorCode = "TRANSFER_BLOCKED"
+	ErrCodeWalletFrozen           ErrorCode = "WALLET_FROZEN"
+	ErrCodeInternal               ErrorCode = "INTERNAL_ERROR"
+)
+
+// errorCatalog maps each code to its HTTP status and SMS reply per language. Replies are
+// fmt templates filled from TransferError.Args and must take the same arguments in every
+// language. Every code has an "en" reply.
+var errorCatalog = map[ErrorCode]struct {
+	Status   int
+	Messages map[string]string
+}{
+	ErrCodeMalformedRequest: {http.StatusBadRequest, map[string]string{
+		"en": "Sorry, we could not read your message",
+		"es": "No pudimos leer su mensaje",
+		"fr": "Nous n'avons pas pu lire votre message",
+		"sw": "Samahani, hatukuweza kusoma ujumbe wako",
+	}},
+	ErrCodeInvalidAmount: {http.StatusBadRequest, map[string]string{
+		"en": "Invalid amount. Send a positive USD amount",
+		"es": "Monto invalido. Envie un monto positivo en USD",
+		"fr": "Montant invalide. Envoyez un montant positif en USD",
+		"sw": "Kiasi si sahihi. Tuma kiasi chanya cha USD",
+	}},
+	ErrCodeUnknownAsset: {http.StatusBadRequest, map[string]string{
+		"en": "Unknown asset %s",
+		"es": "Activo desconocido %s",
+		"fr": "Actif inconnu %s",
+		"sw": "Sarafu %s haijulikani",
+	}},
+	ErrCodeAssetDisabled: {http.StatusUnprocessableEntity, map[string]string{
+		"en": "%s transfers are paused. Try again later",
+		"es": "Las transferencias de %s estan pausadas. Intente mas tarde",
+		"fr": "Les transferts de %s sont suspendus. Reessayez plus tard",
+		"sw": "Uhamisho wa %s umesitishwa. Jaribu tena baadaye",
+	}},
+	ErrCodeBelowMinimum: {http.StatusUnprocessableEntity, map[string]string{
+		"en": "Minimum %s transfer is $%.2f",
+		"es": "La transferencia minima de %s es $%.2f",
+		"fr": "Le transfert minimum de %s est de $%.2f",
+		"sw": "Kiwango cha chini cha uhamisho wa %s ni $%.2f",
+	}},
+	ErrCodeAboveMaximum: {http.StatusUnprocessableEntity, map[string]string{
+		"en": "Maximum %s transfer is $%.2f",
+		"es": "La transferencia maxima de %s es $%.2f",
+		"fr": "Le transfert maximum de %s est de $%.2f",
+		"sw": "Kiwango cha juu cha uhamisho wa %s ni $%.2f",
+	}},
+	ErrCodeInvalidRecipient: {http.StatusBadRequest, map[string]string{
+		"en": "Invalid recipient %s",
+		"es": "Destinatario invalido %s",
+		"fr": "Destinataire invalide %s",
+		"sw": "Mpokeaji %s si sahihi",
+	}},
+	ErrCodeAddressChecksum: {http.StatusBadRequest, map[string]string{
+		"en": "Address checksum failed. Character %d looks wrong",
+		"es": "La direccion no es valida. El caracter %d parece incorrecto",
+		"fr": "Adresse invalide. Le caractere %d semble incorrect",
+		"sw": "Anwani si sahihi. Herufi ya %d inaonekana kuwa na kosa",
+	}},
+	ErrCodeUnsupportedNetwork: {http.StatusBadRequest, map[string]string{
+		"en": "%s is not available on %s",
+		"es": "%s no esta disponible en %s",
+		"fr": "%s n'est pas disponible sur %s",
+		"sw": "%s haipatikani kwenye %s",
+	}},
+	ErrCodeMissingPasskey: {http.StatusUnauthorized, map[string]string{
+		"en": "Passkey missing. Add your passkey to the message",
+		"es": "Falta la clave. Agregue su clave al mensaje",
+		"fr": "Code secret manquant. Ajoutez-le au message",
+		"sw": "Nenosiri halipo. Ongeza nenosiri kwenye ujumbe",
+	}},
+	ErrCodePhoneNotRegistered: {http.StatusForbidden, map[string]string{
+		"en": "Phone number not registered",
+		"es": "Numero de telefono no registrado",
+		"fr": "Numero de telephone non enregistre",
+		"sw": "Namba ya simu haijasajiliwa",
+	}},
+	ErrCodeInvalidPasskey: {http.StatusUnauthorized, map[string]string{
+		"en": "Invalid passkey",
+		"es": "Clave invalida",
+		"fr": "Code secret invalide",
+		"sw": "Nenosiri si sahihi",
+	}},
+	ErrCodeRecipientNotRegistered: {http.StatusNotFound, map[string]string{
+		"en": "Recipient is not registered",
+		"es": "El destinatario no esta registrado",
+		"fr": "Le destinataire n'est pas enregistre",
+		"sw": "Mpokeaji hajasajiliwa",
+	}},
+	ErrCodeLimitExceeded: {http.StatusUnprocessableEntity, map[string]string{
+		"en": "Transaction amount exceeds your $%.2f limit",
+		"es": "El monto supera su limite de $%.2f",
+		"fr": "Le montant depasse votre limite de $%.2f",
+		"sw": "Kiasi kinazidi kikomo chako cha $%.2f",
+	}},
+	ErrCodeVelocityLimit: {http.StatusTooManyRequests, map[string]string{
+		"en": "%s limit reached. $%.2f left, more available %s UTC",
+		"es": "Limite %s alcanzado. Quedan $%.2f, mas disponible %s UTC",
+		"fr": "Limite %s atteinte. Reste $%.2f, plus disponible %s UTC",
+		"sw": "Kikomo cha %s kimefikiwa. Zimebaki $%.2f, zaidi %s UTC",
+	}},
+	ErrCodeInsufficientBalance: {http.StatusUnprocessableEntity, map[string]string{
+		"en": "Insufficient %s balance",
+		"es": "Saldo de %s insuficiente",
+		"fr": "Solde %s insuffisant",
+		"sw": "Salio la %s halitoshi",
+	}},
+	ErrCodeTransferBlocked: {http.StatusForbidden, map[string]string{
+		"en": "Transfer blocked for review. Contact support",
+		"es": "Transferencia bloqueada para revision. Contacte soporte",
+		"fr": "Transfert bloque pour verification. Contactez le support",
+		"sw": "Uhamisho umezuiwa kwa ukaguzi. Wasiliana na huduma",
+	}},
+	ErrCodeWalletFrozen: {http.StatusForbidden, map[string]string{
+		"en": "Your wallet is on hold while we review your balance. Contact support",
+		"es": "Su billetera esta en espera mientras revisamos su saldo. Contacte soporte",
+		"fr": "Votre portefeuille est suspendu pendant la verification de votre solde. Contactez le support",
+		"sw": "Pochi yako imesimamishwa tunapokagua salio lako. Wasiliana na huduma",
+	}},
+	ErrCodeInternal: {http.StatusInternalServerError, map[string]string{
+		"en": "Internal server error",
+		"es": "Error interno del servidor",
+		"fr": "Erreur interne du serveur",
+		"sw": "Hitilafu ya ndani ya seva",
+	}},
+}
+
+// TransferError is a rejected transfer with a stable code
+type TransferError struct {
+	Code ErrorCode
+	// Args fill the code's reply template
+	Args []interface{}
+	// Err is the underlying cause, for logs only
+	Err error
+}
+
+// NewTransferError builds a TransferError
+func NewTransferError(code ErrorCode, err error, args ...interface{}) *TransferError {
+	return &TransferError{Code: code, Args: args, Err: err}
+}
+
+func (e *TransferError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Code, e.Err)
+	}
+	return string(e.Code)
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus returns the HTTP status for the error's code
+func (e *TransferError) HTTPStatus() int {
+	if entry, ok := errorCatalog[e.Code]; ok {
+		return entry.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// Message returns the reply for the error's code in language, falling back to English
+func (e *TransferError) Message(language string) string {
+	entry, ok := errorCatalog[e.Code]
+	if !ok {
+		entry = errorCatalog[ErrCodeInternal]
+	}
+	template, ok := entry.Messages[language]
+	if !ok {
+		template = entry.Messages[DefaultLanguage]
+	}
+	if len(e.Args) == 0 {
+		return template
+	}
+	return fmt.Sprintf(template, e.Args...)
+}
+
+// AsTransferError extracts a TransferError from err, wrapping anything else as INTERNAL_ERROR
+func AsTransferError(err error) *TransferError {
+	var transferErr *TransferError
+	if errors.As(err, &transferErr) {
+		return transferErr
+	}
+	return NewTransferError(ErrCodeInternal, err)
+}
+
+// ReplyTransferError texts the localized reply for err to phoneNumber and returns err
+func ReplyTransferError(phoneNumber string, language string, err *TransferError) error {
+	utils.SendSMS(phoneNumber, os.Getenv("TWILIO_PHONE_NUMBER"), err.Message(language))
+	return err
+}
+
+// LanguageOf returns a user's reply language
+func LanguageOf(service *storage.SmsService) string {
+	if service == nil || service.Language == "" {
+		return DefaultLanguage
+	}
+	return service.Language
+}
+
+// IsSupportedLanguage reports whether replies can be localized into language
+func IsSupportedLanguage(language string) bool {
+	_, ok := errorCatalog[ErrCodeInternal].Messages[language]
+	return ok
+}
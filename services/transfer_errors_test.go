@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var formatVerb = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+
+func TestErrorCatalog(t *testing.T) {
+	languages := []string{"en", "es", "fr", "sw"}
+	for code, entry := range errorCatalog {
+		if entry.Status < 400 || entry.Status > 599 {
+			t.Errorf("%s: status %d isn't an error", code, entry.Status)
+		}
+		english, ok := entry.Messages[DefaultLanguage]
+		if !ok {
+			t.Errorf("%s: no %s reply", code, DefaultLanguage)
+			continue
+		}
+		for _, language := range languages {
+			message, ok := entry.Messages[language]
+			if !ok {
+				t.Errorf("%s: no %s reply", code, language)
+				continue
+			}
+			// Every language is filled from the same TransferError.Args
+			if got, want := formatVerb.FindAllString(message, -1), formatVerb.FindAllString(english, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s reply takes %v, want %v as in English", code, language, got, want)
+			}
+		}
+	}
+}
+
+func TestTransferErrorMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *TransferError
+		language string
+		want     string
+		status   int
+	}{
+		{"no arguments", NewTransferError(ErrCodeInvalidPasskey, nil), "en", "Invalid passkey", http.StatusUnauthorized},
+		{"arguments filled", NewTransferError(ErrCodeBelowMinimum, nil, "BTC", 5.0), "en", "Minimum BTC transfer is $5.00", http.StatusUnprocessableEntity},
+		{"localized", NewTransferError(ErrCodeUnknownAsset, nil, "DOGE"), "es", "Activo desconocido DOGE", http.StatusBadRequest},
+		{"unsupported language falls back to English", NewTransferError(ErrCodeUnknownAsset, nil, "DOGE"), "de", "Unknown asset DOGE", http.StatusBadRequest},
+		{"unknown code is an internal error", NewTransferError("NO_SUCH_CODE", nil), "fr", "Erreur interne du serveur", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if got := test.err.Message(test.language); got != test.want {
+			t.Errorf("%s: message %q, want %q", test.name, got, test.want)
+		}
+		if got := test.err.HTTPStatus(); got != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, got, test.status)
+		}
+	}
+}
+
+func TestAsTransferError(t *testing.T) {
+	cause := errors.New("connection reset")
+	transferErr := NewTransferError(ErrCodeInsufficientBalance, cause, "USDC")
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{"transfer error", transferErr, ErrCodeInsufficientBalance},
+		{"wrapped transfer error", errors.Join(errors.New("executing transfer"), transferErr), ErrCodeInsufficientBalance},
+		{"other error", cause, ErrCodeInternal},
+	}
+	for _, test := range tests {
+		got := AsTransferError(test.err)
+		if got.Code != test.want {
+			t.Errorf("%s: code %s, want %s", test.name, got.Code, test.want)
+		}
+		if !errors.Is(got, cause) {
+			t.Errorf("%s: %v doesn't wrap its cause", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+var recipientAliasPattern = regexp.MustCompile(`^@[a-z0-9_]{3,20}$`)
+
+// TransferRequest is a transfer as submitted by a user, over SMS or the API
+type TransferRequest struct {
+	PhoneNumber      string  `json:"phone_number"`
+	Passkey          string  `json:"passkey"`
+	AmountUSD        float64 `json:"amount_usd"`
+	Crypto           string  `json:"crypto"`
+	RecipientAddress string  `json:"recipient_address"`
+	RecipientCrypto  string  `json:"recipient_crypto"`
+	// Network optionally names the chain an external recipient is paid on
+	Network string `json:"network,omitempty"`
+	// PaymentRequestID links the transfer to the payment request it pays, if any
+	PaymentRequestID primitive.ObjectID `json:"-"`
+}
+
+// Normalize trims the request's fields, upper-cases assets and adds the phone number's plus sign.
+// A missing recipient asset defaults to the sent asset.
+func (r *TransferRequest) Normalize() {
+	r.PhoneNumber = strings.TrimSpace(r.PhoneNumber)
+	if r.PhoneNumber != "" && !strings.HasPrefix(r.PhoneNumber, "+") {
+		r.PhoneNumber = "+" + r.PhoneNumber
+	}
+	r.Passkey = strings.TrimSpace(r.Passkey)
+	r.Crypto = strings.ToUpper(strings.TrimSpace(r.Crypto))
+	r.RecipientCrypto = strings.ToUpper(strings.TrimSpace(r.RecipientCrypto))
+	if r.RecipientCrypto == "" {
+		r.RecipientCrypto = r.Crypto
+	}
+	r.Network = strings.ToLower(strings.TrimSpace(r.Network))
+	r.RecipientAddress = strings.TrimSpace(r.RecipientAddress)
+	if strings.HasPrefix(r.RecipientAddress, "@") {
+		r.RecipientAddress = strings.ToLower(r.RecipientAddress)
+	}
+}
+
+// ValidateTransferRequest checks a request before it is authenticated. Assets are resolved
+// to their registry symbols and the recipient's wallet address is put in canonical form.
+// It expects a normalized request.
+func (app *App) ValidateTransferRequest(ctx context.Context, r *TransferRequest) *TransferError {
+	if r.PhoneNumber == "" {
+		return NewTransferError(ErrCodeMalformedRequest, errors.New("missing phone number"))
+	}
+	if math.IsNaN(r.AmountUSD) || math.IsInf(r.AmountUSD, 0) || r.AmountUSD <= 0 {
+		return NewTransferError(ErrCodeInvalidAmount, nil)
+	}
+	terms, terr := app.resolveTransferTerms(ctx, transferOrder{
+		Recipient:       r.RecipientAddress,
+		Crypto:          r.Crypto,
+		RecipientCrypto: r.RecipientCrypto,
+		Network:         r.Network,
+		AmountUSD:       r.AmountUSD,
+	}, nil)
+	if terr != nil {
+		return terr
+	}
+	r.Crypto, r.RecipientCrypto, r.RecipientAddress = terms.Asset.Symbol, terms.RecipientAsset.Symbol, terms.Recipient
+	if r.Passkey == "" {
+		return NewTransferError(ErrCodeMissingPasskey, nil)
+	}
+	return nil
+}
+
+// transferTerms are the registry entries, canonical recipient and network a transfer runs with
+type transferTerms struct {
+	Asset          *storage.Asset
+	RecipientAsset *storage.Asset
+	Recipient      string
+	// Network is empty when the recipient is a phone number or alias
+	Network string
+}
+
+// resolveTransferTerms resolves both assets against the registry, checks the amount against
+// the sent asset's bounds and validates the recipient on the received asset's networks.
+// preferred maps assets to the sender's preferred network and may be nil.
+func (app *App) resolveTransferTerms(ctx context.Context, order transferOrder, preferred map[string]string) (*transferTerms, *TransferError) {
+	asset, err := app.ResolveAsset(ctx, order.Crypto)
+	if err != nil {
+		return nil, assetTransferError(order.Crypto, err)
+	}
+	recipientAsset, err := app.ResolveAsset(ctx, order.RecipientCrypto)
+	if err != nil {
+		return nil, assetTransferError(order.RecipientCrypto, err)
+	}
+	if terr := checkTransferBounds(asset, order.AmountUSD); terr != nil {
+		return nil, terr
+	}
+	recipient, network, terr := NormalizeRecipient(recipientAsset, order.Recipient, order.Network, preferred[recipientAsset.Symbol])
+	if terr != nil {
+		return nil, terr
+	}
+	return &transferTerms{Asset: asset, RecipientAsset: recipientAsset, Recipient: recipient, Network: network}, nil
+}
+
+// NormalizeRecipient checks that recipient is an @alias, a phone number or a wallet address
+// valid on one of asset's networks, returning wallet addresses in canonical form with the
+// network they are paid on. A stated network must be used; otherwise the preferred network
+// is tried first. Phone numbers and aliases are internal and have no network.
+func NormalizeRecipient(asset *storage.Asset, recipient string, network string, preferred string) (string, string, *TransferError) {
+	network = strings.ToLower(network)
+	if network != "" && !SupportsNetwork(asset, network) {
+		return "", "", NewTransferError(ErrCodeUnsupportedNetwork, nil, asset.Symbol, network)
+	}
+	if strings.HasPrefix(recipient, "@") {
+		if !recipientAliasPattern.MatchString(recipient) {
+			return "", "", NewTransferError(ErrCodeInvalidRecipient, nil, recipient)
+		}
+		return recipient, "", nil
+	}
+	if IsPhoneNumber(recipient) {
+		return recipient, "", nil
+	}
+
+	networks := asset.Networks
+	if network != "" {
+		networks = []string{network}
+	} else if preferred != "" && SupportsNetwork(asset, preferred) {
+		networks = append([]string{preferred}, asset.Networks...)
+	}
+	address, network, err := normalizeWalletAddressOnAny(networks, recipient)
+	var addressErr *utils.AddressError
+	if errors.As(err, &addressErr) && addressErr.Checksum && addressErr.Position > 0 {
+		return "", "", NewTransferError(ErrCodeAddressChecksum, err, addressErr.Position)
+	}
+	if err != nil {
+		return "", "", NewTransferError(ErrCodeInvalidRecipient, err, recipient)
+	}
+	return address, network, nil
+}
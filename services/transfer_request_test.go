@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+func TestNormalizeTransferRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request TransferRequest
+		want    TransferRequest
+	}{
+		{"already normal", TransferRequest{PhoneNumber: "+15550100", Passkey: "1234", Crypto: "USDC", RecipientCrypto: "ETH", RecipientAddress: "+15550101"},
+			TransferRequest{PhoneNumber: "+15550100", Passkey: "1234", Crypto: "USDC", RecipientCrypto: "ETH", RecipientAddress: "+15550101"}},
+		{"plus sign added", TransferRequest{PhoneNumber: " 15550100 "},
+			TransferRequest{PhoneNumber: "+15550100"}},
+		{"assets upper-cased and trimmed", TransferRequest{Crypto: " usdc ", RecipientCrypto: "eth "},
+			TransferRequest{Crypto: "USDC", RecipientCrypto: "ETH"}},
+		{"recipient asset defaults to the sent asset", TransferRequest{Crypto: "usdt"},
+			TransferRequest{Crypto: "USDT", RecipientCrypto: "USDT"}},
+		{"network lower-cased", TransferRequest{Network: " TRON "},
+			TransferRequest{Network: "tron"}},
+		{"aliases lower-cased", TransferRequest{RecipientAddress: " @Carol_Pay "},
+			TransferRequest{RecipientAddress: "@carol_pay"}},
+		{"addresses keep their case", TransferRequest{RecipientAddress: " 0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+			TransferRequest{RecipientAddress: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}},
+		{"passkey trimmed", TransferRequest{Passkey: " 1234\n"},
+			TransferRequest{Passkey: "1234"}},
+	}
+	for _, test := range tests {
+		request := test.request
+		request.Normalize()
+		if request != test.want {
+			t.Errorf("%s: normalized to %+v, want %+v", test.name, request, test.want)
+		}
+	}
+}
+
+func TestValidateTransferRequest(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+	bounded := &storage.Asset{Symbol: "DAI", Decimals: 18, Networks: []string{ChainEthereum}, Enabled: true, MinTransferUSD: 5, MaxTransferUSD: 1000}
+	if err := app.Store.SaveAsset(ctx, bounded); err != nil {
+		t.Fatalf("saving asset: %v", err)
+	}
+	if _, err := app.Store.SetAssetEnabled(ctx, "BTC", false); err != nil {
+		t.Fatalf("disabling asset: %v", err)
+	}
+
+	valid := TransferRequest{PhoneNumber: "+15550100", Passkey: "1234", AmountUSD: 25, Crypto: "USDC", RecipientCrypto: "USDC", RecipientAddress: "+15550101"}
+	with := func(change func(*TransferRequest)) TransferRequest {
+		request := valid
+		change(&request)
+		return request
+	}
+	tests := []struct {
+		name    string
+		request TransferRequest
+		want    ErrorCode
+	}{
+		{"valid", valid, ""},
+		{"missing phone number", with(func(r *TransferRequest) { r.PhoneNumber = "" }), ErrCodeMalformedRequest},
+		{"zero amount", with(func(r *TransferRequest) { r.AmountUSD = 0 }), ErrCodeInvalidAmount},
+		{"negative amount", with(func(r *TransferRequest) { r.AmountUSD = -5 }), ErrCodeInvalidAmount},
+		{"NaN amount", with(func(r *TransferRequest) { r.AmountUSD = math.NaN() }), ErrCodeInvalidAmount},
+		{"infinite amount", with(func(r *TransferRequest) { r.AmountUSD = math.Inf(1) }), ErrCodeInvalidAmount},
+		{"unknown asset", with(func(r *TransferRequest) { r.Crypto = "DOGE" }), ErrCodeUnknownAsset},
+		{"unknown recipient asset", with(func(r *TransferRequest) { r.RecipientCrypto = "DOGE" }), ErrCodeUnknownAsset},
+		{"disabled asset", with(func(r *TransferRequest) { r.Crypto = "BTC" }), ErrCodeAssetDisabled},
+		{"below minimum", with(func(r *TransferRequest) { r.Crypto, r.RecipientCrypto, r.AmountUSD = "DAI", "DAI", 4 }), ErrCodeBelowMinimum},
+		{"above maximum", with(func(r *TransferRequest) { r.Crypto, r.RecipientCrypto, r.AmountUSD = "DAI", "DAI", 1001 }), ErrCodeAboveMaximum},
+		{"within bounds", with(func(r *TransferRequest) { r.Crypto, r.RecipientCrypto, r.AmountUSD = "DAI", "DAI", 1000 }), ""},
+		{"invalid recipient", with(func(r *TransferRequest) { r.RecipientAddress = "nobody" }), ErrCodeInvalidRecipient},
+		{"invalid alias", with(func(r *TransferRequest) { r.RecipientAddress = "@x" }), ErrCodeInvalidRecipient},
+		{"bad address checksum", with(func(r *TransferRequest) { r.RecipientAddress = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD" }), ErrCodeAddressChecksum},
+		{"unsupported network", with(func(r *TransferRequest) { r.Network = ChainTron }), ErrCodeUnsupportedNetwork},
+		{"missing passkey", with(func(r *TransferRequest) { r.Passkey = "" }), ErrCodeMissingPasskey},
+	}
+	for _, test := range tests {
+		request := test.request
+		terr := app.ValidateTransferRequest(ctx, &request)
+		if test.want == "" {
+			if terr != nil {
+				t.Errorf("%s: %v, want the request accepted", test.name, terr)
+			}
+			continue
+		}
+		if terr == nil || terr.Code != test.want {
+			t.Errorf("%s: %v, want %s", test.name, terr, test.want)
+		}
+	}
+}
+
+func TestValidateTransferRequestResolvesTerms(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(ctx); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+
+	request := TransferRequest{PhoneNumber: "+15550100", Passkey: "1234", AmountUSD: 25, Crypto: "TETHER", RecipientCrypto: "ETHEREUM",
+		RecipientAddress: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}
+	if terr := app.ValidateTransferRequest(ctx, &request); terr != nil {
+		t.Fatalf("validating: %v", terr)
+	}
+	if request.Crypto != "USDT" || request.RecipientCrypto != "ETH" || request.RecipientAddress != "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" {
+		t.Errorf("resolved to %s → %s at %s, want USDT → ETH at the checksummed address", request.Crypto, request.RecipientCrypto, request.RecipientAddress)
+	}
+}
+
+func TestNormalizeRecipientNetworks(t *testing.T) {
+	usdt := &storage.Asset{Symbol: "USDT", Networks: []string{ChainEthereum, ChainTron}}
+	ethereumAddress, tronAddress := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+	tests := []struct {
+		name      string
+		recipient string
+		network   string
+		preferred string
+		want      string
+		wantCode  ErrorCode
+	}{
+		{"network found from an Ethereum address", ethereumAddress, "", "", ChainEthereum, ""},
+		{"network found from a Tron address", tronAddress, "", "", ChainTron, ""},
+		{"stated network", tronAddress, "TRON", "", ChainTron, ""},
+		{"preferred network tried first", tronAddress, "", ChainTron, ChainTron, ""},
+		{"preference the address doesn't fit", ethereumAddress, "", ChainTron, ChainEthereum, ""},
+		{"unsupported preference ignored", ethereumAddress, "", "solana", ChainEthereum, ""},
+		{"stated network the address doesn't fit", ethereumAddress, ChainTron, "", "", ErrCodeInvalidRecipient},
+		{"stated network the asset isn't on", ethereumAddress, ChainBitcoin, "", "", ErrCodeUnsupportedNetwork},
+		{"phone numbers have no network", "+15550101", "", ChainTron, "", ""},
+		{"aliases have no network", "@carol", "", "", "", ""},
+		{"stated network checked for phone numbers too", "+15550101", ChainBitcoin, "", "", ErrCodeUnsupportedNetwork},
+	}
+	for _, test := range tests {
+		_, network, terr := NormalizeRecipient(usdt, test.recipient, test.network, test.preferred)
+		if test.wantCode != "" {
+			if terr == nil || terr.Code != test.wantCode {
+				t.Errorf("%s: %v, want %s", test.name, terr, test.wantCode)
+			}
+			continue
+		}
+		if terr != nil {
+			t.Errorf("%s: %v", test.name, terr)
+			continue
+		}
+		if network != test.want {
+			t.Errorf("%s: paid on %q, want %q", test.name, network, test.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// maxTwoFactorFailures is how many wrong codes a phone number may send within a code's
+// lifetime before 2FA checks for it are refused
+const maxTwoFactorFailures = 5
+
+// ErrTooManyAttempts is returned when a phone number has sent too many wrong codes recently
+var ErrTooManyAttempts = errors.New("too many failed attempts")
+
+// Verify2FACode checks a code sent to phoneNumber with /generate-2fa-code. A matching code is
+// used up. Wrong codes are recorded as auth failures, and once a number has
+// maxTwoFactorFailures of them within TwoFactorCodeTTL every check returns ErrTooManyAttempts,
+// so a code can't be guessed in the time it is valid.
+func (app *App) Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool, error) {
+	failures, err := app.Store.CountAuthFailuresSince(ctx, phoneNumber, time.Now().Add(-storage.TwoFactorCodeTTL))
+	if err != nil {
+		return false, err
+	}
+	if failures >= maxTwoFactorFailures {
+		return false, ErrTooManyAttempts
+	}
+	verified, err := app.Store.Verify2FACode(ctx, phoneNumber, code)
+	if err != nil {
+		return false, err
+	}
+	if !verified {
+		app.Store.RecordAuthFailure(ctx, phoneNumber)
+	}
+	return verified, nil
+}
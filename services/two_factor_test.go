@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"crypto-sms/storage/memory"
+)
+
+func TestVerify2FACodeLocksOutAfterFailures(t *testing.T) {
+	ctx := context.Background()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.Store.Generate2FACodeAndStore(ctx, "+15550100", "123456"); err != nil {
+		t.Fatalf("storing code: %v", err)
+	}
+
+	for attempt := 1; attempt <= maxTwoFactorFailures; attempt++ {
+		verified, err := app.Verify2FACode(ctx, "+15550100", "000000")
+		if err != nil || verified {
+			t.Fatalf("wrong code %d verified %v with error %v, want refused without error", attempt, verified, err)
+		}
+	}
+	if verified, err := app.Verify2FACode(ctx, "+15550100", "123456"); !errors.Is(err, ErrTooManyAttempts) || verified {
+		t.Fatalf("right code after %d failures verified %v with error %v, want ErrTooManyAttempts", maxTwoFactorFailures, verified, err)
+	}
+
+	// Other numbers aren't locked out
+	if err := app.Store.Generate2FACodeAndStore(ctx, "+15550101", "654321"); err != nil {
+		t.Fatalf("storing code: %v", err)
+	}
+	if verified, err := app.Verify2FACode(ctx, "+15550101", "654321"); err != nil || !verified {
+		t.Fatalf("another number's code verified %v with error %v, want verified", verified, err)
+	}
+	if verified, err := app.Verify2FACode(ctx, "+15550101", "654321"); err != nil || verified {
+		t.Fatalf("a used code verified %v with error %v, want refused", verified, err)
+	}
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/chain"
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+const (
+	// defaultWithdrawalPendingAddress is the custodian account holding funds on their way
+	// on-chain when WITHDRAWAL_PENDING_ADDRESS is unset
+	defaultWithdrawalPendingAddress = "withdrawals_pending"
+	// defaultWithdrawalConfirmations applies when WITHDRAWAL_CONFIRMATIONS is unset or invalid
+	defaultWithdrawalConfirmations = 12
+	// maxWithdrawalAttempts is how many failed signing or broadcast attempts a withdrawal
+	// gets before it is refunded
+	maxWithdrawalAttempts = 5
+)
+
+// WithdrawalPendingAddress returns the custodian wallet that holds withdrawals until they confirm
+func WithdrawalPendingAddress() string {
+	if address := os.Getenv("WITHDRAWAL_PENDING_ADDRESS"); address != "" {
+		return address
+	}
+	return defaultWithdrawalPendingAddress
+}
+
+// WithdrawalConfirmations returns how many confirmations settle a withdrawal
+func WithdrawalConfirmations() int64 {
+	if confirmations, err := strconv.ParseInt(os.Getenv("WITHDRAWAL_CONFIRMATIONS"), 10, 64); err == nil && confirmations > 0 {
+		return confirmations
+	}
+	return defaultWithdrawalConfirmations
+}
+
+// prepareWithdrawal prices a transfer to an external address in the asset's base units. The
+// custody provider pays it out when it can, and a chain adapter otherwise. It returns nil when
+// neither settles the network, leaving the transfer to escrow.
+func (app *App) prepareWithdrawal(asset *storage.Asset, network string, toAddress string, amountUSD float64) (*storage.Withdrawal, error) {
+	if network == "" {
+		return nil, nil
+	}
+	custodian := ""
+	if _, ok := app.custodyWithdrawer(); ok {
+		custodian = app.Custody().Name()
+	} else if _, ok := app.ChainAdapterFor(network); !ok {
+		return nil, nil
+	}
+	amount, err := toBaseUnits(asset, amountUSD)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Withdrawal{
+		Asset:     asset.Symbol,
+		Network:   network,
+		ToAddress: toAddress,
+		AmountUSD: amountUSD,
+		Amount:    amount.String(),
+		Custodian: custodian,
+	}, nil
+}
+
+// toBaseUnits converts a USD amount to the asset's smallest unit at the current price
+func toBaseUnits(asset *storage.Asset, amountUSD float64) (*big.Int, error) {
+	price, ok := GetPriceUSD(asset.Symbol)
+	if !ok || price <= 0 {
+		return nil, fmt.Errorf("no price for %s", asset.Symbol)
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(asset.Decimals)), nil)
+	units, _ := new(big.Float).Mul(big.NewFloat(amountUSD/price), new(big.Float).SetInt(scale)).Int(nil)
+	if units.Sign() <= 0 {
+		return nil, fmt.Errorf("$%.2f is too small to settle in %s", amountUSD, asset.Symbol)
+	}
+	return units, nil
+}
+
+// QueueWithdrawal stores an already-debited withdrawal for the settlement job. The funds
+// must already be held in the pending withdrawals account.
+func (app *App) QueueWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal) error {
+	now := time.Now()
+	withdrawal.Status = storage.WithdrawalQueued
+	withdrawal.CreatedAt = now
+	withdrawal.UpdatedAt = now
+	return app.Store.CreateWithdrawal(ctx, withdrawal)
+}
+
+// ProcessWithdrawals moves every unsettled withdrawal through signing, broadcast and
+// confirmation, refunding those that fail on-chain or keep failing to send
+func (app *App) ProcessWithdrawals(ctx context.Context) error {
+	withdrawals, err := app.Store.ListWithdrawalsByStatus(ctx, storage.WithdrawalQueued, storage.WithdrawalSigned, storage.WithdrawalBroadcast)
+	if err != nil {
+		return err
+	}
+	for i := range withdrawals {
+		if err := app.settleWithdrawal(ctx, &withdrawals[i]); err != nil {
+			log.Printf("Error settling withdrawal %s: %v", withdrawals[i].ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+func (app *App) settleWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal) error {
+	if withdrawal.Custodian != "" {
+		return app.submitCustodianWithdrawal(ctx, withdrawal)
+	}
+	adapter, ok := app.ChainAdapterFor(withdrawal.Network)
+	if !ok {
+		return fmt.Errorf("no chain adapter for %s", withdrawal.Network)
+	}
+
+	if withdrawal.Status == storage.WithdrawalQueued {
+		signed, err := app.signWithdrawal(ctx, adapter, withdrawal)
+		if err != nil {
+			return app.failWithdrawalAttempt(ctx, adapter, withdrawal, err)
+		}
+		// Store the signed transaction before sending it, so a crash can't lead to a second signature
+		moved, err := app.Store.TransitionWithdrawal(ctx, withdrawal.ID, storage.WithdrawalQueued, storage.WithdrawalSigned, storage.WithdrawalFields{TxHash: &signed.Hash, RawTx: signed.Raw})
+		if err != nil || !moved {
+			return err
+		}
+		withdrawal.Status, withdrawal.TxHash, withdrawal.RawTx = storage.WithdrawalSigned, signed.Hash, signed.Raw
+	}
+
+	if withdrawal.Status == storage.WithdrawalSigned {
+		if err := adapter.Broadcast(ctx, &chain.SignedTx{Hash: withdrawal.TxHash, Raw: withdrawal.RawTx}); err != nil {
+			return app.failWithdrawalAttempt(ctx, adapter, withdrawal, err)
+		}
+		moved, err := app.Store.TransitionWithdrawal(ctx, withdrawal.ID, storage.WithdrawalSigned, storage.WithdrawalBroadcast, storage.WithdrawalFields{})
+		if err != nil || !moved {
+			return err
+		}
+		withdrawal.Status = storage.WithdrawalBroadcast
+	}
+
+	status, err := adapter.TxStatus(ctx, withdrawal.TxHash)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !status.Found:
+		// Dropped from the mempool; the signed transaction is still valid to resend
+		if err := adapter.Broadcast(ctx, &chain.SignedTx{Hash: withdrawal.TxHash, Raw: withdrawal.RawTx}); err != nil {
+			return app.failWithdrawalAttempt(ctx, adapter, withdrawal, err)
+		}
+		return nil
+	case status.Failed:
+		return app.refundWithdrawal(ctx, withdrawal, "transaction reverted on-chain")
+	case status.Confirmations >= WithdrawalConfirmations():
+		return app.confirmWithdrawal(ctx, withdrawal, status.Confirmations)
+	case status.Confirmations != withdrawal.Confirmations:
+		_, err := app.Store.TransitionWithdrawal(ctx, withdrawal.ID, storage.WithdrawalBroadcast, storage.WithdrawalBroadcast, storage.WithdrawalFields{Confirmations: &status.Confirmations})
+		return err
+	}
+	return nil
+}
+
+// submitCustodianWithdrawal hands a queued withdrawal to the custody provider. It then waits
+// in broadcast until the provider's settlement webhook confirms or fails it.
+func (app *App) submitCustodianWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal) error {
+	if withdrawal.Status != storage.WithdrawalQueued {
+		return nil
+	}
+	withdrawer, ok := app.custodyWithdrawer()
+	if !ok || app.Custody().Name() != withdrawal.Custodian {
+		return fmt.Errorf("custody provider %s is not configured", withdrawal.Custodian)
+	}
+
+	err := withdrawer.Withdraw(ctx, custody.Withdrawal{
+		Reference: withdrawal.ID.Hex(),
+		From:      WithdrawalPendingAddress(),
+		Asset:     withdrawal.Crypto,
+		Network:   withdrawal.Network,
+		ToAddress: withdrawal.ToAddress,
+		Amount:    withdrawal.Amount,
+		AmountUSD: withdrawal.AmountUSD,
+	})
+	if errors.Is(err, custody.ErrInsufficientFunds) {
+		return app.refundWithdrawal(ctx, withdrawal, err.Error())
+	}
+	if err != nil {
+		return app.failWithdrawalAttempt(ctx, nil, withdrawal, err)
+	}
+	_, err = app.Store.TransitionWithdrawal(ctx, withdrawal.ID, storage.WithdrawalQueued, storage.WithdrawalBroadcast, storage.WithdrawalFields{})
+	return err
+}
+
+func (app *App) signWithdrawal(ctx context.Context, adapter chain.ChainAdapter, withdrawal *storage.Withdrawal) (*chain.SignedTx, error) {
+	asset, err := app.LookupAsset(ctx, withdrawal.Asset)
+	if err != nil {
+		return nil, err
+	}
+	amount, ok := new(big.Int).SetString(withdrawal.Amount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", withdrawal.Amount)
+	}
+
+	// Assets without a contract on the network are paid in its native coin
+	tx, err := adapter.BuildTransfer(ctx, chain.Transfer{To: withdrawal.ToAddress, Token: asset.Contracts[withdrawal.Network], Amount: amount})
+	if err != nil {
+		return nil, err
+	}
+	return adapter.Sign(ctx, tx)
+}
+
+// failWithdrawalAttempt records a failed signing, broadcast or submission and refunds the
+// withdrawal once it runs out of attempts, unless the chain has seen the transaction after all
+func (app *App) failWithdrawalAttempt(ctx context.Context, adapter chain.ChainAdapter, withdrawal *storage.Withdrawal, attemptErr error) error {
+	if err := app.Store.RecordWithdrawalAttempt(ctx, withdrawal.ID, attemptErr.Error()); err != nil {
+		return err
+	}
+	if withdrawal.Attempts+1 < maxWithdrawalAttempts {
+		return attemptErr
+	}
+	if withdrawal.TxHash != "" {
+		status, err := adapter.TxStatus(ctx, withdrawal.TxHash)
+		if err != nil || status.Found {
+			return attemptErr
+		}
+	}
+	return app.refundWithdrawal(ctx, withdrawal, attemptErr.Error())
+}
+
+// confirmWithdrawal settles a withdrawal once it has enough confirmations
+func (app *App) confirmWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal, confirmations int64) error {
+	moved, err := app.Store.TransitionWithdrawal(ctx, withdrawal.ID, withdrawal.Status, storage.WithdrawalConfirmed, storage.WithdrawalFields{Confirmations: &confirmations})
+	if err != nil || !moved {
+		return err
+	}
+	// Custody providers take withdrawals out of the pending account when they accept them
+	if withdrawal.Custodian == "" {
+		err = app.Custody().Transfer(ctx, custody.Transfer{
+			Reference:      reference("withdrawal", withdrawal.ID),
+			From:           WithdrawalPendingAddress(),
+			Asset:          withdrawal.Crypto,
+			AmountUSD:      withdrawal.AmountUSD,
+			AllowOverdraft: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	utils.SendSMS(withdrawal.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Your $%.2f %s withdrawal to %s is confirmed on %s. Tx %s",
+		withdrawal.AmountUSD, withdrawal.Asset, shortAddress(withdrawal.ToAddress), withdrawal.Network, withdrawal.TxHash))
+	return nil
+}
+
+// refundWithdrawal returns a withdrawal that can't settle to the sender, fee included, and
+// records a reversal against its transaction
+func (app *App) refundWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal, reason string) error {
+	moved, err := app.Store.TransitionWithdrawal(ctx, withdrawal.ID, withdrawal.Status, storage.WithdrawalRefunded, storage.WithdrawalFields{LastError: &reason})
+	if err != nil || !moved {
+		return err
+	}
+	reversalID := primitive.NewObjectID()
+	err = app.Custody().Transfer(ctx, custody.Transfer{
+		Reference:      reference("reversal", reversalID),
+		From:           WithdrawalPendingAddress(),
+		To:             withdrawal.SenderAddress,
+		Asset:          withdrawal.Crypto,
+		AmountUSD:      withdrawal.AmountUSD,
+		AllowOverdraft: true,
+	})
+	if err != nil {
+		return err
+	}
+	if withdrawal.FeeUSD > 0 {
+		err = app.Custody().Transfer(ctx, custody.Transfer{
+			Reference:      reference("reversal-fee", reversalID),
+			From:           FeeRevenueAddress(),
+			To:             withdrawal.SenderAddress,
+			Asset:          withdrawal.Crypto,
+			AmountUSD:      withdrawal.FeeUSD,
+			AllowOverdraft: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The refunded withdrawal no longer counts towards the sender's velocity limits
+	if !withdrawal.TransactionID.IsZero() {
+		if _, err := app.Store.AddRefundedAmount(ctx, withdrawal.TransactionID, 0, withdrawal.AmountUSD); err != nil {
+			return err
+		}
+	}
+	err = app.Store.CreateTransaction(ctx, &storage.Transaction{
+		ID:               reversalID,
+		Kind:             storage.TransactionReversal,
+		SenderAddress:    WithdrawalPendingAddress(),
+		RecipientAddress: withdrawal.SenderAddress,
+		RecipientPhone:   withdrawal.SenderPhone,
+		Crypto:           withdrawal.Crypto,
+		RecipientCrypto:  withdrawal.Crypto,
+		Network:          withdrawal.Network,
+		AmountUSD:        withdrawal.AmountUSD + withdrawal.FeeUSD,
+		FeeUSD:           withdrawal.FeeUSD,
+		ReversalOf:       withdrawal.TransactionID,
+		Reason:           "withdrawal failed: " + reason,
+		Operator:         "settlement",
+		CreatedAt:        time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	utils.SendSMS(withdrawal.SenderPhone, os.Getenv("TWILIO_PHONE_NUMBER"), fmt.Sprintf(
+		"Your $%.2f %s withdrawal to %s could not be sent and $%.2f has been refunded",
+		withdrawal.AmountUSD, withdrawal.Asset, shortAddress(withdrawal.ToAddress), withdrawal.AmountUSD+withdrawal.FeeUSD))
+	return nil
+}
+
+// shortAddress abbreviates a wallet address to fit in an SMS
+func shortAddress(address string) string {
+	if len(address) <= 14 {
+		return address
+	}
+	return address[:6] + "..." + address[len(address)-4:]
+}
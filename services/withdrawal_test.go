@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crypto-sms/chain"
+	"crypto-sms/custody"
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+)
+
+const testWallet = "0x1111111111111111111111111111111111111111"
+
+// newSimulatedApp returns an app kept in memory, with the default assets and a simulated
+// ethereum chain that mines only when told to
+func newSimulatedApp(t *testing.T) (*App, *chain.SimulatedChain) {
+	t.Helper()
+	app := NewApp(memory.NewStore(), nil)
+	if err := app.SeedAssets(context.Background()); err != nil {
+		t.Fatalf("seeding assets: %v", err)
+	}
+	simulated := chain.NewSimulatedChain(ChainEthereum, 0)
+	app.RegisterChainAdapter(simulated)
+	return app, simulated
+}
+
+// newTestWithdrawal prices a withdrawal of amountUSD USDC, with feeUSD charged on top, from
+// the test wallet to an external address
+func newTestWithdrawal(t *testing.T, app *App, amountUSD float64, feeUSD float64) *storage.Withdrawal {
+	t.Helper()
+	asset, err := app.LookupAsset(context.Background(), "USDC")
+	if err != nil {
+		t.Fatalf("looking up USDC: %v", err)
+	}
+	withdrawal, err := app.prepareWithdrawal(asset, ChainEthereum, "0x2222222222222222222222222222222222222222", amountUSD)
+	if err != nil || withdrawal == nil {
+		t.Fatalf("preparing withdrawal: %v", err)
+	}
+	withdrawal.SenderAddress = testWallet
+	withdrawal.SenderPhone = "+15550100"
+	withdrawal.Crypto = asset.Symbol
+	withdrawal.FeeUSD = feeUSD
+	return withdrawal
+}
+
+// queueTestWithdrawal moves the withdrawal's amount into the pending account and its fee into
+// fee revenue, as a send to an external address does, and queues it
+func queueTestWithdrawal(t *testing.T, app *App, withdrawal *storage.Withdrawal) {
+	t.Helper()
+	ctx := context.Background()
+	err := app.Custody().Transfer(ctx, custody.Transfer{To: WithdrawalPendingAddress(), Asset: withdrawal.Crypto, AmountUSD: withdrawal.AmountUSD})
+	if err != nil {
+		t.Fatalf("funding pending account: %v", err)
+	}
+	err = app.Custody().Transfer(ctx, custody.Transfer{To: FeeRevenueAddress(), Asset: withdrawal.Crypto, AmountUSD: withdrawal.FeeUSD})
+	if err != nil {
+		t.Fatalf("funding fee account: %v", err)
+	}
+	if err := app.QueueWithdrawal(ctx, withdrawal); err != nil {
+		t.Fatalf("queueing withdrawal: %v", err)
+	}
+}
+
+// processWithdrawal runs the settlement job once and returns the withdrawal as stored
+func processWithdrawal(t *testing.T, app *App, withdrawal *storage.Withdrawal) *storage.Withdrawal {
+	t.Helper()
+	ctx := context.Background()
+	if err := app.ProcessWithdrawals(ctx); err != nil {
+		t.Fatalf("processing withdrawals: %v", err)
+	}
+	stored, exists, err := app.Store.GetWithdrawalByID(ctx, withdrawal.ID.Hex())
+	if err != nil || !exists {
+		t.Fatalf("reading withdrawal: %v", err)
+	}
+	return stored
+}
+
+// balance returns what account holds of asset with the custody provider
+func balance(t *testing.T, app *App, account string, asset string) float64 {
+	t.Helper()
+	balances, err := app.Custody().Balances(context.Background(), account)
+	if err != nil {
+		t.Fatalf("reading %s balances: %v", account, err)
+	}
+	return balances[asset]
+}
+
+func TestWithdrawalConfirms(t *testing.T) {
+	t.Setenv("WITHDRAWAL_CONFIRMATIONS", "3")
+	app, simulated := newSimulatedApp(t)
+	withdrawal := newTestWithdrawal(t, app, 25, 1)
+	queueTestWithdrawal(t, app, withdrawal)
+
+	stored := processWithdrawal(t, app, withdrawal)
+	if stored.Status != storage.WithdrawalBroadcast || stored.TxHash == "" {
+		t.Fatalf("after the first run the withdrawal is %s with hash %q, want broadcast with a hash", stored.Status, stored.TxHash)
+	}
+
+	simulated.Mine(2)
+	stored = processWithdrawal(t, app, withdrawal)
+	if stored.Status != storage.WithdrawalBroadcast || stored.Confirmations != 2 {
+		t.Fatalf("with 2 blocks the withdrawal is %s with %d confirmations, want broadcast with 2", stored.Status, stored.Confirmations)
+	}
+
+	simulated.Mine(1)
+	stored = processWithdrawal(t, app, withdrawal)
+	if stored.Status != storage.WithdrawalConfirmed || stored.Confirmations != 3 {
+		t.Fatalf("with 3 blocks the withdrawal is %s with %d confirmations, want confirmed with 3", stored.Status, stored.Confirmations)
+	}
+	if got := balance(t, app, WithdrawalPendingAddress(), "USDC"); got != 0 {
+		t.Errorf("pending account holds %v after confirmation, want 0", got)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 0 {
+		t.Errorf("sender holds %v after confirmation, want 0", got)
+	}
+}
+
+func TestWithdrawalRevertedOnChainIsRefunded(t *testing.T) {
+	app, simulated := newSimulatedApp(t)
+	withdrawal := newTestWithdrawal(t, app, 25, 1)
+	queueTestWithdrawal(t, app, withdrawal)
+
+	simulated.FailNext()
+	processWithdrawal(t, app, withdrawal)
+	simulated.Mine(1)
+	stored := processWithdrawal(t, app, withdrawal)
+	if stored.Status != storage.WithdrawalRefunded {
+		t.Fatalf("reverted withdrawal is %s, want refunded", stored.Status)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 26 {
+		t.Errorf("sender holds %v after the refund, want the amount and fee, 26", got)
+	}
+	if got := balance(t, app, WithdrawalPendingAddress(), "USDC"); got != 0 {
+		t.Errorf("pending account holds %v after the refund, want 0", got)
+	}
+
+	transactions, err := app.Store.ListTransactionsForWallet(context.Background(), testWallet)
+	if err != nil {
+		t.Fatalf("listing transactions: %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].Kind != storage.TransactionReversal || transactions[0].AmountUSD != 26 {
+		t.Errorf("sender has transactions %+v, want one $26 reversal", transactions)
+	}
+
+	// A refunded withdrawal is never picked up again
+	simulated.Mine(20)
+	if stored := processWithdrawal(t, app, withdrawal); stored.Status != storage.WithdrawalRefunded {
+		t.Errorf("refunded withdrawal moved to %s", stored.Status)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 26 {
+		t.Errorf("sender holds %v after another run, want 26", got)
+	}
+}
+
+func TestWithdrawalRefundedAfterFailedAttempts(t *testing.T) {
+	app, _ := newSimulatedApp(t)
+	withdrawal := newTestWithdrawal(t, app, 25, 0)
+	// The chain adapter can't build a transfer of an unreadable amount, so every attempt fails
+	withdrawal.Amount = "not a number"
+	transaction := &storage.Transaction{Kind: storage.TransactionWithdrawal, SenderAddress: testWallet, Crypto: "USDC", AmountUSD: 25, CreatedAt: time.Now()}
+	if err := app.Store.CreateTransaction(context.Background(), transaction); err != nil {
+		t.Fatalf("recording the withdrawal's transaction: %v", err)
+	}
+	withdrawal.TransactionID = transaction.ID
+	queueTestWithdrawal(t, app, withdrawal)
+
+	for attempt := 1; attempt < maxWithdrawalAttempts; attempt++ {
+		stored := processWithdrawal(t, app, withdrawal)
+		if stored.Status != storage.WithdrawalQueued || stored.Attempts != attempt || stored.LastError == "" {
+			t.Fatalf("after %d failed attempts the withdrawal is %s with %d attempts and error %q, want queued with %d attempts and an error",
+				attempt, stored.Status, stored.Attempts, stored.LastError, attempt)
+		}
+	}
+	stored := processWithdrawal(t, app, withdrawal)
+	if stored.Status != storage.WithdrawalRefunded {
+		t.Fatalf("after %d failed attempts the withdrawal is %s, want refunded", maxWithdrawalAttempts, stored.Status)
+	}
+	if got := balance(t, app, testWallet, "USDC"); got != 25 {
+		t.Errorf("sender holds %v after the refund, want 25", got)
+	}
+
+	// The refunded withdrawal no longer counts towards velocity limits
+	spending, err := app.Store.ListTransactionsSince(context.Background(), testWallet, time.Now().Add(-time.Hour))
+	if err != nil || len(spending) != 0 {
+		t.Errorf("sender's spending after the refund is %+v (%v), want none", spending, err)
+	}
+}
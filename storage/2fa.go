@@ -4,43 +4,66 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// TwoFactorCodeTTL is how long a 2FA code can be verified after it is sent. A TTL index
+// on created_at removes expired codes.
+const TwoFactorCodeTTL = 10 * time.Minute
+
 // TwoFactorAuth represents a 2FA document in the database
 type TwoFactorAuth struct {
-	PhoneNumber string `bson:"phone_number"`
-	Code        string `bson:"code"`
+	PhoneNumber string    `bson:"phone_number"`
+	Code        string    `bson:"code"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+// TwoFactorAuthRepository stores the 2FA codes sent to phone numbers
+type TwoFactorAuthRepository interface {
+	Generate2FACodeAndStore(ctx context.Context, phoneNumber string, code string) error
+	Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool, error)
+	Store2FACode(ctx context.Context, phoneNumber, code string) error
 }
 
 // GetTwoFactorAuthCollection returns a reference to the 2fa collection
-func GetTwoFactorAuthCollection() *mongo.Collection {
-	return db.Collection("2fa")
+func (m *Mongo) GetTwoFactorAuthCollection() *mongo.Collection {
+	return m.db.Collection("2fa")
 }
 
 // Generate2FACodeAndStore generates a 2FA code and stores it in the 2fa collection// Generate2FACodeAndStore generates a 2FA code and stores it in the 2fa collection
-func Generate2FACodeAndStore(ctx context.Context, phoneNumber string, code string) error {
-	collection := GetTwoFactorAuthCollection()
-	filter := bson.M{"phone_number": phoneNumber}
-	update := bson.M{"$set": bson.M{"code": code}}
+func (m *Mongo) Generate2FACodeAndStore(ctx context.Context, phoneNumber string, code string) error {
+	collection := m.GetTwoFactorAuthCollection()
+	filter := bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber)}
+	fields, err := m.sealTwoFactorFields(phoneNumber, FieldTwoFactorCode, "code", code)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": fields}
 
 	options := options.Update().SetUpsert(true) // Directly pass the boolean value
 
-	_, err := collection.UpdateOne(ctx, filter, update, options)
+	_, err = collection.UpdateOne(ctx, filter, update, options)
 	if err != nil {
 		log.Printf("Error updating 2FA code: %v", err)
 		return errors.New("failed to update 2FA code")
 	}
 	return nil
 }
-// Verify2FACode verifies the 2FA code for a given phone number
-func Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool, error) {
-	collection := GetTwoFactorAuthCollection()
+// Verify2FACode verifies the 2FA code for a given phone number. A code that matches is
+// used up, so it can't be verified again; an empty code never matches.
+func (m *Mongo) Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	collection := m.GetTwoFactorAuthCollection()
 	var twoFactorAuth TwoFactorAuth
-	err := collection.FindOne(ctx, bson.M{"phone_number": phoneNumber}).Decode(&twoFactorAuth)
+	// The TTL monitor only runs every minute, so expired codes are also filtered out here
+	filter := bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber), "created_at": bson.M{"$gt": time.Now().Add(-TwoFactorCodeTTL)}}
+	err := collection.FindOne(ctx, filter).Decode(&twoFactorAuth)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return false, nil
@@ -48,14 +71,34 @@ func Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool,
 		log.Printf("Error checking 2FA code: %v", err)
 		return false, errors.New("failed to check 2FA code")
 	}
-	return twoFactorAuth.Code == code, nil
+	storedCode, err := m.cipher.Open(ctx, FieldTwoFactorCode, twoFactorAuth.Code)
+	if err != nil {
+		log.Printf("Error opening 2FA code: %v", err)
+		return false, errors.New("failed to check 2FA code")
+	}
+	if storedCode == "" || storedCode != code {
+		return false, nil
+	}
+
+	// Only the request that clears the code verifies it, so a code is never accepted twice
+	filter["code"] = twoFactorAuth.Code
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"code": ""}})
+	if err != nil {
+		log.Printf("Error using 2FA code: %v", err)
+		return false, errors.New("failed to check 2FA code")
+	}
+	return result.ModifiedCount == 1, nil
 }
-func Store2FACode(ctx context.Context, phoneNumber, code string) error {
-	collection := GetTwoFactorAuthCollection()
-	_, err := collection.UpdateOne(
+func (m *Mongo) Store2FACode(ctx context.Context, phoneNumber, code string) error {
+	collection := m.GetTwoFactorAuthCollection()
+	fields, err := m.sealTwoFactorFields(phoneNumber, FieldTwoFactorStored, "2fa_code", code)
+	if err != nil {
+		return err
+	}
+	_, err = collection.UpdateOne(
 		ctx,
-		bson.M{"phone_number": phoneNumber},
-		bson.M{"$set": bson.M{"2fa_code": code}},
+		bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber)},
+		bson.M{"$set": fields},
 		options.Update().SetUpsert(true), // Pass the boolean directly
 	)
 	if err != nil {
@@ -64,3 +107,19 @@ func Store2FACode(ctx context.Context, phoneNumber, code string) error {
 	}
 	return nil
 }
+
+// sealTwoFactorFields builds the fields that store a code sent to a phone number, with the
+// phone number and code sealed
+func (m *Mongo) sealTwoFactorFields(phoneNumber string, codeField string, codeKey string, code string) (bson.M, error) {
+	sealedPhone, err := m.cipher.Seal(FieldTwoFactorPhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return nil, errors.New("failed to seal phone number")
+	}
+	sealedCode, err := m.cipher.Seal(codeField, code)
+	if err != nil {
+		log.Printf("Error sealing 2FA code: %v", err)
+		return nil, errors.New("failed to seal 2FA code")
+	}
+	return bson.M{"phone_number": sealedPhone, codeKey: sealedCode, "created_at": time.Now()}, nil
+}
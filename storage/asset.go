@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Asset represents a supported asset in the registry. Symbols are upper case and
+// aliases lower case so lookups can match either exactly.
+type Asset struct {
+	Symbol   string   `bson:"symbol" json:"symbol"`
+	Name     string   `bson:"name" json:"name"`
+	Aliases  []string `bson:"aliases" json:"aliases"`
+	Decimals int      `bson:"decimals" json:"decimals"`
+	// Networks lists the chains the asset settles on, in order of preference
+	Networks []string `bson:"networks" json:"networks"`
+	// Contracts maps each network where the asset is a token to its contract address
+	Contracts      map[string]string `bson:"contracts,omitempty" json:"contracts,omitempty"`
+	Enabled        bool              `bson:"enabled" json:"enabled"`
+	MinTransferUSD float64           `bson:"min_transfer_usd" json:"min_transfer_usd"`
+	// MaxTransferUSD of 0 means no cap
+	MaxTransferUSD float64   `bson:"max_transfer_usd" json:"max_transfer_usd"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// AssetRepository stores the asset registry
+type AssetRepository interface {
+	FindAsset(ctx context.Context, name string) (*Asset, bool, error)
+	ListAssets(ctx context.Context) ([]Asset, error)
+	SaveAsset(ctx context.Context, asset *Asset) error
+	InsertAssetIfMissing(ctx context.Context, asset *Asset) error
+	SetAssetEnabled(ctx context.Context, symbol string, enabled bool) (bool, error)
+}
+
+// GetAssetCollection returns a reference to the asset collection
+func (m *Mongo) GetAssetCollection() *mongo.Collection {
+	return m.db.Collection("asset")
+}
+
+// FindAsset looks up an asset by symbol or alias, ignoring case
+func (m *Mongo) FindAsset(ctx context.Context, name string) (*Asset, bool, error) {
+	collection := m.GetAssetCollection()
+	filter := bson.M{"$or": []bson.M{
+		{"symbol": strings.ToUpper(name)},
+		{"aliases": strings.ToLower(name)},
+	}}
+
+	var asset Asset
+	err := collection.FindOne(ctx, filter).Decode(&asset)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching asset: %v", err)
+		return nil, false, errors.New("failed to fetch asset")
+	}
+	return &asset, true, nil
+}
+
+// ListAssets fetches every asset in the registry, ordered by symbol
+func (m *Mongo) ListAssets(ctx context.Context) ([]Asset, error) {
+	collection := m.GetAssetCollection()
+	opts := options.Find().SetSort(bson.M{"symbol": 1})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		log.Printf("Error listing assets: %v", err)
+		return nil, errors.New("failed to list assets")
+	}
+	defer cursor.Close(ctx)
+
+	var assets []Asset
+	if err := cursor.All(ctx, &assets); err != nil {
+		log.Printf("Error decoding assets: %v", err)
+		return nil, errors.New("failed to decode assets")
+	}
+	return assets, nil
+}
+
+// SaveAsset inserts or replaces the asset with the same symbol
+func (m *Mongo) SaveAsset(ctx context.Context, asset *Asset) error {
+	collection := m.GetAssetCollection()
+	asset.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"symbol": asset.Symbol}, asset, opts)
+	if err != nil {
+		log.Printf("Error saving asset: %v", err)
+		return errors.New("failed to save asset")
+	}
+	return nil
+}
+
+// InsertAssetIfMissing adds asset unless one with the same symbol already exists
+func (m *Mongo) InsertAssetIfMissing(ctx context.Context, asset *Asset) error {
+	collection := m.GetAssetCollection()
+	asset.UpdatedAt = time.Now()
+	opts := options.Update().SetUpsert(true)
+	update := bson.M{"$setOnInsert": asset}
+	_, err := collection.UpdateOne(ctx, bson.M{"symbol": asset.Symbol}, update, opts)
+	if err != nil {
+		log.Printf("Error seeding asset: %v", err)
+		return errors.New("failed to seed asset")
+	}
+	return nil
+}
+
+// SetAssetEnabled turns transfers of an asset on or off. It reports false when no asset has the symbol.
+func (m *Mongo) SetAssetEnabled(ctx context.Context, symbol string, enabled bool) (bool, error) {
+	collection := m.GetAssetCollection()
+	filter := bson.M{"symbol": strings.ToUpper(symbol)}
+	update := bson.M{"$set": bson.M{"enabled": enabled, "updated_at": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating asset: %v", err)
+		return false, errors.New("failed to update asset")
+	}
+	return result.MatchedCount == 1, nil
+}
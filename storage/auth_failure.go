@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuthFailure represents a failed passkey attempt in the database. The phone number is
+// stored sealed, and attempts are counted by its blind index.
+type AuthFailure struct {
+	PhoneNumber      string    `bson:"phone_number"`
+	PhoneNumberIndex string    `bson:"phone_number_index"`
+	CreatedAt        time.Time `bson:"created_at"`
+}
+
+// AuthFailureRepository stores failed passkey attempts
+type AuthFailureRepository interface {
+	RecordAuthFailure(ctx context.Context, phoneNumber string) error
+	CountAuthFailuresSince(ctx context.Context, phoneNumber string, since time.Time) (int64, error)
+}
+
+// GetAuthFailureCollection returns a reference to the auth_failure collection
+func (m *Mongo) GetAuthFailureCollection() *mongo.Collection {
+	return m.db.Collection("auth_failure")
+}
+
+// RecordAuthFailure stores a failed passkey attempt for a phone number
+func (m *Mongo) RecordAuthFailure(ctx context.Context, phoneNumber string) error {
+	collection := m.GetAuthFailureCollection()
+	sealedPhone, err := m.cipher.Seal(FieldAuthFailurePhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return errors.New("failed to seal phone number")
+	}
+	failure := AuthFailure{PhoneNumber: sealedPhone, PhoneNumberIndex: m.cipher.BlindIndex(phoneNumber), CreatedAt: time.Now()}
+	_, err = collection.InsertOne(ctx, failure)
+	if err != nil {
+		log.Printf("Error recording auth failure: %v", err)
+		return errors.New("failed to record auth failure")
+	}
+	return nil
+}
+
+// CountAuthFailuresSince counts failed passkey attempts for a phone number since the given time
+func (m *Mongo) CountAuthFailuresSince(ctx context.Context, phoneNumber string, since time.Time) (int64, error) {
+	collection := m.GetAuthFailureCollection()
+	count, err := collection.CountDocuments(ctx, bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber), "created_at": bson.M{"$gte": since}})
+	if err != nil {
+		log.Printf("Error counting auth failures: %v", err)
+		return 0, errors.New("failed to count auth failures")
+	}
+	return count, nil
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"crypto-sms/kms"
+)
+
+// sealedPrefix starts every sealed value, as "enc:<data key ID>:<base64 nonce and ciphertext>"
+const sealedPrefix = "enc:"
+
+// blindIndexKeyID is the ID of the one blind index key. It is never replaced, since phone
+// lookups depend on it; rotating the master key only rewraps it.
+const blindIndexKeyID = "blind-index"
+
+// FieldCipher encrypts sensitive fields with envelope encryption. Fields are sealed with
+// AES-256-GCM under a data key, whose ID is stored with each value, and data keys are stored
+// wrapped under a KMS master key. Each master key has its own field data key, so rotating
+// the master key also rotates the key new values are sealed under.
+type FieldCipher struct {
+	kms  kms.KMS
+	keys DataKeyRepository
+
+	mu       sync.Mutex
+	dataKeys map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// NewFieldCipher unwraps the stored data keys, creating the blind index key and the current
+// master key's field data key when they don't exist yet
+func NewFieldCipher(ctx context.Context, masterKeys kms.KMS, keys DataKeyRepository) (*FieldCipher, error) {
+	c := &FieldCipher{kms: masterKeys, keys: keys, dataKeys: map[string]cipher.AEAD{}}
+	if err := c.reload(ctx); err != nil {
+		return nil, err
+	}
+	for _, key := range []DataKey{
+		{ID: blindIndexKeyID, Purpose: DataKeyBlindIndex},
+		{ID: c.activeKeyID(), Purpose: DataKeyField},
+	} {
+		if err := c.ensureDataKey(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// activeKeyID is the ID of the data key new values are sealed under
+func (c *FieldCipher) activeKeyID() string {
+	return DataKeyField + "-" + c.kms.CurrentKeyID()
+}
+
+// reload unwraps every stored data key, picking up keys other replicas have created
+func (c *FieldCipher) reload(ctx context.Context) error {
+	keys, err := c.keys.ListDataKeys(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		plaintext, err := c.kms.Decrypt(ctx, key.MasterKeyID, key.WrappedKey)
+		if err != nil {
+			return fmt.Errorf("error unwrapping data key %s under master key %s: %w", key.ID, key.MasterKeyID, err)
+		}
+		if key.Purpose == DataKeyBlindIndex {
+			c.indexKey = plaintext
+			continue
+		}
+		block, err := aes.NewCipher(plaintext)
+		if err != nil {
+			return err
+		}
+		if c.dataKeys[key.ID], err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureDataKey creates a data key unless it is already stored
+func (c *FieldCipher) ensureDataKey(ctx context.Context, key DataKey) error {
+	c.mu.Lock()
+	_, exists := c.dataKeys[key.ID]
+	if key.Purpose == DataKeyBlindIndex {
+		exists = c.indexKey != nil
+	}
+	c.mu.Unlock()
+	if exists {
+		return nil
+	}
+
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return err
+	}
+	wrapped, err := c.kms.Encrypt(ctx, c.kms.CurrentKeyID(), plaintext)
+	if err != nil {
+		return err
+	}
+	key.MasterKeyID = c.kms.CurrentKeyID()
+	key.WrappedKey = wrapped
+	key.CreatedAt = time.Now()
+	if err := c.keys.CreateDataKey(ctx, &key); err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	// Another replica may have won the race to create it, so the stored key is the one used
+	return c.reload(ctx)
+}
+
+// Seal encrypts a field's value under the active data key. The field name is authenticated
+// with it, so a sealed value can't be moved to another field. Empty values stay empty.
+func (c *FieldCipher) Seal(field string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	keyID := c.activeKeyID()
+	c.mu.Lock()
+	aead := c.dataKeys[keyID]
+	c.mu.Unlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field))
+	return sealedPrefix + keyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed by Seal for the same field
+func (c *FieldCipher) Open(ctx context.Context, field string, sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if !IsSealed(sealed) || !ok {
+		return "", fmt.Errorf("%s is not sealed", field)
+	}
+	c.mu.Lock()
+	aead, exists := c.dataKeys[keyID]
+	c.mu.Unlock()
+	if !exists {
+		// Sealed by a replica that has since created a new data key
+		if err := c.reload(ctx); err != nil {
+			return "", err
+		}
+		c.mu.Lock()
+		aead, exists = c.dataKeys[keyID]
+		c.mu.Unlock()
+		if !exists {
+			return "", fmt.Errorf("%s is sealed under unknown data key %s", field, keyID)
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("%s is not sealed", field)
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+// SealedField is a value of a record that is stored sealed, with the field it is sealed for
+type SealedField struct {
+	Field string
+	Value *string
+}
+
+// Sealable is a record with values that are stored sealed
+type Sealable interface {
+	SealedFields() []SealedField
+}
+
+// SealFields seals each value in place
+func (c *FieldCipher) SealFields(fields ...SealedField) error {
+	for _, field := range fields {
+		sealed, err := c.Seal(field.Field, *field.Value)
+		if err != nil {
+			return fmt.Errorf("error sealing %s: %w", field.Field, err)
+		}
+		*field.Value = sealed
+	}
+	return nil
+}
+
+// OpenFields opens each value sealed by SealFields in place
+func (c *FieldCipher) OpenFields(ctx context.Context, fields ...SealedField) error {
+	for _, field := range fields {
+		plaintext, err := c.Open(ctx, field.Field, *field.Value)
+		if err != nil {
+			return err
+		}
+		*field.Value = plaintext
+	}
+	return nil
+}
+
+// IsSealed reports whether a stored value was sealed, rather than written before fields
+// were encrypted
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// IsCurrent reports whether a stored value is empty or sealed under the active data key, so
+// re-encryption can skip it
+func (c *FieldCipher) IsCurrent(value string) bool {
+	return value == "" || strings.HasPrefix(value, sealedPrefix+c.activeKeyID()+":")
+}
+
+// BlindIndex returns the deterministic HMAC-SHA256 of a value, which stands in for the value
+// in lookups without revealing it. Empty values index as empty.
+func (c *FieldCipher) BlindIndex(value string) string {
+	if value == "" {
+		return ""
+	}
+	c.mu.Lock()
+	mac := hmac.New(sha256.New, c.indexKey)
+	c.mu.Unlock()
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RewrapDataKeys rewraps every data key under the current master key, so retired master
+// keys can be removed from the KMS. It returns how many keys it rewrapped.
+func (c *FieldCipher) RewrapDataKeys(ctx context.Context) (int, error) {
+	keys, err := c.keys.ListDataKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	current := c.kms.CurrentKeyID()
+	rewrapped := 0
+	for _, key := range keys {
+		if key.MasterKeyID == current {
+			continue
+		}
+		plaintext, err := c.kms.Decrypt(ctx, key.MasterKeyID, key.WrappedKey)
+		if err != nil {
+			return rewrapped, fmt.Errorf("error unwrapping data key %s under master key %s: %w", key.ID, key.MasterKeyID, err)
+		}
+		wrapped, err := c.kms.Encrypt(ctx, current, plaintext)
+		if err != nil {
+			return rewrapped, err
+		}
+		updated, err := c.keys.RewrapDataKey(ctx, key.ID, key.MasterKeyID, current, wrapped)
+		if err != nil {
+			return rewrapped, err
+		}
+		if updated {
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
@@ -0,0 +1,404 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+func TestSmsServiceFieldsRoundTrip(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		index := uint32(7)
+		alice := storage.SmsService{
+			WalletAddress: "alice",
+			PhoneNumber:   "+15550100",
+			Alias:         "alice",
+			Language:      "sw",
+			Passkey:       "correct horse",
+			Limit:         1000,
+			PublicKey:     "public",
+		}
+		if err := store.CreateSmsService(ctx, alice); err != nil {
+			t.Fatalf("registering alice: %v", err)
+		}
+
+		// Sealed fields come back opened, and the phone number is found through its blind index
+		for name, lookup := range map[string]func() (*storage.SmsService, bool, error){
+			"wallet": func() (*storage.SmsService, bool, error) { return store.CheckWalletExistsInSmsService(ctx, "alice") },
+			"phone number": func() (*storage.SmsService, bool, error) {
+				return store.CheckPhoneNumberExistsInSmsService(ctx, "+15550100")
+			},
+			"alias": func() (*storage.SmsService, bool, error) { return store.CheckAliasExistsInSmsService(ctx, "alice") },
+		} {
+			service, exists, err := lookup()
+			if err != nil || !exists {
+				t.Fatalf("looking alice up by %s: %v, exists %v", name, err, exists)
+			}
+			if service.WalletAddress != "alice" || service.PhoneNumber != "+15550100" || service.Passkey != "correct horse" ||
+				service.Alias != "alice" || service.Language != "sw" || service.Limit != 1000 || service.PublicKey != "public" {
+				t.Errorf("alice looked up by %s is %+v, want the registered fields", name, service)
+			}
+		}
+		for name, lookup := range map[string]func() (*storage.SmsService, bool, error){
+			"wallet": func() (*storage.SmsService, bool, error) { return store.CheckWalletExistsInSmsService(ctx, "bob") },
+			"phone number": func() (*storage.SmsService, bool, error) {
+				return store.CheckPhoneNumberExistsInSmsService(ctx, "+15550101")
+			},
+			"alias": func() (*storage.SmsService, bool, error) { return store.CheckAliasExistsInSmsService(ctx, "bob") },
+		} {
+			if _, exists, err := lookup(); err != nil || exists {
+				t.Errorf("looking up an unknown %s: %v, exists %v, want not found", name, err, exists)
+			}
+		}
+
+		if err := store.UpdateSmsService(ctx, "alice", "battery staple", 500); err != nil {
+			t.Fatalf("updating passkey and limit: %v", err)
+		}
+		if err := store.UpdateLimit(ctx, "alice", 250); err != nil {
+			t.Fatalf("updating limit: %v", err)
+		}
+		limits := []storage.VelocityLimit{{Window: "24h", AmountUSD: 300}, {Window: "168h", Asset: "USDC", AmountUSD: 900}}
+		if err := store.UpdateVelocityLimits(ctx, "alice", limits); err != nil {
+			t.Fatalf("updating velocity limits: %v", err)
+		}
+		if err := store.UpdateLanguage(ctx, "alice", "en"); err != nil {
+			t.Fatalf("updating language: %v", err)
+		}
+		if err := store.UpdatePreferredNetworks(ctx, "alice", map[string]string{"USDT": "tron"}); err != nil {
+			t.Fatalf("updating preferred networks: %v", err)
+		}
+		if err := store.UpdateAlias(ctx, "alice", "mama_mboga"); err != nil {
+			t.Fatalf("updating alias: %v", err)
+		}
+		policy := &storage.GuardianPolicy{Phones: []string{"+15550102", "+15550103"}, ThresholdUSD: 100, RequiredApprovals: 1}
+		if err := store.UpdateGuardians(ctx, "alice", policy); err != nil {
+			t.Fatalf("updating guardians: %v", err)
+		}
+		if set, err := store.SetDepositIndex(ctx, "alice", index); err != nil || !set {
+			t.Fatalf("setting deposit index: %v, set %v", err, set)
+		}
+		if set, err := store.SetDepositIndex(ctx, "alice", index+1); err != nil || set {
+			t.Fatalf("setting a second deposit index: %v, set %v, want refused", err, set)
+		}
+
+		service, exists, err := store.CheckWalletExistsInSmsService(ctx, "alice")
+		if err != nil || !exists {
+			t.Fatalf("reading alice: %v, exists %v", err, exists)
+		}
+		if service.Passkey != "battery staple" || service.Limit != 250 || service.Language != "en" || service.Alias != "mama_mboga" {
+			t.Errorf("updated alice has passkey %q, limit %v, language %q and alias %q, want battery staple, 250, en and mama_mboga",
+				service.Passkey, service.Limit, service.Language, service.Alias)
+		}
+		if len(service.VelocityLimits) != 2 || service.VelocityLimits[0] != limits[0] || service.VelocityLimits[1] != limits[1] {
+			t.Errorf("updated alice has velocity limits %+v, want %+v", service.VelocityLimits, limits)
+		}
+		if len(service.Networks) != 1 || service.Networks["USDT"] != "tron" {
+			t.Errorf("updated alice prefers networks %v, want USDT on tron", service.Networks)
+		}
+		if service.DepositIndex == nil || *service.DepositIndex != index {
+			t.Errorf("updated alice has deposit index %v, want %d", service.DepositIndex, index)
+		}
+		if g := service.Guardians; g == nil || len(g.Phones) != 2 || g.Phones[0] != "+15550102" || g.Phones[1] != "+15550103" ||
+			g.ThresholdUSD != 100 || g.RequiredApprovals != 1 {
+			t.Errorf("updated alice has guardians %+v, want %+v", g, policy)
+		}
+		if _, exists, err := store.CheckAliasExistsInSmsService(ctx, "alice"); err != nil || exists {
+			t.Errorf("the replaced alias is still found: %v, exists %v", err, exists)
+		}
+
+		if err := store.UpdateGuardians(ctx, "alice", nil); err != nil {
+			t.Fatalf("removing guardians: %v", err)
+		}
+		service, _, err = store.CheckWalletExistsInSmsService(ctx, "alice")
+		if err != nil || service.Guardians != nil {
+			t.Errorf("alice has guardians %+v after removing them: %v", service.Guardians, err)
+		}
+
+		// A new phone number replaces the old one in lookups and records when it changed
+		before := time.Now().Add(-time.Second)
+		if err := store.UpdatePhoneNumber(ctx, "alice", "+15550104"); err != nil {
+			t.Fatalf("changing phone number: %v", err)
+		}
+		if _, exists, err := store.CheckPhoneNumberExistsInSmsService(ctx, "+15550100"); err != nil || exists {
+			t.Errorf("the old phone number is still found: %v, exists %v", err, exists)
+		}
+		service, exists, err = store.CheckPhoneNumberExistsInSmsService(ctx, "+15550104")
+		if err != nil || !exists || service.WalletAddress != "alice" {
+			t.Fatalf("the new phone number belongs to %+v: %v", service, err)
+		}
+		if service.PhoneUpdatedAt.Before(before) {
+			t.Errorf("phone number changed at %v, want after %v", service.PhoneUpdatedAt, before)
+		}
+	})
+}
+
+func TestUpdateSmsServiceRegistersUnknownWallets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		if err := store.UpdateSmsService(ctx, "bob", "passkey", 100); err != nil {
+			t.Fatalf("updating an unregistered wallet: %v", err)
+		}
+		service, exists, err := store.CheckWalletExistsInSmsService(ctx, "bob")
+		if err != nil || !exists || service.Passkey != "passkey" || service.Limit != 100 {
+			t.Fatalf("bob is %+v after the update: %v, exists %v", service, err, exists)
+		}
+
+		// Other updates leave unregistered wallets alone
+		if err := store.UpdateLimit(ctx, "carol", 100); err != nil {
+			t.Fatalf("updating an unregistered wallet's limit: %v", err)
+		}
+		if _, exists, err := store.CheckWalletExistsInSmsService(ctx, "carol"); err != nil || exists {
+			t.Errorf("updating carol's limit registered carol: %v, exists %v", err, exists)
+		}
+
+		services, err := store.ListSmsServices(ctx)
+		if err != nil || len(services) != 1 || services[0].WalletAddress != "bob" || services[0].Passkey != "passkey" {
+			t.Errorf("listing wallets returned %+v: %v, want bob alone", services, err)
+		}
+	})
+}
+
+func TestSmsSessionsExpire(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		active := func(phoneNumber string) bool {
+			t.Helper()
+			active, err := store.HasActiveSmsSession(ctx, phoneNumber)
+			if err != nil {
+				t.Fatalf("checking %s's session: %v", phoneNumber, err)
+			}
+			return active
+		}
+
+		if active("+15550100") {
+			t.Fatal("a number that never started a session has one")
+		}
+		if err := store.StartSmsSession(ctx, "+15550100", time.Minute); err != nil {
+			t.Fatalf("starting session: %v", err)
+		}
+		if !active("+15550100") {
+			t.Fatal("a started session is not active")
+		}
+		if active("+15550101") {
+			t.Fatal("another number shares the session")
+		}
+
+		// Starting again replaces the expiry, here with one already past
+		if err := store.StartSmsSession(ctx, "+15550100", -time.Second); err != nil {
+			t.Fatalf("restarting session: %v", err)
+		}
+		if active("+15550100") {
+			t.Fatal("an expired session is active")
+		}
+	})
+}
+
+func TestCountAuthFailuresSince(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		before := time.Now().Add(-time.Second)
+		for i := 0; i < 3; i++ {
+			if err := store.RecordAuthFailure(ctx, "+15550100"); err != nil {
+				t.Fatalf("recording failure: %v", err)
+			}
+		}
+		if err := store.RecordAuthFailure(ctx, "+15550101"); err != nil {
+			t.Fatalf("recording failure: %v", err)
+		}
+
+		for _, test := range []struct {
+			phoneNumber string
+			since       time.Time
+			want        int64
+		}{
+			{"+15550100", before, 3},
+			{"+15550101", before, 1},
+			{"+15550102", before, 0},
+			{"+15550100", time.Now().Add(time.Minute), 0},
+		} {
+			count, err := store.CountAuthFailuresSince(ctx, test.phoneNumber, test.since)
+			if err != nil || count != test.want {
+				t.Errorf("%s has %d failures since %v: %v, want %d", test.phoneNumber, count, test.since, err, test.want)
+			}
+		}
+	})
+}
+
+func TestNextSequenceCountsFromZero(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		for _, test := range []struct {
+			name string
+			want int64
+		}{
+			{"deposit_index", 0},
+			{"deposit_index", 1},
+			{"other", 0},
+			{"deposit_index", 2},
+		} {
+			got, err := store.NextSequence(ctx, test.name)
+			if err != nil || got != test.want {
+				t.Errorf("next %s is %d: %v, want %d", test.name, got, err, test.want)
+			}
+		}
+	})
+}
+
+func TestAcquireLeaseExcludesOtherHolders(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		acquire := func(name string, holder string, ttl time.Duration) bool {
+			t.Helper()
+			acquired, err := store.AcquireLease(ctx, name, holder, ttl)
+			if err != nil {
+				t.Fatalf("%s acquiring %s: %v", holder, name, err)
+			}
+			return acquired
+		}
+
+		if !acquire("withdrawals", "a", time.Minute) {
+			t.Fatal("a free lease was refused")
+		}
+		if acquire("withdrawals", "b", time.Minute) {
+			t.Fatal("another holder took a live lease")
+		}
+		if !acquire("deposits", "b", time.Minute) {
+			t.Fatal("a different lease was refused")
+		}
+
+		// The holder renews its lease, here letting it lapse, after which anyone takes it
+		if !acquire("withdrawals", "a", -time.Second) {
+			t.Fatal("the holder could not renew its lease")
+		}
+		if !acquire("withdrawals", "b", time.Minute) {
+			t.Fatal("an expired lease was refused")
+		}
+		if acquire("withdrawals", "a", time.Minute) {
+			t.Fatal("the previous holder took the lease back while it was live")
+		}
+
+		// Only the holder can release a lease, after which anyone takes it
+		release := func(name string, holder string) {
+			t.Helper()
+			if err := store.ReleaseLease(ctx, name, holder); err != nil {
+				t.Fatalf("%s releasing %s: %v", holder, name, err)
+			}
+		}
+		release("withdrawals", "a")
+		if acquire("withdrawals", "a", time.Minute) {
+			t.Fatal("a lease was taken after someone other than its holder released it")
+		}
+		release("withdrawals", "b")
+		if !acquire("withdrawals", "a", time.Minute) {
+			t.Fatal("a released lease was refused")
+		}
+		release("payments", "a")
+	})
+}
+
+func TestAssetRegistry(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		usdt := &storage.Asset{
+			Symbol:         "USDT",
+			Name:           "Tether",
+			Aliases:        []string{"tether"},
+			Decimals:       6,
+			Networks:       []string{"ethereum", "tron"},
+			Contracts:      map[string]string{"ethereum": "0xdac17f958d2ee523a2206206994597c13d831ec7"},
+			Enabled:        true,
+			MinTransferUSD: 1,
+		}
+		if err := store.InsertAssetIfMissing(ctx, usdt); err != nil {
+			t.Fatalf("seeding USDT: %v", err)
+		}
+		if err := store.InsertAssetIfMissing(ctx, &storage.Asset{Symbol: "USDT", Name: "Replaced"}); err != nil {
+			t.Fatalf("seeding USDT again: %v", err)
+		}
+		if err := store.SaveAsset(ctx, &storage.Asset{Symbol: "BTC", Name: "Bitcoin", Decimals: 8, Networks: []string{"bitcoin"}}); err != nil {
+			t.Fatalf("saving BTC: %v", err)
+		}
+
+		for _, name := range []string{"USDT", "usdt", "Tether"} {
+			asset, exists, err := store.FindAsset(ctx, name)
+			if err != nil || !exists {
+				t.Fatalf("finding %q: %v, exists %v", name, err, exists)
+			}
+			if asset.Name != "Tether" || asset.Decimals != 6 || len(asset.Networks) != 2 || asset.Networks[1] != "tron" ||
+				asset.Contracts["ethereum"] != usdt.Contracts["ethereum"] || !asset.Enabled || asset.MinTransferUSD != 1 {
+				t.Errorf("%q found %+v, want the seeded USDT", name, asset)
+			}
+		}
+		if _, exists, err := store.FindAsset(ctx, "DOGE"); err != nil || exists {
+			t.Errorf("finding an unknown asset: %v, exists %v", err, exists)
+		}
+
+		usdt.Name = "Tether USD"
+		usdt.MaxTransferUSD = 5000
+		if err := store.SaveAsset(ctx, usdt); err != nil {
+			t.Fatalf("saving USDT: %v", err)
+		}
+		if updated, err := store.SetAssetEnabled(ctx, "usdt", false); err != nil || !updated {
+			t.Fatalf("disabling USDT: %v, updated %v", err, updated)
+		}
+		if updated, err := store.SetAssetEnabled(ctx, "DOGE", false); err != nil || updated {
+			t.Errorf("disabling an unknown asset: %v, updated %v", err, updated)
+		}
+
+		assets, err := store.ListAssets(ctx)
+		if err != nil || len(assets) != 2 {
+			t.Fatalf("listing assets: %v, %d assets, want 2", err, len(assets))
+		}
+		if assets[0].Symbol != "BTC" || assets[1].Symbol != "USDT" {
+			t.Errorf("assets are listed as %s, %s, want BTC, USDT", assets[0].Symbol, assets[1].Symbol)
+		}
+		if got := assets[1]; got.Name != "Tether USD" || got.MaxTransferUSD != 5000 || got.Enabled {
+			t.Errorf("saved USDT is %+v, want Tether USD capped at 5000 and disabled", got)
+		}
+	})
+}
+
+func TestFeeScheduleVersions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		if _, exists, err := store.GetActiveFeeSchedule(ctx); err != nil || exists {
+			t.Fatalf("an empty store has an active fee schedule: %v, exists %v", err, exists)
+		}
+		if schedules, err := store.ListFeeSchedules(ctx); err != nil || len(schedules) != 0 {
+			t.Fatalf("an empty store lists %d fee schedules: %v", len(schedules), err)
+		}
+
+		first, err := store.CreateFeeSchedule(ctx, []storage.FeeRule{{FlatUSD: 0.5}})
+		if err != nil || first.Version != 1 {
+			t.Fatalf("creating the first schedule: %v, version %v", err, first)
+		}
+		rules := []storage.FeeRule{
+			{Asset: "USDT", Percent: 1, MinUSD: 0.1, MaxUSD: 5, Tiers: []storage.FeeTier{{UpToUSD: 10, FlatUSD: 0.05}}},
+			{Asset: "USDC", Network: "ethereum", FlatUSD: 2},
+		}
+		second, err := store.CreateFeeSchedule(ctx, rules)
+		if err != nil || second.Version != 2 {
+			t.Fatalf("creating the second schedule: %v, version %v", err, second)
+		}
+
+		active, exists, err := store.GetActiveFeeSchedule(ctx)
+		if err != nil || !exists || active.Version != 2 || len(active.Rules) != 2 {
+			t.Fatalf("active schedule is %+v: %v, want version 2", active, err)
+		}
+		if rule := active.Rules[0]; rule.Asset != "USDT" || rule.Percent != 1 || rule.MinUSD != 0.1 || rule.MaxUSD != 5 ||
+			len(rule.Tiers) != 1 || rule.Tiers[0].UpToUSD != 10 || rule.Tiers[0].FlatUSD != 0.05 {
+			t.Errorf("active schedule's first rule is %+v, want %+v", rule, rules[0])
+		}
+		if rule := active.Rules[1]; rule.Network != "ethereum" || rule.FlatUSD != 2 {
+			t.Errorf("active schedule's second rule is %+v, want %+v", rule, rules[1])
+		}
+
+		schedules, err := store.ListFeeSchedules(ctx)
+		if err != nil || len(schedules) != 2 || schedules[0].Version != 2 || schedules[1].Version != 1 {
+			t.Errorf("listing schedules returned %+v: %v, want versions 2 and 1", schedules, err)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+	"crypto-sms/utils"
+)
+
+func TestRiskDecisionsAndChallenges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		decisions := []*storage.RiskDecision{
+			{SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "bob", Crypto: "USDC", AmountUSD: 10, Outcome: storage.RiskOutcomeAllow, CreatedAt: now.Add(-3 * time.Minute)},
+			{SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "carol", Crypto: "USDC", AmountUSD: 900, Outcome: storage.RiskOutcomeChallenge,
+				Signals: map[string]float64{"amount_ratio": 9}, FiredRules: []storage.FiredRiskRule{{Name: "large", Signal: "amount_ratio", Value: 9, Score: 60}},
+				Score: 60, CreatedAt: now.Add(-2 * time.Minute)},
+			{SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "dave", Crypto: "USDC", AmountUSD: 5000, Outcome: storage.RiskOutcomeBlock, Score: 95, CreatedAt: now.Add(-time.Minute)},
+			{SenderAddress: "bob", SenderPhone: "+15550101", Recipient: "alice", Crypto: "USDC", AmountUSD: 1, Outcome: storage.RiskOutcomeAllow, CreatedAt: now},
+		}
+		for _, decision := range decisions {
+			if err := store.CreateRiskDecision(ctx, decision); err != nil {
+				t.Fatalf("recording risk decision: %v", err)
+			}
+		}
+		allowed, challenged, blocked := decisions[0], decisions[1], decisions[2]
+
+		for _, test := range []struct {
+			name    string
+			outcome string
+			limit   int64
+			want    []*storage.RiskDecision
+		}{
+			{"every decision", "", 0, []*storage.RiskDecision{blocked, challenged, allowed}},
+			{"one outcome", storage.RiskOutcomeAllow, 0, []*storage.RiskDecision{allowed}},
+			{"limited", "", 2, []*storage.RiskDecision{blocked, challenged}},
+		} {
+			listed, err := store.ListRiskDecisions(ctx, "alice", test.outcome, test.limit)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if len(listed) != len(test.want) {
+				t.Errorf("%s: listed %d decisions, want %d", test.name, len(listed), len(test.want))
+				continue
+			}
+			for i := range listed {
+				if listed[i].ID != test.want[i].ID {
+					t.Errorf("%s: decision %d is %+v, want %+v", test.name, i, listed[i], test.want[i])
+				}
+			}
+		}
+		listed, err := store.ListRiskDecisions(ctx, "alice", storage.RiskOutcomeChallenge, 0)
+		if err != nil || len(listed) != 1 {
+			t.Fatalf("listing the challenged decision: %v, %d decisions", err, len(listed))
+		}
+		if got := listed[0]; got.SenderPhone != "+15550100" || got.Signals["amount_ratio"] != 9 || len(got.FiredRules) != 1 || got.FiredRules[0].Name != "large" {
+			t.Errorf("challenged decision is %+v, want its phone number, signals and fired rules kept", got)
+		}
+
+		older := &storage.RiskChallenge{DecisionID: challenged.ID, CodeHash: "older", SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "carol",
+			Crypto: "USDC", AmountUSD: 900, Status: storage.RiskChallengePending, CreatedAt: now.Add(-2 * time.Minute), ExpiresAt: now.Add(5 * time.Minute)}
+		newer := &storage.RiskChallenge{DecisionID: challenged.ID, CodeHash: "newer", SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "carol",
+			Crypto: "USDC", AmountUSD: 900, Status: storage.RiskChallengePending, CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(5 * time.Minute)}
+		lapsed := &storage.RiskChallenge{DecisionID: challenged.ID, CodeHash: "lapsed", SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "carol",
+			Crypto: "USDC", AmountUSD: 900, Status: storage.RiskChallengePending, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}
+		for _, challenge := range []*storage.RiskChallenge{older, newer, lapsed} {
+			if err := store.CreateRiskChallenge(ctx, challenge); err != nil {
+				t.Fatalf("creating risk challenge: %v", err)
+			}
+		}
+
+		challenge, exists, err := store.GetLatestRiskChallenge(ctx, "+15550100")
+		if err != nil || !exists || challenge.ID != newer.ID || challenge.SenderPhone != "+15550100" {
+			t.Fatalf("fetching the latest challenge: %v, exists %v, got %+v", err, exists, challenge)
+		}
+		if moved, err := store.TransitionRiskChallenge(ctx, newer.ID, storage.RiskChallengePending, storage.RiskChallengeVerified); err != nil || !moved {
+			t.Fatalf("verifying: %v, moved %v", err, moved)
+		}
+		if moved, err := store.TransitionRiskChallenge(ctx, newer.ID, storage.RiskChallengePending, storage.RiskChallengeExpired); err != nil || moved {
+			t.Errorf("expiring a verified challenge: %v, moved %v, want refused", err, moved)
+		}
+		if challenge, exists, err := store.GetLatestRiskChallenge(ctx, "+15550100"); err != nil || !exists || challenge.ID != older.ID {
+			t.Errorf("latest challenge after verifying is %+v (%v, exists %v), want the older one", challenge, err, exists)
+		}
+		if _, exists, err := store.GetLatestRiskChallenge(ctx, "+15550101"); err != nil || exists {
+			t.Errorf("fetching a challenge for another phone number: %v, exists %v, want not found", err, exists)
+		}
+		if listed, err := store.ListExpiredRiskChallenges(ctx, now); err != nil || len(listed) != 1 || listed[0].ID != lapsed.ID {
+			t.Errorf("expired challenges are %+v (%v), want the lapsed one", listed, err)
+		}
+	})
+}
+
+func TestDepositsSurviveReorgs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		deposit := func(txHash string, network string, status string, confirmations int64, age time.Duration) *storage.Deposit {
+			return &storage.Deposit{Network: network, TxHash: txHash, FromAddress: "external", ToAddress: "alice", WalletAddress: "alice",
+				Asset: "USDC", Amount: "1000000", AmountUSD: 1, Block: 5, Confirmations: confirmations, Status: status,
+				CreatedAt: now.Add(-age), UpdatedAt: now.Add(-age)}
+		}
+		pending := deposit("0x1", "ethereum", storage.DepositPending, 0, 4*time.Minute)
+		shallow := deposit("0x2", "ethereum", storage.DepositCredited, 3, 3*time.Minute)
+		final := deposit("0x3", "ethereum", storage.DepositCredited, 20, 2*time.Minute)
+		orphaned := deposit("0x4", "ethereum", storage.DepositOrphaned, 0, time.Minute)
+		elsewhere := deposit("0x5", "polygon", storage.DepositPending, 0, 0)
+		for _, deposit := range []*storage.Deposit{pending, shallow, final, orphaned, elsewhere} {
+			if inserted, err := store.InsertDepositIfMissing(ctx, deposit); err != nil || !inserted {
+				t.Fatalf("inserting deposit %s: %v, inserted %v", deposit.TxHash, err, inserted)
+			}
+		}
+
+		unsettled := func() []storage.Deposit {
+			t.Helper()
+			deposits, err := store.ListUnsettledDeposits(ctx, "ethereum", 12)
+			if err != nil {
+				t.Fatalf("listing unsettled deposits: %v", err)
+			}
+			return deposits
+		}
+		if deposits := unsettled(); len(deposits) != 2 || deposits[0].TxHash != "0x2" || deposits[1].TxHash != "0x1" {
+			t.Errorf("unsettled deposits are %+v, want the shallow and pending ones, newest first", deposits)
+		}
+
+		if revived, err := store.ReviveDeposit(ctx, "ethereum", "0x1", 0, 9); err != nil || revived {
+			t.Errorf("reviving a pending deposit: %v, revived %v, want refused", err, revived)
+		}
+		if revived, err := store.ReviveDeposit(ctx, "ethereum", "0x4", 0, 9); err != nil || !revived {
+			t.Fatalf("reviving the orphaned deposit: %v, revived %v", err, revived)
+		}
+		if revived, err := store.ReviveDeposit(ctx, "ethereum", "0x4", 0, 9); err != nil || revived {
+			t.Errorf("reviving twice: %v, revived %v, want refused", err, revived)
+		}
+		deposits := unsettled()
+		if len(deposits) != 3 || deposits[0].TxHash != "0x4" || deposits[0].Status != storage.DepositPending || deposits[0].Block != 9 {
+			t.Errorf("unsettled deposits are %+v, want the revived one pending at block 9 first", deposits)
+		}
+		if listed, err := store.ListDepositsForWallet(ctx, "alice"); err != nil || len(listed) != 5 || listed[0].TxHash != "0x5" {
+			t.Errorf("alice's deposits are %+v (%v), want all five, newest first", listed, err)
+		}
+
+		if _, exists, err := store.GetScanCursor(ctx, "ethereum"); err != nil || exists {
+			t.Errorf("fetching an unset scan cursor: %v, exists %v, want not found", err, exists)
+		}
+		for _, next := range []int64{100, 120} {
+			if err := store.SetScanCursor(ctx, "ethereum", next); err != nil {
+				t.Fatalf("setting the scan cursor to %d: %v", next, err)
+			}
+			if block, exists, err := store.GetScanCursor(ctx, "ethereum"); err != nil || !exists || block != next {
+				t.Errorf("scan cursor is %d (%v, exists %v), want %d", block, err, exists, next)
+			}
+		}
+		if _, exists, err := store.GetScanCursor(ctx, "polygon"); err != nil || exists {
+			t.Errorf("fetching another network's scan cursor: %v, exists %v, want not found", err, exists)
+		}
+	})
+}
+
+func TestWithdrawalQueues(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		withdrawal := func(sender string, status string, age time.Duration) *storage.Withdrawal {
+			return &storage.Withdrawal{SenderAddress: sender, SenderPhone: "+15550100", Crypto: "USDC", Asset: "USDC", Network: "ethereum",
+				ToAddress: "0x2222222222222222222222222222222222222222", AmountUSD: 5, Amount: "5000000", Status: status,
+				CreatedAt: now.Add(-age), UpdatedAt: now.Add(-age)}
+		}
+		queued := withdrawal("alice", storage.WithdrawalQueued, 3*time.Minute)
+		signed := withdrawal("alice", storage.WithdrawalSigned, 2*time.Minute)
+		confirmed := withdrawal("alice", storage.WithdrawalConfirmed, time.Minute)
+		others := withdrawal("bob", storage.WithdrawalQueued, 0)
+		for _, withdrawal := range []*storage.Withdrawal{queued, signed, confirmed, others} {
+			if err := store.CreateWithdrawal(ctx, withdrawal); err != nil {
+				t.Fatalf("creating withdrawal: %v", err)
+			}
+			if withdrawal.ID.IsZero() {
+				t.Fatalf("withdrawal %+v was not given an ID", withdrawal)
+			}
+		}
+
+		listed, err := store.ListWithdrawalsByStatus(ctx, storage.WithdrawalQueued, storage.WithdrawalSigned)
+		if err != nil || len(listed) != 3 || listed[0].ID != queued.ID || listed[1].ID != signed.ID || listed[2].ID != others.ID {
+			t.Errorf("queued and signed withdrawals are %+v (%v), want three, oldest first", listed, err)
+		}
+		listed, err = store.ListWithdrawalsForWallet(ctx, "alice")
+		if err != nil || len(listed) != 3 || listed[0].ID != confirmed.ID || listed[2].ID != queued.ID {
+			t.Errorf("alice's withdrawals are %+v (%v), want three, newest first", listed, err)
+		}
+
+		for _, attemptErr := range []string{"nonce too low", "rpc timeout"} {
+			if err := store.RecordWithdrawalAttempt(ctx, signed.ID, attemptErr); err != nil {
+				t.Fatalf("recording an attempt: %v", err)
+			}
+		}
+		stored, exists, err := store.GetWithdrawalByID(ctx, signed.ID.Hex())
+		if err != nil || !exists || stored.Attempts != 2 || stored.LastError != "rpc timeout" || stored.SenderPhone != "+15550100" {
+			t.Errorf("withdrawal after two attempts is %+v (%v, exists %v), want 2 attempts ending in the timeout", stored, err, exists)
+		}
+		for _, id := range []string{"nonsense", primitive.NewObjectID().Hex()} {
+			if _, exists, err := store.GetWithdrawalByID(ctx, id); err != nil || exists {
+				t.Errorf("fetching withdrawal %q: %v, exists %v, want not found", id, err, exists)
+			}
+		}
+	})
+}
+
+func TestReconciliationFreezes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		if _, exists, err := store.GetReconciliationReport(ctx, ""); err != nil || exists {
+			t.Errorf("fetching the latest report of an empty store: %v, exists %v, want not found", err, exists)
+		}
+		earlier := &storage.ReconciliationReport{Provider: "custodian", Accounts: 2, CreatedAt: now.Add(-time.Hour)}
+		latest := &storage.ReconciliationReport{Provider: "custodian", Accounts: 3, CreatedAt: now, Frozen: []string{"alice"},
+			Discrepancies: []storage.Discrepancy{{Account: "alice", Asset: "USDC", BalanceUSD: 5, LedgerUSD: 7, DifferenceUSD: -2, Persistent: true}},
+			Totals:        []storage.AssetTotal{{Asset: "USDC", BalanceUSD: 5, LedgerUSD: 7, DifferenceUSD: -2}}}
+		for _, report := range []*storage.ReconciliationReport{earlier, latest} {
+			if err := store.CreateReconciliationReport(ctx, report); err != nil {
+				t.Fatalf("creating report: %v", err)
+			}
+		}
+		report, exists, err := store.GetReconciliationReport(ctx, "")
+		if err != nil || !exists || report.ID != latest.ID || len(report.Discrepancies) != 1 || !report.Discrepancies[0].Persistent || len(report.Totals) != 1 {
+			t.Errorf("latest report is %+v (%v, exists %v), want the latest with its discrepancies", report, err, exists)
+		}
+		if report, exists, err := store.GetReconciliationReport(ctx, earlier.ID.Hex()); err != nil || !exists || report.Accounts != 2 {
+			t.Errorf("fetching the earlier report: %+v (%v, exists %v)", report, err, exists)
+		}
+		if _, exists, err := store.GetReconciliationReport(ctx, "nonsense"); err != nil || exists {
+			t.Errorf("fetching a report by a malformed ID: %v, exists %v, want not found", err, exists)
+		}
+
+		freeze := func(wallet string, age time.Duration) bool {
+			t.Helper()
+			created, err := store.FreezeWallet(ctx, &storage.WalletFreeze{WalletAddress: wallet, ReportID: latest.ID, Reason: "ledger mismatch", CreatedAt: now.Add(-age)})
+			if err != nil {
+				t.Fatalf("freezing %s: %v", wallet, err)
+			}
+			return created
+		}
+		if !freeze("alice", time.Minute) || !freeze("bob", 0) {
+			t.Fatal("freezing alice and bob was refused")
+		}
+		if freeze("alice", 0) {
+			t.Error("alice was frozen twice")
+		}
+		if frozen, err := store.IsWalletFrozen(ctx, "alice"); err != nil || !frozen {
+			t.Errorf("alice frozen: %v, %v, want frozen", frozen, err)
+		}
+		if listed, err := store.ListActiveWalletFreezes(ctx); err != nil || len(listed) != 2 || listed[0].WalletAddress != "alice" || listed[0].Status != storage.FreezeActive {
+			t.Errorf("active freezes are %+v (%v), want alice then bob", listed, err)
+		}
+
+		if resolved, err := store.ResolveWalletFreeze(ctx, "alice", "ops", "balance corrected"); err != nil || !resolved {
+			t.Fatalf("resolving alice's freeze: %v, resolved %v", err, resolved)
+		}
+		if resolved, err := store.ResolveWalletFreeze(ctx, "alice", "ops", "again"); err != nil || resolved {
+			t.Errorf("resolving alice's freeze twice: %v, resolved %v, want refused", err, resolved)
+		}
+		if frozen, err := store.IsWalletFrozen(ctx, "alice"); err != nil || frozen {
+			t.Errorf("alice frozen after resolving: %v, %v, want not frozen", frozen, err)
+		}
+		if listed, err := store.ListActiveWalletFreezes(ctx); err != nil || len(listed) != 1 || listed[0].WalletAddress != "bob" {
+			t.Errorf("active freezes are %+v (%v), want only bob", listed, err)
+		}
+		if !freeze("alice", 0) {
+			t.Error("alice could not be frozen again after the freeze was resolved")
+		}
+	})
+}
+
+func TestReserveSnapshotsAndProofs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		earlier := &storage.ReserveSnapshot{Root: "root-1", TotalLiabilitiesCents: 100, AssetTotalsCents: map[string]uint64{"USDC": 100}, Accounts: 1, CreatedAt: now.Add(-time.Hour)}
+		if err := store.CreateReserveSnapshot(ctx, earlier, []storage.ReserveProof{{WalletAddress: "alice", Code: "EARLY", Leaf: "leaf-0"}}); err != nil {
+			t.Fatalf("creating the earlier snapshot: %v", err)
+		}
+		latest := &storage.ReserveSnapshot{Root: "root-2", TotalLiabilitiesCents: 350, AssetTotalsCents: map[string]uint64{"USDC": 300, "ETH": 50}, Accounts: 2, CreatedAt: now}
+		proofs := []storage.ReserveProof{
+			{WalletAddress: "alice", Code: "ALICE", Salt: "salt-a", AccountHash: "hash-a", BalancesCents: map[string]uint64{"USDC": 300}, SumCents: 300,
+				Leaf: "leaf-a", Proof: []utils.MerkleProofStep{{Hash: "leaf-b", Sum: 50, Left: false}}},
+			{WalletAddress: "bob", Code: "BOB", Salt: "salt-b", AccountHash: "hash-b", BalancesCents: map[string]uint64{"ETH": 50}, SumCents: 50,
+				Leaf: "leaf-b", Proof: []utils.MerkleProofStep{{Hash: "leaf-a", Sum: 300, Left: true}}},
+		}
+		if err := store.CreateReserveSnapshot(ctx, latest, proofs); err != nil {
+			t.Fatalf("creating the latest snapshot: %v", err)
+		}
+		if latest.ID.IsZero() || proofs[0].SnapshotID != latest.ID {
+			t.Fatalf("snapshot %s and proof snapshot %s, want the snapshot ID assigned to both", latest.ID.Hex(), proofs[0].SnapshotID.Hex())
+		}
+
+		snapshot, exists, err := store.GetReserveSnapshot(ctx, "")
+		if err != nil || !exists || snapshot.ID != latest.ID || snapshot.AssetTotalsCents["ETH"] != 50 || snapshot.TotalLiabilitiesCents != 350 {
+			t.Errorf("latest snapshot is %+v (%v, exists %v), want root-2", snapshot, err, exists)
+		}
+		if snapshot, exists, err := store.GetReserveSnapshot(ctx, earlier.ID.Hex()); err != nil || !exists || snapshot.Root != "root-1" {
+			t.Errorf("fetching the earlier snapshot: %+v (%v, exists %v)", snapshot, err, exists)
+		}
+		if listed, err := store.ListReserveSnapshots(ctx, 1); err != nil || len(listed) != 1 || listed[0].ID != latest.ID {
+			t.Errorf("one snapshot listed as %+v (%v), want the latest", listed, err)
+		}
+		if listed, err := store.ListReserveSnapshots(ctx, 0); err != nil || len(listed) != 2 || listed[1].ID != earlier.ID {
+			t.Errorf("every snapshot listed as %+v (%v), want both, newest first", listed, err)
+		}
+
+		proof, exists, err := store.GetReserveProofByCode(ctx, "ALICE")
+		if err != nil || !exists || proof.SnapshotID != latest.ID || proof.WalletAddress != "alice" || proof.SumCents != 300 ||
+			len(proof.Proof) != 1 || proof.Proof[0].Hash != "leaf-b" || proof.Proof[0].Sum != 50 {
+			t.Errorf("proof for ALICE is %+v (%v, exists %v), want alice's proof in the latest snapshot", proof, err, exists)
+		}
+		if proof, exists, err := store.GetReserveProofForWallet(ctx, latest.ID, "bob"); err != nil || !exists || proof.Code != "BOB" || !proof.Proof[0].Left {
+			t.Errorf("bob's proof is %+v (%v, exists %v), want BOB", proof, err, exists)
+		}
+		if _, exists, err := store.GetReserveProofForWallet(ctx, earlier.ID, "bob"); err != nil || exists {
+			t.Errorf("fetching bob's proof in a snapshot without bob: %v, exists %v, want not found", err, exists)
+		}
+		if _, exists, err := store.GetReserveProofByCode(ctx, "NOBODY"); err != nil || exists {
+			t.Errorf("fetching an unknown code: %v, exists %v, want not found", err, exists)
+		}
+	})
+}
+
+func TestMigrationsApplyOnce(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		if applied, err := store.Migrate(ctx); err != nil || len(applied) != 0 {
+			t.Errorf("migrating a migrated store applied %+v (%v), want nothing", applied, err)
+		}
+		statuses, err := store.ListMigrations(ctx)
+		if err != nil {
+			t.Fatalf("listing migrations: %v", err)
+		}
+		for _, status := range statuses {
+			if status.AppliedAt.IsZero() {
+				t.Errorf("migration %d (%s) is not applied", status.Version, status.Description)
+			}
+		}
+	})
+}
+
+func TestReencryptSecretsLeavesFreshRecordsAlone(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		if err := store.CreateSmsService(ctx, storage.SmsService{WalletAddress: "alice", PhoneNumber: "+15550100", Passkey: "correct horse"}); err != nil {
+			t.Fatalf("registering alice: %v", err)
+		}
+		if err := store.Generate2FACodeAndStore(ctx, "+15550100", "123456"); err != nil {
+			t.Fatalf("storing a 2FA code: %v", err)
+		}
+		if resealed, err := store.ReencryptSecrets(ctx); err != nil || resealed != 0 {
+			t.Errorf("re-encrypting records sealed under the active key resealed %d (%v), want 0", resealed, err)
+		}
+		service, exists, err := store.CheckPhoneNumberExistsInSmsService(ctx, "+15550100")
+		if err != nil || !exists || service.Passkey != "correct horse" {
+			t.Errorf("alice after re-encrypting is %+v (%v, exists %v), want the passkey readable", service, err, exists)
+		}
+		if verified, err := store.Verify2FACode(ctx, "+15550100", "123456"); err != nil || !verified {
+			t.Errorf("verifying the 2FA code after re-encrypting: %v, verified %v", err, verified)
+		}
+	})
+}
@@ -0,0 +1,438 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"crypto-sms/kms"
+	"crypto-sms/storage"
+	"crypto-sms/storage/memory"
+	"crypto-sms/storage/postgres"
+)
+
+// backend opens an empty, migrated store for one test
+type backend func(t *testing.T) *storage.Store
+
+// backends returns every store the conformance suite runs against. The memory store always
+// runs; MongoDB and PostgreSQL run when MONGODB_URI or POSTGRES_URL name a server, each test
+// getting a database or schema of its own that is dropped when it ends.
+func backends() map[string]backend {
+	return map[string]backend{
+		"memory":   openMemory,
+		"mongo":    openMongo,
+		"postgres": openPostgres,
+	}
+}
+
+// forEachBackend runs test against a fresh store of every backend
+func forEachBackend(t *testing.T, test func(t *testing.T, store *storage.Store)) {
+	for name, open := range backends() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			if _, err := store.Migrate(context.Background()); err != nil {
+				t.Fatalf("migrating: %v", err)
+			}
+			test(t, store)
+		})
+	}
+}
+
+func openMemory(t *testing.T) *storage.Store {
+	return memory.NewStore()
+}
+
+func openMongo(t *testing.T) *storage.Store {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	db := client.Database("crypto_sms_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	store, err := storage.NewMongoStore(db, testMasterKeys(t))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	return store
+}
+
+func openPostgres(t *testing.T) *storage.Store {
+	url := os.Getenv("POSTGRES_URL")
+	if url == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+	admin, err := postgres.Open(url)
+	if err != nil {
+		t.Fatalf("connecting to PostgreSQL: %v", err)
+	}
+	schema := "crypto_sms_test_" + primitive.NewObjectID().Hex()
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	// Unknown connection parameters are sent to the server as run-time settings
+	separator := " "
+	if strings.Contains(url, "://") {
+		separator = "?"
+		if strings.Contains(url, "?") {
+			separator = "&"
+		}
+	}
+	db, err := postgres.Open(url + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatalf("connecting to schema: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store, err := postgres.NewStore(db, testMasterKeys(t))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	return store
+}
+
+// testMasterKeys writes a key file holding one random master key and loads it
+func testMasterKeys(t *testing.T) kms.KMS {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	data, _ := json.Marshal(map[string]any{
+		"current": "test",
+		"keys":    map[string]string{"test": base64.StdEncoding.EncodeToString(key)},
+	})
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+	keys, err := kms.LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("loading key file: %v", err)
+	}
+	return keys
+}
+
+// balanceOf returns what wallet holds of asset, zero when it has no custodian record
+func balanceOf(t *testing.T, store *storage.Store, wallet string, asset string) float64 {
+	t.Helper()
+	custodian, exists, err := store.GetCustodianByWalletAddress(context.Background(), wallet)
+	if err != nil {
+		t.Fatalf("reading %s: %v", wallet, err)
+	}
+	if !exists {
+		return 0
+	}
+	return custodian.Cryptocurrencies[asset]
+}
+
+func TestTransferBalanceAppliesEachReferenceOnce(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		transfer := func(transfer storage.BalanceTransfer) bool {
+			t.Helper()
+			moved, err := store.TransferBalance(ctx, transfer)
+			if err != nil {
+				t.Fatalf("transferring %s: %v", transfer.Reference, err)
+			}
+			return moved
+		}
+
+		fund := storage.BalanceTransfer{Reference: "fund", To: "alice", ToAsset: "USDC", Amount: 10}
+		if !transfer(fund) || !transfer(fund) {
+			t.Fatal("funding alice was refused")
+		}
+		if got := balanceOf(t, store, "alice", "USDC"); got != 10 {
+			t.Fatalf("alice holds %v after funding twice under one reference, want 10", got)
+		}
+
+		send := storage.BalanceTransfer{Reference: "send", From: "alice", FromAsset: "USDC", To: "bob", ToAsset: "USDC", Amount: 4}
+		if !transfer(send) || !transfer(send) {
+			t.Fatal("sending to bob was refused")
+		}
+		if alice, bob := balanceOf(t, store, "alice", "USDC"), balanceOf(t, store, "bob", "USDC"); alice != 6 || bob != 4 {
+			t.Fatalf("after sending twice under one reference alice holds %v and bob %v, want 6 and 4", alice, bob)
+		}
+
+		overdraft := storage.BalanceTransfer{Reference: "overdraft", From: "alice", FromAsset: "USDC", To: "bob", ToAsset: "USDC", Amount: 7}
+		if transfer(overdraft) {
+			t.Fatal("a transfer larger than alice's balance was applied")
+		}
+		if alice, bob := balanceOf(t, store, "alice", "USDC"), balanceOf(t, store, "bob", "USDC"); alice != 6 || bob != 4 {
+			t.Fatalf("after a refused transfer alice holds %v and bob %v, want 6 and 4", alice, bob)
+		}
+
+		overdraft.AllowOverdraft = true
+		if !transfer(overdraft) || !transfer(overdraft) {
+			t.Fatal("an overdraft that is allowed was refused")
+		}
+		if alice, bob := balanceOf(t, store, "alice", "USDC"), balanceOf(t, store, "bob", "USDC"); alice != -1 || bob != 11 {
+			t.Fatalf("after an allowed overdraft alice holds %v and bob %v, want -1 and 11", alice, bob)
+		}
+	})
+}
+
+func TestTransitionWithdrawalComparesStatus(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now()
+		withdrawal := &storage.Withdrawal{
+			ID:            primitive.NewObjectID(),
+			SenderAddress: "alice",
+			SenderPhone:   "+15550100",
+			Crypto:        "USDC",
+			Asset:         "USDC",
+			Network:       "ethereum",
+			ToAddress:     "0x2222222222222222222222222222222222222222",
+			AmountUSD:     25,
+			Amount:        "25000000",
+			Status:        storage.WithdrawalQueued,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := store.CreateWithdrawal(ctx, withdrawal); err != nil {
+			t.Fatalf("creating withdrawal: %v", err)
+		}
+		transition := func(from string, to string, fields storage.WithdrawalFields) bool {
+			t.Helper()
+			moved, err := store.TransitionWithdrawal(ctx, withdrawal.ID, from, to, fields)
+			if err != nil {
+				t.Fatalf("moving withdrawal from %s to %s: %v", from, to, err)
+			}
+			return moved
+		}
+		stored := func() *storage.Withdrawal {
+			t.Helper()
+			stored, exists, err := store.GetWithdrawalByID(ctx, withdrawal.ID.Hex())
+			if err != nil || !exists {
+				t.Fatalf("reading withdrawal: %v", err)
+			}
+			return stored
+		}
+
+		hash, raw := "0xabc", []byte("signed")
+		if !transition(storage.WithdrawalQueued, storage.WithdrawalSigned, storage.WithdrawalFields{TxHash: &hash, RawTx: raw}) {
+			t.Fatal("queued withdrawal was not signed")
+		}
+		other := "0xdef"
+		if transition(storage.WithdrawalQueued, storage.WithdrawalSigned, storage.WithdrawalFields{TxHash: &other}) {
+			t.Fatal("withdrawal was signed twice")
+		}
+		if got := stored(); got.Status != storage.WithdrawalSigned || got.TxHash != hash || string(got.RawTx) != "signed" {
+			t.Fatalf("signed withdrawal is %s with hash %q and raw %q, want signed with %q and %q", got.Status, got.TxHash, got.RawTx, hash, raw)
+		}
+
+		// Fields left nil keep their values
+		if !transition(storage.WithdrawalSigned, storage.WithdrawalBroadcast, storage.WithdrawalFields{}) {
+			t.Fatal("signed withdrawal was not broadcast")
+		}
+		confirmations := int64(2)
+		if !transition(storage.WithdrawalBroadcast, storage.WithdrawalBroadcast, storage.WithdrawalFields{Confirmations: &confirmations}) {
+			t.Fatal("broadcast withdrawal's confirmations were not updated")
+		}
+		if got := stored(); got.Status != storage.WithdrawalBroadcast || got.TxHash != hash || got.Confirmations != 2 {
+			t.Fatalf("broadcast withdrawal is %s with hash %q and %d confirmations, want broadcast with %q and 2", got.Status, got.TxHash, got.Confirmations, hash)
+		}
+
+		reason := "reverted"
+		if !transition(storage.WithdrawalBroadcast, storage.WithdrawalRefunded, storage.WithdrawalFields{LastError: &reason}) {
+			t.Fatal("broadcast withdrawal was not refunded")
+		}
+		if transition(storage.WithdrawalBroadcast, storage.WithdrawalConfirmed, storage.WithdrawalFields{Confirmations: &confirmations}) {
+			t.Fatal("refunded withdrawal was confirmed")
+		}
+		if got := stored(); got.Status != storage.WithdrawalRefunded || got.LastError != reason {
+			t.Fatalf("refunded withdrawal is %s with error %q, want refunded with %q", got.Status, got.LastError, reason)
+		}
+	})
+}
+
+func TestTransitionDepositComparesStatus(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now()
+		deposit := &storage.Deposit{
+			Network:       "ethereum",
+			TxHash:        "0xabc",
+			FromAddress:   "external",
+			ToAddress:     "alice",
+			WalletAddress: "alice",
+			Asset:         "USDC",
+			Amount:        "40000000",
+			AmountUSD:     40,
+			Block:         1,
+			Status:        storage.DepositPending,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if inserted, err := store.InsertDepositIfMissing(ctx, deposit); err != nil || !inserted {
+			t.Fatalf("inserting deposit: %v, inserted %v", err, inserted)
+		}
+		again := *deposit
+		again.ID = primitive.NilObjectID
+		if inserted, err := store.InsertDepositIfMissing(ctx, &again); err != nil || inserted {
+			t.Fatalf("inserting the deposit again: %v, inserted %v", err, inserted)
+		}
+		deposits, err := store.ListDepositsForWallet(ctx, "alice")
+		if err != nil || len(deposits) != 1 {
+			t.Fatalf("listing deposits: %v, %d deposits, want 1", err, len(deposits))
+		}
+		id := deposits[0].ID
+
+		transition := func(from string, to string, fields storage.DepositFields) bool {
+			t.Helper()
+			moved, err := store.TransitionDeposit(ctx, id, from, to, fields)
+			if err != nil {
+				t.Fatalf("moving deposit from %s to %s: %v", from, to, err)
+			}
+			return moved
+		}
+		confirmations, creditedAt := int64(3), now.Truncate(time.Millisecond)
+		if !transition(storage.DepositPending, storage.DepositCredited, storage.DepositFields{Confirmations: &confirmations, CreditedAt: &creditedAt}) {
+			t.Fatal("pending deposit was not credited")
+		}
+		if transition(storage.DepositPending, storage.DepositCredited, storage.DepositFields{Confirmations: &confirmations}) {
+			t.Fatal("deposit was credited twice")
+		}
+		if transition(storage.DepositPending, storage.DepositOrphaned, storage.DepositFields{}) {
+			t.Fatal("credited deposit was orphaned as if it were pending")
+		}
+
+		deposits, err = store.ListDepositsForWallet(ctx, "alice")
+		if err != nil || len(deposits) != 1 {
+			t.Fatalf("listing deposits: %v, %d deposits, want 1", err, len(deposits))
+		}
+		if got := deposits[0]; got.Status != storage.DepositCredited || got.Confirmations != 3 || !got.CreditedAt.Equal(creditedAt) {
+			t.Fatalf("credited deposit is %s with %d confirmations credited at %v, want credited with 3 at %v",
+				got.Status, got.Confirmations, got.CreditedAt, creditedAt)
+		}
+	})
+}
+
+func TestDuplicateSmsServicesAreRefused(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		alice := storage.SmsService{WalletAddress: "alice", PhoneNumber: "+15550100", Alias: "alice"}
+		if err := store.CreateSmsService(ctx, alice); err != nil {
+			t.Fatalf("registering alice: %v", err)
+		}
+		if err := store.CreateSmsService(ctx, storage.SmsService{WalletAddress: "bob", PhoneNumber: "+15550101"}); err != nil {
+			t.Fatalf("registering bob: %v", err)
+		}
+
+		for name, service := range map[string]storage.SmsService{
+			"wallet":       {WalletAddress: "alice", PhoneNumber: "+15550102"},
+			"phone number": {WalletAddress: "carol", PhoneNumber: "+15550100"},
+			"alias":        {WalletAddress: "carol", PhoneNumber: "+15550102", Alias: "alice"},
+		} {
+			if err := store.CreateSmsService(ctx, service); !errors.Is(err, storage.ErrDuplicate) {
+				t.Errorf("registering a taken %s returned %v, want ErrDuplicate", name, err)
+			}
+		}
+		if err := store.UpdateAlias(ctx, "bob", "alice"); !errors.Is(err, storage.ErrDuplicate) {
+			t.Errorf("taking alice's alias returned %v, want ErrDuplicate", err)
+		}
+
+		// Refused writes leave the registered wallets as they were
+		service, exists, err := store.CheckPhoneNumberExistsInSmsService(ctx, "+15550100")
+		if err != nil || !exists || service.WalletAddress != "alice" {
+			t.Fatalf("phone number +15550100 belongs to %+v, want alice", service)
+		}
+		service, exists, err = store.CheckAliasExistsInSmsService(ctx, "alice")
+		if err != nil || !exists || service.WalletAddress != "alice" {
+			t.Fatalf("alias alice belongs to %+v, want alice", service)
+		}
+
+		// A phone number moved to another wallet is taken from the one that held it
+		if err := store.UpdatePhoneNumber(ctx, "bob", "+15550100"); err != nil {
+			t.Fatalf("moving alice's phone number to bob: %v", err)
+		}
+		service, exists, err = store.CheckPhoneNumberExistsInSmsService(ctx, "+15550100")
+		if err != nil || !exists || service.WalletAddress != "bob" {
+			t.Fatalf("moved phone number +15550100 belongs to %+v, want bob", service)
+		}
+		service, exists, err = store.CheckWalletExistsInSmsService(ctx, "alice")
+		if err != nil || !exists || service.PhoneNumber != "" {
+			t.Fatalf("alice is %+v after the phone number moved, want alice registered without one", service)
+		}
+	})
+}
+
+func TestVerify2FACodeIsSingleUse(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		verify := func(phoneNumber string, code string) bool {
+			t.Helper()
+			verified, err := store.Verify2FACode(ctx, phoneNumber, code)
+			if err != nil {
+				t.Fatalf("verifying %q for %s: %v", code, phoneNumber, err)
+			}
+			return verified
+		}
+
+		if verify("+15550100", "") {
+			t.Fatal("an empty code verified for a number that was never sent one")
+		}
+		if err := store.Generate2FACodeAndStore(ctx, "+15550100", "123456"); err != nil {
+			t.Fatalf("storing code: %v", err)
+		}
+		if verify("+15550100", "") {
+			t.Fatal("an empty code verified")
+		}
+		if verify("+15550100", "654321") {
+			t.Fatal("a wrong code verified")
+		}
+		if verify("+15550101", "123456") {
+			t.Fatal("the code verified for another number")
+		}
+		if !verify("+15550100", "123456") {
+			t.Fatal("the code sent to the number did not verify")
+		}
+		if verify("+15550100", "123456") {
+			t.Fatal("the code verified a second time")
+		}
+		if verify("+15550100", "") {
+			t.Fatal("an empty code verified once the code was used")
+		}
+
+		// A stored code doesn't verify, and doesn't revive a used one
+		if err := store.Store2FACode(ctx, "+15550100", "123456"); err != nil {
+			t.Fatalf("storing separate code: %v", err)
+		}
+		if verify("+15550100", "123456") {
+			t.Fatal("a code kept with Store2FACode verified")
+		}
+
+		// A new code replaces the used one
+		if err := store.Generate2FACodeAndStore(ctx, "+15550100", "111111"); err != nil {
+			t.Fatalf("storing second code: %v", err)
+		}
+		if !verify("+15550100", "111111") {
+			t.Fatal("a second code sent to the number did not verify")
+		}
+	})
+}
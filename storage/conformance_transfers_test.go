@@ -0,0 +1,571 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+func TestCustodianBalances(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		for _, credit := range []struct {
+			wallet string
+			asset  string
+			amount float64
+		}{{"alice", "USDC", 10}, {"alice", "ETH", 1}, {"carol", "USDC", 3}} {
+			if err := store.CreditCustodian(ctx, credit.wallet, credit.asset, credit.amount); err != nil {
+				t.Fatalf("crediting %s: %v", credit.wallet, err)
+			}
+		}
+
+		tests := []struct {
+			name   string
+			wallet string
+			asset  string
+			amount float64
+			want   bool
+		}{
+			{"covered debit", "alice", "USDC", 4, true},
+			{"debit above the balance", "alice", "USDC", 7, false},
+			{"debit of an asset never held", "alice", "BTC", 1, false},
+			{"debit of an unknown wallet", "bob", "USDC", 1, false},
+		}
+		for _, test := range tests {
+			if debited, err := store.DebitCustodian(ctx, test.wallet, test.asset, test.amount); err != nil || debited != test.want {
+				t.Errorf("%s: %v, debited %v, want %v", test.name, err, debited, test.want)
+			}
+		}
+		custodian, exists, err := store.GetCustodianByWalletAddress(ctx, "alice")
+		if err != nil || !exists {
+			t.Fatalf("fetching alice: %v, exists %v", err, exists)
+		}
+		if custodian.Cryptocurrencies["USDC"] != 6 || custodian.Cryptocurrencies["ETH"] != 1 {
+			t.Errorf("alice holds %v, want 6 USDC and 1 ETH", custodian.Cryptocurrencies)
+		}
+
+		// Updating replaces every balance, and never creates a custodian
+		if err := store.UpdateCustodian(ctx, &storage.Custodian{WalletAddress: "alice", Cryptocurrencies: map[string]float64{"USDC": 2}}); err != nil {
+			t.Fatalf("updating alice: %v", err)
+		}
+		custodian, _, err = store.GetCustodianByWalletAddress(ctx, "alice")
+		if err != nil || len(custodian.Cryptocurrencies) != 1 || custodian.Cryptocurrencies["USDC"] != 2 {
+			t.Errorf("alice holds %v after the update (%v), want only 2 USDC", custodian.Cryptocurrencies, err)
+		}
+		if err := store.UpdateCustodian(ctx, &storage.Custodian{WalletAddress: "bob", Cryptocurrencies: map[string]float64{"USDC": 2}}); err != nil {
+			t.Fatalf("updating bob: %v", err)
+		}
+		if _, exists, err := store.GetCustodianByWalletAddress(ctx, "bob"); err != nil || exists {
+			t.Errorf("fetching bob: %v, exists %v, want not found", err, exists)
+		}
+
+		addresses, err := store.ListCustodianAddresses(ctx)
+		if err != nil {
+			t.Fatalf("listing custodian addresses: %v", err)
+		}
+		sort.Strings(addresses)
+		if len(addresses) != 2 || addresses[0] != "alice" || addresses[1] != "carol" {
+			t.Errorf("custodian addresses are %v, want alice and carol", addresses)
+		}
+	})
+}
+
+func TestTransactionLookups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		transactions := []*storage.Transaction{
+			{Kind: storage.TransactionTransfer, SenderAddress: "alice", SenderPhone: "+15550100", RecipientAddress: "bob", RecipientPhone: "+15550101", Crypto: "USDC", AmountUSD: 20, CreatedAt: now.Add(-2 * time.Hour)},
+			{Kind: storage.TransactionReversal, SenderAddress: "alice", RecipientAddress: "carol", Crypto: "USDC", AmountUSD: 5, CreatedAt: now.Add(-time.Hour)},
+			{Kind: storage.TransactionTransfer, SenderAddress: "alice", RecipientAddress: "dave", Crypto: "USDC", AmountUSD: 7, CreatedAt: now.Add(-30 * time.Minute)},
+			{Kind: storage.TransactionTransfer, SenderAddress: "bob", RecipientAddress: "alice", Crypto: "USDC", AmountUSD: 3, CreatedAt: now.Add(-10 * time.Minute)},
+		}
+		for _, transaction := range transactions {
+			if err := store.CreateTransaction(ctx, transaction); err != nil {
+				t.Fatalf("recording transaction: %v", err)
+			}
+			if transaction.ID.IsZero() {
+				t.Fatalf("transaction %+v was not given an ID", transaction)
+			}
+		}
+		first, reversal, latest, incoming := transactions[0], transactions[1], transactions[2], transactions[3]
+
+		for _, test := range []struct {
+			since time.Time
+			want  []*storage.Transaction
+		}{
+			{now.Add(-3 * time.Hour), []*storage.Transaction{first, latest}},
+			{now.Add(-90 * time.Minute), []*storage.Transaction{latest}},
+			{now, nil},
+		} {
+			listed, err := store.ListTransactionsSince(ctx, "alice", test.since)
+			if err != nil {
+				t.Fatalf("listing transactions since %v: %v", test.since, err)
+			}
+			if !sameTransactions(listed, test.want) {
+				t.Errorf("transactions since %v are %+v, want %+v", test.since, listed, test.want)
+			}
+		}
+		listed, err := store.ListTransactionsForWallet(ctx, "alice")
+		if err != nil {
+			t.Fatalf("listing alice's transactions: %v", err)
+		}
+		if !sameTransactions(listed, []*storage.Transaction{incoming, latest, reversal, first}) {
+			t.Errorf("alice's transactions are %+v, want every one newest first", listed)
+		}
+
+		for _, test := range []struct {
+			name             string
+			sender           string
+			recipientAddress string
+			recipientPhone   string
+			want             bool
+		}{
+			{"recipient address", "alice", "bob", "", true},
+			{"recipient phone number", "alice", "", "+15550101", true},
+			{"reversed recipient", "alice", "carol", "", false},
+			{"no recipient", "alice", "", "", false},
+			{"other sender", "bob", "dave", "", false},
+		} {
+			if sent, err := store.HasTransactionTo(ctx, test.sender, test.recipientAddress, test.recipientPhone); err != nil || sent != test.want {
+				t.Errorf("%s: %v, sent %v, want %v", test.name, err, sent, test.want)
+			}
+		}
+
+		count := 0
+		if err := store.EachTransaction(ctx, func(*storage.Transaction) error { count++; return nil }); err != nil || count != len(transactions) {
+			t.Errorf("visiting every transaction: %v, visited %d, want %d", err, count, len(transactions))
+		}
+		stop := errors.New("stop")
+		count = 0
+		if err := store.EachTransaction(ctx, func(*storage.Transaction) error { count++; return stop }); err != stop || count != 1 {
+			t.Errorf("stopping the visit: %v after %d transactions, want the callback's error after 1", err, count)
+		}
+
+		fetched, exists, err := store.GetTransactionByID(ctx, first.ID.Hex())
+		if err != nil || !exists {
+			t.Fatalf("fetching the first transaction: %v, exists %v", err, exists)
+		}
+		if fetched.SenderPhone != "+15550100" || fetched.RecipientPhone != "+15550101" || fetched.AmountUSD != 20 {
+			t.Errorf("fetched %+v, want the recorded transaction", fetched)
+		}
+		for _, id := range []string{"nonsense", primitive.NewObjectID().Hex()} {
+			if _, exists, err := store.GetTransactionByID(ctx, id); err != nil || exists {
+				t.Errorf("fetching transaction %q: %v, exists %v, want not found", id, err, exists)
+			}
+		}
+
+		for _, test := range []struct {
+			name     string
+			expected float64
+			amount   float64
+			want     bool
+		}{
+			{"first refund", 0, 5, true},
+			{"stale refund", 0, 5, false},
+			{"empty refund", 5, 0, false},
+			{"second refund", 5, 2.5, true},
+		} {
+			if added, err := store.AddRefundedAmount(ctx, first.ID, test.expected, test.amount); err != nil || added != test.want {
+				t.Errorf("%s: %v, added %v, want %v", test.name, err, added, test.want)
+			}
+		}
+		if fetched, _, err := store.GetTransactionByID(ctx, first.ID.Hex()); err != nil || fetched.RefundedUSD != 7.5 {
+			t.Errorf("refunded %v (%v), want 7.5", fetched.RefundedUSD, err)
+		}
+	})
+}
+
+func TestListTransactionsSinceCountsOutstandingTransfers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		transactions := []*storage.Transaction{
+			{Kind: storage.TransactionTransfer, SenderAddress: "alice", RecipientAddress: "bob", Crypto: "USDC", AmountUSD: 20, CreatedAt: now.Add(-5 * time.Minute)},
+			{Kind: storage.TransactionTransfer, SenderAddress: "alice", RecipientAddress: "bob", Crypto: "USDC", AmountUSD: 30, CreatedAt: now.Add(-4 * time.Minute)},
+			{Kind: storage.TransactionWithdrawal, SenderAddress: "alice", RecipientAddress: "0x22", Crypto: "USDC", AmountUSD: 40, CreatedAt: now.Add(-3 * time.Minute)},
+			{Kind: storage.TransactionAdjustment, SenderAddress: "alice", RecipientAddress: "ledger", Crypto: "USDC", AmountUSD: 50, CreatedAt: now.Add(-2 * time.Minute)},
+			{Kind: storage.TransactionReversal, SenderAddress: "alice", RecipientAddress: "carol", Crypto: "USDC", AmountUSD: 60, CreatedAt: now.Add(-time.Minute)},
+		}
+		for _, transaction := range transactions {
+			if err := store.CreateTransaction(ctx, transaction); err != nil {
+				t.Fatalf("recording transaction: %v", err)
+			}
+		}
+		partly, reversed, withdrawal := transactions[0], transactions[1], transactions[2]
+		for _, refund := range []struct {
+			transaction *storage.Transaction
+			amount      float64
+		}{{partly, 5}, {reversed, 30}, {withdrawal, 40}} {
+			if added, err := store.AddRefundedAmount(ctx, refund.transaction.ID, 0, refund.amount); err != nil || !added {
+				t.Fatalf("refunding: %v, added %v", err, added)
+			}
+		}
+
+		// Adjustments, reversals and fully refunded transfers don't count as spending
+		listed, err := store.ListTransactionsSince(ctx, "alice", now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("listing transactions: %v", err)
+		}
+		if len(listed) != 1 || listed[0].ID != partly.ID || listed[0].RefundedUSD != 5 {
+			t.Errorf("transactions since an hour ago are %+v, want only the partly refunded transfer", listed)
+		}
+	})
+}
+
+func TestEscrowLifecycle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		claimable := &storage.Escrow{SenderAddress: "alice", SenderPhone: "+15550100", RecipientPhone: "+15550101", Crypto: "USDC",
+			AmountUSD: 10, ClaimCode: "1234", Status: storage.EscrowHeld, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		expired := &storage.Escrow{SenderAddress: "alice", SenderPhone: "+15550100", RecipientAddress: "bob", Crypto: "USDC",
+			AmountUSD: 4, ClaimCode: "9999", Status: storage.EscrowHeld, CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}
+		other := &storage.Escrow{SenderAddress: "carol", SenderPhone: "+15550102", RecipientPhone: "+15550101", Crypto: "USDC",
+			AmountUSD: 6, ClaimCode: "5678", Status: storage.EscrowHeld, CreatedAt: now, ExpiresAt: now.Add(2 * time.Hour)}
+		for _, escrow := range []*storage.Escrow{claimable, expired, other} {
+			if err := store.CreateEscrow(ctx, escrow); err != nil {
+				t.Fatalf("creating escrow: %v", err)
+			}
+		}
+
+		escrow, exists, err := store.GetHeldEscrowByClaimCode(ctx, "+15550101", "1234")
+		if err != nil || !exists || escrow.ID != claimable.ID || escrow.SenderPhone != "+15550100" {
+			t.Fatalf("fetching the escrow by claim code: %v, exists %v, got %+v", err, exists, escrow)
+		}
+		if _, exists, err := store.GetHeldEscrowByClaimCode(ctx, "+15550101", "9999"); err != nil || exists {
+			t.Errorf("fetching with another escrow's claim code: %v, exists %v, want not found", err, exists)
+		}
+		if held, err := store.ListHeldEscrowsForPhone(ctx, "+15550101"); err != nil || !sameEscrows(held, claimable, other) {
+			t.Errorf("held escrows for the phone number are %+v (%v), want two", held, err)
+		}
+		if held, err := store.ListHeldEscrowsForAddress(ctx, "bob"); err != nil || !sameEscrows(held, expired) {
+			t.Errorf("held escrows for bob are %+v (%v), want the expired one", held, err)
+		}
+		if listed, err := store.ListExpiredEscrows(ctx, now); err != nil || !sameEscrows(listed, expired) {
+			t.Errorf("expired escrows are %+v (%v), want one", listed, err)
+		}
+
+		if resolved, err := store.ResolveEscrow(ctx, claimable.ID, storage.EscrowClaimed, "bob"); err != nil || !resolved {
+			t.Fatalf("claiming: %v, resolved %v", err, resolved)
+		}
+		if resolved, err := store.ResolveEscrow(ctx, claimable.ID, storage.EscrowRefunded, ""); err != nil || resolved {
+			t.Errorf("refunding a claimed escrow: %v, resolved %v, want refused", err, resolved)
+		}
+		if _, exists, err := store.GetHeldEscrowByClaimCode(ctx, "+15550101", "1234"); err != nil || exists {
+			t.Errorf("fetching a claimed escrow by claim code: %v, exists %v, want not found", err, exists)
+		}
+		listed, err := store.ListResolvedEscrows(ctx)
+		if err != nil || !sameEscrows(listed, claimable) {
+			t.Fatalf("resolved escrows are %+v (%v), want the claimed one", listed, err)
+		}
+		if listed[0].Status != storage.EscrowClaimed || listed[0].ClaimedBy != "bob" || listed[0].ResolvedAt.IsZero() {
+			t.Errorf("claimed escrow is %+v, want claimed by bob with a resolution time", listed[0])
+		}
+
+		// A failed release puts the escrow back on hold
+		if reopened, err := store.ReopenEscrow(ctx, claimable.ID, storage.EscrowRefunded); err != nil || reopened {
+			t.Errorf("reopening from the wrong status: %v, reopened %v, want refused", err, reopened)
+		}
+		if reopened, err := store.ReopenEscrow(ctx, claimable.ID, storage.EscrowClaimed); err != nil || !reopened {
+			t.Fatalf("reopening: %v, reopened %v", err, reopened)
+		}
+		escrow, exists, err = store.GetHeldEscrowByClaimCode(ctx, "+15550101", "1234")
+		if err != nil || !exists || escrow.ClaimedBy != "" || !escrow.ResolvedAt.IsZero() {
+			t.Errorf("reopened escrow is %+v (%v, exists %v), want held with no claimant", escrow, err, exists)
+		}
+		if listed, err := store.ListResolvedEscrows(ctx); err != nil || len(listed) != 0 {
+			t.Errorf("resolved escrows are %+v (%v), want none", listed, err)
+		}
+	})
+}
+
+func TestPaymentRequestLifecycle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		open := &storage.PaymentRequest{Reference: "R1", RequesterAddress: "bob", RequesterPhone: "+15550101", PayerAddress: "alice",
+			PayerPhone: "+15550100", Asset: "USDC", AmountUSD: 12, Status: storage.PaymentRequestPending, CreatedAt: now.Add(-2 * time.Minute), ExpiresAt: now.Add(time.Hour)}
+		lapsed := &storage.PaymentRequest{Reference: "R2", RequesterAddress: "bob", RequesterPhone: "+15550101", PayerAddress: "alice",
+			PayerPhone: "+15550100", Asset: "USDC", AmountUSD: 8, Status: storage.PaymentRequestPending, CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(-time.Minute)}
+		approving := &storage.PaymentRequest{Reference: "R3", RequesterAddress: "carol", RequesterPhone: "+15550102", PayerAddress: "dave",
+			PayerPhone: "+15550103", Asset: "USDC", AmountUSD: 5, Status: storage.PaymentRequestApproving, CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}
+		for _, request := range []*storage.PaymentRequest{open, lapsed, approving} {
+			if err := store.CreatePaymentRequest(ctx, request); err != nil {
+				t.Fatalf("creating payment request: %v", err)
+			}
+		}
+
+		request, exists, err := store.GetPendingPaymentRequest(ctx, "+15550100", "R1")
+		if err != nil || !exists || request.ID != open.ID || request.RequesterPhone != "+15550101" {
+			t.Fatalf("fetching the pending request: %v, exists %v, got %+v", err, exists, request)
+		}
+		if _, exists, err := store.GetPendingPaymentRequest(ctx, "+15550100", "R2"); err != nil || exists {
+			t.Errorf("fetching an expired request: %v, exists %v, want not found", err, exists)
+		}
+		if request, exists, err := store.GetPaymentRequest(ctx, approving.ID); err != nil || !exists || request.Status != storage.PaymentRequestApproving {
+			t.Errorf("fetching by ID: %v, exists %v, got %+v", err, exists, request)
+		}
+		if listed, err := store.ListExpiredPaymentRequests(ctx, now); err != nil || !samePaymentRequests(listed, approving, lapsed) {
+			t.Errorf("expired requests are %+v (%v), want the approving and lapsed ones, newest first", listed, err)
+		}
+		if listed, err := store.ListPaymentRequestsForWallet(ctx, "bob"); err != nil || !samePaymentRequests(listed, lapsed, open) {
+			t.Errorf("bob's requests are %+v (%v), want both, newest first", listed, err)
+		}
+
+		if moved, err := store.TransitionPaymentRequest(ctx, open.ID, storage.PaymentRequestPending, storage.PaymentRequestDeclined); err != nil || !moved {
+			t.Fatalf("declining: %v, moved %v", err, moved)
+		}
+		if moved, err := store.TransitionPaymentRequest(ctx, open.ID, storage.PaymentRequestPending, storage.PaymentRequestApproving); err != nil || moved {
+			t.Errorf("approving a declined request: %v, moved %v, want refused", err, moved)
+		}
+		request, _, err = store.GetPaymentRequest(ctx, open.ID)
+		if err != nil || request.Status != storage.PaymentRequestDeclined || request.RespondedAt.IsZero() {
+			t.Errorf("declined request is %+v (%v), want declined with a response time", request, err)
+		}
+		if _, exists, err := store.GetPendingPaymentRequest(ctx, "+15550100", "R1"); err != nil || exists {
+			t.Errorf("fetching a declined request as pending: %v, exists %v, want not found", err, exists)
+		}
+	})
+}
+
+func TestPendingTransferDecisions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		held := &storage.PendingTransfer{Reference: "G1", SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "bob", Crypto: "USDC",
+			AmountUSD: 500, Guardians: []string{"+15550102", "+15550103"}, RequiredApprovals: 2, Status: storage.PendingTransferPending,
+			CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}
+		lapsed := &storage.PendingTransfer{Reference: "G2", SenderAddress: "alice", SenderPhone: "+15550100", Recipient: "carol", Crypto: "USDC",
+			AmountUSD: 300, Guardians: []string{"+15550102"}, RequiredApprovals: 1, Status: storage.PendingTransferPending,
+			CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}
+		for _, transfer := range []*storage.PendingTransfer{held, lapsed} {
+			if err := store.CreatePendingTransfer(ctx, transfer); err != nil {
+				t.Fatalf("creating pending transfer: %v", err)
+			}
+		}
+
+		transfer, exists, err := store.GetPendingTransferForGuardian(ctx, "G1", "+15550103")
+		if err != nil || !exists || transfer.ID != held.ID || len(transfer.Guardians) != 2 || transfer.Guardians[0] != "+15550102" || transfer.SenderPhone != "+15550100" {
+			t.Fatalf("fetching for a guardian: %v, exists %v, got %+v", err, exists, transfer)
+		}
+		if _, exists, err := store.GetPendingTransferForGuardian(ctx, "G1", "+15550104"); err != nil || exists {
+			t.Errorf("fetching for someone who isn't a guardian: %v, exists %v, want not found", err, exists)
+		}
+
+		decision := storage.GuardianDecision{PhoneNumber: "+15550102", Decision: storage.GuardianApprove, DecidedAt: now}
+		updated, recorded, err := store.RecordGuardianDecision(ctx, held.ID, decision)
+		if err != nil || !recorded || len(updated.Decisions) != 1 || updated.Decisions[0].PhoneNumber != "+15550102" {
+			t.Fatalf("recording a decision: %v, recorded %v, got %+v", err, recorded, updated)
+		}
+		if _, recorded, err := store.RecordGuardianDecision(ctx, held.ID, decision); err != nil || recorded {
+			t.Errorf("recording the same guardian twice: %v, recorded %v, want refused", err, recorded)
+		}
+		if listed, err := store.ListExpiredPendingTransfers(ctx, now); err != nil || len(listed) != 1 || listed[0].ID != lapsed.ID {
+			t.Errorf("expired pending transfers are %+v (%v), want the lapsed one", listed, err)
+		}
+		if listed, err := store.ListPendingTransfersForWallet(ctx, "alice"); err != nil || len(listed) != 2 || listed[0].ID != lapsed.ID || listed[1].ID != held.ID {
+			t.Errorf("alice's pending transfers are %+v (%v), want both, newest first", listed, err)
+		}
+
+		if moved, err := store.TransitionPendingTransfer(ctx, held.ID, storage.PendingTransferPending, storage.PendingTransferApproved); err != nil || !moved {
+			t.Fatalf("approving: %v, moved %v", err, moved)
+		}
+		if moved, err := store.TransitionPendingTransfer(ctx, held.ID, storage.PendingTransferPending, storage.PendingTransferDenied); err != nil || moved {
+			t.Errorf("denying an approved transfer: %v, moved %v, want refused", err, moved)
+		}
+		late := storage.GuardianDecision{PhoneNumber: "+15550103", Decision: storage.GuardianDeny, DecidedAt: now}
+		if _, recorded, err := store.RecordGuardianDecision(ctx, held.ID, late); err != nil || recorded {
+			t.Errorf("deciding on an approved transfer: %v, recorded %v, want refused", err, recorded)
+		}
+		if _, exists, err := store.GetPendingTransferForGuardian(ctx, "G1", "+15550103"); err != nil || exists {
+			t.Errorf("fetching an approved transfer for a guardian: %v, exists %v, want not found", err, exists)
+		}
+	})
+}
+
+func TestScheduledTransferRuns(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		due := &storage.ScheduledTransfer{Reference: "S1", SenderAddress: "alice", Recipient: "bob", Crypto: "USDC", AmountUSD: 10,
+			Frequency: storage.FrequencyDaily, Status: storage.ScheduledTransferActive, NextRunAt: now.Add(-time.Minute), CreatedAt: now}
+		later := &storage.ScheduledTransfer{Reference: "S2", SenderAddress: "alice", Recipient: "carol", Crypto: "USDC", AmountUSD: 20,
+			Frequency: storage.FrequencyOnce, Status: storage.ScheduledTransferActive, NextRunAt: now.Add(time.Hour), CreatedAt: now}
+		others := &storage.ScheduledTransfer{Reference: "S3", SenderAddress: "bob", Recipient: "alice", Crypto: "USDC", AmountUSD: 30,
+			Frequency: storage.FrequencyWeekly, Weekday: time.Monday, Status: storage.ScheduledTransferActive, NextRunAt: now.Add(2 * time.Hour), CreatedAt: now}
+		for _, transfer := range []*storage.ScheduledTransfer{due, later, others} {
+			if err := store.CreateScheduledTransfer(ctx, transfer); err != nil {
+				t.Fatalf("scheduling: %v", err)
+			}
+		}
+
+		if transfer, exists, err := store.GetActiveScheduledTransfer(ctx, "alice", "S1"); err != nil || !exists || transfer.ID != due.ID {
+			t.Errorf("fetching S1: %v, exists %v, got %+v", err, exists, transfer)
+		}
+		if _, exists, err := store.GetActiveScheduledTransfer(ctx, "alice", "S3"); err != nil || exists {
+			t.Errorf("fetching bob's transfer as alice: %v, exists %v, want not found", err, exists)
+		}
+		if listed, err := store.ListScheduledTransfers(ctx, "alice"); err != nil || !sameScheduledTransfers(listed, due, later) {
+			t.Errorf("alice's scheduled transfers are %+v (%v), want both, next run first", listed, err)
+		}
+		if listed, err := store.ListDueScheduledTransfers(ctx, now); err != nil || !sameScheduledTransfers(listed, due) {
+			t.Errorf("due transfers are %+v (%v), want S1", listed, err)
+		}
+		if listed, err := store.ListUnremindedScheduledTransfers(ctx, now.Add(90*time.Minute)); err != nil || !sameScheduledTransfers(listed, due, later) {
+			t.Errorf("transfers to remind are %+v (%v), want S1 and S2", listed, err)
+		}
+		if err := store.MarkScheduledTransferReminded(ctx, due.ID, due.NextRunAt); err != nil {
+			t.Fatalf("marking S1 reminded: %v", err)
+		}
+		if listed, err := store.ListUnremindedScheduledTransfers(ctx, now.Add(90*time.Minute)); err != nil || !sameScheduledTransfers(listed, later) {
+			t.Errorf("transfers to remind are %+v (%v), want only S2", listed, err)
+		}
+
+		// Only one runner advances a given run
+		next := due.NextRunAt.Add(24 * time.Hour)
+		if advanced, err := store.AdvanceScheduledTransfer(ctx, due.ID, now, next, storage.ScheduledTransferActive); err != nil || advanced {
+			t.Errorf("advancing from the wrong run: %v, advanced %v, want refused", err, advanced)
+		}
+		if advanced, err := store.AdvanceScheduledTransfer(ctx, due.ID, due.NextRunAt, next, storage.ScheduledTransferActive); err != nil || !advanced {
+			t.Fatalf("advancing: %v, advanced %v", err, advanced)
+		}
+		if advanced, err := store.AdvanceScheduledTransfer(ctx, due.ID, due.NextRunAt, next, storage.ScheduledTransferActive); err != nil || advanced {
+			t.Errorf("advancing the same run twice: %v, advanced %v, want refused", err, advanced)
+		}
+		if err := store.RecordScheduledTransferRun(ctx, due.ID, due.NextRunAt, "insufficient funds"); err != nil {
+			t.Fatalf("recording the run: %v", err)
+		}
+		transfer, _, err := store.GetActiveScheduledTransfer(ctx, "alice", "S1")
+		if err != nil || !transfer.NextRunAt.Equal(next) || !transfer.LastRunAt.Equal(due.NextRunAt) || transfer.LastError != "insufficient funds" {
+			t.Errorf("advanced transfer is %+v (%v), want the next run and the recorded outcome", transfer, err)
+		}
+		if listed, err := store.ListUnremindedScheduledTransfers(ctx, next); err != nil || !sameScheduledTransfers(listed, later, others, due) {
+			t.Errorf("transfers to remind are %+v (%v), want S1's next run reminded again", listed, err)
+		}
+
+		if cancelled, err := store.CancelScheduledTransfer(ctx, "alice", "S2"); err != nil || !cancelled {
+			t.Fatalf("cancelling S2: %v, cancelled %v", err, cancelled)
+		}
+		if cancelled, err := store.CancelScheduledTransfer(ctx, "alice", "S2"); err != nil || cancelled {
+			t.Errorf("cancelling S2 twice: %v, cancelled %v, want refused", err, cancelled)
+		}
+		if listed, err := store.ListDueScheduledTransfers(ctx, now.Add(3*time.Hour)); err != nil || !sameScheduledTransfers(listed, others) {
+			t.Errorf("due transfers are %+v (%v), want only S3", listed, err)
+		}
+	})
+}
+
+func TestLimitChangesWaitUntilEffective(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		raise := &storage.LimitChange{WalletAddress: "alice", Kind: storage.LimitChangeKindLimit, OldLimit: 100, NewLimit: 500,
+			CancelCode: "111111", Status: storage.LimitChangePending, CreatedAt: now, EffectiveAt: now.Add(2 * time.Hour)}
+		velocity := &storage.LimitChange{WalletAddress: "alice", Kind: storage.LimitChangeKindVelocity, VelocityLimits: []storage.VelocityLimit{{Window: "24h", AmountUSD: 300}},
+			CancelCode: "222222", Status: storage.LimitChangePending, CreatedAt: now, EffectiveAt: now.Add(time.Hour)}
+		others := &storage.LimitChange{WalletAddress: "bob", Kind: storage.LimitChangeKindLimit, OldLimit: 100, NewLimit: 200,
+			CancelCode: "333333", Status: storage.LimitChangePending, CreatedAt: now, EffectiveAt: now.Add(time.Hour)}
+		for _, change := range []*storage.LimitChange{raise, velocity, others} {
+			if err := store.CreateLimitChange(ctx, change); err != nil {
+				t.Fatalf("creating limit change: %v", err)
+			}
+		}
+
+		listed, err := store.ListPendingLimitChanges(ctx, "alice")
+		if err != nil || len(listed) != 2 || listed[0].ID != velocity.ID || listed[1].ID != raise.ID {
+			t.Fatalf("alice's pending changes are %+v (%v), want both, soonest first", listed, err)
+		}
+		if len(listed[0].VelocityLimits) != 1 || listed[0].VelocityLimits[0].AmountUSD != 300 {
+			t.Errorf("velocity change is %+v, want its limits kept", listed[0])
+		}
+
+		if err := store.SetLimitChangeStatus(ctx, velocity.ID, storage.LimitChangeApplied); err != nil {
+			t.Fatalf("applying: %v", err)
+		}
+		if cancelled, err := store.CancelLimitChangeByCode(ctx, "alice", "222222"); err != nil || cancelled {
+			t.Errorf("cancelling an applied change: %v, cancelled %v, want refused", err, cancelled)
+		}
+		if cancelled, err := store.CancelLimitChangeByCode(ctx, "alice", "333333"); err != nil || cancelled {
+			t.Errorf("cancelling with bob's code: %v, cancelled %v, want refused", err, cancelled)
+		}
+		if cancelled, err := store.CancelLimitChangeByCode(ctx, "alice", "111111"); err != nil || !cancelled {
+			t.Fatalf("cancelling: %v, cancelled %v", err, cancelled)
+		}
+		// A cancelled change stays cancelled
+		if err := store.SetLimitChangeStatus(ctx, raise.ID, storage.LimitChangeApplied); err != nil {
+			t.Fatalf("applying a cancelled change: %v", err)
+		}
+		if listed, err := store.ListPendingLimitChanges(ctx, "alice"); err != nil || len(listed) != 0 {
+			t.Errorf("alice's pending changes are %+v (%v), want none", listed, err)
+		}
+		if cancelled, err := store.CancelLimitChangeByCode(ctx, "alice", "111111"); err != nil || cancelled {
+			t.Errorf("cancelling twice: %v, cancelled %v, want refused", err, cancelled)
+		}
+		if listed, err := store.ListPendingLimitChanges(ctx, "bob"); err != nil || len(listed) != 1 || listed[0].ID != others.ID {
+			t.Errorf("bob's pending changes are %+v (%v), want the one bob made", listed, err)
+		}
+	})
+}
+
+// sameTransactions reports whether listed holds the wanted transactions in order
+func sameTransactions(listed []storage.Transaction, want []*storage.Transaction) bool {
+	if len(listed) != len(want) {
+		return false
+	}
+	for i := range listed {
+		if listed[i].ID != want[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// sameEscrows reports whether listed holds exactly the wanted escrows, in any order
+func sameEscrows(listed []storage.Escrow, want ...*storage.Escrow) bool {
+	if len(listed) != len(want) {
+		return false
+	}
+	found := map[primitive.ObjectID]bool{}
+	for _, escrow := range listed {
+		found[escrow.ID] = true
+	}
+	for _, escrow := range want {
+		if !found[escrow.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// samePaymentRequests reports whether listed holds the wanted requests in order
+func samePaymentRequests(listed []storage.PaymentRequest, want ...*storage.PaymentRequest) bool {
+	if len(listed) != len(want) {
+		return false
+	}
+	for i := range listed {
+		if listed[i].ID != want[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// sameScheduledTransfers reports whether listed holds the wanted transfers in order
+func sameScheduledTransfers(listed []storage.ScheduledTransfer, want ...*storage.ScheduledTransfer) bool {
+	if len(listed) != len(want) {
+		return false
+	}
+	for i := range listed {
+		if listed[i].ID != want[i].ID {
+			return false
+		}
+	}
+	return true
+}
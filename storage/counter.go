@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CounterRepository stores named sequence counters
+type CounterRepository interface {
+	NextSequence(ctx context.Context, name string) (int64, error)
+}
+
+// GetCounterCollection returns a reference to the counter collection
+func (m *Mongo) GetCounterCollection() *mongo.Collection {
+	return m.db.Collection("counter")
+}
+
+// NextSequence atomically increments the named counter and returns its previous value, so
+// the first call returns 0
+func (m *Mongo) NextSequence(ctx context.Context, name string) (int64, error) {
+	collection := m.GetCounterCollection()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": name}, bson.M{"$inc": bson.M{"value": 1}}, opts).Decode(&counter)
+	if err != nil {
+		log.Printf("Error incrementing counter: %v", err)
+		return 0, errors.New("failed to increment counter")
+	}
+	return counter.Value - 1, nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Custodian represents a custodian document in the database
@@ -15,14 +17,56 @@ type Custodian struct {
 	Cryptocurrencies map[string]float64 `bson:"cryptocurrencies"`
 }
 
+// BalanceTransfer moves Amount out of From's FromAsset balance and into To's ToAsset
+// balance. An empty From or To leaves that side out. Reference identifies the transfer.
+type BalanceTransfer struct {
+	Reference      string
+	From           string
+	FromAsset      string
+	To             string
+	ToAsset        string
+	Amount         float64
+	AllowOverdraft bool
+}
+
+// custodianTransfer journals an applied balance transfer under its reference
+type custodianTransfer struct {
+	Reference      string    `bson:"reference,omitempty"`
+	From           string    `bson:"from_address"`
+	FromAsset      string    `bson:"from_asset"`
+	To             string    `bson:"to_address"`
+	ToAsset        string    `bson:"to_asset"`
+	Amount         float64   `bson:"amount"`
+	AllowOverdraft bool      `bson:"allow_overdraft"`
+	CreatedAt      time.Time `bson:"created_at"`
+}
+
+// errInsufficientBalance aborts a transfer whose source balance doesn't cover it
+var errInsufficientBalance = errors.New("insufficient balance")
+
+// CustodianRepository stores the balances held by the storage custody provider
+type CustodianRepository interface {
+	GetCustodianByWalletAddress(ctx context.Context, walletAddress string) (*Custodian, bool, error)
+	ListCustodianAddresses(ctx context.Context) ([]string, error)
+	UpdateCustodian(ctx context.Context, custodian *Custodian) error
+	CreditCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) error
+	DebitCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) (bool, error)
+	TransferBalance(ctx context.Context, transfer BalanceTransfer) (bool, error)
+}
+
 // GetCustodianCollection returns a reference to the custodian collection
-func GetCustodianCollection() *mongo.Collection {
-	return db.Collection("custodian")
+func (m *Mongo) GetCustodianCollection() *mongo.Collection {
+	return m.db.Collection("custodian")
+}
+
+// GetCustodianTransferCollection returns a reference to the custodian_transfer journal
+func (m *Mongo) GetCustodianTransferCollection() *mongo.Collection {
+	return m.db.Collection("custodian_transfer")
 }
 
 // GetCustodianByWalletAddress fetches the custodian data for a given wallet address
-func GetCustodianByWalletAddress(ctx context.Context, walletAddress string) (*Custodian, bool, error) {
-	collection := GetCustodianCollection()
+func (m *Mongo) GetCustodianByWalletAddress(ctx context.Context, walletAddress string) (*Custodian, bool, error) {
+	collection := m.GetCustodianCollection()
 	var custodian Custodian
 	err := collection.FindOne(ctx, bson.M{"wallet_address": walletAddress}).Decode(&custodian)
 	if err != nil {
@@ -35,9 +79,27 @@ func GetCustodianByWalletAddress(ctx context.Context, walletAddress string) (*Cu
 	return &custodian, true, nil
 }
 
+// ListCustodianAddresses fetches the wallet address of every custodian
+func (m *Mongo) ListCustodianAddresses(ctx context.Context) ([]string, error) {
+	collection := m.GetCustodianCollection()
+	values, err := collection.Distinct(ctx, "wallet_address", bson.M{})
+	if err != nil {
+		log.Printf("Error listing custodians: %v", err)
+		return nil, errors.New("failed to list custodians")
+	}
+
+	addresses := make([]string, 0, len(values))
+	for _, value := range values {
+		if address, ok := value.(string); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
 // UpdateCustodian updates the custodian data in the database
-func UpdateCustodian(ctx context.Context, custodian *Custodian) error {
-	collection := GetCustodianCollection()
+func (m *Mongo) UpdateCustodian(ctx context.Context, custodian *Custodian) error {
+	collection := m.GetCustodianCollection()
 	filter := bson.M{"wallet_address": custodian.WalletAddress}
 	update := bson.M{"$set": custodian}
 
@@ -48,3 +110,103 @@ func UpdateCustodian(ctx context.Context, custodian *Custodian) error {
 	}
 	return nil
 }
+
+// CreditCustodian atomically adds amount to one balance, creating the custodian if needed
+func (m *Mongo) CreditCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) error {
+	collection := m.GetCustodianCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$inc": bson.M{"cryptocurrencies." + crypto: amount}}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error crediting custodian: %v", err)
+		return errors.New("failed to credit custodian")
+	}
+	return nil
+}
+
+// DebitCustodian atomically subtracts amount from one balance if the balance covers it.
+// It reports false when the balance is too low.
+func (m *Mongo) DebitCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) (bool, error) {
+	collection := m.GetCustodianCollection()
+	filter := bson.M{"wallet_address": walletAddress, "cryptocurrencies." + crypto: bson.M{"$gte": amount}}
+	update := bson.M{"$inc": bson.M{"cryptocurrencies." + crypto: -amount}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error debiting custodian: %v", err)
+		return false, errors.New("failed to debit custodian")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// TransferBalance debits the source balance, unless it is too low and the transfer doesn't
+// allow overdrafts, and credits the destination in one transaction, recording the transfer
+// in the custodian_transfer journal. It reports false when the source balance doesn't cover
+// the transfer. A reference already in the journal was applied before, so a retry succeeds
+// without moving funds again. Transactions need MongoDB to run as a replica set.
+func (m *Mongo) TransferBalance(ctx context.Context, transfer BalanceTransfer) (bool, error) {
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		log.Printf("Error starting session: %v", err)
+		return false, errors.New("failed to transfer balance")
+	}
+	defer session.EndSession(ctx)
+
+	journal := m.GetCustodianTransferCollection()
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if transfer.Reference != "" {
+			err := journal.FindOne(sc, bson.M{"reference": transfer.Reference}).Err()
+			if err == nil {
+				return nil, nil
+			}
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, err
+			}
+		}
+		_, err := journal.InsertOne(sc, custodianTransfer{
+			Reference:      transfer.Reference,
+			From:           transfer.From,
+			FromAsset:      transfer.FromAsset,
+			To:             transfer.To,
+			ToAsset:        transfer.ToAsset,
+			Amount:         transfer.Amount,
+			AllowOverdraft: transfer.AllowOverdraft,
+			CreatedAt:      time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if transfer.From != "" {
+			if transfer.AllowOverdraft {
+				if err := m.CreditCustodian(sc, transfer.From, transfer.FromAsset, -transfer.Amount); err != nil {
+					return nil, err
+				}
+			} else {
+				debited, err := m.DebitCustodian(sc, transfer.From, transfer.FromAsset, transfer.Amount)
+				if err != nil {
+					return nil, err
+				}
+				if !debited {
+					return nil, errInsufficientBalance
+				}
+			}
+		}
+		if transfer.To != "" {
+			return nil, m.CreditCustodian(sc, transfer.To, transfer.ToAsset, transfer.Amount)
+		}
+		return nil, nil
+	})
+	switch {
+	case errors.Is(err, errInsufficientBalance):
+		return false, nil
+	case mongo.IsDuplicateKeyError(err):
+		// A concurrent retry journaled the reference first
+		return true, nil
+	case err != nil:
+		log.Printf("Error transferring balance: %v", err)
+		return false, errors.New("failed to transfer balance")
+	}
+	return true, nil
+}
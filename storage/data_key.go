@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Data key purposes
+const (
+	DataKeyField      = "field"
+	DataKeyBlindIndex = "blind_index"
+)
+
+// DataKey represents a data key document in the database. The key itself is only stored
+// wrapped under a KMS master key.
+type DataKey struct {
+	ID          string    `bson:"_id"`
+	Purpose     string    `bson:"purpose"`
+	MasterKeyID string    `bson:"master_key_id"`
+	WrappedKey  []byte    `bson:"wrapped_key"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+// DataKeyRepository stores the wrapped data keys that encrypt sensitive fields
+type DataKeyRepository interface {
+	ListDataKeys(ctx context.Context) ([]DataKey, error)
+	CreateDataKey(ctx context.Context, key *DataKey) error
+	RewrapDataKey(ctx context.Context, id string, fromMasterKeyID string, toMasterKeyID string, wrappedKey []byte) (bool, error)
+}
+
+// GetDataKeyCollection returns a reference to the data_key collection
+func (m *Mongo) GetDataKeyCollection() *mongo.Collection {
+	return m.db.Collection("data_key")
+}
+
+// ListDataKeys fetches every data key
+func (m *Mongo) ListDataKeys(ctx context.Context) ([]DataKey, error) {
+	collection := m.GetDataKeyCollection()
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Error listing data keys: %v", err)
+		return nil, errors.New("failed to list data keys")
+	}
+	defer cursor.Close(ctx)
+
+	keys := []DataKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		log.Printf("Error decoding data keys: %v", err)
+		return nil, errors.New("failed to list data keys")
+	}
+	return keys, nil
+}
+
+// CreateDataKey stores a new data key. It returns ErrDuplicate when a key with the same ID
+// exists, which happens when replicas race to create it.
+func (m *Mongo) CreateDataKey(ctx context.Context, key *DataKey) error {
+	collection := m.GetDataKeyCollection()
+	if _, err := collection.InsertOne(ctx, key); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicate
+		}
+		log.Printf("Error adding data key: %v", err)
+		return errors.New("failed to add data key")
+	}
+	return nil
+}
+
+// RewrapDataKey replaces a data key's wrapping, provided it is still wrapped under
+// fromMasterKeyID. It reports false when another replica rewrapped it first.
+func (m *Mongo) RewrapDataKey(ctx context.Context, id string, fromMasterKeyID string, toMasterKeyID string, wrappedKey []byte) (bool, error) {
+	collection := m.GetDataKeyCollection()
+	filter := bson.M{"_id": id, "master_key_id": fromMasterKeyID}
+	update := bson.M{"$set": bson.M{"master_key_id": toMasterKeyID, "wrapped_key": wrappedKey}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error rewrapping data key: %v", err)
+		return false, errors.New("failed to rewrap data key")
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Deposit statuses
+const (
+	DepositPending  = "pending"
+	DepositCredited = "credited"
+	DepositOrphaned = "orphaned"
+	DepositReversed = "reversed"
+)
+
+// Deposit represents an on-chain payment to a custodial address. A deposit is identified by
+// network, transaction hash and index, and Amount is in the asset's base units.
+type Deposit struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Network       string             `bson:"network" json:"network"`
+	TxHash        string             `bson:"tx_hash" json:"tx_hash"`
+	Index         int                `bson:"index" json:"index"`
+	FromAddress   string             `bson:"from_address" json:"from_address"`
+	ToAddress     string             `bson:"to_address" json:"to_address"`
+	WalletAddress string             `bson:"wallet_address" json:"wallet_address"`
+	Asset         string             `bson:"asset" json:"asset"`
+	Amount        string             `bson:"amount" json:"amount"`
+	AmountUSD     float64            `bson:"amount_usd" json:"amount_usd"`
+	Block         int64              `bson:"block" json:"block"`
+	Confirmations int64              `bson:"confirmations" json:"confirmations"`
+	Status        string             `bson:"status" json:"status"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	CreditedAt    time.Time          `bson:"credited_at,omitempty" json:"credited_at,omitempty"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// DepositFields are the fields TransitionDeposit may set along with the status. Nil fields
+// are left unchanged.
+type DepositFields struct {
+	Confirmations *int64
+	CreditedAt    *time.Time
+}
+
+// set adds the fields that are present to a $set update
+func (fields DepositFields) set(set bson.M) {
+	if fields.Confirmations != nil {
+		set["confirmations"] = *fields.Confirmations
+	}
+	if fields.CreditedAt != nil {
+		set["credited_at"] = *fields.CreditedAt
+	}
+}
+
+// DepositRepository stores on-chain deposits and the block scan cursor of each network
+type DepositRepository interface {
+	InsertDepositIfMissing(ctx context.Context, deposit *Deposit) (bool, error)
+	ReviveDeposit(ctx context.Context, network string, txHash string, index int, block int64) (bool, error)
+	ListUnsettledDeposits(ctx context.Context, network string, final int64) ([]Deposit, error)
+	ListDepositsForWallet(ctx context.Context, walletAddress string) ([]Deposit, error)
+	TransitionDeposit(ctx context.Context, id primitive.ObjectID, from string, to string, fields DepositFields) (bool, error)
+	GetScanCursor(ctx context.Context, network string) (int64, bool, error)
+	SetScanCursor(ctx context.Context, network string, nextBlock int64) error
+}
+
+// GetDepositCollection returns a reference to the deposit collection
+func (m *Mongo) GetDepositCollection() *mongo.Collection {
+	return m.db.Collection("deposit")
+}
+
+// InsertDepositIfMissing stores a newly seen deposit. It reports false when the deposit
+// was already recorded.
+func (m *Mongo) InsertDepositIfMissing(ctx context.Context, deposit *Deposit) (bool, error) {
+	collection := m.GetDepositCollection()
+	filter := bson.M{"network": deposit.Network, "tx_hash": deposit.TxHash, "index": deposit.Index}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": deposit}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error adding deposit: %v", err)
+		return false, errors.New("failed to add deposit")
+	}
+	if id, ok := result.UpsertedID.(primitive.ObjectID); ok {
+		deposit.ID = id
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// ReviveDeposit returns an orphaned or reversed deposit to pending when a reorg includes it
+// in a new block
+func (m *Mongo) ReviveDeposit(ctx context.Context, network string, txHash string, index int, block int64) (bool, error) {
+	collection := m.GetDepositCollection()
+	filter := bson.M{"network": network, "tx_hash": txHash, "index": index, "status": bson.M{"$in": []string{DepositOrphaned, DepositReversed}}}
+	update := bson.M{"$set": bson.M{"status": DepositPending, "block": block, "confirmations": 0, "updated_at": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error reviving deposit: %v", err)
+		return false, errors.New("failed to revive deposit")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ListUnsettledDeposits fetches a network's pending deposits and its credited deposits with
+// fewer than final confirmations, which a reorg could still undo
+func (m *Mongo) ListUnsettledDeposits(ctx context.Context, network string, final int64) ([]Deposit, error) {
+	filter := bson.M{"network": network, "$or": []bson.M{
+		{"status": DepositPending},
+		{"status": DepositCredited, "confirmations": bson.M{"$lt": final}},
+	}}
+	return m.listDeposits(ctx, filter)
+}
+
+// ListDepositsForWallet fetches the deposits to a wallet, newest first
+func (m *Mongo) ListDepositsForWallet(ctx context.Context, walletAddress string) ([]Deposit, error) {
+	return m.listDeposits(ctx, bson.M{"wallet_address": walletAddress})
+}
+
+func (m *Mongo) listDeposits(ctx context.Context, filter bson.M) ([]Deposit, error) {
+	collection := m.GetDepositCollection()
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		log.Printf("Error listing deposits: %v", err)
+		return nil, errors.New("failed to list deposits")
+	}
+	defer cursor.Close(ctx)
+
+	deposits := []Deposit{}
+	if err := cursor.All(ctx, &deposits); err != nil {
+		log.Printf("Error decoding deposits: %v", err)
+		return nil, errors.New("failed to list deposits")
+	}
+	return deposits, nil
+}
+
+// TransitionDeposit moves a deposit from one status to another, setting the given fields.
+// It reports false when the deposit was no longer in the from status.
+func (m *Mongo) TransitionDeposit(ctx context.Context, id primitive.ObjectID, from string, to string, fields DepositFields) (bool, error) {
+	collection := m.GetDepositCollection()
+	set := bson.M{"status": to, "updated_at": time.Now()}
+	fields.set(set)
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": set})
+	if err != nil {
+		log.Printf("Error updating deposit: %v", err)
+		return false, errors.New("failed to update deposit")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// GetScanCursor returns the next block to scan for deposits on a network
+func (m *Mongo) GetScanCursor(ctx context.Context, network string) (int64, bool, error) {
+	collection := m.db.Collection("scan_cursor")
+	var cursor struct {
+		NextBlock int64 `bson:"next_block"`
+	}
+	err := collection.FindOne(ctx, bson.M{"_id": network}).Decode(&cursor)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, false, nil
+		}
+		log.Printf("Error fetching scan cursor: %v", err)
+		return 0, false, errors.New("failed to fetch scan cursor")
+	}
+	return cursor.NextBlock, true, nil
+}
+
+// SetScanCursor records the next block to scan for deposits on a network
+func (m *Mongo) SetScanCursor(ctx context.Context, network string, nextBlock int64) error {
+	collection := m.db.Collection("scan_cursor")
+	update := bson.M{"$set": bson.M{"next_block": nextBlock, "updated_at": time.Now()}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": network}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error updating scan cursor: %v", err)
+		return errors.New("failed to update scan cursor")
+	}
+	return nil
+}
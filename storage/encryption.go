@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Sealed fields, named by collection and field. The name is authenticated with each value,
+// and is the same in every backend.
+const (
+	FieldSmsServicePhone   = "sms_service.phone_number"
+	FieldSmsServicePasskey = "sms_service.passkey"
+	FieldTwoFactorPhone    = "2fa.phone_number"
+	FieldTwoFactorCode     = "2fa.code"
+	FieldTwoFactorStored   = "2fa.2fa_code"
+
+	FieldTransactionSenderPhone       = "transaction.sender_phone"
+	FieldTransactionRecipientPhone    = "transaction.recipient_phone"
+	FieldEscrowSenderPhone            = "escrow.sender_phone"
+	FieldEscrowRecipientPhone         = "escrow.recipient_phone"
+	FieldPaymentRequestRequesterPhone = "payment_request.requester_phone"
+	FieldPaymentRequestPayerPhone     = "payment_request.payer_phone"
+	FieldPendingTransferSenderPhone   = "pending_transfer.sender_phone"
+	FieldPendingTransferGuardian      = "pending_transfer.guardians"
+	FieldGuardianDecisionPhone        = "pending_transfer.decisions.phone_number"
+	FieldRiskDecisionSenderPhone      = "risk_decision.sender_phone"
+	FieldRiskChallengeSenderPhone     = "risk_challenge.sender_phone"
+	FieldWithdrawalSenderPhone        = "withdrawal.sender_phone"
+	FieldSmsSessionPhone              = "sms_session.phone_number"
+	FieldAuthFailurePhone             = "auth_failure.phone_number"
+)
+
+// SecretRepository keeps the encryption of sensitive fields current
+type SecretRepository interface {
+	ReencryptSecrets(ctx context.Context) (int, error)
+}
+
+// ReencryptSecrets rewraps every data key under the current master key and reseals every
+// phone number, passkey and 2FA code in any collection that isn't sealed under the active data key, including
+// values written before fields were encrypted. It returns how many records it resealed.
+func (m *Mongo) ReencryptSecrets(ctx context.Context) (int, error) {
+	if _, err := m.cipher.RewrapDataKeys(ctx); err != nil {
+		log.Printf("Error rewrapping data keys: %v", err)
+		return 0, errors.New("failed to rewrap data keys")
+	}
+	resealed, err := m.resealSmsServices(ctx)
+	if err != nil {
+		return resealed, err
+	}
+	for _, sealed := range m.sealedCollections() {
+		count, err := m.resealCollection(ctx, sealed.collection, sealed.keys)
+		resealed += count
+		if err != nil {
+			return resealed, err
+		}
+	}
+	transfers, err := m.resealPendingTransfers(ctx)
+	return resealed + transfers, err
+}
+
+// staleFilter matches documents with any of fields set to something other than a value
+// sealed under the active data key
+func (m *Mongo) staleFilter(fields ...string) bson.M {
+	current := primitive.Regex{Pattern: "^(" + regexp.QuoteMeta(sealedPrefix+m.cipher.activeKeyID()+":") + "|$)"}
+	stale := make([]bson.M, len(fields))
+	for i, field := range fields {
+		stale[i] = bson.M{field: bson.M{"$not": current}}
+	}
+	return bson.M{"$or": stale}
+}
+
+// sealRecord seals a record's sealed fields in place. Callers seal a copy, so the record
+// they were given keeps its plaintext.
+func (m *Mongo) sealRecord(kind string, record Sealable) error {
+	if err := m.cipher.SealFields(record.SealedFields()...); err != nil {
+		log.Printf("Error sealing %s: %v", kind, err)
+		return errors.New("failed to seal " + kind)
+	}
+	return nil
+}
+
+// openRecord opens the sealed fields of a decoded record in place
+func (m *Mongo) openRecord(ctx context.Context, kind string, record Sealable) error {
+	if err := m.cipher.OpenFields(ctx, record.SealedFields()...); err != nil {
+		log.Printf("Error opening %s: %v", kind, err)
+		return errors.New("failed to decrypt " + kind)
+	}
+	return nil
+}
+
+// openRecords opens the sealed fields of each decoded record in place
+func openRecords[T any, P interface {
+	*T
+	Sealable
+}](ctx context.Context, m *Mongo, kind string, records []T) error {
+	for i := range records {
+		if err := m.openRecord(ctx, kind, P(&records[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reopen opens a sealed value, passing through values written before fields were encrypted
+func (m *Mongo) reopen(ctx context.Context, field string, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	return m.cipher.Open(ctx, field, value)
+}
+
+func (m *Mongo) resealSmsServices(ctx context.Context) (int, error) {
+	collection := m.GetSmsServiceCollection()
+	cursor, err := collection.Find(ctx, m.staleFilter("phone_number", "passkey"))
+	if err != nil {
+		log.Printf("Error listing SMS services to reseal: %v", err)
+		return 0, errors.New("failed to reseal SMS services")
+	}
+	defer cursor.Close(ctx)
+
+	resealed := 0
+	for cursor.Next(ctx) {
+		var stored struct {
+			WalletAddress string `bson:"wallet_address"`
+			PhoneNumber   string `bson:"phone_number"`
+			Passkey       string `bson:"passkey"`
+		}
+		if err := cursor.Decode(&stored); err != nil {
+			log.Printf("Error decoding SMS service: %v", err)
+			return resealed, errors.New("failed to reseal SMS services")
+		}
+		phoneNumber, err := m.reopen(ctx, FieldSmsServicePhone, stored.PhoneNumber)
+		if err != nil {
+			log.Printf("Error opening phone number of %s: %v", stored.WalletAddress, err)
+			return resealed, errors.New("failed to reseal SMS services")
+		}
+		passkey, err := m.reopen(ctx, FieldSmsServicePasskey, stored.Passkey)
+		if err != nil {
+			log.Printf("Error opening passkey of %s: %v", stored.WalletAddress, err)
+			return resealed, errors.New("failed to reseal SMS services")
+		}
+		update, err := m.sealedSmsServiceFields(phoneNumber, passkey)
+		if err != nil {
+			return resealed, err
+		}
+
+		// Records changed since they were read are left for the next run
+		filter := bson.M{"wallet_address": stored.WalletAddress, "phone_number": stored.PhoneNumber, "passkey": stored.Passkey}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Printf("Error resealing SMS service: %v", err)
+			return resealed, errors.New("failed to reseal SMS services")
+		}
+		resealed += int(result.ModifiedCount)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error listing SMS services to reseal: %v", err)
+		return resealed, errors.New("failed to reseal SMS services")
+	}
+	return resealed, nil
+}
+
+// sealedSmsServiceFields builds the update that stores a wallet's phone number and passkey
+// sealed, with the phone number's blind index
+func (m *Mongo) sealedSmsServiceFields(phoneNumber string, passkey string) (bson.M, error) {
+	sealedPhone, err := m.cipher.Seal(FieldSmsServicePhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return nil, errors.New("failed to seal phone number")
+	}
+	sealedPasskey, err := m.cipher.Seal(FieldSmsServicePasskey, passkey)
+	if err != nil {
+		log.Printf("Error sealing passkey: %v", err)
+		return nil, errors.New("failed to seal passkey")
+	}
+	update := bson.M{"$set": bson.M{"phone_number": sealedPhone, "passkey": sealedPasskey}}
+	if phoneNumber == "" {
+		update["$unset"] = bson.M{"phone_number_index": ""}
+	} else {
+		update["$set"].(bson.M)["phone_number_index"] = m.cipher.BlindIndex(phoneNumber)
+	}
+	return update, nil
+}
+
+// sealedKey is a top-level field of a collection that is stored sealed. Index names the
+// field holding its blind index, when lookups match on it.
+type sealedKey struct {
+	field string
+	key   string
+	index string
+}
+
+// sealedCollections lists the collections whose sealed fields are all top-level strings
+func (m *Mongo) sealedCollections() []struct {
+	collection *mongo.Collection
+	keys       []sealedKey
+} {
+	return []struct {
+		collection *mongo.Collection
+		keys       []sealedKey
+	}{
+		{m.GetTwoFactorAuthCollection(), []sealedKey{
+			{FieldTwoFactorPhone, "phone_number", "phone_number_index"},
+			{FieldTwoFactorCode, "code", ""},
+			{FieldTwoFactorStored, "2fa_code", ""},
+		}},
+		{m.GetTransactionCollection(), []sealedKey{
+			{FieldTransactionSenderPhone, "sender_phone", ""},
+			{FieldTransactionRecipientPhone, "recipient_phone", "recipient_phone_index"},
+		}},
+		{m.GetEscrowCollection(), []sealedKey{
+			{FieldEscrowSenderPhone, "sender_phone", ""},
+			{FieldEscrowRecipientPhone, "recipient_phone", "recipient_phone_index"},
+		}},
+		{m.GetPaymentRequestCollection(), []sealedKey{
+			{FieldPaymentRequestRequesterPhone, "requester_phone", ""},
+			{FieldPaymentRequestPayerPhone, "payer_phone", "payer_phone_index"},
+		}},
+		{m.GetRiskDecisionCollection(), []sealedKey{{FieldRiskDecisionSenderPhone, "sender_phone", ""}}},
+		{m.GetRiskChallengeCollection(), []sealedKey{{FieldRiskChallengeSenderPhone, "sender_phone", "sender_phone_index"}}},
+		{m.GetWithdrawalCollection(), []sealedKey{{FieldWithdrawalSenderPhone, "sender_phone", ""}}},
+		{m.GetSmsSessionCollection(), []sealedKey{{FieldSmsSessionPhone, "phone_number", "phone_number_index"}}},
+		{m.GetAuthFailureCollection(), []sealedKey{{FieldAuthFailurePhone, "phone_number", "phone_number_index"}}},
+	}
+}
+
+// resealCollection reseals the stale fields of a collection's documents, setting the blind
+// index of each indexed field
+func (m *Mongo) resealCollection(ctx context.Context, collection *mongo.Collection, keys []sealedKey) (int, error) {
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = key.key
+	}
+	cursor, err := collection.Find(ctx, m.staleFilter(fields...))
+	if err != nil {
+		log.Printf("Error listing %s to reseal: %v", collection.Name(), err)
+		return 0, errors.New("failed to reseal " + collection.Name())
+	}
+	defer cursor.Close(ctx)
+
+	resealed := 0
+	for cursor.Next(ctx) {
+		var stored bson.M
+		if err := cursor.Decode(&stored); err != nil {
+			log.Printf("Error decoding %s: %v", collection.Name(), err)
+			return resealed, errors.New("failed to reseal " + collection.Name())
+		}
+
+		filter := bson.M{"_id": stored["_id"]}
+		set, unset := bson.M{}, bson.M{}
+		for _, key := range keys {
+			value, _ := stored[key.key].(string)
+			plaintext, err := m.reopen(ctx, key.field, value)
+			if err != nil {
+				log.Printf("Error opening %s: %v", key.field, err)
+				return resealed, errors.New("failed to reseal " + collection.Name())
+			}
+			if set[key.key], err = m.cipher.Seal(key.field, plaintext); err != nil {
+				log.Printf("Error sealing %s: %v", key.field, err)
+				return resealed, errors.New("failed to reseal " + collection.Name())
+			}
+			if key.index != "" && plaintext != "" {
+				set[key.index] = m.cipher.BlindIndex(plaintext)
+			} else if key.index != "" {
+				unset[key.index] = ""
+			}
+			// Records changed since they were read are left for the next run. A missing
+			// field decodes as empty, and only matches null.
+			filter[key.key] = value
+			if value == "" {
+				filter[key.key] = bson.M{"$in": bson.A{"", nil}}
+			}
+		}
+
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Printf("Error resealing %s: %v", collection.Name(), err)
+			return resealed, errors.New("failed to reseal " + collection.Name())
+		}
+		resealed += int(result.ModifiedCount)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error listing %s to reseal: %v", collection.Name(), err)
+		return resealed, errors.New("failed to reseal " + collection.Name())
+	}
+	return resealed, nil
+}
+
+// resealPendingTransfers reseals the phone numbers of pending transfers, which include the
+// guardian and decision arrays, and sets their blind indexes
+func (m *Mongo) resealPendingTransfers(ctx context.Context) (int, error) {
+	collection := m.GetPendingTransferCollection()
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Error listing pending transfers to reseal: %v", err)
+		return 0, errors.New("failed to reseal pending transfers")
+	}
+	defer cursor.Close(ctx)
+
+	resealed := 0
+	for cursor.Next(ctx) {
+		var transfer PendingTransfer
+		if err := cursor.Decode(&transfer); err != nil {
+			log.Printf("Error decoding pending transfer: %v", err)
+			return resealed, errors.New("failed to reseal pending transfers")
+		}
+		stale := false
+		for _, field := range transfer.SealedFields() {
+			stale = stale || !m.cipher.IsCurrent(*field.Value)
+		}
+		if !stale {
+			continue
+		}
+
+		// Decisions are only ever added, so a changed count means the record changed since
+		// it was read, and is left for the next run
+		filter := bson.M{
+			"_id":          transfer.ID,
+			"sender_phone": transfer.SenderPhone,
+			"guardians":    slices.Clone(transfer.Guardians),
+			"decisions":    bson.M{"$size": len(transfer.Decisions)},
+		}
+		for _, field := range transfer.SealedFields() {
+			if *field.Value, err = m.reopen(ctx, field.Field, *field.Value); err != nil {
+				log.Printf("Error opening %s of pending transfer %s: %v", field.Field, transfer.ID.Hex(), err)
+				return resealed, errors.New("failed to reseal pending transfers")
+			}
+		}
+		guardianIndexes, decisionIndexes := []string{}, []string{}
+		for _, guardian := range transfer.Guardians {
+			guardianIndexes = append(guardianIndexes, m.cipher.BlindIndex(guardian))
+		}
+		for _, decision := range transfer.Decisions {
+			decisionIndexes = append(decisionIndexes, m.cipher.BlindIndex(decision.PhoneNumber))
+		}
+		if err := m.sealRecord("pending transfer", &transfer); err != nil {
+			return resealed, err
+		}
+
+		update := bson.M{"$set": bson.M{
+			"sender_phone":     transfer.SenderPhone,
+			"guardians":        transfer.Guardians,
+			"decisions":        transfer.Decisions,
+			"guardian_indexes": guardianIndexes,
+			"decision_indexes": decisionIndexes,
+		}}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Printf("Error resealing pending transfer: %v", err)
+			return resealed, errors.New("failed to reseal pending transfers")
+		}
+		resealed += int(result.ModifiedCount)
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error listing pending transfers to reseal: %v", err)
+		return resealed, errors.New("failed to reseal pending transfers")
+	}
+	return resealed, nil
+}
+
+// dropIndex drops a collection's index by name, if it exists
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+		var commandErr mongo.CommandError
+		if errors.As(err, &commandErr) && commandErr.Name == "IndexNotFound" {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
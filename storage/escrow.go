@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Escrow statuses
+const (
+	EscrowHeld     = "held"
+	EscrowClaimed  = "claimed"
+	EscrowRefunded = "refunded"
+)
+
+// Escrow represents funds held for a recipient who has not registered yet.
+// Exactly one of RecipientPhone and RecipientAddress is set.
+type Escrow struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SenderAddress    string             `bson:"sender_address" json:"sender_address"`
+	SenderPhone      string             `bson:"sender_phone" json:"sender_phone"`
+	RecipientPhone   string             `bson:"recipient_phone,omitempty" json:"recipient_phone,omitempty"`
+	RecipientAddress string             `bson:"recipient_address,omitempty" json:"recipient_address,omitempty"`
+	Crypto           string             `bson:"crypto" json:"crypto"`
+	RecipientCrypto  string             `bson:"recipient_crypto" json:"recipient_crypto"`
+	AmountUSD        float64            `bson:"amount_usd" json:"amount_usd"`
+	ClaimCode        string             `bson:"claim_code" json:"-"`
+	Status           string             `bson:"status" json:"status"`
+	ClaimedBy        string             `bson:"claimed_by,omitempty" json:"claimed_by,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expires_at"`
+	ResolvedAt       time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+}
+
+// SealedFields returns the escrow's phone numbers, which are stored sealed
+func (e *Escrow) SealedFields() []SealedField {
+	return []SealedField{
+		{FieldEscrowSenderPhone, &e.SenderPhone},
+		{FieldEscrowRecipientPhone, &e.RecipientPhone},
+	}
+}
+
+// sealedEscrow is an Escrow as stored, with the blind index of its recipient phone, which
+// claims match on
+type sealedEscrow struct {
+	Escrow              `bson:",inline"`
+	RecipientPhoneIndex string `bson:"recipient_phone_index,omitempty"`
+}
+
+// EscrowRepository stores escrows held for unregistered recipients
+type EscrowRepository interface {
+	CreateEscrow(ctx context.Context, escrow *Escrow) error
+	GetHeldEscrowByClaimCode(ctx context.Context, recipientPhone string, claimCode string) (*Escrow, bool, error)
+	ListHeldEscrowsForPhone(ctx context.Context, recipientPhone string) ([]Escrow, error)
+	ListHeldEscrowsForAddress(ctx context.Context, recipientAddress string) ([]Escrow, error)
+	ListExpiredEscrows(ctx context.Context, now time.Time) ([]Escrow, error)
+	ListResolvedEscrows(ctx context.Context) ([]Escrow, error)
+	ResolveEscrow(ctx context.Context, id primitive.ObjectID, status string, claimedBy string) (bool, error)
+	ReopenEscrow(ctx context.Context, id primitive.ObjectID, status string) (bool, error)
+}
+
+// GetEscrowCollection returns a reference to the escrow collection
+func (m *Mongo) GetEscrowCollection() *mongo.Collection {
+	return m.db.Collection("escrow")
+}
+
+// CreateEscrow stores a new held escrow
+func (m *Mongo) CreateEscrow(ctx context.Context, escrow *Escrow) error {
+	collection := m.GetEscrowCollection()
+	sealed := sealedEscrow{Escrow: *escrow, RecipientPhoneIndex: m.cipher.BlindIndex(escrow.RecipientPhone)}
+	if err := m.sealRecord("escrow", &sealed.Escrow); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding escrow: %v", err)
+		return errors.New("failed to add escrow")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		escrow.ID = id
+	}
+	return nil
+}
+
+// GetHeldEscrowByClaimCode fetches the held escrow for a phone number and claim code
+func (m *Mongo) GetHeldEscrowByClaimCode(ctx context.Context, recipientPhone string, claimCode string) (*Escrow, bool, error) {
+	collection := m.GetEscrowCollection()
+	var escrow Escrow
+	filter := bson.M{"recipient_phone_index": m.cipher.BlindIndex(recipientPhone), "claim_code": claimCode, "status": EscrowHeld}
+	err := collection.FindOne(ctx, filter).Decode(&escrow)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching escrow: %v", err)
+		return nil, false, errors.New("failed to fetch escrow")
+	}
+	if err := m.openRecord(ctx, "escrow", &escrow); err != nil {
+		return nil, false, err
+	}
+	return &escrow, true, nil
+}
+
+// ListHeldEscrowsForPhone fetches the held escrows waiting for a phone number
+func (m *Mongo) ListHeldEscrowsForPhone(ctx context.Context, recipientPhone string) ([]Escrow, error) {
+	return m.listHeldEscrows(ctx, bson.M{"recipient_phone_index": m.cipher.BlindIndex(recipientPhone)})
+}
+
+// ListHeldEscrowsForAddress fetches the held escrows waiting for a wallet address
+func (m *Mongo) ListHeldEscrowsForAddress(ctx context.Context, recipientAddress string) ([]Escrow, error) {
+	return m.listHeldEscrows(ctx, bson.M{"recipient_address": recipientAddress})
+}
+
+// ListExpiredEscrows fetches the held escrows whose expiry has passed
+func (m *Mongo) ListExpiredEscrows(ctx context.Context, now time.Time) ([]Escrow, error) {
+	return m.listHeldEscrows(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+}
+
+func (m *Mongo) listHeldEscrows(ctx context.Context, filter bson.M) ([]Escrow, error) {
+	collection := m.GetEscrowCollection()
+	query := bson.M{"status": EscrowHeld}
+	for key, value := range filter {
+		query[key] = value
+	}
+	cursor, err := collection.Find(ctx, query)
+	if err != nil {
+		log.Printf("Error listing escrows: %v", err)
+		return nil, errors.New("failed to list escrows")
+	}
+	defer cursor.Close(ctx)
+
+	var escrows []Escrow
+	if err := cursor.All(ctx, &escrows); err != nil {
+		log.Printf("Error decoding escrows: %v", err)
+		return nil, errors.New("failed to list escrows")
+	}
+	if err := openRecords(ctx, m, "escrows", escrows); err != nil {
+		return nil, err
+	}
+	return escrows, nil
+}
+
+// ListResolvedEscrows fetches every claimed or refunded escrow
+func (m *Mongo) ListResolvedEscrows(ctx context.Context) ([]Escrow, error) {
+	collection := m.GetEscrowCollection()
+	cursor, err := collection.Find(ctx, bson.M{"status": bson.M{"$in": []string{EscrowClaimed, EscrowRefunded}}})
+	if err != nil {
+		log.Printf("Error listing escrows: %v", err)
+		return nil, errors.New("failed to list escrows")
+	}
+	defer cursor.Close(ctx)
+
+	var escrows []Escrow
+	if err := cursor.All(ctx, &escrows); err != nil {
+		log.Printf("Error decoding escrows: %v", err)
+		return nil, errors.New("failed to list escrows")
+	}
+	if err := openRecords(ctx, m, "escrows", escrows); err != nil {
+		return nil, err
+	}
+	return escrows, nil
+}
+
+// ResolveEscrow moves a held escrow to claimed or refunded. It reports false when the
+// escrow was already resolved, so concurrent claims and refunds cannot both succeed.
+func (m *Mongo) ResolveEscrow(ctx context.Context, id primitive.ObjectID, status string, claimedBy string) (bool, error) {
+	collection := m.GetEscrowCollection()
+	filter := bson.M{"_id": id, "status": EscrowHeld}
+	update := bson.M{"$set": bson.M{"status": status, "claimed_by": claimedBy, "resolved_at": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error resolving escrow: %v", err)
+		return false, errors.New("failed to resolve escrow")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ReopenEscrow moves an escrow resolved to status back to held, for a release or refund
+// whose transfer failed. It reports false when the escrow isn't in that status.
+func (m *Mongo) ReopenEscrow(ctx context.Context, id primitive.ObjectID, status string) (bool, error) {
+	collection := m.GetEscrowCollection()
+	filter := bson.M{"_id": id, "status": status}
+	update := bson.M{"$set": bson.M{"status": EscrowHeld}, "$unset": bson.M{"claimed_by": "", "resolved_at": ""}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error reopening escrow: %v", err)
+		return false, errors.New("failed to reopen escrow")
+	}
+	return result.ModifiedCount > 0, nil
+}
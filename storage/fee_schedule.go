@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FeeSchedule represents a versioned set of fee rules in the database.
+// Schedules are never edited; a change inserts a new version.
+type FeeSchedule struct {
+	Version   int       `bson:"version" json:"version"`
+	Rules     []FeeRule `bson:"rules" json:"rules"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// FeeRule prices transfers of one asset, one corridor (asset to recipient asset), or
+// all transfers when both are empty. A network limits the rule to transfers paid out
+// to external addresses on that network.
+type FeeRule struct {
+	Asset          string    `bson:"asset,omitempty" json:"asset,omitempty"`
+	RecipientAsset string    `bson:"recipient_asset,omitempty" json:"recipient_asset,omitempty"`
+	Network        string    `bson:"network,omitempty" json:"network,omitempty"`
+	FlatUSD        float64   `bson:"flat_usd" json:"flat_usd"`
+	Percent        float64   `bson:"percent" json:"percent"`
+	Tiers          []FeeTier `bson:"tiers,omitempty" json:"tiers,omitempty"`
+	MinUSD         float64   `bson:"min_usd" json:"min_usd"`
+	MaxUSD         float64   `bson:"max_usd" json:"max_usd"`
+}
+
+// FeeTier overrides the flat and percentage fee for amounts up to UpToUSD
+type FeeTier struct {
+	UpToUSD float64 `bson:"up_to_usd" json:"up_to_usd"`
+	FlatUSD float64 `bson:"flat_usd" json:"flat_usd"`
+	Percent float64 `bson:"percent" json:"percent"`
+}
+
+// FeeScheduleRepository stores the versioned fee schedules
+type FeeScheduleRepository interface {
+	GetActiveFeeSchedule(ctx context.Context) (*FeeSchedule, bool, error)
+	CreateFeeSchedule(ctx context.Context, rules []FeeRule) (*FeeSchedule, error)
+	ListFeeSchedules(ctx context.Context) ([]FeeSchedule, error)
+}
+
+// GetFeeScheduleCollection returns a reference to the fee_schedule collection
+func (m *Mongo) GetFeeScheduleCollection() *mongo.Collection {
+	return m.db.Collection("fee_schedule")
+}
+
+// GetActiveFeeSchedule fetches the fee schedule with the highest version
+func (m *Mongo) GetActiveFeeSchedule(ctx context.Context) (*FeeSchedule, bool, error) {
+	collection := m.GetFeeScheduleCollection()
+	var schedule FeeSchedule
+	opts := options.FindOne().SetSort(bson.M{"version": -1})
+	err := collection.FindOne(ctx, bson.M{}, opts).Decode(&schedule)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching fee schedule: %v", err)
+		return nil, false, errors.New("failed to fetch fee schedule")
+	}
+	return &schedule, true, nil
+}
+
+// feeScheduleAttempts bounds how often CreateFeeSchedule retries a version taken by a
+// concurrent create
+const feeScheduleAttempts = 5
+
+// CreateFeeSchedule stores rules as the next fee schedule version. A create that loses the
+// version to a concurrent one retries with the following version.
+func (m *Mongo) CreateFeeSchedule(ctx context.Context, rules []FeeRule) (*FeeSchedule, error) {
+	collection := m.GetFeeScheduleCollection()
+	for attempt := 1; ; attempt++ {
+		current, exists, err := m.GetActiveFeeSchedule(ctx)
+		if err != nil {
+			return nil, err
+		}
+		schedule := &FeeSchedule{Version: 1, Rules: rules, CreatedAt: time.Now()}
+		if exists {
+			schedule.Version = current.Version + 1
+		}
+
+		_, err = collection.InsertOne(ctx, schedule)
+		if err == nil {
+			return schedule, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == feeScheduleAttempts {
+			log.Printf("Error adding fee schedule: %v", err)
+			return nil, errors.New("failed to add fee schedule")
+		}
+	}
+}
+
+// ListFeeSchedules fetches every fee schedule version, newest first
+func (m *Mongo) ListFeeSchedules(ctx context.Context) ([]FeeSchedule, error) {
+	collection := m.GetFeeScheduleCollection()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"version": -1}))
+	if err != nil {
+		log.Printf("Error listing fee schedules: %v", err)
+		return nil, errors.New("failed to list fee schedules")
+	}
+	defer cursor.Close(ctx)
+
+	schedules := []FeeSchedule{}
+	if err := cursor.All(ctx, &schedules); err != nil {
+		log.Printf("Error decoding fee schedules: %v", err)
+		return nil, errors.New("failed to list fee schedules")
+	}
+	return schedules, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lease represents a named leadership lease in the database
+type Lease struct {
+	Name      string    `bson:"_id"`
+	Holder    string    `bson:"holder"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// LeaseRepository stores leadership leases
+type LeaseRepository interface {
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// GetLeaseCollection returns a reference to the lease collection
+func (m *Mongo) GetLeaseCollection() *mongo.Collection {
+	return m.db.Collection("lease")
+}
+
+// AcquireLease takes or renews the named lease for holder. It reports false while another
+// holder's lease is unexpired.
+func (m *Mongo) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	collection := m.GetLeaseCollection()
+	now := time.Now()
+	filter := bson.M{"_id": name, "$or": []bson.M{{"holder": holder}, {"expires_at": bson.M{"$lte": now}}}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		// The upsert collides with the live lease's _id when someone else holds it
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		log.Printf("Error acquiring lease: %v", err)
+		return false, errors.New("failed to acquire lease")
+	}
+	return true, nil
+}
+
+// ReleaseLease gives up the named lease if holder still holds it
+func (m *Mongo) ReleaseLease(ctx context.Context, name string, holder string) error {
+	collection := m.GetLeaseCollection()
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	if err != nil {
+		log.Printf("Error releasing lease: %v", err)
+		return errors.New("failed to release lease")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limit change statuses
+const (
+	LimitChangePending   = "pending"
+	LimitChangeApplied   = "applied"
+	LimitChangeCancelled = "cancelled"
+)
+
+// Limit change kinds. Changes recorded before rolling limits could be scheduled have no kind
+// and raise the per-transaction limit.
+const (
+	LimitChangeKindLimit     = "limit"
+	LimitChangeKindVelocity  = "velocity_limits"
+	LimitChangeKindGuardians = "guardians"
+)
+
+// LimitChange represents a scheduled loosening of a wallet's spending limits in the
+// database: a per-transaction limit increase, a replacement of the rolling limits that
+// raises or removes one of them, or a guardian policy that weakens or removes the current one
+type LimitChange struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WalletAddress  string             `bson:"wallet_address" json:"wallet_address"`
+	Kind           string             `bson:"kind,omitempty" json:"kind"`
+	OldLimit       float64            `bson:"old_limit" json:"old_limit"`
+	NewLimit       float64            `bson:"new_limit" json:"new_limit"`
+	VelocityLimits []VelocityLimit    `bson:"velocity_limits,omitempty" json:"velocity_limits,omitempty"`
+	Guardians      *GuardianPolicy    `bson:"guardians,omitempty" json:"guardians,omitempty"`
+	CancelCode     string             `bson:"cancel_code" json:"-"`
+	Status         string             `bson:"status" json:"status"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	EffectiveAt    time.Time          `bson:"effective_at" json:"effective_at"`
+}
+
+// ChangeKind returns the change's kind, treating changes without one as limit increases
+func (c LimitChange) ChangeKind() string {
+	if c.Kind == "" {
+		return LimitChangeKindLimit
+	}
+	return c.Kind
+}
+
+// LimitChangeRepository stores scheduled spending limit changes
+type LimitChangeRepository interface {
+	CreateLimitChange(ctx context.Context, change *LimitChange) error
+	ListPendingLimitChanges(ctx context.Context, walletAddress string) ([]LimitChange, error)
+	SetLimitChangeStatus(ctx context.Context, id primitive.ObjectID, status string) error
+	CancelLimitChangeByCode(ctx context.Context, walletAddress string, cancelCode string) (bool, error)
+}
+
+// GetLimitChangeCollection returns a reference to the limit_change collection
+func (m *Mongo) GetLimitChangeCollection() *mongo.Collection {
+	return m.db.Collection("limit_change")
+}
+
+// CreateLimitChange stores a new pending limit change
+func (m *Mongo) CreateLimitChange(ctx context.Context, change *LimitChange) error {
+	collection := m.GetLimitChangeCollection()
+	result, err := collection.InsertOne(ctx, change)
+	if err != nil {
+		log.Printf("Error adding limit change: %v", err)
+		return errors.New("failed to add limit change")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		change.ID = id
+	}
+	return nil
+}
+
+// ListPendingLimitChanges fetches the pending limit changes for a wallet address, oldest first
+func (m *Mongo) ListPendingLimitChanges(ctx context.Context, walletAddress string) ([]LimitChange, error) {
+	collection := m.GetLimitChangeCollection()
+	filter := bson.M{"wallet_address": walletAddress, "status": LimitChangePending}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"effective_at": 1}))
+	if err != nil {
+		log.Printf("Error listing limit changes: %v", err)
+		return nil, errors.New("failed to list limit changes")
+	}
+	defer cursor.Close(ctx)
+
+	changes := []LimitChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		log.Printf("Error decoding limit changes: %v", err)
+		return nil, errors.New("failed to list limit changes")
+	}
+	return changes, nil
+}
+
+// SetLimitChangeStatus moves a pending limit change to a new status
+func (m *Mongo) SetLimitChangeStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	collection := m.GetLimitChangeCollection()
+	filter := bson.M{"_id": id, "status": LimitChangePending}
+	update := bson.M{"$set": bson.M{"status": status}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating limit change: %v", err)
+		return errors.New("failed to update limit change")
+	}
+	return nil
+}
+
+// CancelLimitChangeByCode cancels the pending limit change matching a cancel code
+func (m *Mongo) CancelLimitChangeByCode(ctx context.Context, walletAddress string, cancelCode string) (bool, error) {
+	collection := m.GetLimitChangeCollection()
+	filter := bson.M{"wallet_address": walletAddress, "cancel_code": cancelCode, "status": LimitChangePending}
+	update := bson.M{"$set": bson.M{"status": LimitChangeCancelled}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error cancelling limit change: %v", err)
+		return false, errors.New("failed to cancel limit change")
+	}
+	return result.ModifiedCount > 0, nil
+}
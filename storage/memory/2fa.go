@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// Generate2FACodeAndStore stores the 2FA code sent to a phone number
+func (s *Store) Generate2FACodeAndStore(ctx context.Context, phoneNumber string, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.twoFactorCodes[phoneNumber] = storage.TwoFactorAuth{PhoneNumber: phoneNumber, Code: code, CreatedAt: time.Now()}
+	return nil
+}
+
+// Verify2FACode verifies the 2FA code for a given phone number, provided it hasn't expired.
+// A code that matches is used up, so it can't be verified again; an empty code never matches.
+func (s *Store) Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, exists := s.twoFactorCodes[phoneNumber]
+	if !exists || time.Since(stored.CreatedAt) >= storage.TwoFactorCodeTTL || stored.Code != code {
+		return false, nil
+	}
+	delete(s.twoFactorCodes, phoneNumber)
+	return true, nil
+}
+
+// Store2FACode stores a code in the separate 2fa_code field, which Verify2FACode doesn't read
+func (s *Store) Store2FACode(ctx context.Context, phoneNumber, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storedCodes[phoneNumber] = code
+	return nil
+}
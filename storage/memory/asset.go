@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+func (s *Store) asset(symbol string) *storage.Asset {
+	return find(s.assets, func(asset *storage.Asset) bool { return asset.Symbol == symbol })
+}
+
+// FindAsset looks up an asset by symbol or alias, ignoring case
+func (s *Store) FindAsset(ctx context.Context, name string) (*storage.Asset, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.assets, func(asset *storage.Asset) bool {
+		if asset.Symbol == strings.ToUpper(name) {
+			return true
+		}
+		for _, alias := range asset.Aliases {
+			if alias == strings.ToLower(name) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ListAssets fetches every asset in the registry, ordered by symbol
+func (s *Store) ListAssets(ctx context.Context) ([]storage.Asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assets := collect(nil, s.assets, func(*storage.Asset) bool { return true })
+	sort.SliceStable(assets, func(i, j int) bool { return assets[i].Symbol < assets[j].Symbol })
+	return assets, nil
+}
+
+// SaveAsset inserts or replaces the asset with the same symbol
+func (s *Store) SaveAsset(ctx context.Context, asset *storage.Asset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asset.UpdatedAt = time.Now()
+	if existing := s.asset(asset.Symbol); existing != nil {
+		*existing = clone(*asset)
+		return nil
+	}
+	s.assets = append(s.assets, clone(*asset))
+	return nil
+}
+
+// InsertAssetIfMissing adds asset unless one with the same symbol already exists
+func (s *Store) InsertAssetIfMissing(ctx context.Context, asset *storage.Asset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asset.UpdatedAt = time.Now()
+	if s.asset(asset.Symbol) == nil {
+		s.assets = append(s.assets, clone(*asset))
+	}
+	return nil
+}
+
+// SetAssetEnabled turns transfers of an asset on or off. It reports false when no asset has the symbol.
+func (s *Store) SetAssetEnabled(ctx context.Context, symbol string, enabled bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asset := s.asset(strings.ToUpper(symbol))
+	if asset == nil {
+		return false, nil
+	}
+	asset.Enabled = enabled
+	asset.UpdatedAt = time.Now()
+	*asset = clone(*asset)
+	return true, nil
+}
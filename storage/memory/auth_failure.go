@@ -0,0 +1,29 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// RecordAuthFailure stores a failed passkey attempt for a phone number
+func (s *Store) RecordAuthFailure(ctx context.Context, phoneNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authFailures = append(s.authFailures, clone(storage.AuthFailure{PhoneNumber: phoneNumber, CreatedAt: time.Now()}))
+	return nil
+}
+
+// CountAuthFailuresSince counts failed passkey attempts for a phone number since the given time
+func (s *Store) CountAuthFailuresSince(ctx context.Context, phoneNumber string, since time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	for _, failure := range s.authFailures {
+		if failure.PhoneNumber == phoneNumber && !failure.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"context"
+)
+
+// NextSequence increments the named counter and returns its previous value, so the first
+// call returns 0
+func (s *Store) NextSequence(ctx context.Context, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[name]++
+	return s.counters[name] - 1, nil
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+
+	"crypto-sms/storage"
+)
+
+func (s *Store) custodian(walletAddress string) *storage.Custodian {
+	return find(s.custodians, func(custodian *storage.Custodian) bool { return custodian.WalletAddress == walletAddress })
+}
+
+// GetCustodianByWalletAddress fetches the custodian data for a given wallet address
+func (s *Store) GetCustodianByWalletAddress(ctx context.Context, walletAddress string) (*storage.Custodian, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.custodians, func(custodian *storage.Custodian) bool { return custodian.WalletAddress == walletAddress })
+}
+
+// ListCustodianAddresses fetches the wallet address of every custodian
+func (s *Store) ListCustodianAddresses(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, custodian := range s.custodians {
+		if !seen[custodian.WalletAddress] {
+			seen[custodian.WalletAddress] = true
+			addresses = append(addresses, custodian.WalletAddress)
+		}
+	}
+	return addresses, nil
+}
+
+// UpdateCustodian replaces the balances of an existing custodian
+func (s *Store) UpdateCustodian(ctx context.Context, custodian *storage.Custodian) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing := s.custodian(custodian.WalletAddress); existing != nil {
+		*existing = clone(*custodian)
+	}
+	return nil
+}
+
+// CreditCustodian adds amount to one balance, creating the custodian if needed
+func (s *Store) CreditCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credit(walletAddress, crypto, amount)
+	return nil
+}
+
+func (s *Store) credit(walletAddress string, crypto string, amount float64) {
+	custodian := s.custodian(walletAddress)
+	if custodian == nil {
+		s.custodians = append(s.custodians, storage.Custodian{WalletAddress: walletAddress})
+		custodian = &s.custodians[len(s.custodians)-1]
+	}
+	if custodian.Cryptocurrencies == nil {
+		custodian.Cryptocurrencies = map[string]float64{}
+	}
+	custodian.Cryptocurrencies[crypto] += amount
+}
+
+// DebitCustodian subtracts amount from one balance if the balance covers it. It reports
+// false when the balance is too low.
+func (s *Store) DebitCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	custodian := s.custodian(walletAddress)
+	if custodian == nil {
+		return false, nil
+	}
+	balance, exists := custodian.Cryptocurrencies[crypto]
+	if !exists || balance < amount {
+		return false, nil
+	}
+	custodian.Cryptocurrencies[crypto] = balance - amount
+	return true, nil
+}
+
+// TransferBalance debits the source balance, unless it is too low and the transfer doesn't
+// allow overdrafts, then credits the destination, as one step. It reports false when the
+// source balance doesn't cover the transfer. A reference that was applied before succeeds
+// without moving funds again.
+func (s *Store) TransferBalance(ctx context.Context, transfer storage.BalanceTransfer) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if transfer.Reference != "" && s.appliedTransfers[transfer.Reference] {
+		return true, nil
+	}
+	if transfer.From != "" {
+		source := s.custodian(transfer.From)
+		if !transfer.AllowOverdraft && (source == nil || source.Cryptocurrencies[transfer.FromAsset] < transfer.Amount) {
+			return false, nil
+		}
+		s.credit(transfer.From, transfer.FromAsset, -transfer.Amount)
+	}
+	if transfer.To != "" {
+		s.credit(transfer.To, transfer.ToAsset, transfer.Amount)
+	}
+	if transfer.Reference != "" {
+		s.appliedTransfers[transfer.Reference] = true
+	}
+	return true, nil
+}
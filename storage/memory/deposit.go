@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// InsertDepositIfMissing stores a newly seen deposit. It reports false when the deposit
+// was already recorded.
+func (s *Store) InsertDepositIfMissing(ctx context.Context, deposit *storage.Deposit) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if find(s.deposits, func(existing *storage.Deposit) bool {
+		return existing.Network == deposit.Network && existing.TxHash == deposit.TxHash && existing.Index == deposit.Index
+	}) != nil {
+		return false, nil
+	}
+	if err := insert(&s.deposits, deposit, func(deposit *storage.Deposit) *primitive.ObjectID { return &deposit.ID }); err != nil {
+		return false, failed("add deposit", err)
+	}
+	return true, nil
+}
+
+// ReviveDeposit returns an orphaned or reversed deposit to pending when a reorg includes it
+// in a new block
+func (s *Store) ReviveDeposit(ctx context.Context, network string, txHash string, index int, block int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deposit := find(s.deposits, func(deposit *storage.Deposit) bool {
+		return deposit.Network == network && deposit.TxHash == txHash && deposit.Index == index &&
+			(deposit.Status == storage.DepositOrphaned || deposit.Status == storage.DepositReversed)
+	})
+	if deposit == nil {
+		return false, nil
+	}
+	deposit.Status = storage.DepositPending
+	deposit.Block = block
+	deposit.Confirmations = 0
+	deposit.UpdatedAt = time.Now()
+	*deposit = clone(*deposit)
+	return true, nil
+}
+
+// ListUnsettledDeposits fetches a network's pending deposits and its credited deposits with
+// fewer than final confirmations, which a reorg could still undo
+func (s *Store) ListUnsettledDeposits(ctx context.Context, network string, final int64) ([]storage.Deposit, error) {
+	return s.listDeposits(func(deposit *storage.Deposit) bool {
+		return deposit.Network == network && (deposit.Status == storage.DepositPending ||
+			(deposit.Status == storage.DepositCredited && deposit.Confirmations < final))
+	})
+}
+
+// ListDepositsForWallet fetches the deposits to a wallet, newest first
+func (s *Store) ListDepositsForWallet(ctx context.Context, walletAddress string) ([]storage.Deposit, error) {
+	return s.listDeposits(func(deposit *storage.Deposit) bool { return deposit.WalletAddress == walletAddress })
+}
+
+func (s *Store) listDeposits(keep func(*storage.Deposit) bool) ([]storage.Deposit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deposits := collect([]storage.Deposit{}, s.deposits, keep)
+	byTime(deposits, func(deposit *storage.Deposit) time.Time { return deposit.CreatedAt }, true)
+	return deposits, nil
+}
+
+// TransitionDeposit moves a deposit from one status to another, setting the given fields.
+// It reports false when the deposit was no longer in the from status.
+func (s *Store) TransitionDeposit(ctx context.Context, id primitive.ObjectID, from string, to string, fields storage.DepositFields) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deposit := find(s.deposits, func(deposit *storage.Deposit) bool { return deposit.ID == id && deposit.Status == from })
+	if deposit == nil {
+		return false, nil
+	}
+	deposit.Status, deposit.UpdatedAt = to, time.Now()
+	if fields.Confirmations != nil {
+		deposit.Confirmations = *fields.Confirmations
+	}
+	if fields.CreditedAt != nil {
+		deposit.CreditedAt = *fields.CreditedAt
+	}
+	*deposit = clone(*deposit)
+	return true, nil
+}
+
+// GetScanCursor returns the next block to scan for deposits on a network
+func (s *Store) GetScanCursor(ctx context.Context, network string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nextBlock, exists := s.scanCursors[network]
+	return nextBlock, exists, nil
+}
+
+// SetScanCursor records the next block to scan for deposits on a network
+func (s *Store) SetScanCursor(ctx context.Context, network string, nextBlock int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanCursors[network] = nextBlock
+	return nil
+}
@@ -0,0 +1,11 @@
+package memory
+
+import (
+	"context"
+)
+
+// ReencryptSecrets has nothing to reseal. Records never leave the process, so nothing is
+// encrypted.
+func (s *Store) ReencryptSecrets(ctx context.Context) (int, error) {
+	return 0, nil
+}
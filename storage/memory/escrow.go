@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreateEscrow stores a new held escrow
+func (s *Store) CreateEscrow(ctx context.Context, escrow *storage.Escrow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.escrows, escrow, func(escrow *storage.Escrow) *primitive.ObjectID { return &escrow.ID }); err != nil {
+		return failed("add escrow", err)
+	}
+	return nil
+}
+
+// GetHeldEscrowByClaimCode fetches the held escrow for a phone number and claim code
+func (s *Store) GetHeldEscrowByClaimCode(ctx context.Context, recipientPhone string, claimCode string) (*storage.Escrow, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.escrows, func(escrow *storage.Escrow) bool {
+		return escrow.RecipientPhone == recipientPhone && escrow.ClaimCode == claimCode && escrow.Status == storage.EscrowHeld
+	})
+}
+
+// ListHeldEscrowsForPhone fetches the held escrows waiting for a phone number
+func (s *Store) ListHeldEscrowsForPhone(ctx context.Context, recipientPhone string) ([]storage.Escrow, error) {
+	return s.listHeldEscrows(func(escrow *storage.Escrow) bool { return escrow.RecipientPhone == recipientPhone })
+}
+
+// ListHeldEscrowsForAddress fetches the held escrows waiting for a wallet address
+func (s *Store) ListHeldEscrowsForAddress(ctx context.Context, recipientAddress string) ([]storage.Escrow, error) {
+	return s.listHeldEscrows(func(escrow *storage.Escrow) bool { return escrow.RecipientAddress == recipientAddress })
+}
+
+// ListExpiredEscrows fetches the held escrows whose expiry has passed
+func (s *Store) ListExpiredEscrows(ctx context.Context, now time.Time) ([]storage.Escrow, error) {
+	return s.listHeldEscrows(func(escrow *storage.Escrow) bool { return !escrow.ExpiresAt.After(now) })
+}
+
+func (s *Store) listHeldEscrows(keep func(*storage.Escrow) bool) ([]storage.Escrow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return collect(nil, s.escrows, func(escrow *storage.Escrow) bool {
+		return escrow.Status == storage.EscrowHeld && keep(escrow)
+	}), nil
+}
+
+// ListResolvedEscrows fetches every claimed or refunded escrow
+func (s *Store) ListResolvedEscrows(ctx context.Context) ([]storage.Escrow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return collect(nil, s.escrows, func(escrow *storage.Escrow) bool {
+		return escrow.Status == storage.EscrowClaimed || escrow.Status == storage.EscrowRefunded
+	}), nil
+}
+
+// ResolveEscrow moves a held escrow to claimed or refunded. It reports false when the
+// escrow was already resolved, so concurrent claims and refunds cannot both succeed.
+func (s *Store) ResolveEscrow(ctx context.Context, id primitive.ObjectID, status string, claimedBy string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	escrow := find(s.escrows, func(escrow *storage.Escrow) bool { return escrow.ID == id && escrow.Status == storage.EscrowHeld })
+	if escrow == nil {
+		return false, nil
+	}
+	escrow.Status = status
+	escrow.ClaimedBy = claimedBy
+	escrow.ResolvedAt = time.Now()
+	*escrow = clone(*escrow)
+	return true, nil
+}
+
+// ReopenEscrow moves an escrow resolved to status back to held, for a release or refund
+// whose transfer failed. It reports false when the escrow isn't in that status.
+func (s *Store) ReopenEscrow(ctx context.Context, id primitive.ObjectID, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	escrow := find(s.escrows, func(escrow *storage.Escrow) bool { return escrow.ID == id && escrow.Status == status })
+	if escrow == nil {
+		return false, nil
+	}
+	escrow.Status = storage.EscrowHeld
+	escrow.ClaimedBy = ""
+	escrow.ResolvedAt = time.Time{}
+	*escrow = clone(*escrow)
+	return true, nil
+}
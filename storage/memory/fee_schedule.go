@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// GetActiveFeeSchedule fetches the fee schedule with the highest version
+func (s *Store) GetActiveFeeSchedule(ctx context.Context) (*storage.FeeSchedule, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeFeeSchedule()
+}
+
+func (s *Store) activeFeeSchedule() (*storage.FeeSchedule, bool, error) {
+	var active *storage.FeeSchedule
+	for i := range s.feeSchedules {
+		if active == nil || s.feeSchedules[i].Version > active.Version {
+			active = &s.feeSchedules[i]
+		}
+	}
+	if active == nil {
+		return nil, false, nil
+	}
+	schedule := clone(*active)
+	return &schedule, true, nil
+}
+
+// CreateFeeSchedule stores rules as the next fee schedule version
+func (s *Store) CreateFeeSchedule(ctx context.Context, rules []storage.FeeRule) (*storage.FeeSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists, err := s.activeFeeSchedule()
+	if err != nil {
+		return nil, err
+	}
+	schedule := &storage.FeeSchedule{Version: 1, Rules: rules, CreatedAt: time.Now()}
+	if exists {
+		schedule.Version = current.Version + 1
+	}
+	s.feeSchedules = append(s.feeSchedules, clone(*schedule))
+	return schedule, nil
+}
+
+// ListFeeSchedules fetches every fee schedule version, newest first
+func (s *Store) ListFeeSchedules(ctx context.Context) ([]storage.FeeSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := collect([]storage.FeeSchedule{}, s.feeSchedules, func(*storage.FeeSchedule) bool { return true })
+	sort.SliceStable(schedules, func(i, j int) bool { return schedules[i].Version > schedules[j].Version })
+	return schedules, nil
+}
@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// AcquireLease takes or renews the named lease for holder. It reports false while another
+// holder's lease is unexpired.
+func (s *Store) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if lease, exists := s.leases[name]; exists && lease.Holder != holder && lease.ExpiresAt.After(now) {
+		return false, nil
+	}
+	s.leases[name] = clone(storage.Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)})
+	return true, nil
+}
+
+// ReleaseLease gives up the named lease if holder still holds it
+func (s *Store) ReleaseLease(ctx context.Context, name string, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lease, exists := s.leases[name]; exists && lease.Holder == holder {
+		delete(s.leases, name)
+	}
+	return nil
+}
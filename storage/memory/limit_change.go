@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreateLimitChange stores a new pending limit change
+func (s *Store) CreateLimitChange(ctx context.Context, change *storage.LimitChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.limitChanges, change, func(change *storage.LimitChange) *primitive.ObjectID { return &change.ID }); err != nil {
+		return failed("add limit change", err)
+	}
+	return nil
+}
+
+// ListPendingLimitChanges fetches the pending limit changes for a wallet address, oldest first
+func (s *Store) ListPendingLimitChanges(ctx context.Context, walletAddress string) ([]storage.LimitChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := collect([]storage.LimitChange{}, s.limitChanges, func(change *storage.LimitChange) bool {
+		return change.WalletAddress == walletAddress && change.Status == storage.LimitChangePending
+	})
+	byTime(changes, func(change *storage.LimitChange) time.Time { return change.EffectiveAt }, false)
+	return changes, nil
+}
+
+// SetLimitChangeStatus moves a pending limit change to a new status
+func (s *Store) SetLimitChangeStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if change := find(s.limitChanges, func(change *storage.LimitChange) bool {
+		return change.ID == id && change.Status == storage.LimitChangePending
+	}); change != nil {
+		change.Status = status
+	}
+	return nil
+}
+
+// CancelLimitChangeByCode cancels the pending limit change matching a cancel code
+func (s *Store) CancelLimitChangeByCode(ctx context.Context, walletAddress string, cancelCode string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change := find(s.limitChanges, func(change *storage.LimitChange) bool {
+		return change.WalletAddress == walletAddress && change.CancelCode == cancelCode && change.Status == storage.LimitChangePending
+	})
+	if change == nil {
+		return false, nil
+	}
+	change.Status = storage.LimitChangeCancelled
+	return true, nil
+}
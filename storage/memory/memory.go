@@ -0,0 +1,170 @@
+// Package memory keeps every repository in process memory, for development and tests.
+// Records are copied in and out through BSON, so callers read back exactly what they would
+// from MongoDB, and nothing survives a restart.
+package memory
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// errDuplicateID is logged when a record is inserted with the ID of an existing one, which
+// MongoDB rejects as a duplicate key
+var errDuplicateID = errors.New("duplicate _id")
+
+// Store implements every repository. A single lock guards all records, so each method is
+// atomic the way a single-document MongoDB update is.
+type Store struct {
+	mu sync.Mutex
+
+	smsServices        []storage.SmsService
+	twoFactorCodes     map[string]storage.TwoFactorAuth
+	storedCodes        map[string]string
+	smsSessions        map[string]time.Time
+	authFailures       []storage.AuthFailure
+	custodians         []storage.Custodian
+	appliedTransfers   map[string]bool
+	assets             []storage.Asset
+	feeSchedules       []storage.FeeSchedule
+	counters           map[string]int64
+	leases             map[string]storage.Lease
+	transactions       []storage.Transaction
+	escrows            []storage.Escrow
+	pendingTransfers   []storage.PendingTransfer
+	paymentRequests    []storage.PaymentRequest
+	scheduledTransfers []storage.ScheduledTransfer
+	limitChanges       []storage.LimitChange
+	riskDecisions      []storage.RiskDecision
+	riskChallenges     []storage.RiskChallenge
+	deposits           []storage.Deposit
+	scanCursors        map[string]int64
+	withdrawals        []storage.Withdrawal
+	reports            []storage.ReconciliationReport
+	freezes            []storage.WalletFreeze
+	snapshots          []storage.ReserveSnapshot
+	proofs             []storage.ReserveProof
+}
+
+// NewStore returns an empty store kept in memory
+func NewStore() *storage.Store {
+	s := &Store{
+		twoFactorCodes:   map[string]storage.TwoFactorAuth{},
+		storedCodes:      map[string]string{},
+		smsSessions:      map[string]time.Time{},
+		appliedTransfers: map[string]bool{},
+		counters:         map[string]int64{},
+		leases:           map[string]storage.Lease{},
+		scanCursors:      map[string]int64{},
+	}
+	return &storage.Store{
+		SmsServiceRepository:        s,
+		TwoFactorAuthRepository:     s,
+		SmsSessionRepository:        s,
+		AuthFailureRepository:       s,
+		CustodianRepository:         s,
+		AssetRepository:             s,
+		FeeScheduleRepository:       s,
+		CounterRepository:           s,
+		LeaseRepository:             s,
+		TransactionRepository:       s,
+		EscrowRepository:            s,
+		PendingTransferRepository:   s,
+		PaymentRequestRepository:    s,
+		ScheduledTransferRepository: s,
+		LimitChangeRepository:       s,
+		RiskRepository:              s,
+		DepositRepository:           s,
+		WithdrawalRepository:        s,
+		ReconciliationRepository:    s,
+		ReserveRepository:           s,
+		MigrationRepository:         s,
+		SecretRepository:            s,
+	}
+}
+
+// clone deep-copies v through BSON, the way a record is written to and read back from
+// MongoDB: times lose sub-millisecond precision and empty omitempty fields come back zero
+func clone[T any](v T) T {
+	data, err := bson.Marshal(struct {
+		V T `bson:"v"`
+	}{v})
+	if err != nil {
+		panic(err)
+	}
+	var out struct {
+		V T `bson:"v"`
+	}
+	if err := bson.Unmarshal(data, &out); err != nil {
+		panic(err)
+	}
+	return out.V
+}
+
+// insert appends a copy of record, giving it a new ID unless it has one. It fails when
+// another record already has the ID.
+func insert[T any](records *[]T, record *T, id func(*T) *primitive.ObjectID) error {
+	if id(record).IsZero() {
+		*id(record) = primitive.NewObjectID()
+	}
+	for i := range *records {
+		if *id(&(*records)[i]) == *id(record) {
+			return errDuplicateID
+		}
+	}
+	*records = append(*records, clone(*record))
+	return nil
+}
+
+// find returns the first record matching keep, or nil
+func find[T any](records []T, keep func(*T) bool) *T {
+	for i := range records {
+		if keep(&records[i]) {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// findCopy returns a copy of the first record matching keep
+func findCopy[T any](records []T, keep func(*T) bool) (*T, bool, error) {
+	record := find(records, keep)
+	if record == nil {
+		return nil, false, nil
+	}
+	found := clone(*record)
+	return &found, true, nil
+}
+
+// collect appends copies of the records matching keep to out
+func collect[T any](out []T, records []T, keep func(*T) bool) []T {
+	for i := range records {
+		if keep(&records[i]) {
+			out = append(out, clone(records[i]))
+		}
+	}
+	return out
+}
+
+// byTime sorts records by a time, oldest first unless descending
+func byTime[T any](records []T, at func(*T) time.Time, descending bool) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if descending {
+			return at(&records[i]).After(at(&records[j]))
+		}
+		return at(&records[i]).Before(at(&records[j]))
+	})
+}
+
+// failed logs err and returns the error MongoDB's implementation returns for the operation
+func failed(operation string, err error) error {
+	log.Printf("Error in %s: %v", operation, err)
+	return errors.New("failed to " + operation)
+}
@@ -0,0 +1,18 @@
+package memory
+
+import (
+	"context"
+
+	"crypto-sms/storage"
+)
+
+// Migrate has nothing to apply. The store starts empty on every run and enforces the unique
+// indexes and 2FA code expiry itself.
+func (s *Store) Migrate(ctx context.Context) ([]storage.MigrationStatus, error) {
+	return []storage.MigrationStatus{}, nil
+}
+
+// ListMigrations reports no migrations, since the store has none
+func (s *Store) ListMigrations(ctx context.Context) ([]storage.MigrationStatus, error) {
+	return []storage.MigrationStatus{}, nil
+}
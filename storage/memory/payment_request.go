@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreatePaymentRequest stores a new payment request
+func (s *Store) CreatePaymentRequest(ctx context.Context, request *storage.PaymentRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.paymentRequests, request, func(request *storage.PaymentRequest) *primitive.ObjectID { return &request.ID }); err != nil {
+		return failed("add payment request", err)
+	}
+	return nil
+}
+
+// GetPendingPaymentRequest fetches the pending, unexpired request addressed to a payer with a reference
+func (s *Store) GetPendingPaymentRequest(ctx context.Context, payerPhone string, reference string) (*storage.PaymentRequest, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	return findCopy(s.paymentRequests, func(request *storage.PaymentRequest) bool {
+		return request.PayerPhone == payerPhone && request.Reference == reference &&
+			request.Status == storage.PaymentRequestPending && request.ExpiresAt.After(now)
+	})
+}
+
+// GetPaymentRequest fetches a request by ID
+func (s *Store) GetPaymentRequest(ctx context.Context, id primitive.ObjectID) (*storage.PaymentRequest, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.paymentRequests, func(request *storage.PaymentRequest) bool { return request.ID == id })
+}
+
+// TransitionPaymentRequest moves a request from one status to another. It reports false
+// when the request was no longer in the from status.
+func (s *Store) TransitionPaymentRequest(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request := find(s.paymentRequests, func(request *storage.PaymentRequest) bool { return request.ID == id && request.Status == from })
+	if request == nil {
+		return false, nil
+	}
+	request.Status = to
+	request.RespondedAt = time.Now()
+	*request = clone(*request)
+	return true, nil
+}
+
+// ListExpiredPaymentRequests fetches pending and approving requests whose expiry has passed
+func (s *Store) ListExpiredPaymentRequests(ctx context.Context, now time.Time) ([]storage.PaymentRequest, error) {
+	return s.listPaymentRequests(func(request *storage.PaymentRequest) bool {
+		open := request.Status == storage.PaymentRequestPending || request.Status == storage.PaymentRequestApproving
+		return open && !request.ExpiresAt.After(now)
+	})
+}
+
+// ListPaymentRequestsForWallet fetches the requests a wallet has made or received, newest first
+func (s *Store) ListPaymentRequestsForWallet(ctx context.Context, walletAddress string) ([]storage.PaymentRequest, error) {
+	return s.listPaymentRequests(func(request *storage.PaymentRequest) bool {
+		return request.RequesterAddress == walletAddress || request.PayerAddress == walletAddress
+	})
+}
+
+func (s *Store) listPaymentRequests(keep func(*storage.PaymentRequest) bool) ([]storage.PaymentRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := collect([]storage.PaymentRequest{}, s.paymentRequests, keep)
+	byTime(requests, func(request *storage.PaymentRequest) time.Time { return request.CreatedAt }, true)
+	return requests, nil
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreatePendingTransfer stores a new pending transfer
+func (s *Store) CreatePendingTransfer(ctx context.Context, transfer *storage.PendingTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.pendingTransfers, transfer, func(transfer *storage.PendingTransfer) *primitive.ObjectID { return &transfer.ID }); err != nil {
+		return failed("add pending transfer", err)
+	}
+	return nil
+}
+
+// GetPendingTransferForGuardian fetches the pending transfer with a reference that a guardian may decide on
+func (s *Store) GetPendingTransferForGuardian(ctx context.Context, reference string, guardianPhone string) (*storage.PendingTransfer, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.pendingTransfers, func(transfer *storage.PendingTransfer) bool {
+		if transfer.Reference != reference || transfer.Status != storage.PendingTransferPending {
+			return false
+		}
+		for _, guardian := range transfer.Guardians {
+			if guardian == guardianPhone {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RecordGuardianDecision adds a guardian's decision to a pending transfer and returns the
+// updated transfer. It reports false when the guardian already decided or the transfer
+// is no longer pending.
+func (s *Store) RecordGuardianDecision(ctx context.Context, id primitive.ObjectID, decision storage.GuardianDecision) (*storage.PendingTransfer, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer := find(s.pendingTransfers, func(transfer *storage.PendingTransfer) bool {
+		if transfer.ID != id || transfer.Status != storage.PendingTransferPending {
+			return false
+		}
+		for _, decided := range transfer.Decisions {
+			if decided.PhoneNumber == decision.PhoneNumber {
+				return false
+			}
+		}
+		return true
+	})
+	if transfer == nil {
+		return nil, false, nil
+	}
+	transfer.Decisions = append(transfer.Decisions, decision)
+	*transfer = clone(*transfer)
+	updated := clone(*transfer)
+	return &updated, true, nil
+}
+
+// TransitionPendingTransfer moves a transfer from one status to another. It reports false
+// when the transfer was no longer in the from status.
+func (s *Store) TransitionPendingTransfer(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer := find(s.pendingTransfers, func(transfer *storage.PendingTransfer) bool { return transfer.ID == id && transfer.Status == from })
+	if transfer == nil || from == to {
+		return false, nil
+	}
+	transfer.Status = to
+	return true, nil
+}
+
+// ListExpiredPendingTransfers fetches pending transfers whose approval window has passed
+func (s *Store) ListExpiredPendingTransfers(ctx context.Context, now time.Time) ([]storage.PendingTransfer, error) {
+	return s.listPendingTransfers(func(transfer *storage.PendingTransfer) bool {
+		return transfer.Status == storage.PendingTransferPending && !transfer.ExpiresAt.After(now)
+	})
+}
+
+// ListPendingTransfersForWallet fetches every guardian-gated transfer a wallet has made, newest first
+func (s *Store) ListPendingTransfersForWallet(ctx context.Context, senderAddress string) ([]storage.PendingTransfer, error) {
+	return s.listPendingTransfers(func(transfer *storage.PendingTransfer) bool { return transfer.SenderAddress == senderAddress })
+}
+
+func (s *Store) listPendingTransfers(keep func(*storage.PendingTransfer) bool) ([]storage.PendingTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfers := collect([]storage.PendingTransfer{}, s.pendingTransfers, keep)
+	byTime(transfers, func(transfer *storage.PendingTransfer) time.Time { return transfer.CreatedAt }, true)
+	return transfers, nil
+}
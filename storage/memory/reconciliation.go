@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreateReconciliationReport stores a finished reconciliation run
+func (s *Store) CreateReconciliationReport(ctx context.Context, report *storage.ReconciliationReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.reports, report, func(report *storage.ReconciliationReport) *primitive.ObjectID { return &report.ID }); err != nil {
+		return failed("add reconciliation report", err)
+	}
+	return nil
+}
+
+// GetReconciliationReport fetches a report by its hex ID, or the latest report when id is empty
+func (s *Store) GetReconciliationReport(ctx context.Context, id string) (*storage.ReconciliationReport, bool, error) {
+	var objectID primitive.ObjectID
+	if id != "" {
+		var err error
+		if objectID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, false, nil
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reports := collect(nil, s.reports, func(report *storage.ReconciliationReport) bool { return id == "" || report.ID == objectID })
+	if len(reports) == 0 {
+		return nil, false, nil
+	}
+	byTime(reports, func(report *storage.ReconciliationReport) time.Time { return report.CreatedAt }, true)
+	return &reports[0], true, nil
+}
+
+// FreezeWallet freezes a wallet unless it is already frozen. It reports whether a new
+// freeze was created.
+func (s *Store) FreezeWallet(ctx context.Context, freeze *storage.WalletFreeze) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	freeze.Status = storage.FreezeActive
+	if s.activeFreeze(freeze.WalletAddress) != nil {
+		return false, nil
+	}
+	stored := clone(*freeze)
+	stored.ID = primitive.NewObjectID()
+	s.freezes = append(s.freezes, stored)
+	return true, nil
+}
+
+func (s *Store) activeFreeze(walletAddress string) *storage.WalletFreeze {
+	return find(s.freezes, func(freeze *storage.WalletFreeze) bool {
+		return freeze.WalletAddress == walletAddress && freeze.Status == storage.FreezeActive
+	})
+}
+
+// IsWalletFrozen reports whether a wallet has an active freeze
+func (s *Store) IsWalletFrozen(ctx context.Context, walletAddress string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeFreeze(walletAddress) != nil, nil
+}
+
+// ListActiveWalletFreezes fetches every active freeze, oldest first
+func (s *Store) ListActiveWalletFreezes(ctx context.Context) ([]storage.WalletFreeze, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	freezes := collect([]storage.WalletFreeze{}, s.freezes, func(freeze *storage.WalletFreeze) bool { return freeze.Status == storage.FreezeActive })
+	byTime(freezes, func(freeze *storage.WalletFreeze) time.Time { return freeze.CreatedAt }, false)
+	return freezes, nil
+}
+
+// ResolveWalletFreeze lifts a wallet's active freeze. It reports false when the wallet
+// wasn't frozen.
+func (s *Store) ResolveWalletFreeze(ctx context.Context, walletAddress string, operator string, resolution string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	freeze := s.activeFreeze(walletAddress)
+	if freeze == nil {
+		return false, nil
+	}
+	freeze.Status = storage.FreezeResolved
+	freeze.ResolvedBy = operator
+	freeze.Resolution = resolution
+	freeze.ResolvedAt = time.Now()
+	*freeze = clone(*freeze)
+	return true, nil
+}
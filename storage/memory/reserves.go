@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+func snapshotCreatedAt(snapshot *storage.ReserveSnapshot) time.Time { return snapshot.CreatedAt }
+
+// CreateReserveSnapshot stores a snapshot with its proofs
+func (s *Store) CreateReserveSnapshot(ctx context.Context, snapshot *storage.ReserveSnapshot, proofs []storage.ReserveProof) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snapshot.ID.IsZero() {
+		snapshot.ID = primitive.NewObjectID()
+	}
+	for i := range proofs {
+		proofs[i].SnapshotID = snapshot.ID
+		proof := clone(proofs[i])
+		proof.ID = primitive.NewObjectID()
+		s.proofs = append(s.proofs, proof)
+	}
+	if err := insert(&s.snapshots, snapshot, func(snapshot *storage.ReserveSnapshot) *primitive.ObjectID { return &snapshot.ID }); err != nil {
+		return failed("add reserve snapshot", err)
+	}
+	return nil
+}
+
+// GetReserveSnapshot fetches a snapshot by its hex ID, or the latest snapshot when id is empty
+func (s *Store) GetReserveSnapshot(ctx context.Context, id string) (*storage.ReserveSnapshot, bool, error) {
+	var objectID primitive.ObjectID
+	if id != "" {
+		var err error
+		if objectID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, false, nil
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshots := collect(nil, s.snapshots, func(snapshot *storage.ReserveSnapshot) bool { return id == "" || snapshot.ID == objectID })
+	if len(snapshots) == 0 {
+		return nil, false, nil
+	}
+	byTime(snapshots, snapshotCreatedAt, true)
+	return &snapshots[0], true, nil
+}
+
+// ListReserveSnapshots fetches the most recent snapshots, newest first
+func (s *Store) ListReserveSnapshots(ctx context.Context, limit int64) ([]storage.ReserveSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshots := collect([]storage.ReserveSnapshot{}, s.snapshots, func(*storage.ReserveSnapshot) bool { return true })
+	byTime(snapshots, snapshotCreatedAt, true)
+	if limit > 0 && int64(len(snapshots)) > limit {
+		snapshots = snapshots[:limit]
+	}
+	return snapshots, nil
+}
+
+// GetReserveProofByCode fetches the proof with a verification code
+func (s *Store) GetReserveProofByCode(ctx context.Context, code string) (*storage.ReserveProof, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.proofs, func(proof *storage.ReserveProof) bool { return proof.Code == code })
+}
+
+// GetReserveProofForWallet fetches a wallet's proof in a snapshot
+func (s *Store) GetReserveProofForWallet(ctx context.Context, snapshotID primitive.ObjectID, walletAddress string) (*storage.ReserveProof, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.proofs, func(proof *storage.ReserveProof) bool {
+		return proof.SnapshotID == snapshotID && proof.WalletAddress == walletAddress
+	})
+}
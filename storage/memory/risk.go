@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreateRiskDecision stores a risk decision
+func (s *Store) CreateRiskDecision(ctx context.Context, decision *storage.RiskDecision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.riskDecisions, decision, func(decision *storage.RiskDecision) *primitive.ObjectID { return &decision.ID }); err != nil {
+		return failed("add risk decision", err)
+	}
+	return nil
+}
+
+// ListRiskDecisions fetches a wallet's risk decisions, newest first, optionally filtered by outcome
+func (s *Store) ListRiskDecisions(ctx context.Context, senderAddress string, outcome string, limit int64) ([]storage.RiskDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	decisions := collect([]storage.RiskDecision{}, s.riskDecisions, func(decision *storage.RiskDecision) bool {
+		return decision.SenderAddress == senderAddress && (outcome == "" || decision.Outcome == outcome)
+	})
+	byTime(decisions, func(decision *storage.RiskDecision) time.Time { return decision.CreatedAt }, true)
+	if limit > 0 && int64(len(decisions)) > limit {
+		decisions = decisions[:limit]
+	}
+	return decisions, nil
+}
+
+// CreateRiskChallenge stores a transfer awaiting an extra 2FA step
+func (s *Store) CreateRiskChallenge(ctx context.Context, challenge *storage.RiskChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.riskChallenges, challenge, func(challenge *storage.RiskChallenge) *primitive.ObjectID { return &challenge.ID }); err != nil {
+		return failed("add risk challenge", err)
+	}
+	return nil
+}
+
+// GetLatestRiskChallenge fetches the newest pending, unexpired challenge for a phone number
+func (s *Store) GetLatestRiskChallenge(ctx context.Context, senderPhone string) (*storage.RiskChallenge, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var latest *storage.RiskChallenge
+	for i := range s.riskChallenges {
+		challenge := &s.riskChallenges[i]
+		if challenge.SenderPhone != senderPhone || challenge.Status != storage.RiskChallengePending || !challenge.ExpiresAt.After(now) {
+			continue
+		}
+		if latest == nil || challenge.CreatedAt.After(latest.CreatedAt) {
+			latest = challenge
+		}
+	}
+	if latest == nil {
+		return nil, false, nil
+	}
+	challenge := clone(*latest)
+	return &challenge, true, nil
+}
+
+// TransitionRiskChallenge moves a challenge from one status to another. It reports false
+// when the challenge was no longer in the from status.
+func (s *Store) TransitionRiskChallenge(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge := find(s.riskChallenges, func(challenge *storage.RiskChallenge) bool {
+		return challenge.ID == id && challenge.Status == from
+	})
+	if challenge == nil || from == to {
+		return false, nil
+	}
+	challenge.Status = to
+	return true, nil
+}
+
+// ListExpiredRiskChallenges fetches pending challenges whose verification window has passed
+func (s *Store) ListExpiredRiskChallenges(ctx context.Context, now time.Time) ([]storage.RiskChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenges := collect([]storage.RiskChallenge{}, s.riskChallenges, func(challenge *storage.RiskChallenge) bool {
+		return challenge.Status == storage.RiskChallengePending && !challenge.ExpiresAt.After(now)
+	})
+	byTime(challenges, func(challenge *storage.RiskChallenge) time.Time { return challenge.CreatedAt }, true)
+	return challenges, nil
+}
@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// updateScheduledTransfer applies update to the first scheduled transfer matching keep. It
+// reports false when none matched.
+func (s *Store) updateScheduledTransfer(keep func(*storage.ScheduledTransfer) bool, update func(*storage.ScheduledTransfer)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer := find(s.scheduledTransfers, keep)
+	if transfer == nil {
+		return false
+	}
+	update(transfer)
+	*transfer = clone(*transfer)
+	return true
+}
+
+// CreateScheduledTransfer stores a new scheduled transfer
+func (s *Store) CreateScheduledTransfer(ctx context.Context, transfer *storage.ScheduledTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.scheduledTransfers, transfer, func(transfer *storage.ScheduledTransfer) *primitive.ObjectID { return &transfer.ID }); err != nil {
+		return failed("add scheduled transfer", err)
+	}
+	return nil
+}
+
+// GetActiveScheduledTransfer fetches an active scheduled transfer by sender and reference
+func (s *Store) GetActiveScheduledTransfer(ctx context.Context, senderAddress string, reference string) (*storage.ScheduledTransfer, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.scheduledTransfers, func(transfer *storage.ScheduledTransfer) bool {
+		return transfer.SenderAddress == senderAddress && transfer.Reference == reference && transfer.Status == storage.ScheduledTransferActive
+	})
+}
+
+// ListScheduledTransfers fetches every scheduled transfer for a sender, next run first
+func (s *Store) ListScheduledTransfers(ctx context.Context, senderAddress string) ([]storage.ScheduledTransfer, error) {
+	return s.listScheduledTransfers(func(transfer *storage.ScheduledTransfer) bool { return transfer.SenderAddress == senderAddress })
+}
+
+// ListDueScheduledTransfers fetches active scheduled transfers whose next run has arrived
+func (s *Store) ListDueScheduledTransfers(ctx context.Context, now time.Time) ([]storage.ScheduledTransfer, error) {
+	return s.listScheduledTransfers(func(transfer *storage.ScheduledTransfer) bool {
+		return transfer.Status == storage.ScheduledTransferActive && !transfer.NextRunAt.After(now)
+	})
+}
+
+// ListUnremindedScheduledTransfers fetches active scheduled transfers running before the
+// given time that haven't had a reminder for that run yet
+func (s *Store) ListUnremindedScheduledTransfers(ctx context.Context, before time.Time) ([]storage.ScheduledTransfer, error) {
+	return s.listScheduledTransfers(func(transfer *storage.ScheduledTransfer) bool {
+		return transfer.Status == storage.ScheduledTransferActive && !transfer.NextRunAt.After(before) &&
+			!transfer.RemindedFor.Equal(transfer.NextRunAt)
+	})
+}
+
+func (s *Store) listScheduledTransfers(keep func(*storage.ScheduledTransfer) bool) ([]storage.ScheduledTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfers := collect([]storage.ScheduledTransfer{}, s.scheduledTransfers, keep)
+	byTime(transfers, func(transfer *storage.ScheduledTransfer) time.Time { return transfer.NextRunAt }, false)
+	return transfers, nil
+}
+
+// AdvanceScheduledTransfer moves an active transfer's next run from runAt to next and sets
+// its status. It reports false when the run was already advanced by someone else.
+func (s *Store) AdvanceScheduledTransfer(ctx context.Context, id primitive.ObjectID, runAt time.Time, next time.Time, status string) (bool, error) {
+	return s.updateScheduledTransfer(func(transfer *storage.ScheduledTransfer) bool {
+		return transfer.ID == id && transfer.Status == storage.ScheduledTransferActive && transfer.NextRunAt.Equal(runAt)
+	}, func(transfer *storage.ScheduledTransfer) {
+		transfer.NextRunAt = next
+		transfer.Status = status
+	}), nil
+}
+
+// RecordScheduledTransferRun stores the outcome of a scheduled run
+func (s *Store) RecordScheduledTransferRun(ctx context.Context, id primitive.ObjectID, runAt time.Time, runErr string) error {
+	s.updateScheduledTransfer(func(transfer *storage.ScheduledTransfer) bool { return transfer.ID == id }, func(transfer *storage.ScheduledTransfer) {
+		transfer.LastRunAt = runAt
+		transfer.LastError = runErr
+	})
+	return nil
+}
+
+// MarkScheduledTransferReminded records that the reminder for the run at runAt was sent
+func (s *Store) MarkScheduledTransferReminded(ctx context.Context, id primitive.ObjectID, runAt time.Time) error {
+	s.updateScheduledTransfer(func(transfer *storage.ScheduledTransfer) bool { return transfer.ID == id }, func(transfer *storage.ScheduledTransfer) {
+		transfer.RemindedFor = runAt
+	})
+	return nil
+}
+
+// CancelScheduledTransfer cancels an active scheduled transfer
+func (s *Store) CancelScheduledTransfer(ctx context.Context, senderAddress string, reference string) (bool, error) {
+	return s.updateScheduledTransfer(func(transfer *storage.ScheduledTransfer) bool {
+		return transfer.SenderAddress == senderAddress && transfer.Reference == reference && transfer.Status == storage.ScheduledTransferActive
+	}, func(transfer *storage.ScheduledTransfer) {
+		transfer.Status = storage.ScheduledTransferCancelled
+	}), nil
+}
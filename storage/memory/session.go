@@ -0,0 +1,22 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// StartSmsSession opens (or extends) an authenticated session for a phone number
+func (s *Store) StartSmsSession(ctx context.Context, phoneNumber string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.smsSessions[phoneNumber] = time.Now().Add(ttl)
+	return nil
+}
+
+// HasActiveSmsSession checks if a phone number has an unexpired session
+func (s *Store) HasActiveSmsSession(ctx context.Context, phoneNumber string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, exists := s.smsSessions[phoneNumber]
+	return exists && time.Now().Before(expiresAt), nil
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+func (s *Store) smsService(walletAddress string) *storage.SmsService {
+	return find(s.smsServices, func(service *storage.SmsService) bool { return service.WalletAddress == walletAddress })
+}
+
+// updateSmsService applies update to the wallet's SMS service, if it has one
+func (s *Store) updateSmsService(walletAddress string, update func(*storage.SmsService)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if service := s.smsService(walletAddress); service != nil {
+		update(service)
+		*service = clone(*service)
+	}
+}
+
+// CheckWalletExistsInSmsService checks if a wallet address is registered
+func (s *Store) CheckWalletExistsInSmsService(ctx context.Context, walletAddress string) (*storage.SmsService, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.smsServices, func(service *storage.SmsService) bool { return service.WalletAddress == walletAddress })
+}
+
+// CheckPhoneNumberExistsInSmsService checks if a phone number is used by any wallet address
+func (s *Store) CheckPhoneNumberExistsInSmsService(ctx context.Context, phoneNumber string) (*storage.SmsService, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.smsServices, func(service *storage.SmsService) bool { return service.PhoneNumber == phoneNumber })
+}
+
+// CheckAliasExistsInSmsService checks if an alias is used by any wallet address
+func (s *Store) CheckAliasExistsInSmsService(ctx context.Context, alias string) (*storage.SmsService, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.smsServices, func(service *storage.SmsService) bool { return service.Alias == alias })
+}
+
+// CreateSmsService registers a wallet. Like the unique indexes on sms_service, it refuses a
+// wallet, phone number or alias that is already registered.
+func (s *Store) CreateSmsService(ctx context.Context, service storage.SmsService) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taken := find(s.smsServices, func(existing *storage.SmsService) bool {
+		return existing.WalletAddress == service.WalletAddress ||
+			(service.PhoneNumber != "" && existing.PhoneNumber == service.PhoneNumber) ||
+			(service.Alias != "" && existing.Alias == service.Alias)
+	})
+	if taken != nil {
+		return storage.ErrDuplicate
+	}
+	s.smsServices = append(s.smsServices, clone(service))
+	return nil
+}
+
+// UpdateSmsService sets a wallet's passkey and limit, registering the wallet if needed
+func (s *Store) UpdateSmsService(ctx context.Context, walletAddress string, passkey string, limit float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if service := s.smsService(walletAddress); service != nil {
+		service.Passkey = passkey
+		service.Limit = limit
+		return nil
+	}
+	s.smsServices = append(s.smsServices, storage.SmsService{WalletAddress: walletAddress, Passkey: passkey, Limit: limit})
+	return nil
+}
+
+// UpdateLimit sets the per-transaction limit for a given wallet address
+func (s *Store) UpdateLimit(ctx context.Context, walletAddress string, limit float64) error {
+	s.updateSmsService(walletAddress, func(service *storage.SmsService) { service.Limit = limit })
+	return nil
+}
+
+// UpdateVelocityLimits replaces the rolling spending limits for a given wallet address
+func (s *Store) UpdateVelocityLimits(ctx context.Context, walletAddress string, limits []storage.VelocityLimit) error {
+	s.updateSmsService(walletAddress, func(service *storage.SmsService) { service.VelocityLimits = limits })
+	return nil
+}
+
+// UpdateLanguage sets the reply language for a given wallet address
+func (s *Store) UpdateLanguage(ctx context.Context, walletAddress string, language string) error {
+	s.updateSmsService(walletAddress, func(service *storage.SmsService) { service.Language = language })
+	return nil
+}
+
+// UpdatePreferredNetworks replaces the preferred network per asset for a given wallet address
+func (s *Store) UpdatePreferredNetworks(ctx context.Context, walletAddress string, networks map[string]string) error {
+	s.updateSmsService(walletAddress, func(service *storage.SmsService) { service.Networks = networks })
+	return nil
+}
+
+// SetDepositIndex assigns a deposit derivation index to a wallet that has none. It reports
+// false when the wallet already had one.
+func (s *Store) SetDepositIndex(ctx context.Context, walletAddress string, index uint32) (bool, error) {
+	set := false
+	s.updateSmsService(walletAddress, func(service *storage.SmsService) {
+		if service.DepositIndex == nil {
+			service.DepositIndex = &index
+			set = true
+		}
+	})
+	return set, nil
+}
+
+// UpdateAlias sets the alias for a given wallet address
+func (s *Store) UpdateAlias(ctx context.Context, walletAddress string, alias string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taken := find(s.smsServices, func(service *storage.SmsService) bool {
+		return service.Alias == alias && service.WalletAddress != walletAddress
+	})
+	if alias != "" && taken != nil {
+		return storage.ErrDuplicate
+	}
+	if service := s.smsService(walletAddress); service != nil {
+		service.Alias = alias
+	}
+	return nil
+}
+
+// UpdateGuardians replaces the guardian policy for a given wallet address; nil removes it
+func (s *Store) UpdateGuardians(ctx context.Context, walletAddress string, policy *storage.GuardianPolicy) error {
+	s.updateSmsService(walletAddress, func(service *storage.SmsService) { service.Guardians = policy })
+	return nil
+}
+
+// UpdatePhoneNumber moves a phone number to a wallet address, clearing it from any other
+// wallet that used it
+func (s *Store) UpdatePhoneNumber(ctx context.Context, walletAddress string, phoneNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing := find(s.smsServices, func(service *storage.SmsService) bool { return service.PhoneNumber == phoneNumber }); existing != nil {
+		existing.PhoneNumber = ""
+	}
+	if service := s.smsService(walletAddress); service != nil {
+		service.PhoneNumber = phoneNumber
+		service.PhoneUpdatedAt = time.Now()
+		*service = clone(*service)
+	}
+	return nil
+}
+
+// ListSmsServices fetches every registered wallet
+func (s *Store) ListSmsServices(ctx context.Context) ([]storage.SmsService, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return collect(nil, s.smsServices, func(*storage.SmsService) bool { return true }), nil
+}
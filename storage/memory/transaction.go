@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+func transactionID(transaction *storage.Transaction) *primitive.ObjectID { return &transaction.ID }
+
+func transactionCreatedAt(transaction *storage.Transaction) time.Time { return transaction.CreatedAt }
+
+// CreateTransaction records a completed transfer
+func (s *Store) CreateTransaction(ctx context.Context, transaction *storage.Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.transactions, transaction, transactionID); err != nil {
+		return failed("add transaction", err)
+	}
+	return nil
+}
+
+// ListTransactionsSince fetches the transfers sent from a wallet address since the given time,
+// oldest first. Reversals, adjustments and fully refunded transfers are excluded.
+func (s *Store) ListTransactionsSince(ctx context.Context, senderAddress string, since time.Time) ([]storage.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transactions := collect(nil, s.transactions, func(transaction *storage.Transaction) bool {
+		if transaction.Kind == storage.TransactionReversal || transaction.Kind == storage.TransactionAdjustment {
+			return false
+		}
+		return transaction.SenderAddress == senderAddress && !transaction.CreatedAt.Before(since) && transaction.RefundedUSD < transaction.AmountUSD
+	})
+	byTime(transactions, transactionCreatedAt, false)
+	return transactions, nil
+}
+
+// ListTransactionsForWallet fetches the transfers a wallet address has sent or received, newest first
+func (s *Store) ListTransactionsForWallet(ctx context.Context, walletAddress string) ([]storage.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transactions := collect([]storage.Transaction{}, s.transactions, func(transaction *storage.Transaction) bool {
+		return transaction.SenderAddress == walletAddress || transaction.RecipientAddress == walletAddress
+	})
+	byTime(transactions, transactionCreatedAt, true)
+	return transactions, nil
+}
+
+// EachTransaction calls fn with every transaction, oldest first, stopping at the first error.
+// fn runs without the lock held, so it may use the store.
+func (s *Store) EachTransaction(ctx context.Context, fn func(*storage.Transaction) error) error {
+	s.mu.Lock()
+	transactions := collect(nil, s.transactions, func(*storage.Transaction) bool { return true })
+	s.mu.Unlock()
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return bytes.Compare(transactions[i].ID[:], transactions[j].ID[:]) < 0
+	})
+	for i := range transactions {
+		if err := fn(&transactions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasTransactionTo reports whether a wallet has ever sent to a recipient address or phone number
+func (s *Store) HasTransactionTo(ctx context.Context, senderAddress string, recipientAddress string, recipientPhone string) (bool, error) {
+	if recipientAddress == "" && recipientPhone == "" {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transaction := find(s.transactions, func(transaction *storage.Transaction) bool {
+		if transaction.SenderAddress != senderAddress || transaction.Kind == storage.TransactionReversal {
+			return false
+		}
+		return (recipientAddress != "" && transaction.RecipientAddress == recipientAddress) ||
+			(recipientPhone != "" && transaction.RecipientPhone == recipientPhone)
+	})
+	return transaction != nil, nil
+}
+
+// GetTransactionByID fetches a transaction by its hex ID
+func (s *Store) GetTransactionByID(ctx context.Context, id string) (*storage.Transaction, bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.transactions, func(transaction *storage.Transaction) bool { return transaction.ID == objectID })
+}
+
+// AddRefundedAmount increases a transaction's refunded amount, provided it still equals
+// expected. It reports false when another refund got there first.
+func (s *Store) AddRefundedAmount(ctx context.Context, id primitive.ObjectID, expected float64, amount float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transaction := find(s.transactions, func(transaction *storage.Transaction) bool {
+		return transaction.ID == id && transaction.RefundedUSD == expected
+	})
+	if transaction == nil || amount == 0 {
+		return false, nil
+	}
+	transaction.RefundedUSD = expected + amount
+	return true, nil
+}
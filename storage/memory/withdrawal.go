@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+// CreateWithdrawal stores a new withdrawal
+func (s *Store) CreateWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := insert(&s.withdrawals, withdrawal, func(withdrawal *storage.Withdrawal) *primitive.ObjectID { return &withdrawal.ID }); err != nil {
+		return failed("add withdrawal", err)
+	}
+	return nil
+}
+
+// GetWithdrawalByID fetches a withdrawal by its hex ID
+func (s *Store) GetWithdrawalByID(ctx context.Context, id string) (*storage.Withdrawal, bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findCopy(s.withdrawals, func(withdrawal *storage.Withdrawal) bool { return withdrawal.ID == objectID })
+}
+
+// ListWithdrawalsByStatus fetches withdrawals in any of the given statuses, oldest first
+func (s *Store) ListWithdrawalsByStatus(ctx context.Context, statuses ...string) ([]storage.Withdrawal, error) {
+	return s.listWithdrawals(func(withdrawal *storage.Withdrawal) bool {
+		for _, status := range statuses {
+			if withdrawal.Status == status {
+				return true
+			}
+		}
+		return false
+	}, false)
+}
+
+// ListWithdrawalsForWallet fetches the withdrawals sent from a wallet address, newest first
+func (s *Store) ListWithdrawalsForWallet(ctx context.Context, senderAddress string) ([]storage.Withdrawal, error) {
+	return s.listWithdrawals(func(withdrawal *storage.Withdrawal) bool { return withdrawal.SenderAddress == senderAddress }, true)
+}
+
+func (s *Store) listWithdrawals(keep func(*storage.Withdrawal) bool, newestFirst bool) ([]storage.Withdrawal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	withdrawals := collect([]storage.Withdrawal{}, s.withdrawals, keep)
+	byTime(withdrawals, func(withdrawal *storage.Withdrawal) time.Time { return withdrawal.CreatedAt }, newestFirst)
+	return withdrawals, nil
+}
+
+// TransitionWithdrawal moves a withdrawal from one status to another, setting the given
+// fields. It reports false when the withdrawal was no longer in the from status.
+func (s *Store) TransitionWithdrawal(ctx context.Context, id primitive.ObjectID, from string, to string, fields storage.WithdrawalFields) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	withdrawal := find(s.withdrawals, func(withdrawal *storage.Withdrawal) bool { return withdrawal.ID == id && withdrawal.Status == from })
+	if withdrawal == nil {
+		return false, nil
+	}
+	withdrawal.Status, withdrawal.UpdatedAt = to, time.Now()
+	if fields.TxHash != nil {
+		withdrawal.TxHash = *fields.TxHash
+	}
+	if fields.RawTx != nil {
+		withdrawal.RawTx = fields.RawTx
+	}
+	if fields.Confirmations != nil {
+		withdrawal.Confirmations = *fields.Confirmations
+	}
+	if fields.LastError != nil {
+		withdrawal.LastError = *fields.LastError
+	}
+	*withdrawal = clone(*withdrawal)
+	return true, nil
+}
+
+// RecordWithdrawalAttempt counts a failed settlement step and stores its error
+func (s *Store) RecordWithdrawalAttempt(ctx context.Context, id primitive.ObjectID, attemptErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if withdrawal := find(s.withdrawals, func(withdrawal *storage.Withdrawal) bool { return withdrawal.ID == id }); withdrawal != nil {
+		withdrawal.Attempts++
+		withdrawal.LastError = attemptErr
+		withdrawal.UpdatedAt = time.Now()
+		*withdrawal = clone(*withdrawal)
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationStatus reports a migration and when it was applied; AppliedAt is zero while it
+// is pending
+type MigrationStatus struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"applied_at,omitempty" json:"applied_at,omitempty"`
+}
+
+// MigrationRepository brings a backend's indexes and records up to date with the code
+type MigrationRepository interface {
+	Migrate(ctx context.Context) ([]MigrationStatus, error)
+	ListMigrations(ctx context.Context) ([]MigrationStatus, error)
+}
+
+// mongoMigration is one versioned change to MongoDB's indexes or records. Up must be safe
+// to run again, since a migration interrupted part way through is retried from the start.
+type mongoMigration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, m *Mongo) error
+}
+
+// mongoMigrations are applied in order, each once. Released migrations must never change;
+// add a new version instead.
+var mongoMigrations = []mongoMigration{
+	{1, "unique wallet, phone number and alias indexes on sms_service", indexSmsServices},
+	{2, "expire 2FA codes with a TTL index", expireTwoFactorCodes},
+	{3, "index the lookups of every other collection", indexCollections},
+	{4, "backfill refunded_usd on transactions recorded before refunds", backfillRefundedAmounts},
+	{5, "encrypt phone numbers, passkeys and 2FA codes, looking phones up by blind index", encryptSecrets},
+	{6, "unique reference index on the custodian_transfer journal", indexCustodianTransfers},
+	{7, "record an opening adjustment for each custodian balance loaded before the ledger", recordOpeningBalances},
+}
+
+// Migration records move from running to applied. A running record older than
+// migrationClaimTTL belongs to a replica that died mid-migration, and is taken over.
+const (
+	migrationRunning  = "running"
+	migrationApplied  = "applied"
+	migrationClaimTTL = 10 * time.Minute
+)
+
+// GetMigrationCollection returns a reference to the migration collection
+func (m *Mongo) GetMigrationCollection() *mongo.Collection {
+	return m.db.Collection("migration")
+}
+
+// Migrate applies every pending migration in order and returns the ones it applied. Replicas
+// starting together wait for each other, so each migration runs on one of them.
+func (m *Mongo) Migrate(ctx context.Context) ([]MigrationStatus, error) {
+	collection := m.GetMigrationCollection()
+	applied := []MigrationStatus{}
+	for _, migration := range mongoMigrations {
+		claimed, err := m.claimMigration(ctx, migration)
+		if err != nil {
+			return applied, err
+		}
+		if !claimed {
+			continue
+		}
+
+		log.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+		if err := migration.Up(ctx, m); err != nil {
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": migration.Version, "state": migrationRunning}); err != nil {
+				log.Printf("Error releasing migration %d: %v", migration.Version, err)
+			}
+			return applied, fmt.Errorf("migration %d failed: %w", migration.Version, err)
+		}
+
+		status := MigrationStatus{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()}
+		update := bson.M{"$set": bson.M{"state": migrationApplied, "applied_at": status.AppliedAt}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": migration.Version}, update); err != nil {
+			log.Printf("Error recording migration %d: %v", migration.Version, err)
+			return applied, errors.New("failed to record migration")
+		}
+		applied = append(applied, status)
+	}
+	return applied, nil
+}
+
+// claimMigration records that this replica is running a migration. It reports false once
+// the migration has been applied, waiting while another replica runs it.
+func (m *Mongo) claimMigration(ctx context.Context, migration mongoMigration) (bool, error) {
+	collection := m.GetMigrationCollection()
+	for {
+		now := time.Now()
+		filter := bson.M{"_id": migration.Version, "state": migrationRunning, "started_at": bson.M{"$lte": now.Add(-migrationClaimTTL)}}
+		update := bson.M{"$set": bson.M{"description": migration.Description, "state": migrationRunning, "started_at": now}}
+		_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err == nil {
+			return true, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Printf("Error claiming migration %d: %v", migration.Version, err)
+			return false, errors.New("failed to claim migration")
+		}
+
+		// The record exists, so the migration is either applied or running elsewhere
+		var record struct {
+			State string `bson:"state"`
+		}
+		if err := collection.FindOne(ctx, bson.M{"_id": migration.Version}).Decode(&record); err != nil {
+			log.Printf("Error fetching migration %d: %v", migration.Version, err)
+			return false, errors.New("failed to fetch migration")
+		}
+		if record.State == migrationApplied {
+			return false, nil
+		}
+		log.Printf("Waiting for another replica to apply migration %d", migration.Version)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// ListMigrations reports every migration, oldest first, with when it was applied
+func (m *Mongo) ListMigrations(ctx context.Context) ([]MigrationStatus, error) {
+	collection := m.GetMigrationCollection()
+	cursor, err := collection.Find(ctx, bson.M{"state": migrationApplied})
+	if err != nil {
+		log.Printf("Error listing migrations: %v", err)
+		return nil, errors.New("failed to list migrations")
+	}
+	defer cursor.Close(ctx)
+
+	var records []MigrationStatus
+	if err := cursor.All(ctx, &records); err != nil {
+		log.Printf("Error decoding migrations: %v", err)
+		return nil, errors.New("failed to list migrations")
+	}
+	appliedAt := map[int]time.Time{}
+	for _, record := range records {
+		appliedAt[record.Version] = record.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, len(mongoMigrations))
+	for i, migration := range mongoMigrations {
+		statuses[i] = MigrationStatus{Version: migration.Version, Description: migration.Description, AppliedAt: appliedAt[migration.Version]}
+	}
+	return statuses, nil
+}
+
+// indexKeys builds an index's keys from field names, descending when prefixed with "-"
+func indexKeys(fields ...string) bson.D {
+	keys := bson.D{}
+	for _, field := range fields {
+		if name, descending := strings.CutPrefix(field, "-"); descending {
+			keys = append(keys, bson.E{Key: name, Value: -1})
+		} else {
+			keys = append(keys, bson.E{Key: field, Value: 1})
+		}
+	}
+	return keys
+}
+
+// createIndexes creates a collection's indexes. Indexes that already exist with the same
+// keys and options are left alone.
+func createIndexes(ctx context.Context, collection *mongo.Collection, models ...mongo.IndexModel) error {
+	if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+		return fmt.Errorf("creating %s indexes: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+// createUniqueIndex creates a unique index over fields, limited to the documents matching
+// partial when it isn't nil. Existing duplicates are reported first, since they would fail
+// the index build and must be resolved by hand.
+func createUniqueIndex(ctx context.Context, collection *mongo.Collection, partial bson.M, fields ...string) error {
+	group := bson.M{}
+	for _, field := range fields {
+		group[field] = "$" + field
+	}
+	match := bson.M{}
+	if partial != nil {
+		match = partial
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": group, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: 10}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("checking %s for duplicates: %w", collection.Name(), err)
+	}
+	var duplicates []struct {
+		Key   bson.M `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("checking %s for duplicates: %w", collection.Name(), err)
+	}
+	if len(duplicates) > 0 {
+		examples := make([]string, len(duplicates))
+		for i, duplicate := range duplicates {
+			examples[i] = fmt.Sprintf("%v (%d documents)", duplicate.Key, duplicate.Count)
+		}
+		return fmt.Errorf("%s has duplicate %s, which must be resolved before it can be uniquely indexed: %s",
+			collection.Name(), strings.Join(fields, ", "), strings.Join(examples, "; "))
+	}
+
+	opts := options.Index().SetUnique(true)
+	if partial != nil {
+		opts.SetPartialFilterExpression(partial)
+	}
+	return createIndexes(ctx, collection, mongo.IndexModel{Keys: indexKeys(fields...), Options: opts})
+}
+
+// nonEmpty matches documents where field is set to a non-empty string. Wallets without a
+// phone number or alias store an empty one, and mustn't collide with each other.
+func nonEmpty(field string) bson.M {
+	return bson.M{field: bson.M{"$gt": ""}}
+}
+
+func indexSmsServices(ctx context.Context, m *Mongo) error {
+	collection := m.GetSmsServiceCollection()
+	if err := createUniqueIndex(ctx, collection, nil, "wallet_address"); err != nil {
+		return err
+	}
+	if err := createUniqueIndex(ctx, collection, nonEmpty("phone_number"), "phone_number"); err != nil {
+		return err
+	}
+	return createUniqueIndex(ctx, collection, nonEmpty("alias"), "alias")
+}
+
+func expireTwoFactorCodes(ctx context.Context, m *Mongo) error {
+	collection := m.GetTwoFactorAuthCollection()
+
+	// Codes sent before created_at was recorded expire a full TTL from now
+	filter := bson.M{"created_at": bson.M{"$exists": false}}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"created_at": time.Now()}}); err != nil {
+		return fmt.Errorf("backfilling 2FA code times: %w", err)
+	}
+	if err := createUniqueIndex(ctx, collection, nil, "phone_number"); err != nil {
+		return err
+	}
+	ttl := options.Index().SetExpireAfterSeconds(int32(TwoFactorCodeTTL / time.Second))
+	return createIndexes(ctx, collection, mongo.IndexModel{Keys: indexKeys("created_at"), Options: ttl})
+}
+
+func indexCollections(ctx context.Context, m *Mongo) error {
+	unique := []struct {
+		collection *mongo.Collection
+		partial    bson.M
+		fields     []string
+	}{
+		{m.GetAssetCollection(), nil, []string{"symbol"}},
+		{m.GetCustodianCollection(), nil, []string{"wallet_address"}},
+		{m.GetDepositCollection(), nil, []string{"network", "tx_hash", "index"}},
+		{m.GetFeeScheduleCollection(), nil, []string{"version"}},
+		{m.GetWalletFreezeCollection(), bson.M{"status": FreezeActive}, []string{"wallet_address"}},
+	}
+	for _, index := range unique {
+		if err := createUniqueIndex(ctx, index.collection, index.partial, index.fields...); err != nil {
+			return err
+		}
+	}
+
+	expireAtTime := options.Index().SetExpireAfterSeconds(0)
+	indexes := []struct {
+		collection *mongo.Collection
+		models     []mongo.IndexModel
+	}{
+		{m.GetAuthFailureCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("phone_number", "created_at")},
+		}},
+		{m.GetSmsSessionCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("phone_number")},
+			{Keys: indexKeys("expires_at"), Options: expireAtTime},
+		}},
+		{m.GetTransactionCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("sender_address", "created_at")},
+			{Keys: indexKeys("recipient_address", "created_at")},
+			{Keys: indexKeys("recipient_phone")},
+		}},
+		{m.GetEscrowCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("recipient_phone", "status")},
+			{Keys: indexKeys("recipient_address", "status")},
+			{Keys: indexKeys("status", "expires_at")},
+		}},
+		{m.GetPendingTransferCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("reference", "status")},
+			{Keys: indexKeys("status", "expires_at")},
+			{Keys: indexKeys("sender_address", "-created_at")},
+		}},
+		{m.GetPaymentRequestCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("requester_address", "-created_at")},
+			{Keys: indexKeys("payer_address", "-created_at")},
+			{Keys: indexKeys("status", "expires_at")},
+		}},
+		{m.GetScheduledTransferCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("sender_address", "next_run_at")},
+			{Keys: indexKeys("status", "next_run_at")},
+		}},
+		{m.GetLimitChangeCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("wallet_address", "status", "effective_at")},
+		}},
+		{m.GetRiskDecisionCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("sender_address", "-created_at")},
+			{Keys: indexKeys("-created_at")},
+		}},
+		{m.GetRiskChallengeCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("sender_phone", "status", "-created_at")},
+		}},
+		{m.GetDepositCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("network", "status")},
+			{Keys: indexKeys("wallet_address", "-created_at")},
+		}},
+		{m.GetWithdrawalCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("status", "created_at")},
+			{Keys: indexKeys("sender_address", "-created_at")},
+		}},
+		{m.GetReconciliationReportCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("-created_at")},
+		}},
+		{m.GetWalletFreezeCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("status", "created_at")},
+		}},
+		{m.GetReserveSnapshotCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("-created_at")},
+		}},
+		{m.GetReserveProofCollection(), []mongo.IndexModel{
+			{Keys: indexKeys("code")},
+			{Keys: indexKeys("snapshot_id", "wallet_address")},
+		}},
+	}
+	for _, index := range indexes {
+		if err := createIndexes(ctx, index.collection, index.models...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func backfillRefundedAmounts(ctx context.Context, m *Mongo) error {
+	filter := bson.M{"refunded_usd": bson.M{"$exists": false}}
+	if _, err := m.GetTransactionCollection().UpdateMany(ctx, filter, bson.M{"$set": bson.M{"refunded_usd": 0.0}}); err != nil {
+		return fmt.Errorf("backfilling refunded amounts: %w", err)
+	}
+	return nil
+}
+
+func encryptSecrets(ctx context.Context, m *Mongo) error {
+	if _, err := m.ReencryptSecrets(ctx); err != nil {
+		return err
+	}
+
+	// Sealed phone numbers never repeat, so uniqueness moves to their blind index
+	for _, collection := range []*mongo.Collection{m.GetSmsServiceCollection(), m.GetTwoFactorAuthCollection()} {
+		if err := dropIndex(ctx, collection, "phone_number_1"); err != nil {
+			return fmt.Errorf("dropping %s phone number index: %w", collection.Name(), err)
+		}
+	}
+	if err := createUniqueIndex(ctx, m.GetSmsServiceCollection(), nonEmpty("phone_number_index"), "phone_number_index"); err != nil {
+		return err
+	}
+	if err := createUniqueIndex(ctx, m.GetTwoFactorAuthCollection(), nil, "phone_number_index"); err != nil {
+		return err
+	}
+
+	// The phone lookups indexed by migration 3 move to blind indexes too
+	indexes := []struct {
+		collection *mongo.Collection
+		plaintext  string
+		model      mongo.IndexModel
+	}{
+		{m.GetAuthFailureCollection(), "phone_number_1_created_at_1", mongo.IndexModel{Keys: indexKeys("phone_number_index", "created_at")}},
+		{m.GetSmsSessionCollection(), "phone_number_1", mongo.IndexModel{Keys: indexKeys("phone_number_index")}},
+		{m.GetTransactionCollection(), "recipient_phone_1", mongo.IndexModel{Keys: indexKeys("recipient_phone_index")}},
+		{m.GetEscrowCollection(), "recipient_phone_1_status_1", mongo.IndexModel{Keys: indexKeys("recipient_phone_index", "status")}},
+		{m.GetRiskChallengeCollection(), "sender_phone_1_status_1_created_at_-1", mongo.IndexModel{Keys: indexKeys("sender_phone_index", "status", "-created_at")}},
+	}
+	for _, index := range indexes {
+		if err := dropIndex(ctx, index.collection, index.plaintext); err != nil {
+			return fmt.Errorf("dropping %s phone number index: %w", index.collection.Name(), err)
+		}
+		if err := createIndexes(ctx, index.collection, index.model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexCustodianTransfers(ctx context.Context, m *Mongo) error {
+	journal := m.GetCustodianTransferCollection()
+	if err := createUniqueIndex(ctx, journal, nonEmpty("reference"), "reference"); err != nil {
+		return err
+	}
+	return createIndexes(ctx, journal,
+		mongo.IndexModel{Keys: indexKeys("from_address", "created_at")},
+		mongo.IndexModel{Keys: indexKeys("to_address", "created_at")})
+}
+
+// Opening adjustments are recorded by migration 7 under this reason and operator
+const (
+	openingBalanceReason   = "opening balance"
+	openingBalanceOperator = "migration"
+)
+
+// recordOpeningBalances records an adjustment crediting each custodian balance, so
+// reconciliation starts from the balances held before transactions were recorded. Stores
+// that already hold other transactions started with the ledger and are left alone; their
+// drift is resolved by operators with adjust_ledger.
+func recordOpeningBalances(ctx context.Context, m *Mongo) error {
+	transactions := m.GetTransactionCollection()
+	notOpening := bson.M{"$or": bson.A{
+		bson.M{"kind": bson.M{"$ne": TransactionAdjustment}},
+		bson.M{"operator": bson.M{"$ne": openingBalanceOperator}},
+	}}
+	recorded, err := transactions.CountDocuments(ctx, notOpening, options.Count().SetLimit(1))
+	if err != nil {
+		return fmt.Errorf("counting transactions: %w", err)
+	}
+	if recorded > 0 {
+		return nil
+	}
+
+	// A retried migration skips balances it already opened
+	opened := map[string]bool{}
+	err = m.EachTransaction(ctx, func(transaction *Transaction) error {
+		opened[transaction.RecipientAddress+"/"+transaction.RecipientCrypto] = true
+		opened[transaction.SenderAddress+"/"+transaction.Crypto] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := m.GetCustodianCollection().Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("listing custodians: %w", err)
+	}
+	custodians := []Custodian{}
+	if err := cursor.All(ctx, &custodians); err != nil {
+		return fmt.Errorf("decoding custodians: %w", err)
+	}
+
+	now := time.Now()
+	for _, custodian := range custodians {
+		for asset, amount := range custodian.Cryptocurrencies {
+			if amount == 0 || opened[custodian.WalletAddress+"/"+asset] {
+				continue
+			}
+			adjustment := Transaction{
+				Kind:            TransactionAdjustment,
+				Crypto:          asset,
+				RecipientCrypto: asset,
+				AmountUSD:       math.Abs(amount),
+				Reason:          openingBalanceReason,
+				Operator:        openingBalanceOperator,
+				CreatedAt:       now,
+			}
+			if amount > 0 {
+				adjustment.RecipientAddress = custodian.WalletAddress
+			} else {
+				adjustment.SenderAddress = custodian.WalletAddress
+			}
+			if err := m.CreateTransaction(ctx, &adjustment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
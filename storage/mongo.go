@@ -7,12 +7,19 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"crypto-sms/kms"
 )
 
-var db *mongo.Database
+// Mongo implements every repository over a MongoDB database, one collection per repository.
+// Phone numbers, passkeys and 2FA codes are sealed with cipher.
+type Mongo struct {
+	db     *mongo.Database
+	cipher *FieldCipher
+}
 
-// InitMongoDB initializes the MongoDB client
-func InitMongoDB(uri string) {
+// InitMongoDB connects to MongoDB and returns the crypto_sms database
+func InitMongoDB(uri string) *mongo.Database {
 	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
@@ -24,5 +31,40 @@ func InitMongoDB(uri string) {
 		log.Fatalf("Failed to ping MongoDB: %v", err)
 	}
 
-	db = client.Database("crypto_sms")
+	return client.Database("crypto_sms")
+}
+
+// NewMongoStore returns a store keeping every collection in db, with sensitive fields
+// encrypted under data keys wrapped by masterKeys
+func NewMongoStore(db *mongo.Database, masterKeys kms.KMS) (*Store, error) {
+	m := &Mongo{db: db}
+	cipher, err := NewFieldCipher(context.Background(), masterKeys, m)
+	if err != nil {
+		return nil, err
+	}
+	m.cipher = cipher
+	return &Store{
+		SmsServiceRepository:        m,
+		TwoFactorAuthRepository:     m,
+		SmsSessionRepository:        m,
+		AuthFailureRepository:       m,
+		CustodianRepository:         m,
+		AssetRepository:             m,
+		FeeScheduleRepository:       m,
+		CounterRepository:           m,
+		LeaseRepository:             m,
+		TransactionRepository:       m,
+		EscrowRepository:            m,
+		PendingTransferRepository:   m,
+		PaymentRequestRepository:    m,
+		ScheduledTransferRepository: m,
+		LimitChangeRepository:       m,
+		RiskRepository:              m,
+		DepositRepository:           m,
+		WithdrawalRepository:        m,
+		ReconciliationRepository:    m,
+		ReserveRepository:           m,
+		MigrationRepository:         m,
+		SecretRepository:            m,
+	}, nil
 }
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Payment request statuses
+const (
+	PaymentRequestPending   = "pending"
+	PaymentRequestApproving = "approving"
+	PaymentRequestPaid      = "paid"
+	PaymentRequestDeclined  = "declined"
+	PaymentRequestExpired   = "expired"
+)
+
+// PaymentRequest represents a request for the payer to send funds to the requester. A
+// request is approving while the payer's transfer waits for guardians or a risk challenge.
+type PaymentRequest struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Reference        string             `bson:"reference" json:"reference"`
+	RequesterAddress string             `bson:"requester_address" json:"requester_address"`
+	RequesterPhone   string             `bson:"requester_phone" json:"requester_phone"`
+	PayerAddress     string             `bson:"payer_address" json:"payer_address"`
+	PayerPhone       string             `bson:"payer_phone" json:"payer_phone"`
+	Asset            string             `bson:"asset" json:"asset"`
+	AmountUSD        float64            `bson:"amount_usd" json:"amount_usd"`
+	Status           string             `bson:"status" json:"status"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt        time.Time          `bson:"expires_at" json:"expires_at"`
+	RespondedAt      time.Time          `bson:"responded_at,omitempty" json:"responded_at,omitempty"`
+}
+
+// SealedFields returns the request's phone numbers, which are stored sealed
+func (r *PaymentRequest) SealedFields() []SealedField {
+	return []SealedField{
+		{FieldPaymentRequestRequesterPhone, &r.RequesterPhone},
+		{FieldPaymentRequestPayerPhone, &r.PayerPhone},
+	}
+}
+
+// sealedPaymentRequest is a PaymentRequest as stored, with the blind index of the payer's
+// phone, which payers' replies match on
+type sealedPaymentRequest struct {
+	PaymentRequest  `bson:",inline"`
+	PayerPhoneIndex string `bson:"payer_phone_index,omitempty"`
+}
+
+// PaymentRequestRepository stores payment requests
+type PaymentRequestRepository interface {
+	CreatePaymentRequest(ctx context.Context, request *PaymentRequest) error
+	GetPendingPaymentRequest(ctx context.Context, payerPhone string, reference string) (*PaymentRequest, bool, error)
+	GetPaymentRequest(ctx context.Context, id primitive.ObjectID) (*PaymentRequest, bool, error)
+	TransitionPaymentRequest(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error)
+	ListExpiredPaymentRequests(ctx context.Context, now time.Time) ([]PaymentRequest, error)
+	ListPaymentRequestsForWallet(ctx context.Context, walletAddress string) ([]PaymentRequest, error)
+}
+
+// GetPaymentRequestCollection returns a reference to the payment_request collection
+func (m *Mongo) GetPaymentRequestCollection() *mongo.Collection {
+	return m.db.Collection("payment_request")
+}
+
+// CreatePaymentRequest stores a new payment request
+func (m *Mongo) CreatePaymentRequest(ctx context.Context, request *PaymentRequest) error {
+	collection := m.GetPaymentRequestCollection()
+	sealed := sealedPaymentRequest{PaymentRequest: *request, PayerPhoneIndex: m.cipher.BlindIndex(request.PayerPhone)}
+	if err := m.sealRecord("payment request", &sealed.PaymentRequest); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding payment request: %v", err)
+		return errors.New("failed to add payment request")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		request.ID = id
+	}
+	return nil
+}
+
+// GetPendingPaymentRequest fetches the pending, unexpired request addressed to a payer with a reference
+func (m *Mongo) GetPendingPaymentRequest(ctx context.Context, payerPhone string, reference string) (*PaymentRequest, bool, error) {
+	collection := m.GetPaymentRequestCollection()
+	var request PaymentRequest
+	filter := bson.M{
+		"payer_phone_index": m.cipher.BlindIndex(payerPhone),
+		"reference":         reference,
+		"status":            PaymentRequestPending,
+		"expires_at":        bson.M{"$gt": time.Now()},
+	}
+	err := collection.FindOne(ctx, filter).Decode(&request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching payment request: %v", err)
+		return nil, false, errors.New("failed to fetch payment request")
+	}
+	if err := m.openRecord(ctx, "payment request", &request); err != nil {
+		return nil, false, err
+	}
+	return &request, true, nil
+}
+
+// GetPaymentRequest fetches a request by ID
+func (m *Mongo) GetPaymentRequest(ctx context.Context, id primitive.ObjectID) (*PaymentRequest, bool, error) {
+	collection := m.GetPaymentRequestCollection()
+	var request PaymentRequest
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching payment request: %v", err)
+		return nil, false, errors.New("failed to fetch payment request")
+	}
+	if err := m.openRecord(ctx, "payment request", &request); err != nil {
+		return nil, false, err
+	}
+	return &request, true, nil
+}
+
+// TransitionPaymentRequest moves a request from one status to another. It reports false
+// when the request was no longer in the from status.
+func (m *Mongo) TransitionPaymentRequest(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	collection := m.GetPaymentRequestCollection()
+	filter := bson.M{"_id": id, "status": from}
+	update := bson.M{"$set": bson.M{"status": to, "responded_at": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating payment request: %v", err)
+		return false, errors.New("failed to update payment request")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ListExpiredPaymentRequests fetches pending and approving requests whose expiry has passed
+func (m *Mongo) ListExpiredPaymentRequests(ctx context.Context, now time.Time) ([]PaymentRequest, error) {
+	filter := bson.M{"status": bson.M{"$in": []string{PaymentRequestPending, PaymentRequestApproving}}, "expires_at": bson.M{"$lte": now}}
+	return m.listPaymentRequests(ctx, filter)
+}
+
+// ListPaymentRequestsForWallet fetches the requests a wallet has made or received, newest first
+func (m *Mongo) ListPaymentRequestsForWallet(ctx context.Context, walletAddress string) ([]PaymentRequest, error) {
+	filter := bson.M{"$or": []bson.M{{"requester_address": walletAddress}, {"payer_address": walletAddress}}}
+	return m.listPaymentRequests(ctx, filter)
+}
+
+func (m *Mongo) listPaymentRequests(ctx context.Context, filter bson.M) ([]PaymentRequest, error) {
+	collection := m.GetPaymentRequestCollection()
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		log.Printf("Error listing payment requests: %v", err)
+		return nil, errors.New("failed to list payment requests")
+	}
+	defer cursor.Close(ctx)
+
+	requests := []PaymentRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		log.Printf("Error decoding payment requests: %v", err)
+		return nil, errors.New("failed to list payment requests")
+	}
+	if err := openRecords(ctx, m, "payment requests", requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pending transfer statuses
+const (
+	PendingTransferPending  = "pending"
+	PendingTransferApproved = "approved"
+	PendingTransferDenied   = "denied"
+	PendingTransferExpired  = "expired"
+)
+
+// Guardian decisions
+const (
+	GuardianApprove = "approve"
+	GuardianDeny    = "deny"
+)
+
+// PendingTransfer represents a transfer waiting for guardian co-approval
+type PendingTransfer struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Reference         string             `bson:"reference" json:"reference"`
+	SenderAddress     string             `bson:"sender_address" json:"sender_address"`
+	SenderPhone       string             `bson:"sender_phone" json:"sender_phone"`
+	Recipient         string             `bson:"recipient" json:"recipient"`
+	Crypto            string             `bson:"crypto" json:"crypto"`
+	RecipientCrypto   string             `bson:"recipient_crypto" json:"recipient_crypto"`
+	Network           string             `bson:"network,omitempty" json:"network,omitempty"`
+	AmountUSD         float64            `bson:"amount_usd" json:"amount_usd"`
+	Guardians         []string           `bson:"guardians" json:"guardians"`
+	RequiredApprovals int                `bson:"required_approvals" json:"required_approvals"`
+	Decisions         []GuardianDecision `bson:"decisions" json:"decisions"`
+	PaymentRequestID  primitive.ObjectID `bson:"payment_request_id,omitempty" json:"payment_request_id,omitempty"`
+	Status            string             `bson:"status" json:"status"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt         time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// GuardianDecision records one guardian's response to a pending transfer
+type GuardianDecision struct {
+	PhoneNumber string    `bson:"phone_number" json:"phone_number"`
+	Decision    string    `bson:"decision" json:"decision"`
+	DecidedAt   time.Time `bson:"decided_at" json:"decided_at"`
+}
+
+// SealedFields returns the transfer's sender, guardian and decision phone numbers, which
+// are stored sealed
+func (t *PendingTransfer) SealedFields() []SealedField {
+	fields := []SealedField{{FieldPendingTransferSenderPhone, &t.SenderPhone}}
+	for i := range t.Guardians {
+		fields = append(fields, SealedField{FieldPendingTransferGuardian, &t.Guardians[i]})
+	}
+	for i := range t.Decisions {
+		fields = append(fields, SealedField{FieldGuardianDecisionPhone, &t.Decisions[i].PhoneNumber})
+	}
+	return fields
+}
+
+// sealedPendingTransfer is a PendingTransfer as stored, with the blind indexes of its
+// guardians, which guardians' replies match on, and of the guardians that have decided
+type sealedPendingTransfer struct {
+	PendingTransfer `bson:",inline"`
+	GuardianIndexes []string `bson:"guardian_indexes"`
+	DecisionIndexes []string `bson:"decision_indexes"`
+}
+
+// PendingTransferRepository stores transfers waiting for guardian approval
+type PendingTransferRepository interface {
+	CreatePendingTransfer(ctx context.Context, transfer *PendingTransfer) error
+	GetPendingTransferForGuardian(ctx context.Context, reference string, guardianPhone string) (*PendingTransfer, bool, error)
+	RecordGuardianDecision(ctx context.Context, id primitive.ObjectID, decision GuardianDecision) (*PendingTransfer, bool, error)
+	TransitionPendingTransfer(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error)
+	ListExpiredPendingTransfers(ctx context.Context, now time.Time) ([]PendingTransfer, error)
+	ListPendingTransfersForWallet(ctx context.Context, senderAddress string) ([]PendingTransfer, error)
+}
+
+// GetPendingTransferCollection returns a reference to the pending_transfer collection
+func (m *Mongo) GetPendingTransferCollection() *mongo.Collection {
+	return m.db.Collection("pending_transfer")
+}
+
+// CreatePendingTransfer stores a new pending transfer
+func (m *Mongo) CreatePendingTransfer(ctx context.Context, transfer *PendingTransfer) error {
+	collection := m.GetPendingTransferCollection()
+	sealed := sealedPendingTransfer{PendingTransfer: *transfer, GuardianIndexes: []string{}, DecisionIndexes: []string{}}
+	for _, guardian := range transfer.Guardians {
+		sealed.GuardianIndexes = append(sealed.GuardianIndexes, m.cipher.BlindIndex(guardian))
+	}
+	for _, decision := range transfer.Decisions {
+		sealed.DecisionIndexes = append(sealed.DecisionIndexes, m.cipher.BlindIndex(decision.PhoneNumber))
+	}
+	sealed.Guardians, sealed.Decisions = slices.Clone(transfer.Guardians), slices.Clone(transfer.Decisions)
+	if err := m.sealRecord("pending transfer", &sealed.PendingTransfer); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding pending transfer: %v", err)
+		return errors.New("failed to add pending transfer")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		transfer.ID = id
+	}
+	return nil
+}
+
+// GetPendingTransferForGuardian fetches the pending transfer with a reference that a guardian may decide on
+func (m *Mongo) GetPendingTransferForGuardian(ctx context.Context, reference string, guardianPhone string) (*PendingTransfer, bool, error) {
+	collection := m.GetPendingTransferCollection()
+	var transfer PendingTransfer
+	filter := bson.M{"reference": reference, "guardian_indexes": m.cipher.BlindIndex(guardianPhone), "status": PendingTransferPending}
+	err := collection.FindOne(ctx, filter).Decode(&transfer)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching pending transfer: %v", err)
+		return nil, false, errors.New("failed to fetch pending transfer")
+	}
+	if err := m.openRecord(ctx, "pending transfer", &transfer); err != nil {
+		return nil, false, err
+	}
+	return &transfer, true, nil
+}
+
+// RecordGuardianDecision adds a guardian's decision to a pending transfer and returns the
+// updated transfer. It reports false when the guardian already decided or the transfer
+// is no longer pending.
+func (m *Mongo) RecordGuardianDecision(ctx context.Context, id primitive.ObjectID, decision GuardianDecision) (*PendingTransfer, bool, error) {
+	collection := m.GetPendingTransferCollection()
+	index := m.cipher.BlindIndex(decision.PhoneNumber)
+	sealed, err := m.cipher.Seal(FieldGuardianDecisionPhone, decision.PhoneNumber)
+	if err != nil {
+		log.Printf("Error sealing guardian decision: %v", err)
+		return nil, false, errors.New("failed to seal guardian decision")
+	}
+	decision.PhoneNumber = sealed
+	filter := bson.M{
+		"_id":              id,
+		"status":           PendingTransferPending,
+		"decision_indexes": bson.M{"$ne": index},
+	}
+	update := bson.M{"$push": bson.M{"decisions": decision, "decision_indexes": index}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var transfer PendingTransfer
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&transfer)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error recording guardian decision: %v", err)
+		return nil, false, errors.New("failed to record guardian decision")
+	}
+	if err := m.openRecord(ctx, "pending transfer", &transfer); err != nil {
+		return nil, false, err
+	}
+	return &transfer, true, nil
+}
+
+// TransitionPendingTransfer moves a transfer from one status to another. It reports false
+// when the transfer was no longer in the from status.
+func (m *Mongo) TransitionPendingTransfer(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	collection := m.GetPendingTransferCollection()
+	filter := bson.M{"_id": id, "status": from}
+	update := bson.M{"$set": bson.M{"status": to}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating pending transfer: %v", err)
+		return false, errors.New("failed to update pending transfer")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ListExpiredPendingTransfers fetches pending transfers whose approval window has passed
+func (m *Mongo) ListExpiredPendingTransfers(ctx context.Context, now time.Time) ([]PendingTransfer, error) {
+	return m.listPendingTransfers(ctx, bson.M{"status": PendingTransferPending, "expires_at": bson.M{"$lte": now}})
+}
+
+// ListPendingTransfersForWallet fetches every guardian-gated transfer a wallet has made, newest first
+func (m *Mongo) ListPendingTransfersForWallet(ctx context.Context, senderAddress string) ([]PendingTransfer, error) {
+	return m.listPendingTransfers(ctx, bson.M{"sender_address": senderAddress})
+}
+
+func (m *Mongo) listPendingTransfers(ctx context.Context, filter bson.M) ([]PendingTransfer, error) {
+	collection := m.GetPendingTransferCollection()
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		log.Printf("Error listing pending transfers: %v", err)
+		return nil, errors.New("failed to list pending transfers")
+	}
+	defer cursor.Close(ctx)
+
+	transfers := []PendingTransfer{}
+	if err := cursor.All(ctx, &transfers); err != nil {
+		log.Printf("Error decoding pending transfers: %v", err)
+		return nil, errors.New("failed to list pending transfers")
+	}
+	if err := openRecords(ctx, m, "pending transfers", transfers); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// Generate2FACodeAndStore stores the 2FA code sent to a phone number
+func (s *Store) Generate2FACodeAndStore(ctx context.Context, phoneNumber string, code string) error {
+	return s.storeTwoFactorCode(ctx, "update 2FA code", storage.FieldTwoFactorCode, "code", phoneNumber, code)
+}
+
+// Verify2FACode verifies the 2FA code for a given phone number, provided it hasn't expired.
+// A code that matches is used up, so it can't be verified again; an empty code never matches.
+func (s *Store) Verify2FACode(ctx context.Context, phoneNumber string, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	index := s.cipher.BlindIndex(phoneNumber)
+	sealed, exists, err := queryOne(ctx, s.db, "check 2FA code", func(row scanner, code *string) error {
+		return row.Scan(code)
+	}, `SELECT code FROM two_factor_auth WHERE phone_number_index = $1 AND created_at > $2`,
+		index, time.Now().Add(-storage.TwoFactorCodeTTL))
+	if err != nil || !exists {
+		return false, err
+	}
+	storedCode, err := s.cipher.Open(ctx, storage.FieldTwoFactorCode, *sealed)
+	if err != nil {
+		log.Printf("Error opening 2FA code: %v", err)
+		return false, errors.New("failed to check 2FA code")
+	}
+	if storedCode == "" || storedCode != code {
+		return false, nil
+	}
+
+	// Only the request that clears the code verifies it, so a code is never accepted twice
+	return exec(ctx, s.db, "use 2FA code", `UPDATE two_factor_auth SET code = '' WHERE phone_number_index = $1 AND code = $2`, index, *sealed)
+}
+
+// Store2FACode stores a code in the separate stored_code column, which Verify2FACode doesn't read
+func (s *Store) Store2FACode(ctx context.Context, phoneNumber, code string) error {
+	return s.storeTwoFactorCode(ctx, "store 2FA code", storage.FieldTwoFactorStored, "stored_code", phoneNumber, code)
+}
+
+// storeTwoFactorCode seals a code sent to a phone number into column, keeping the phone
+// number's other code
+func (s *Store) storeTwoFactorCode(ctx context.Context, operation string, field string, column string, phoneNumber string, code string) error {
+	sealedPhone, err := s.cipher.Seal(storage.FieldTwoFactorPhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return errors.New("failed to seal phone number")
+	}
+	sealedCode, err := s.cipher.Seal(field, code)
+	if err != nil {
+		log.Printf("Error sealing 2FA code: %v", err)
+		return errors.New("failed to seal 2FA code")
+	}
+	_, err = exec(ctx, s.db, operation, `INSERT INTO two_factor_auth (phone_number_index, phone_number, `+column+`, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (phone_number_index) DO UPDATE SET phone_number = EXCLUDED.phone_number, `+column+` = EXCLUDED.`+column+`, created_at = EXCLUDED.created_at`,
+		s.cipher.BlindIndex(phoneNumber), sealedPhone, sealedCode, time.Now())
+	return err
+}
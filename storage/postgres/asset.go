@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"crypto-sms/storage"
+)
+
+const assetColumns = `symbol, name, aliases, decimals, networks, contracts, enabled, min_transfer_usd, max_transfer_usd, updated_at`
+
+func scanAsset(row scanner, asset *storage.Asset) error {
+	return row.Scan(&asset.Symbol, &asset.Name, pq.Array(&asset.Aliases), &asset.Decimals, pq.Array(&asset.Networks),
+		jsonb{&asset.Contracts}, &asset.Enabled, &asset.MinTransferUSD, &asset.MaxTransferUSD, &asset.UpdatedAt)
+}
+
+func assetValues(asset *storage.Asset) []any {
+	return []any{asset.Symbol, asset.Name, pq.Array(asset.Aliases), asset.Decimals, pq.Array(asset.Networks),
+		jsonb{asset.Contracts}, asset.Enabled, asset.MinTransferUSD, asset.MaxTransferUSD, asset.UpdatedAt}
+}
+
+// FindAsset looks up an asset by symbol or alias, ignoring case
+func (s *Store) FindAsset(ctx context.Context, name string) (*storage.Asset, bool, error) {
+	return queryOne(ctx, s.db, "fetch asset", scanAsset,
+		`SELECT `+assetColumns+` FROM asset WHERE symbol = $1 OR $2 = ANY (aliases) LIMIT 1`, strings.ToUpper(name), strings.ToLower(name))
+}
+
+// ListAssets fetches every asset in the registry, ordered by symbol
+func (s *Store) ListAssets(ctx context.Context) ([]storage.Asset, error) {
+	return queryAll(ctx, s.db, "list assets", scanAsset, `SELECT `+assetColumns+` FROM asset ORDER BY symbol`)
+}
+
+// SaveAsset inserts or replaces the asset with the same symbol
+func (s *Store) SaveAsset(ctx context.Context, asset *storage.Asset) error {
+	asset.UpdatedAt = time.Now()
+	_, err := exec(ctx, s.db, "save asset", `INSERT INTO asset (`+assetColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (symbol) DO UPDATE SET name = EXCLUDED.name, aliases = EXCLUDED.aliases, decimals = EXCLUDED.decimals,
+		networks = EXCLUDED.networks, contracts = EXCLUDED.contracts, enabled = EXCLUDED.enabled,
+		min_transfer_usd = EXCLUDED.min_transfer_usd, max_transfer_usd = EXCLUDED.max_transfer_usd, updated_at = EXCLUDED.updated_at`,
+		assetValues(asset)...)
+	return err
+}
+
+// InsertAssetIfMissing adds asset unless one with the same symbol already exists
+func (s *Store) InsertAssetIfMissing(ctx context.Context, asset *storage.Asset) error {
+	asset.UpdatedAt = time.Now()
+	_, err := exec(ctx, s.db, "seed asset", `INSERT INTO asset (`+assetColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (symbol) DO NOTHING`, assetValues(asset)...)
+	return err
+}
+
+// SetAssetEnabled turns transfers of an asset on or off. It reports false when no asset has the symbol.
+func (s *Store) SetAssetEnabled(ctx context.Context, symbol string, enabled bool) (bool, error) {
+	return exec(ctx, s.db, "update asset", `UPDATE asset SET enabled = $2, updated_at = $3 WHERE symbol = $1`,
+		strings.ToUpper(symbol), enabled, time.Now())
+}
@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// RecordAuthFailure stores a failed passkey attempt for a phone number
+func (s *Store) RecordAuthFailure(ctx context.Context, phoneNumber string) error {
+	sealedPhone, err := s.cipher.Seal(storage.FieldAuthFailurePhone, phoneNumber)
+	if err != nil {
+		return failed("record auth failure", err)
+	}
+	_, err = exec(ctx, s.db, "record auth failure", `INSERT INTO auth_failure (phone_number, phone_number_index, created_at)
+		VALUES ($1, $2, $3)`, sealedPhone, s.cipher.BlindIndex(phoneNumber), time.Now())
+	return err
+}
+
+// CountAuthFailuresSince counts failed passkey attempts for a phone number since the given time
+func (s *Store) CountAuthFailuresSince(ctx context.Context, phoneNumber string, since time.Time) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM auth_failure WHERE phone_number_index = $1 AND created_at >= $2`,
+		s.cipher.BlindIndex(phoneNumber), since).Scan(&count)
+	if err != nil {
+		return 0, failed("count auth failures", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,17 @@
+package postgres
+
+import (
+	"context"
+)
+
+// NextSequence atomically increments the named counter and returns its previous value, so
+// the first call returns 0
+func (s *Store) NextSequence(ctx context.Context, name string) (int64, error) {
+	var value int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO counter (name, value) VALUES ($1, 1)
+		ON CONFLICT (name) DO UPDATE SET value = counter.value + 1 RETURNING value - 1`, name).Scan(&value)
+	if err != nil {
+		return 0, failed("increment counter", err)
+	}
+	return value, nil
+}
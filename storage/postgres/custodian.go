@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// errInsufficientBalance rolls back a transfer whose source balance doesn't cover it
+var errInsufficientBalance = errors.New("insufficient balance")
+
+// GetCustodianByWalletAddress fetches the balances held for a given wallet address
+func (s *Store) GetCustodianByWalletAddress(ctx context.Context, walletAddress string) (*storage.Custodian, bool, error) {
+	type balance struct {
+		asset  string
+		amount float64
+	}
+	balances, err := queryAll(ctx, s.db, "fetch custodian data", func(row scanner, b *balance) error {
+		return row.Scan(&b.asset, &b.amount)
+	}, `SELECT asset, amount FROM custodian_balance WHERE wallet_address = $1`, walletAddress)
+	if err != nil || len(balances) == 0 {
+		return nil, false, err
+	}
+	custodian := &storage.Custodian{WalletAddress: walletAddress, Cryptocurrencies: map[string]float64{}}
+	for _, b := range balances {
+		custodian.Cryptocurrencies[b.asset] = b.amount
+	}
+	return custodian, true, nil
+}
+
+// ListCustodianAddresses fetches the wallet address of every custodian
+func (s *Store) ListCustodianAddresses(ctx context.Context) ([]string, error) {
+	return queryAll(ctx, s.db, "list custodians", func(row scanner, address *string) error {
+		return row.Scan(address)
+	}, `SELECT DISTINCT wallet_address FROM custodian_balance`)
+}
+
+// UpdateCustodian replaces the balances of an existing custodian
+func (s *Store) UpdateCustodian(ctx context.Context, custodian *storage.Custodian) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM custodian_balance WHERE wallet_address = $1`, custodian.WalletAddress)
+		if err != nil {
+			return err
+		}
+		if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+			return err
+		}
+		for asset, amount := range custodian.Cryptocurrencies {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO custodian_balance (wallet_address, asset, amount, overdraft) VALUES ($1, $2, $3, $4)`,
+				custodian.WalletAddress, asset, amount, amount < 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return failed("update custodian data", err)
+	}
+	return nil
+}
+
+// CreditCustodian atomically adds amount to one balance, creating it if needed
+func (s *Store) CreditCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) error {
+	if err := credit(ctx, s.db, walletAddress, crypto, amount, false); err != nil {
+		return failed("credit custodian", err)
+	}
+	return nil
+}
+
+// DebitCustodian atomically subtracts amount from one balance if the balance covers it.
+// It reports false when the balance is too low.
+func (s *Store) DebitCustodian(ctx context.Context, walletAddress string, crypto string, amount float64) (bool, error) {
+	return exec(ctx, s.db, "debit custodian", `UPDATE custodian_balance SET amount = amount - $3
+		WHERE wallet_address = $1 AND asset = $2 AND amount >= $3`, walletAddress, crypto, amount)
+}
+
+// TransferBalance debits the source balance, unless it is too low and the transfer doesn't
+// allow overdrafts, and credits the destination in one transaction, recording the transfer
+// in the custodian_transfer journal. It reports false when the source balance doesn't cover
+// the transfer. A reference already in the journal was applied before, so a retry succeeds
+// without moving funds again.
+func (s *Store) TransferBalance(ctx context.Context, transfer storage.BalanceTransfer) (bool, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var reference any
+		if transfer.Reference != "" {
+			reference = transfer.Reference
+		}
+		result, err := tx.ExecContext(ctx, `INSERT INTO custodian_transfer
+			(reference, from_address, from_asset, to_address, to_asset, amount, allow_overdraft, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (reference) DO NOTHING`,
+			reference, transfer.From, transfer.FromAsset, transfer.To, transfer.ToAsset, transfer.Amount, transfer.AllowOverdraft, time.Now())
+		if err != nil {
+			return err
+		}
+		if recorded, err := result.RowsAffected(); err != nil || recorded == 0 {
+			return err
+		}
+
+		if transfer.From != "" {
+			if transfer.AllowOverdraft {
+				if err := credit(ctx, tx, transfer.From, transfer.FromAsset, -transfer.Amount, true); err != nil {
+					return err
+				}
+			} else {
+				result, err := tx.ExecContext(ctx, `UPDATE custodian_balance SET amount = amount - $3
+					WHERE wallet_address = $1 AND asset = $2 AND amount >= $3`, transfer.From, transfer.FromAsset, transfer.Amount)
+				if err != nil {
+					return err
+				}
+				debited, err := result.RowsAffected()
+				if err != nil {
+					return err
+				}
+				if debited == 0 {
+					return errInsufficientBalance
+				}
+			}
+		}
+		if transfer.To != "" {
+			return credit(ctx, tx, transfer.To, transfer.ToAsset, transfer.Amount, false)
+		}
+		return nil
+	})
+	if errors.Is(err, errInsufficientBalance) {
+		return false, nil
+	}
+	if err != nil {
+		return false, failed("transfer balance", err)
+	}
+	return true, nil
+}
+
+// credit adds amount to one balance, creating it if needed. An overdraft marks the balance
+// as one that may go negative.
+func credit(ctx context.Context, db querier, walletAddress string, crypto string, amount float64, overdraft bool) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO custodian_balance (wallet_address, asset, amount, overdraft) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (wallet_address, asset) DO UPDATE
+		SET amount = custodian_balance.amount + EXCLUDED.amount, overdraft = custodian_balance.overdraft OR EXCLUDED.overdraft`,
+		walletAddress, crypto, amount, overdraft)
+	return err
+}
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+
+	"crypto-sms/storage"
+)
+
+// ListDataKeys fetches every data key
+func (s *Store) ListDataKeys(ctx context.Context) ([]storage.DataKey, error) {
+	return queryAll(ctx, s.db, "list data keys", func(row scanner, key *storage.DataKey) error {
+		return row.Scan(&key.ID, &key.Purpose, &key.MasterKeyID, &key.WrappedKey, &key.CreatedAt)
+	}, `SELECT id, purpose, master_key_id, wrapped_key, created_at FROM data_key`)
+}
+
+// CreateDataKey stores a new data key. It returns ErrDuplicate when a key with the same ID
+// exists, which happens when replicas race to create it.
+func (s *Store) CreateDataKey(ctx context.Context, key *storage.DataKey) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO data_key (id, purpose, master_key_id, wrapped_key, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		key.ID, key.Purpose, key.MasterKeyID, key.WrappedKey, key.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return failed("add data key", err)
+	}
+	return nil
+}
+
+// RewrapDataKey replaces a data key's wrapping, provided it is still wrapped under
+// fromMasterKeyID. It reports false when another replica rewrapped it first.
+func (s *Store) RewrapDataKey(ctx context.Context, id string, fromMasterKeyID string, toMasterKeyID string, wrappedKey []byte) (bool, error) {
+	return exec(ctx, s.db, "rewrap data key", `UPDATE data_key SET master_key_id = $3, wrapped_key = $4 WHERE id = $1 AND master_key_id = $2`,
+		id, fromMasterKeyID, toMasterKeyID, wrappedKey)
+}
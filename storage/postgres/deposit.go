@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const depositColumns = `id, network, tx_hash, index, from_address, to_address, wallet_address, asset, amount, amount_usd,
+	block, confirmations, status, created_at, credited_at, updated_at`
+
+func scanDeposit(row scanner, deposit *storage.Deposit) error {
+	return row.Scan((*objectID)(&deposit.ID), &deposit.Network, &deposit.TxHash, &deposit.Index, &deposit.FromAddress,
+		&deposit.ToAddress, &deposit.WalletAddress, &deposit.Asset, &deposit.Amount, &deposit.AmountUSD, &deposit.Block,
+		&deposit.Confirmations, &deposit.Status, &deposit.CreatedAt, (*timestamp)(&deposit.CreditedAt), &deposit.UpdatedAt)
+}
+
+// InsertDepositIfMissing stores a newly seen deposit. It reports false when the deposit
+// was already recorded.
+func (s *Store) InsertDepositIfMissing(ctx context.Context, deposit *storage.Deposit) (bool, error) {
+	id := deposit.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	inserted, err := exec(ctx, s.db, "add deposit", `INSERT INTO deposit (`+depositColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (network, tx_hash, index) DO NOTHING`,
+		objectID(id), deposit.Network, deposit.TxHash, deposit.Index, deposit.FromAddress, deposit.ToAddress,
+		deposit.WalletAddress, deposit.Asset, deposit.Amount, deposit.AmountUSD, deposit.Block, deposit.Confirmations,
+		deposit.Status, deposit.CreatedAt, timestamp(deposit.CreditedAt), deposit.UpdatedAt)
+	if inserted {
+		deposit.ID = id
+	}
+	return inserted, err
+}
+
+// ReviveDeposit returns an orphaned or reversed deposit to pending when a reorg includes it
+// in a new block
+func (s *Store) ReviveDeposit(ctx context.Context, network string, txHash string, index int, block int64) (bool, error) {
+	return exec(ctx, s.db, "revive deposit", `UPDATE deposit SET status = $5, block = $4, confirmations = 0, updated_at = $8
+		WHERE network = $1 AND tx_hash = $2 AND index = $3 AND status IN ($6, $7)`,
+		network, txHash, index, block, storage.DepositPending, storage.DepositOrphaned, storage.DepositReversed, time.Now())
+}
+
+// ListUnsettledDeposits fetches a network's pending deposits and its credited deposits with
+// fewer than final confirmations, which a reorg could still undo
+func (s *Store) ListUnsettledDeposits(ctx context.Context, network string, final int64) ([]storage.Deposit, error) {
+	return queryAll(ctx, s.db, "list deposits", scanDeposit, `SELECT `+depositColumns+` FROM deposit
+		WHERE network = $1 AND (status = $2 OR (status = $3 AND confirmations < $4)) ORDER BY created_at DESC`,
+		network, storage.DepositPending, storage.DepositCredited, final)
+}
+
+// ListDepositsForWallet fetches the deposits to a wallet, newest first
+func (s *Store) ListDepositsForWallet(ctx context.Context, walletAddress string) ([]storage.Deposit, error) {
+	return queryAll(ctx, s.db, "list deposits", scanDeposit, `SELECT `+depositColumns+` FROM deposit
+		WHERE wallet_address = $1 ORDER BY created_at DESC`, walletAddress)
+}
+
+// TransitionDeposit moves a deposit from one status to another, setting the given fields.
+// It reports false when the deposit was no longer in the from status.
+func (s *Store) TransitionDeposit(ctx context.Context, id primitive.ObjectID, from string, to string, fields storage.DepositFields) (bool, error) {
+	return exec(ctx, s.db, "update deposit", `UPDATE deposit SET status = $3, updated_at = $4,
+		confirmations = COALESCE($5, confirmations), credited_at = COALESCE($6, credited_at)
+		WHERE id = $1 AND status = $2`,
+		id.Hex(), from, to, time.Now(), fields.Confirmations, fields.CreditedAt)
+}
+
+// GetScanCursor returns the next block to scan for deposits on a network
+func (s *Store) GetScanCursor(ctx context.Context, network string) (int64, bool, error) {
+	nextBlock, exists, err := queryOne(ctx, s.db, "fetch scan cursor", func(row scanner, nextBlock *int64) error {
+		return row.Scan(nextBlock)
+	}, `SELECT next_block FROM scan_cursor WHERE network = $1`, network)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	return *nextBlock, true, nil
+}
+
+// SetScanCursor records the next block to scan for deposits on a network
+func (s *Store) SetScanCursor(ctx context.Context, network string, nextBlock int64) error {
+	_, err := exec(ctx, s.db, "update scan cursor", `INSERT INTO scan_cursor (network, next_block) VALUES ($1, $2)
+		ON CONFLICT (network) DO UPDATE SET next_block = EXCLUDED.next_block`, network, nextBlock)
+	return err
+}
@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"crypto-sms/storage"
+)
+
+// ReencryptSecrets rewraps every data key under the current master key and reseals every
+// phone number, passkey and 2FA code in any table that isn't sealed under the active data key. It returns
+// how many records it resealed.
+func (s *Store) ReencryptSecrets(ctx context.Context) (int, error) {
+	if _, err := s.cipher.RewrapDataKeys(ctx); err != nil {
+		log.Printf("Error rewrapping data keys: %v", err)
+		return 0, errors.New("failed to rewrap data keys")
+	}
+	resealed := 0
+	for _, sealed := range sealedColumns {
+		count, err := s.resealTable(ctx, sealed.table, sealed.keyColumn, sealed.values)
+		resealed += count
+		if err != nil {
+			return resealed, err
+		}
+	}
+	transfers, err := s.resealPendingTransfers(ctx)
+	return resealed + transfers, err
+}
+
+// sealedValue is a sealed column of a stored record with the field it was sealed for
+type sealedValue struct {
+	field  string
+	column string
+	stored string
+}
+
+// reseal reseals values that aren't current in the row matching key, provided they still
+// hold what was read. It reports false when nothing needed resealing or the row changed
+// since it was read, which leaves it for the next run.
+func (s *Store) reseal(ctx context.Context, table string, keyColumn string, key string, values []sealedValue) (bool, error) {
+	stale := false
+	for _, value := range values {
+		stale = stale || !s.cipher.IsCurrent(value.stored)
+	}
+	if !stale {
+		return false, nil
+	}
+
+	set := ""
+	where := keyColumn + " = $1"
+	args := []any{key}
+	for _, value := range values {
+		plaintext, err := s.cipher.Open(ctx, value.field, value.stored)
+		if err != nil {
+			log.Printf("Error opening %s: %v", value.field, err)
+			return false, errors.New("failed to reseal " + table)
+		}
+		sealed, err := s.cipher.Seal(value.field, plaintext)
+		if err != nil {
+			log.Printf("Error sealing %s: %v", value.field, err)
+			return false, errors.New("failed to reseal " + table)
+		}
+		args = append(args, sealed, value.stored)
+		if set != "" {
+			set += ", "
+		}
+		set += value.column + " = $" + strconv.Itoa(len(args)-1)
+		where += " AND " + value.column + " = $" + strconv.Itoa(len(args))
+	}
+	return exec(ctx, s.db, "reseal "+table, `UPDATE `+table+` SET `+set+` WHERE `+where, args...)
+}
+
+// opening wraps scan to open the sealed fields of each record it reads
+func opening[T any, P interface {
+	*T
+	storage.Sealable
+}](ctx context.Context, cipher *storage.FieldCipher, scan func(scanner, *T) error) func(scanner, *T) error {
+	return func(row scanner, record *T) error {
+		if err := scan(row, record); err != nil {
+			return err
+		}
+		return cipher.OpenFields(ctx, P(record).SealedFields()...)
+	}
+}
+
+// sealedColumns lists the tables whose sealed values are all in text columns, with the
+// column identifying each row
+var sealedColumns = []struct {
+	table     string
+	keyColumn string
+	values    []sealedValue
+}{
+	{"sms_service", "wallet_address", []sealedValue{
+		{field: storage.FieldSmsServicePhone, column: "phone_number"},
+		{field: storage.FieldSmsServicePasskey, column: "passkey"},
+	}},
+	{"two_factor_auth", "phone_number_index", []sealedValue{
+		{field: storage.FieldTwoFactorPhone, column: "phone_number"},
+		{field: storage.FieldTwoFactorCode, column: "code"},
+		{field: storage.FieldTwoFactorStored, column: "stored_code"},
+	}},
+	{"transaction", "id", []sealedValue{
+		{field: storage.FieldTransactionSenderPhone, column: "sender_phone"},
+		{field: storage.FieldTransactionRecipientPhone, column: "recipient_phone"},
+	}},
+	{"escrow", "id", []sealedValue{
+		{field: storage.FieldEscrowSenderPhone, column: "sender_phone"},
+		{field: storage.FieldEscrowRecipientPhone, column: "recipient_phone"},
+	}},
+	{"payment_request", "id", []sealedValue{
+		{field: storage.FieldPaymentRequestRequesterPhone, column: "requester_phone"},
+		{field: storage.FieldPaymentRequestPayerPhone, column: "payer_phone"},
+	}},
+	{"risk_decision", "id", []sealedValue{{field: storage.FieldRiskDecisionSenderPhone, column: "sender_phone"}}},
+	{"risk_challenge", "id", []sealedValue{{field: storage.FieldRiskChallengeSenderPhone, column: "sender_phone"}}},
+	{"withdrawal", "id", []sealedValue{{field: storage.FieldWithdrawalSenderPhone, column: "sender_phone"}}},
+	{"sms_session", "phone_number_index", []sealedValue{{field: storage.FieldSmsSessionPhone, column: "phone_number"}}},
+	{"auth_failure", "id", []sealedValue{{field: storage.FieldAuthFailurePhone, column: "phone_number"}}},
+}
+
+// resealTable reseals the stale values of every row of a table
+func (s *Store) resealTable(ctx context.Context, table string, keyColumn string, columns []sealedValue) (int, error) {
+	selected := keyColumn
+	for _, column := range columns {
+		selected += ", " + column.column
+	}
+	type stored struct {
+		key    string
+		values []sealedValue
+	}
+	rows, err := queryAll(ctx, s.db, "list "+table+" to reseal", func(row scanner, record *stored) error {
+		record.values = slices.Clone(columns)
+		dest := []any{&record.key}
+		for i := range record.values {
+			dest = append(dest, &record.values[i].stored)
+		}
+		return row.Scan(dest...)
+	}, `SELECT `+selected+` FROM `+table)
+	if err != nil {
+		return 0, err
+	}
+
+	resealed := 0
+	for _, row := range rows {
+		updated, err := s.reseal(ctx, table, keyColumn, row.key, row.values)
+		if err != nil {
+			return resealed, err
+		}
+		if updated {
+			resealed++
+		}
+	}
+	return resealed, nil
+}
+
+// resealPendingTransfers reseals the phone numbers of pending transfers, which include the
+// guardian array and the decisions
+func (s *Store) resealPendingTransfers(ctx context.Context) (int, error) {
+	transfers, err := queryAll(ctx, s.db, "list pending transfers to reseal", scanPendingTransfer,
+		`SELECT `+pendingTransferColumns+` FROM pending_transfer`)
+	if err != nil {
+		return 0, err
+	}
+
+	resealed := 0
+	for _, transfer := range transfers {
+		stale := false
+		for _, field := range transfer.SealedFields() {
+			stale = stale || !s.cipher.IsCurrent(*field.Value)
+		}
+		if !stale {
+			continue
+		}
+
+		// Rows changed since they were read are left for the next run
+		senderPhone, guardians, decisions := transfer.SenderPhone, slices.Clone(transfer.Guardians), slices.Clone(transfer.Decisions)
+		if err := s.cipher.OpenFields(ctx, transfer.SealedFields()...); err != nil {
+			return resealed, failed("reseal pending_transfer", err)
+		}
+		if err := s.cipher.SealFields(transfer.SealedFields()...); err != nil {
+			return resealed, failed("reseal pending_transfer", err)
+		}
+		updated, err := exec(ctx, s.db, "reseal pending_transfer", `UPDATE pending_transfer
+			SET sender_phone = $2, guardians = $3, decisions = $4
+			WHERE id = $1 AND sender_phone = $5 AND guardians IS NOT DISTINCT FROM $6 AND decisions IS NOT DISTINCT FROM $7::jsonb`,
+			transfer.ID.Hex(), transfer.SenderPhone, pq.Array(transfer.Guardians), jsonb{transfer.Decisions},
+			senderPhone, pq.Array(guardians), jsonb{decisions})
+		if err != nil {
+			return resealed, err
+		}
+		if updated {
+			resealed++
+		}
+	}
+	return resealed, nil
+}
@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const escrowColumns = `id, sender_address, sender_phone, recipient_phone, recipient_address, crypto, recipient_crypto,
+	amount_usd, claim_code, status, claimed_by, created_at, expires_at, resolved_at`
+
+func scanEscrow(row scanner, escrow *storage.Escrow) error {
+	return row.Scan((*objectID)(&escrow.ID), &escrow.SenderAddress, &escrow.SenderPhone, &escrow.RecipientPhone,
+		&escrow.RecipientAddress, &escrow.Crypto, &escrow.RecipientCrypto, &escrow.AmountUSD, &escrow.ClaimCode,
+		&escrow.Status, &escrow.ClaimedBy, &escrow.CreatedAt, &escrow.ExpiresAt, (*timestamp)(&escrow.ResolvedAt))
+}
+
+// CreateEscrow stores a new held escrow
+func (s *Store) CreateEscrow(ctx context.Context, escrow *storage.Escrow) error {
+	if escrow.ID.IsZero() {
+		escrow.ID = primitive.NewObjectID()
+	}
+	sealed := *escrow
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add escrow", err)
+	}
+	_, err := exec(ctx, s.db, "add escrow", `INSERT INTO escrow (`+escrowColumns+`, recipient_phone_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		objectID(sealed.ID), sealed.SenderAddress, sealed.SenderPhone, sealed.RecipientPhone, sealed.RecipientAddress,
+		sealed.Crypto, sealed.RecipientCrypto, sealed.AmountUSD, sealed.ClaimCode, sealed.Status, sealed.ClaimedBy,
+		sealed.CreatedAt, sealed.ExpiresAt, timestamp(sealed.ResolvedAt), s.cipher.BlindIndex(escrow.RecipientPhone))
+	return err
+}
+
+// GetHeldEscrowByClaimCode fetches the held escrow for a phone number and claim code
+func (s *Store) GetHeldEscrowByClaimCode(ctx context.Context, recipientPhone string, claimCode string) (*storage.Escrow, bool, error) {
+	return queryOne(ctx, s.db, "fetch escrow", opening(ctx, s.cipher, scanEscrow), `SELECT `+escrowColumns+` FROM escrow
+		WHERE recipient_phone_index = $1 AND claim_code = $2 AND status = $3 LIMIT 1`, s.cipher.BlindIndex(recipientPhone), claimCode, storage.EscrowHeld)
+}
+
+// ListHeldEscrowsForPhone fetches the held escrows waiting for a phone number
+func (s *Store) ListHeldEscrowsForPhone(ctx context.Context, recipientPhone string) ([]storage.Escrow, error) {
+	return queryAll(ctx, s.db, "list escrows", opening(ctx, s.cipher, scanEscrow), `SELECT `+escrowColumns+` FROM escrow
+		WHERE recipient_phone_index = $1 AND status = $2`, s.cipher.BlindIndex(recipientPhone), storage.EscrowHeld)
+}
+
+// ListHeldEscrowsForAddress fetches the held escrows waiting for a wallet address
+func (s *Store) ListHeldEscrowsForAddress(ctx context.Context, recipientAddress string) ([]storage.Escrow, error) {
+	return queryAll(ctx, s.db, "list escrows", opening(ctx, s.cipher, scanEscrow), `SELECT `+escrowColumns+` FROM escrow
+		WHERE recipient_address = $1 AND status = $2`, recipientAddress, storage.EscrowHeld)
+}
+
+// ListExpiredEscrows fetches the held escrows whose expiry has passed
+func (s *Store) ListExpiredEscrows(ctx context.Context, now time.Time) ([]storage.Escrow, error) {
+	return queryAll(ctx, s.db, "list expired escrows", opening(ctx, s.cipher, scanEscrow), `SELECT `+escrowColumns+` FROM escrow
+		WHERE status = $1 AND expires_at <= $2`, storage.EscrowHeld, now)
+}
+
+// ListResolvedEscrows fetches every claimed or refunded escrow
+func (s *Store) ListResolvedEscrows(ctx context.Context) ([]storage.Escrow, error) {
+	return queryAll(ctx, s.db, "list resolved escrows", opening(ctx, s.cipher, scanEscrow), `SELECT `+escrowColumns+` FROM escrow
+		WHERE status IN ($1, $2)`, storage.EscrowClaimed, storage.EscrowRefunded)
+}
+
+// ResolveEscrow moves a held escrow to claimed or refunded. It reports false when the
+// escrow was already resolved, so concurrent claims and refunds cannot both succeed.
+func (s *Store) ResolveEscrow(ctx context.Context, id primitive.ObjectID, status string, claimedBy string) (bool, error) {
+	return exec(ctx, s.db, "resolve escrow", `UPDATE escrow SET status = $3, claimed_by = $4, resolved_at = $5
+		WHERE id = $1 AND status = $2`, id.Hex(), storage.EscrowHeld, status, claimedBy, time.Now())
+}
+
+// ReopenEscrow moves an escrow resolved to status back to held, for a release or refund
+// whose transfer failed. It reports false when the escrow isn't in that status.
+func (s *Store) ReopenEscrow(ctx context.Context, id primitive.ObjectID, status string) (bool, error) {
+	return exec(ctx, s.db, "reopen escrow", `UPDATE escrow SET status = $3, claimed_by = '', resolved_at = NULL
+		WHERE id = $1 AND status = $2`, id.Hex(), status, storage.EscrowHeld)
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// feeScheduleAttempts bounds how often CreateFeeSchedule retries a version taken by a
+// concurrent create
+const feeScheduleAttempts = 5
+
+const feeScheduleColumns = `version, rules, created_at`
+
+func scanFeeSchedule(row scanner, schedule *storage.FeeSchedule) error {
+	return row.Scan(&schedule.Version, jsonb{&schedule.Rules}, &schedule.CreatedAt)
+}
+
+// GetActiveFeeSchedule fetches the fee schedule with the highest version
+func (s *Store) GetActiveFeeSchedule(ctx context.Context) (*storage.FeeSchedule, bool, error) {
+	return queryOne(ctx, s.db, "fetch fee schedule", scanFeeSchedule,
+		`SELECT `+feeScheduleColumns+` FROM fee_schedule ORDER BY version DESC LIMIT 1`)
+}
+
+// CreateFeeSchedule stores rules as the next fee schedule version. A create that loses the
+// version to a concurrent one retries with the following version.
+func (s *Store) CreateFeeSchedule(ctx context.Context, rules []storage.FeeRule) (*storage.FeeSchedule, error) {
+	schedule := &storage.FeeSchedule{Rules: rules, CreatedAt: time.Now()}
+	for attempt := 1; ; attempt++ {
+		err := s.db.QueryRowContext(ctx, `INSERT INTO fee_schedule (version, rules, created_at)
+			SELECT COALESCE(MAX(version), 0) + 1, $1, $2 FROM fee_schedule RETURNING version`,
+			jsonb{rules}, schedule.CreatedAt).Scan(&schedule.Version)
+		if err == nil {
+			return schedule, nil
+		}
+		if !isUniqueViolation(err) || attempt == feeScheduleAttempts {
+			return nil, failed("add fee schedule", err)
+		}
+	}
+}
+
+// ListFeeSchedules fetches every fee schedule version, newest first
+func (s *Store) ListFeeSchedules(ctx context.Context) ([]storage.FeeSchedule, error) {
+	return queryAll(ctx, s.db, "list fee schedules", scanFeeSchedule,
+		`SELECT `+feeScheduleColumns+` FROM fee_schedule ORDER BY version DESC`)
+}
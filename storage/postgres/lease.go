@@ -0,0 +1,21 @@
+package postgres
+
+import (
+	"context"
+	"time"
+)
+
+// AcquireLease takes or renews the named lease for holder. It reports false while another
+// holder's lease is unexpired.
+func (s *Store) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	return exec(ctx, s.db, "acquire lease", `INSERT INTO lease (name, holder, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE lease.holder = EXCLUDED.holder OR lease.expires_at <= $4`, name, holder, now.Add(ttl), now)
+}
+
+// ReleaseLease gives up the named lease if holder still holds it
+func (s *Store) ReleaseLease(ctx context.Context, name string, holder string) error {
+	_, err := exec(ctx, s.db, "release lease", `DELETE FROM lease WHERE name = $1 AND holder = $2`, name, holder)
+	return err
+}
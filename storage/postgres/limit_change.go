@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const limitChangeColumns = `id, wallet_address, kind, old_limit, new_limit, velocity_limits, guardians, cancel_code, status, created_at, effective_at`
+
+func scanLimitChange(row scanner, change *storage.LimitChange) error {
+	return row.Scan((*objectID)(&change.ID), &change.WalletAddress, &change.Kind, &change.OldLimit, &change.NewLimit,
+		jsonb{&change.VelocityLimits}, jsonb{&change.Guardians}, &change.CancelCode, &change.Status, &change.CreatedAt, &change.EffectiveAt)
+}
+
+// CreateLimitChange stores a new pending limit change
+func (s *Store) CreateLimitChange(ctx context.Context, change *storage.LimitChange) error {
+	if change.ID.IsZero() {
+		change.ID = primitive.NewObjectID()
+	}
+	_, err := exec(ctx, s.db, "add limit change", `INSERT INTO limit_change (`+limitChangeColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, objectID(change.ID), change.WalletAddress, change.Kind,
+		change.OldLimit, change.NewLimit, jsonb{change.VelocityLimits}, jsonb{change.Guardians}, change.CancelCode, change.Status, change.CreatedAt,
+		change.EffectiveAt)
+	return err
+}
+
+// ListPendingLimitChanges fetches the pending limit changes for a wallet address, oldest first
+func (s *Store) ListPendingLimitChanges(ctx context.Context, walletAddress string) ([]storage.LimitChange, error) {
+	return queryAll(ctx, s.db, "list limit changes", scanLimitChange, `SELECT `+limitChangeColumns+` FROM limit_change
+		WHERE wallet_address = $1 AND status = $2 ORDER BY effective_at`, walletAddress, storage.LimitChangePending)
+}
+
+// SetLimitChangeStatus moves a pending limit change to a new status
+func (s *Store) SetLimitChangeStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	_, err := exec(ctx, s.db, "update limit change", `UPDATE limit_change SET status = $3 WHERE id = $1 AND status = $2`,
+		id.Hex(), storage.LimitChangePending, status)
+	return err
+}
+
+// CancelLimitChangeByCode cancels the pending limit change matching a cancel code
+func (s *Store) CancelLimitChangeByCode(ctx context.Context, walletAddress string, cancelCode string) (bool, error) {
+	return exec(ctx, s.db, "cancel limit change", `UPDATE limit_change SET status = $4
+		WHERE id = (SELECT id FROM limit_change WHERE wallet_address = $1 AND cancel_code = $2 AND status = $3 LIMIT 1)`,
+		walletAddress, cancelCode, storage.LimitChangePending, storage.LimitChangeCancelled)
+}
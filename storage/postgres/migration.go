@@ -0,0 +1,483 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// migration is one versioned change to the schema, applied in a transaction with its record
+// in schema_migration, so it either applies completely or not at all
+type migration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// migrations are applied in order, each once. Released migrations must never change; add a
+// new version instead.
+var migrations = []migration{
+	{1, "create every table, with its keys and balance constraints", createTables},
+	{2, "index the lookups of every table", createIndexes},
+}
+
+// migrationLockID names the advisory lock replicas take while migrating, so each migration
+// runs on one of them
+const migrationLockID = 7236512
+
+// bootstrap creates the tables the store needs before migrations can run: the record of
+// applied migrations, and the data keys the field cipher is built from
+func (s *Store) bootstrap(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migration (
+			version     INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at  TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS data_key (
+			id            TEXT PRIMARY KEY,
+			purpose       TEXT NOT NULL,
+			master_key_id TEXT NOT NULL,
+			wrapped_key   BYTEA NOT NULL,
+			created_at    TIMESTAMPTZ NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("error creating PostgreSQL bootstrap tables: %w", err)
+	}
+	return nil
+}
+
+// Migrate applies every pending migration in order and returns the ones it applied. Replicas
+// starting together wait on an advisory lock, so each migration runs on one of them.
+func (s *Store) Migrate(ctx context.Context) ([]storage.MigrationStatus, error) {
+	applied := []storage.MigrationStatus{}
+	for _, migration := range migrations {
+		status := storage.MigrationStatus{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()}
+		ran := false
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+				return err
+			}
+			var exists bool
+			if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migration WHERE version = $1)`, migration.Version).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return nil
+			}
+
+			log.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+			if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+				return fmt.Errorf("migration %d failed: %w", migration.Version, err)
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migration (version, description, applied_at) VALUES ($1, $2, $3)`,
+				status.Version, status.Description, status.AppliedAt)
+			ran = err == nil
+			return err
+		})
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied = append(applied, status)
+		}
+	}
+	return applied, nil
+}
+
+// ListMigrations reports every migration, oldest first, with when it was applied
+func (s *Store) ListMigrations(ctx context.Context) ([]storage.MigrationStatus, error) {
+	records, err := queryAll(ctx, s.db, "list migrations", func(row scanner, status *storage.MigrationStatus) error {
+		return row.Scan(&status.Version, &status.Description, &status.AppliedAt)
+	}, `SELECT version, description, applied_at FROM schema_migration`)
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := map[int]time.Time{}
+	for _, record := range records {
+		appliedAt[record.Version] = record.AppliedAt
+	}
+
+	statuses := make([]storage.MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = storage.MigrationStatus{Version: migration.Version, Description: migration.Description, AppliedAt: appliedAt[migration.Version]}
+	}
+	return statuses, nil
+}
+
+// createTables mirrors the MongoDB collections. Unique constraints stand in for MongoDB's
+// unique indexes, with partial indexes where empty values mustn't collide.
+const createTables = `
+CREATE TABLE sms_service (
+	wallet_address     TEXT PRIMARY KEY,
+	phone_number       TEXT NOT NULL DEFAULT '',
+	phone_number_index TEXT NOT NULL DEFAULT '',
+	phone_updated_at   TIMESTAMPTZ,
+	alias              TEXT NOT NULL DEFAULT '',
+	language           TEXT NOT NULL DEFAULT '',
+	preferred_networks JSONB,
+	passkey            TEXT NOT NULL DEFAULT '',
+	"limit"            DOUBLE PRECISION NOT NULL DEFAULT 0,
+	velocity_limits    JSONB,
+	guardians          JSONB,
+	public_key         TEXT NOT NULL DEFAULT '',
+	deposit_index      BIGINT CHECK (deposit_index >= 0)
+);
+CREATE UNIQUE INDEX sms_service_phone_number_index ON sms_service (phone_number_index) WHERE phone_number_index <> '';
+CREATE UNIQUE INDEX sms_service_alias ON sms_service (alias) WHERE alias <> '';
+
+CREATE TABLE two_factor_auth (
+	phone_number_index TEXT PRIMARY KEY,
+	phone_number       TEXT NOT NULL,
+	code               TEXT NOT NULL DEFAULT '',
+	stored_code        TEXT NOT NULL DEFAULT '',
+	created_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE sms_session (
+	phone_number_index TEXT PRIMARY KEY,
+	phone_number       TEXT NOT NULL,
+	expires_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE auth_failure (
+	id                 BIGSERIAL PRIMARY KEY,
+	phone_number       TEXT NOT NULL,
+	phone_number_index TEXT NOT NULL,
+	created_at         TIMESTAMPTZ NOT NULL
+);
+
+-- Accounts that may be overdrawn, such as fee and escrow accounts, are marked when a
+-- transfer first overdraws them; every other balance must stay covered
+CREATE TABLE custodian_balance (
+	wallet_address TEXT NOT NULL,
+	asset          TEXT NOT NULL,
+	amount         DOUBLE PRECISION NOT NULL,
+	overdraft      BOOLEAN NOT NULL DEFAULT false,
+	PRIMARY KEY (wallet_address, asset),
+	CHECK (overdraft OR amount >= 0)
+);
+
+-- The journal of custodian transfers. A reference is applied once, so retries never move
+-- funds twice.
+CREATE TABLE custodian_transfer (
+	id              BIGSERIAL PRIMARY KEY,
+	reference       TEXT UNIQUE,
+	from_address    TEXT NOT NULL DEFAULT '',
+	from_asset      TEXT NOT NULL DEFAULT '',
+	to_address      TEXT NOT NULL DEFAULT '',
+	to_asset        TEXT NOT NULL DEFAULT '',
+	amount          DOUBLE PRECISION NOT NULL CHECK (amount >= 0),
+	allow_overdraft BOOLEAN NOT NULL,
+	created_at      TIMESTAMPTZ NOT NULL,
+	CHECK (from_address <> '' OR to_address <> '')
+);
+
+CREATE TABLE asset (
+	symbol           TEXT PRIMARY KEY,
+	name             TEXT NOT NULL,
+	aliases          TEXT[],
+	decimals         INTEGER NOT NULL,
+	networks         TEXT[],
+	contracts        JSONB,
+	enabled          BOOLEAN NOT NULL,
+	min_transfer_usd DOUBLE PRECISION NOT NULL,
+	max_transfer_usd DOUBLE PRECISION NOT NULL,
+	updated_at       TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE fee_schedule (
+	version    INTEGER PRIMARY KEY,
+	rules      JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE counter (
+	name  TEXT PRIMARY KEY,
+	value BIGINT NOT NULL
+);
+
+CREATE TABLE lease (
+	name       TEXT PRIMARY KEY,
+	holder     TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE transaction (
+	id                    TEXT PRIMARY KEY,
+	kind                  TEXT NOT NULL DEFAULT '',
+	sender_address        TEXT NOT NULL,
+	sender_phone          TEXT NOT NULL,
+	recipient_address     TEXT NOT NULL,
+	recipient_phone       TEXT NOT NULL DEFAULT '',
+	recipient_phone_index TEXT NOT NULL DEFAULT '',
+	crypto                TEXT NOT NULL,
+	recipient_crypto      TEXT NOT NULL,
+	network               TEXT NOT NULL DEFAULT '',
+	amount_usd            DOUBLE PRECISION NOT NULL,
+	escrowed              BOOLEAN NOT NULL DEFAULT false,
+	fee_usd               DOUBLE PRECISION NOT NULL,
+	fee_version           INTEGER NOT NULL DEFAULT 0,
+	refunded_usd          DOUBLE PRECISION NOT NULL DEFAULT 0,
+	reversal_of           TEXT REFERENCES transaction (id),
+	reason                TEXT NOT NULL DEFAULT '',
+	operator              TEXT NOT NULL DEFAULT '',
+	created_at            TIMESTAMPTZ NOT NULL,
+	CHECK (refunded_usd >= 0)
+);
+
+CREATE TABLE escrow (
+	id                    TEXT PRIMARY KEY,
+	sender_address        TEXT NOT NULL,
+	sender_phone          TEXT NOT NULL,
+	recipient_phone       TEXT NOT NULL DEFAULT '',
+	recipient_phone_index TEXT NOT NULL DEFAULT '',
+	recipient_address     TEXT NOT NULL DEFAULT '',
+	crypto                TEXT NOT NULL,
+	recipient_crypto      TEXT NOT NULL,
+	amount_usd            DOUBLE PRECISION NOT NULL,
+	claim_code            TEXT NOT NULL,
+	status                TEXT NOT NULL CHECK (status IN ('held', 'claimed', 'refunded')),
+	claimed_by            TEXT NOT NULL DEFAULT '',
+	created_at            TIMESTAMPTZ NOT NULL,
+	expires_at            TIMESTAMPTZ NOT NULL,
+	resolved_at           TIMESTAMPTZ
+);
+
+CREATE TABLE pending_transfer (
+	id                 TEXT PRIMARY KEY,
+	reference          TEXT NOT NULL,
+	sender_address     TEXT NOT NULL,
+	sender_phone       TEXT NOT NULL,
+	recipient          TEXT NOT NULL,
+	crypto             TEXT NOT NULL,
+	recipient_crypto   TEXT NOT NULL,
+	network            TEXT NOT NULL DEFAULT '',
+	amount_usd         DOUBLE PRECISION NOT NULL,
+	guardians          TEXT[],
+	guardian_indexes   TEXT[] NOT NULL DEFAULT '{}',
+	required_approvals INTEGER NOT NULL,
+	decisions          JSONB,
+	decision_indexes   TEXT[] NOT NULL DEFAULT '{}',
+	payment_request_id TEXT,
+	status             TEXT NOT NULL CHECK (status IN ('pending', 'approved', 'denied', 'expired')),
+	created_at         TIMESTAMPTZ NOT NULL,
+	expires_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE payment_request (
+	id                TEXT PRIMARY KEY,
+	reference         TEXT NOT NULL,
+	requester_address TEXT NOT NULL,
+	requester_phone   TEXT NOT NULL,
+	payer_address     TEXT NOT NULL,
+	payer_phone       TEXT NOT NULL,
+	payer_phone_index TEXT NOT NULL DEFAULT '',
+	asset             TEXT NOT NULL,
+	amount_usd        DOUBLE PRECISION NOT NULL,
+	status            TEXT NOT NULL CHECK (status IN ('pending', 'approving', 'paid', 'declined', 'expired')),
+	created_at        TIMESTAMPTZ NOT NULL,
+	expires_at        TIMESTAMPTZ NOT NULL,
+	responded_at      TIMESTAMPTZ
+);
+
+CREATE TABLE scheduled_transfer (
+	id               TEXT PRIMARY KEY,
+	reference        TEXT NOT NULL,
+	sender_address   TEXT NOT NULL,
+	recipient        TEXT NOT NULL,
+	crypto           TEXT NOT NULL,
+	recipient_crypto TEXT NOT NULL,
+	network          TEXT NOT NULL DEFAULT '',
+	amount_usd       DOUBLE PRECISION NOT NULL,
+	frequency        TEXT NOT NULL CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+	weekday          INTEGER NOT NULL,
+	day_of_month     INTEGER NOT NULL,
+	hour             INTEGER NOT NULL,
+	status           TEXT NOT NULL CHECK (status IN ('active', 'completed', 'cancelled')),
+	next_run_at      TIMESTAMPTZ NOT NULL,
+	reminded_for     TIMESTAMPTZ,
+	last_run_at      TIMESTAMPTZ,
+	last_error       TEXT NOT NULL DEFAULT '',
+	created_at       TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE limit_change (
+	id              TEXT PRIMARY KEY,
+	wallet_address  TEXT NOT NULL,
+	kind            TEXT NOT NULL DEFAULT '',
+	old_limit       DOUBLE PRECISION NOT NULL,
+	new_limit       DOUBLE PRECISION NOT NULL,
+	velocity_limits JSONB,
+	guardians       JSONB,
+	cancel_code     TEXT NOT NULL,
+	status          TEXT NOT NULL CHECK (status IN ('pending', 'applied', 'cancelled')),
+	created_at      TIMESTAMPTZ NOT NULL,
+	effective_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE risk_decision (
+	id             TEXT PRIMARY KEY,
+	sender_address TEXT NOT NULL,
+	sender_phone   TEXT NOT NULL,
+	recipient      TEXT NOT NULL,
+	crypto         TEXT NOT NULL,
+	amount_usd     DOUBLE PRECISION NOT NULL,
+	signals        JSONB,
+	fired_rules    JSONB,
+	score          DOUBLE PRECISION NOT NULL,
+	outcome        TEXT NOT NULL CHECK (outcome IN ('allow', 'challenge', 'block')),
+	created_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE risk_challenge (
+	id                 TEXT PRIMARY KEY,
+	decision_id        TEXT REFERENCES risk_decision (id),
+	code_hash          TEXT NOT NULL DEFAULT '',
+	payment_request_id TEXT,
+	sender_address     TEXT NOT NULL,
+	sender_phone       TEXT NOT NULL,
+	sender_phone_index TEXT NOT NULL DEFAULT '',
+	recipient          TEXT NOT NULL,
+	crypto             TEXT NOT NULL,
+	recipient_crypto   TEXT NOT NULL,
+	network            TEXT NOT NULL DEFAULT '',
+	amount_usd         DOUBLE PRECISION NOT NULL,
+	status             TEXT NOT NULL CHECK (status IN ('pending', 'verified', 'expired')),
+	created_at         TIMESTAMPTZ NOT NULL,
+	expires_at         TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE deposit (
+	id             TEXT PRIMARY KEY,
+	network        TEXT NOT NULL,
+	tx_hash        TEXT NOT NULL,
+	index          INTEGER NOT NULL,
+	from_address   TEXT NOT NULL,
+	to_address     TEXT NOT NULL,
+	wallet_address TEXT NOT NULL,
+	asset          TEXT NOT NULL,
+	amount         TEXT NOT NULL,
+	amount_usd     DOUBLE PRECISION NOT NULL,
+	block          BIGINT NOT NULL,
+	confirmations  BIGINT NOT NULL,
+	status         TEXT NOT NULL CHECK (status IN ('pending', 'credited', 'orphaned', 'reversed')),
+	created_at     TIMESTAMPTZ NOT NULL,
+	credited_at    TIMESTAMPTZ,
+	updated_at     TIMESTAMPTZ NOT NULL,
+	UNIQUE (network, tx_hash, index)
+);
+
+CREATE TABLE scan_cursor (
+	network    TEXT PRIMARY KEY,
+	next_block BIGINT NOT NULL
+);
+
+CREATE TABLE withdrawal (
+	id             TEXT PRIMARY KEY,
+	transaction_id TEXT REFERENCES transaction (id),
+	sender_address TEXT NOT NULL,
+	sender_phone   TEXT NOT NULL,
+	crypto         TEXT NOT NULL,
+	asset          TEXT NOT NULL,
+	network        TEXT NOT NULL,
+	to_address     TEXT NOT NULL,
+	amount_usd     DOUBLE PRECISION NOT NULL,
+	fee_usd        DOUBLE PRECISION NOT NULL,
+	amount         TEXT NOT NULL,
+	status         TEXT NOT NULL CHECK (status IN ('queued', 'signed', 'broadcast', 'confirmed', 'refunded')),
+	tx_hash        TEXT NOT NULL DEFAULT '',
+	raw_tx         BYTEA,
+	confirmations  BIGINT NOT NULL DEFAULT 0,
+	attempts       INTEGER NOT NULL DEFAULT 0,
+	custodian      TEXT NOT NULL DEFAULT '',
+	last_error     TEXT NOT NULL DEFAULT '',
+	created_at     TIMESTAMPTZ NOT NULL,
+	updated_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE reconciliation_report (
+	id            TEXT PRIMARY KEY,
+	provider      TEXT NOT NULL,
+	accounts      INTEGER NOT NULL,
+	discrepancies JSONB,
+	totals        JSONB,
+	alerts        TEXT[],
+	frozen        TEXT[],
+	created_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE wallet_freeze (
+	id             TEXT PRIMARY KEY,
+	wallet_address TEXT NOT NULL,
+	report_id      TEXT,
+	reason         TEXT NOT NULL,
+	status         TEXT NOT NULL CHECK (status IN ('active', 'resolved')),
+	created_at     TIMESTAMPTZ NOT NULL,
+	resolved_by    TEXT NOT NULL DEFAULT '',
+	resolution     TEXT NOT NULL DEFAULT '',
+	resolved_at    TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX wallet_freeze_active ON wallet_freeze (wallet_address) WHERE status = 'active';
+
+CREATE TABLE reserve_snapshot (
+	id                      TEXT PRIMARY KEY,
+	root                    TEXT NOT NULL,
+	total_liabilities_cents BIGINT NOT NULL CHECK (total_liabilities_cents >= 0),
+	asset_totals_cents      JSONB,
+	accounts                INTEGER NOT NULL,
+	created_at              TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE reserve_proof (
+	id             TEXT PRIMARY KEY,
+	snapshot_id    TEXT NOT NULL REFERENCES reserve_snapshot (id),
+	wallet_address TEXT NOT NULL,
+	code           TEXT NOT NULL,
+	salt           TEXT NOT NULL,
+	account_hash   TEXT NOT NULL,
+	balances_cents JSONB,
+	sum_cents      BIGINT NOT NULL CHECK (sum_cents >= 0),
+	leaf           TEXT NOT NULL,
+	proof          JSONB
+);
+`
+
+// createIndexes mirrors the lookup indexes of MongoDB's collections
+const createIndexes = `
+CREATE INDEX auth_failure_phone_number_index ON auth_failure (phone_number_index, created_at);
+CREATE INDEX custodian_transfer_from ON custodian_transfer (from_address, created_at);
+CREATE INDEX custodian_transfer_to ON custodian_transfer (to_address, created_at);
+CREATE INDEX transaction_sender_address ON transaction (sender_address, created_at);
+CREATE INDEX transaction_recipient_address ON transaction (recipient_address, created_at);
+CREATE INDEX transaction_recipient_phone_index ON transaction (recipient_phone_index);
+CREATE INDEX escrow_recipient_phone_index ON escrow (recipient_phone_index, status);
+CREATE INDEX escrow_recipient_address ON escrow (recipient_address, status);
+CREATE INDEX escrow_status ON escrow (status, expires_at);
+CREATE INDEX pending_transfer_reference ON pending_transfer (reference, status);
+CREATE INDEX pending_transfer_status ON pending_transfer (status, expires_at);
+CREATE INDEX pending_transfer_sender_address ON pending_transfer (sender_address, created_at DESC);
+CREATE INDEX payment_request_requester_address ON payment_request (requester_address, created_at DESC);
+CREATE INDEX payment_request_payer_address ON payment_request (payer_address, created_at DESC);
+CREATE INDEX payment_request_status ON payment_request (status, expires_at);
+CREATE INDEX scheduled_transfer_sender_address ON scheduled_transfer (sender_address, next_run_at);
+CREATE INDEX scheduled_transfer_status ON scheduled_transfer (status, next_run_at);
+CREATE INDEX limit_change_wallet_address ON limit_change (wallet_address, status, effective_at);
+CREATE INDEX risk_decision_sender_address ON risk_decision (sender_address, created_at DESC);
+CREATE INDEX risk_decision_created_at ON risk_decision (created_at DESC);
+CREATE INDEX risk_challenge_sender_phone_index ON risk_challenge (sender_phone_index, status, created_at DESC);
+CREATE INDEX risk_challenge_status ON risk_challenge (status, expires_at);
+CREATE INDEX deposit_network ON deposit (network, status);
+CREATE INDEX deposit_wallet_address ON deposit (wallet_address, created_at DESC);
+CREATE INDEX withdrawal_status ON withdrawal (status, created_at);
+CREATE INDEX withdrawal_sender_address ON withdrawal (sender_address, created_at DESC);
+CREATE INDEX reconciliation_report_created_at ON reconciliation_report (created_at DESC);
+CREATE INDEX wallet_freeze_status ON wallet_freeze (status, created_at);
+CREATE INDEX reserve_snapshot_created_at ON reserve_snapshot (created_at DESC);
+CREATE INDEX reserve_proof_code ON reserve_proof (code);
+CREATE INDEX reserve_proof_snapshot_id ON reserve_proof (snapshot_id, wallet_address);
+`
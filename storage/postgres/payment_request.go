@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const paymentRequestColumns = `id, reference, requester_address, requester_phone, payer_address, payer_phone, asset,
+	amount_usd, status, created_at, expires_at, responded_at`
+
+func scanPaymentRequest(row scanner, request *storage.PaymentRequest) error {
+	return row.Scan((*objectID)(&request.ID), &request.Reference, &request.RequesterAddress, &request.RequesterPhone,
+		&request.PayerAddress, &request.PayerPhone, &request.Asset, &request.AmountUSD, &request.Status,
+		&request.CreatedAt, &request.ExpiresAt, (*timestamp)(&request.RespondedAt))
+}
+
+// CreatePaymentRequest stores a new payment request
+func (s *Store) CreatePaymentRequest(ctx context.Context, request *storage.PaymentRequest) error {
+	if request.ID.IsZero() {
+		request.ID = primitive.NewObjectID()
+	}
+	sealed := *request
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add payment request", err)
+	}
+	_, err := exec(ctx, s.db, "add payment request", `INSERT INTO payment_request (`+paymentRequestColumns+`, payer_phone_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		objectID(sealed.ID), sealed.Reference, sealed.RequesterAddress, sealed.RequesterPhone, sealed.PayerAddress,
+		sealed.PayerPhone, sealed.Asset, sealed.AmountUSD, sealed.Status, sealed.CreatedAt, sealed.ExpiresAt,
+		timestamp(sealed.RespondedAt), s.cipher.BlindIndex(request.PayerPhone))
+	return err
+}
+
+// GetPendingPaymentRequest fetches the pending, unexpired request addressed to a payer with a reference
+func (s *Store) GetPendingPaymentRequest(ctx context.Context, payerPhone string, reference string) (*storage.PaymentRequest, bool, error) {
+	return queryOne(ctx, s.db, "fetch payment request", opening(ctx, s.cipher, scanPaymentRequest), `SELECT `+paymentRequestColumns+` FROM payment_request
+		WHERE payer_phone_index = $1 AND reference = $2 AND status = $3 AND expires_at > $4 LIMIT 1`,
+		s.cipher.BlindIndex(payerPhone), reference, storage.PaymentRequestPending, time.Now())
+}
+
+// GetPaymentRequest fetches a request by ID
+func (s *Store) GetPaymentRequest(ctx context.Context, id primitive.ObjectID) (*storage.PaymentRequest, bool, error) {
+	return queryOne(ctx, s.db, "fetch payment request", opening(ctx, s.cipher, scanPaymentRequest), `SELECT `+paymentRequestColumns+`
+		FROM payment_request WHERE id = $1`, id.Hex())
+}
+
+// TransitionPaymentRequest moves a request from one status to another. It reports false
+// when the request was no longer in the from status.
+func (s *Store) TransitionPaymentRequest(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	return exec(ctx, s.db, "update payment request", `UPDATE payment_request SET status = $3, responded_at = $4
+		WHERE id = $1 AND status = $2`, id.Hex(), from, to, time.Now())
+}
+
+// ListExpiredPaymentRequests fetches pending and approving requests whose expiry has passed
+func (s *Store) ListExpiredPaymentRequests(ctx context.Context, now time.Time) ([]storage.PaymentRequest, error) {
+	return queryAll(ctx, s.db, "list expired payment requests", opening(ctx, s.cipher, scanPaymentRequest), `SELECT `+paymentRequestColumns+`
+		FROM payment_request WHERE status IN ($1, $2) AND expires_at <= $3 ORDER BY created_at DESC`,
+		storage.PaymentRequestPending, storage.PaymentRequestApproving, now)
+}
+
+// ListPaymentRequestsForWallet fetches the requests a wallet has made or received, newest first
+func (s *Store) ListPaymentRequestsForWallet(ctx context.Context, walletAddress string) ([]storage.PaymentRequest, error) {
+	return queryAll(ctx, s.db, "list payment requests", opening(ctx, s.cipher, scanPaymentRequest), `SELECT `+paymentRequestColumns+`
+		FROM payment_request WHERE requester_address = $1 OR payer_address = $1 ORDER BY created_at DESC`, walletAddress)
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const pendingTransferColumns = `id, reference, sender_address, sender_phone, recipient, crypto, recipient_crypto, network,
+	amount_usd, guardians, required_approvals, decisions, payment_request_id, status, created_at, expires_at`
+
+func scanPendingTransfer(row scanner, transfer *storage.PendingTransfer) error {
+	return row.Scan((*objectID)(&transfer.ID), &transfer.Reference, &transfer.SenderAddress, &transfer.SenderPhone,
+		&transfer.Recipient, &transfer.Crypto, &transfer.RecipientCrypto, &transfer.Network, &transfer.AmountUSD,
+		pq.Array(&transfer.Guardians), &transfer.RequiredApprovals, jsonb{&transfer.Decisions},
+		(*objectID)(&transfer.PaymentRequestID), &transfer.Status,
+		&transfer.CreatedAt, &transfer.ExpiresAt)
+}
+
+// CreatePendingTransfer stores a new pending transfer
+func (s *Store) CreatePendingTransfer(ctx context.Context, transfer *storage.PendingTransfer) error {
+	if transfer.ID.IsZero() {
+		transfer.ID = primitive.NewObjectID()
+	}
+	guardianIndexes, decisionIndexes := []string{}, []string{}
+	for _, guardian := range transfer.Guardians {
+		guardianIndexes = append(guardianIndexes, s.cipher.BlindIndex(guardian))
+	}
+	for _, decision := range transfer.Decisions {
+		decisionIndexes = append(decisionIndexes, s.cipher.BlindIndex(decision.PhoneNumber))
+	}
+	sealed := *transfer
+	sealed.Guardians, sealed.Decisions = slices.Clone(transfer.Guardians), slices.Clone(transfer.Decisions)
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add pending transfer", err)
+	}
+	_, err := exec(ctx, s.db, "add pending transfer", `INSERT INTO pending_transfer (`+pendingTransferColumns+`,
+		guardian_indexes, decision_indexes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+		objectID(sealed.ID), sealed.Reference, sealed.SenderAddress, sealed.SenderPhone, sealed.Recipient,
+		sealed.Crypto, sealed.RecipientCrypto, sealed.Network, sealed.AmountUSD, pq.Array(sealed.Guardians),
+		sealed.RequiredApprovals, jsonb{sealed.Decisions}, objectID(sealed.PaymentRequestID), sealed.Status,
+		sealed.CreatedAt, sealed.ExpiresAt, pq.Array(guardianIndexes), pq.Array(decisionIndexes))
+	return err
+}
+
+// GetPendingTransferForGuardian fetches the pending transfer with a reference that a guardian may decide on
+func (s *Store) GetPendingTransferForGuardian(ctx context.Context, reference string, guardianPhone string) (*storage.PendingTransfer, bool, error) {
+	return queryOne(ctx, s.db, "fetch pending transfer", opening(ctx, s.cipher, scanPendingTransfer), `SELECT `+pendingTransferColumns+`
+		FROM pending_transfer WHERE reference = $1 AND status = $2 AND $3 = ANY (guardian_indexes) LIMIT 1`,
+		reference, storage.PendingTransferPending, s.cipher.BlindIndex(guardianPhone))
+}
+
+// RecordGuardianDecision adds a guardian's decision to a pending transfer and returns the
+// updated transfer. It reports false when the guardian already decided or the transfer
+// is no longer pending.
+func (s *Store) RecordGuardianDecision(ctx context.Context, id primitive.ObjectID, decision storage.GuardianDecision) (*storage.PendingTransfer, bool, error) {
+	index := s.cipher.BlindIndex(decision.PhoneNumber)
+	sealed, err := s.cipher.Seal(storage.FieldGuardianDecisionPhone, decision.PhoneNumber)
+	if err != nil {
+		return nil, false, failed("record guardian decision", err)
+	}
+	decision.PhoneNumber = sealed
+	return queryOne(ctx, s.db, "record guardian decision", opening(ctx, s.cipher, scanPendingTransfer), `UPDATE pending_transfer
+		SET decisions = COALESCE(decisions, '[]'::jsonb) || jsonb_build_array($3::jsonb), decision_indexes = array_append(decision_indexes, $4)
+		WHERE id = $1 AND status = $2 AND NOT $4 = ANY (decision_indexes)
+		RETURNING `+pendingTransferColumns, id.Hex(), storage.PendingTransferPending, jsonb{decision}, index)
+}
+
+// TransitionPendingTransfer moves a transfer from one status to another. It reports false
+// when the transfer was no longer in the from status.
+func (s *Store) TransitionPendingTransfer(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	if from == to {
+		return false, nil
+	}
+	return exec(ctx, s.db, "update pending transfer", `UPDATE pending_transfer SET status = $3 WHERE id = $1 AND status = $2`,
+		id.Hex(), from, to)
+}
+
+// ListExpiredPendingTransfers fetches pending transfers whose approval window has passed
+func (s *Store) ListExpiredPendingTransfers(ctx context.Context, now time.Time) ([]storage.PendingTransfer, error) {
+	return queryAll(ctx, s.db, "list expired pending transfers", opening(ctx, s.cipher, scanPendingTransfer), `SELECT `+pendingTransferColumns+`
+		FROM pending_transfer WHERE status = $1 AND expires_at <= $2 ORDER BY created_at DESC`, storage.PendingTransferPending, now)
+}
+
+// ListPendingTransfersForWallet fetches every guardian-gated transfer a wallet has made, newest first
+func (s *Store) ListPendingTransfersForWallet(ctx context.Context, senderAddress string) ([]storage.PendingTransfer, error) {
+	return queryAll(ctx, s.db, "list pending transfers", opening(ctx, s.cipher, scanPendingTransfer), `SELECT `+pendingTransferColumns+`
+		FROM pending_transfer WHERE sender_address = $1 ORDER BY created_at DESC`, senderAddress)
+}
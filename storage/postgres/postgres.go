@@ -0,0 +1,248 @@
+// Package postgres keeps every repository in a PostgreSQL database, one table per MongoDB
+// collection. Fields that are queried, sorted or constrained get their own column; nested
+// records, such as fee rules or guardian decisions, are stored as JSONB. Custodian balances
+// live one row per account and asset, so transfers run as a single SQL transaction and
+// CHECK constraints keep balances from going negative.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/kms"
+	"crypto-sms/storage"
+)
+
+// Store implements every repository over a PostgreSQL database. Phone numbers, passkeys and
+// 2FA codes are sealed with cipher, exactly as the MongoDB store seals them.
+type Store struct {
+	db     *sql.DB
+	cipher *storage.FieldCipher
+}
+
+// Open connects to the PostgreSQL database at url
+func Open(url string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to PostgreSQL: %w", err)
+	}
+	return db, nil
+}
+
+// NewStore returns a store keeping every table in db, with sensitive fields encrypted under
+// data keys wrapped by masterKeys
+func NewStore(db *sql.DB, masterKeys kms.KMS) (*storage.Store, error) {
+	ctx := context.Background()
+	s := &Store{db: db}
+	if err := s.bootstrap(ctx); err != nil {
+		return nil, err
+	}
+	cipher, err := storage.NewFieldCipher(ctx, masterKeys, s)
+	if err != nil {
+		return nil, err
+	}
+	s.cipher = cipher
+	return &storage.Store{
+		SmsServiceRepository:        s,
+		TwoFactorAuthRepository:     s,
+		SmsSessionRepository:        s,
+		AuthFailureRepository:       s,
+		CustodianRepository:         s,
+		AssetRepository:             s,
+		FeeScheduleRepository:       s,
+		CounterRepository:           s,
+		LeaseRepository:             s,
+		TransactionRepository:       s,
+		EscrowRepository:            s,
+		PendingTransferRepository:   s,
+		PaymentRequestRepository:    s,
+		ScheduledTransferRepository: s,
+		LimitChangeRepository:       s,
+		RiskRepository:              s,
+		DepositRepository:           s,
+		WithdrawalRepository:        s,
+		ReconciliationRepository:    s,
+		ReserveRepository:           s,
+		MigrationRepository:         s,
+		SecretRepository:            s,
+	}, nil
+}
+
+// querier runs statements on the database or inside a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// scanner reads the columns of one row
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// failed logs err and returns the error MongoDB's implementation returns for the operation
+func failed(operation string, err error) error {
+	log.Printf("Error in %s: %v", operation, err)
+	return errors.New("failed to " + operation)
+}
+
+// isUniqueViolation reports whether err is a write rejected by a unique constraint
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// exec runs a statement and reports whether it changed any row
+func exec(ctx context.Context, db querier, operation string, query string, args ...any) (bool, error) {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, failed(operation, err)
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, failed(operation, err)
+	}
+	return changed > 0, nil
+}
+
+// queryOne runs a query for at most one row, reading it with scan
+func queryOne[T any](ctx context.Context, db querier, operation string, scan func(scanner, *T) error, query string, args ...any) (*T, bool, error) {
+	var record T
+	if err := scan(db.QueryRowContext(ctx, query, args...), &record); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, failed(operation, err)
+	}
+	return &record, true, nil
+}
+
+// queryAll runs a query, reading every row with scan
+func queryAll[T any](ctx context.Context, db querier, operation string, scan func(scanner, *T) error, query string, args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, failed(operation, err)
+	}
+	defer rows.Close()
+
+	records := []T{}
+	for rows.Next() {
+		var record T
+		if err := scan(rows, &record); err != nil {
+			return nil, failed(operation, err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, failed(operation, err)
+	}
+	return records, nil
+}
+
+// inTx runs fn in a transaction, committing it when fn succeeds
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// objectID stores an ObjectID as its hex string, and a zero ObjectID as NULL
+type objectID primitive.ObjectID
+
+func (id objectID) Value() (driver.Value, error) {
+	if primitive.ObjectID(id).IsZero() {
+		return nil, nil
+	}
+	return primitive.ObjectID(id).Hex(), nil
+}
+
+func (id *objectID) Scan(src any) error {
+	var hex string
+	switch value := src.(type) {
+	case nil:
+		*id = objectID(primitive.NilObjectID)
+		return nil
+	case string:
+		hex = value
+	case []byte:
+		hex = string(value)
+	default:
+		return fmt.Errorf("can't scan %T into an ObjectID", src)
+	}
+	parsed, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return err
+	}
+	*id = objectID(parsed)
+	return nil
+}
+
+// timestamp stores a time, and a zero time as NULL, the way omitempty leaves it unset
+type timestamp time.Time
+
+func (t timestamp) Value() (driver.Value, error) {
+	if time.Time(t).IsZero() {
+		return nil, nil
+	}
+	return time.Time(t), nil
+}
+
+func (t *timestamp) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*t = timestamp(time.Time{})
+	case time.Time:
+		*t = timestamp(value)
+	default:
+		return fmt.Errorf("can't scan %T into a time", src)
+	}
+	return nil
+}
+
+// jsonb stores a nested value as JSONB. Scanning decodes into the value, which must be a
+// pointer; NULL leaves it unset.
+type jsonb struct {
+	v any
+}
+
+func (j jsonb) Value() (driver.Value, error) {
+	data, err := json.Marshal(j.v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (j jsonb) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(value), j.v)
+	case []byte:
+		return json.Unmarshal(value, j.v)
+	default:
+		return fmt.Errorf("can't scan %T into JSON", src)
+	}
+}
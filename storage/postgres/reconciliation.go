@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const reconciliationReportColumns = `id, provider, accounts, discrepancies, totals, alerts, frozen, created_at`
+
+func scanReconciliationReport(row scanner, report *storage.ReconciliationReport) error {
+	return row.Scan((*objectID)(&report.ID), &report.Provider, &report.Accounts, jsonb{&report.Discrepancies},
+		jsonb{&report.Totals}, pq.Array(&report.Alerts), pq.Array(&report.Frozen), &report.CreatedAt)
+}
+
+const walletFreezeColumns = `id, wallet_address, report_id, reason, status, created_at, resolved_by, resolution, resolved_at`
+
+func scanWalletFreeze(row scanner, freeze *storage.WalletFreeze) error {
+	return row.Scan((*objectID)(&freeze.ID), &freeze.WalletAddress, (*objectID)(&freeze.ReportID), &freeze.Reason,
+		&freeze.Status, &freeze.CreatedAt, &freeze.ResolvedBy, &freeze.Resolution, (*timestamp)(&freeze.ResolvedAt))
+}
+
+// CreateReconciliationReport stores a finished reconciliation run
+func (s *Store) CreateReconciliationReport(ctx context.Context, report *storage.ReconciliationReport) error {
+	if report.ID.IsZero() {
+		report.ID = primitive.NewObjectID()
+	}
+	_, err := exec(ctx, s.db, "add reconciliation report", `INSERT INTO reconciliation_report (`+reconciliationReportColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, objectID(report.ID), report.Provider, report.Accounts,
+		jsonb{report.Discrepancies}, jsonb{report.Totals}, pq.Array(report.Alerts), pq.Array(report.Frozen), report.CreatedAt)
+	return err
+}
+
+// GetReconciliationReport fetches a report by its hex ID, or the latest report when id is empty
+func (s *Store) GetReconciliationReport(ctx context.Context, id string) (*storage.ReconciliationReport, bool, error) {
+	if id == "" {
+		return queryOne(ctx, s.db, "fetch reconciliation report", scanReconciliationReport,
+			`SELECT `+reconciliationReportColumns+` FROM reconciliation_report ORDER BY created_at DESC LIMIT 1`)
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	return queryOne(ctx, s.db, "fetch reconciliation report", scanReconciliationReport,
+		`SELECT `+reconciliationReportColumns+` FROM reconciliation_report WHERE id = $1`, objectID.Hex())
+}
+
+// FreezeWallet freezes a wallet unless it is already frozen. It reports whether a new
+// freeze was created.
+func (s *Store) FreezeWallet(ctx context.Context, freeze *storage.WalletFreeze) (bool, error) {
+	freeze.Status = storage.FreezeActive
+	id := primitive.NewObjectID()
+	created, err := exec(ctx, s.db, "freeze wallet", `INSERT INTO wallet_freeze (`+walletFreezeColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (wallet_address) WHERE status = 'active' DO NOTHING`,
+		objectID(id), freeze.WalletAddress, objectID(freeze.ReportID), freeze.Reason, freeze.Status, freeze.CreatedAt,
+		freeze.ResolvedBy, freeze.Resolution, timestamp(freeze.ResolvedAt))
+	if created {
+		freeze.ID = id
+	}
+	return created, err
+}
+
+// IsWalletFrozen reports whether a wallet has an active freeze
+func (s *Store) IsWalletFrozen(ctx context.Context, walletAddress string) (bool, error) {
+	var frozen bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM wallet_freeze WHERE wallet_address = $1 AND status = $2)`,
+		walletAddress, storage.FreezeActive).Scan(&frozen)
+	if err != nil {
+		return false, failed("check wallet freeze", err)
+	}
+	return frozen, nil
+}
+
+// ListActiveWalletFreezes fetches every active freeze, oldest first
+func (s *Store) ListActiveWalletFreezes(ctx context.Context) ([]storage.WalletFreeze, error) {
+	return queryAll(ctx, s.db, "list wallet freezes", scanWalletFreeze, `SELECT `+walletFreezeColumns+` FROM wallet_freeze
+		WHERE status = $1 ORDER BY created_at`, storage.FreezeActive)
+}
+
+// ResolveWalletFreeze lifts a wallet's active freeze. It reports false when the wallet
+// wasn't frozen.
+func (s *Store) ResolveWalletFreeze(ctx context.Context, walletAddress string, operator string, resolution string) (bool, error) {
+	return exec(ctx, s.db, "resolve wallet freeze", `UPDATE wallet_freeze SET status = $3, resolved_by = $4, resolution = $5, resolved_at = $6
+		WHERE wallet_address = $1 AND status = $2`,
+		walletAddress, storage.FreezeActive, storage.FreezeResolved, operator, resolution, time.Now())
+}
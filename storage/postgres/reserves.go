@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const reserveSnapshotColumns = `id, root, total_liabilities_cents, asset_totals_cents, accounts, created_at`
+
+func scanReserveSnapshot(row scanner, snapshot *storage.ReserveSnapshot) error {
+	return row.Scan((*objectID)(&snapshot.ID), &snapshot.Root, &snapshot.TotalLiabilitiesCents,
+		jsonb{&snapshot.AssetTotalsCents}, &snapshot.Accounts, &snapshot.CreatedAt)
+}
+
+const reserveProofColumns = `id, snapshot_id, wallet_address, code, salt, account_hash, balances_cents, sum_cents, leaf, proof`
+
+func scanReserveProof(row scanner, proof *storage.ReserveProof) error {
+	return row.Scan((*objectID)(&proof.ID), (*objectID)(&proof.SnapshotID), &proof.WalletAddress, &proof.Code, &proof.Salt,
+		&proof.AccountHash, jsonb{&proof.BalancesCents}, &proof.SumCents, &proof.Leaf, jsonb{&proof.Proof})
+}
+
+// CreateReserveSnapshot stores a snapshot with its proofs in one transaction, so a snapshot
+// is only published once every proof can be fetched
+func (s *Store) CreateReserveSnapshot(ctx context.Context, snapshot *storage.ReserveSnapshot, proofs []storage.ReserveProof) error {
+	if snapshot.ID.IsZero() {
+		snapshot.ID = primitive.NewObjectID()
+	}
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO reserve_snapshot (`+reserveSnapshotColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+			objectID(snapshot.ID), snapshot.Root, snapshot.TotalLiabilitiesCents, jsonb{snapshot.AssetTotalsCents},
+			snapshot.Accounts, snapshot.CreatedAt); err != nil {
+			return err
+		}
+		for i := range proofs {
+			proof := &proofs[i]
+			if proof.ID.IsZero() {
+				proof.ID = primitive.NewObjectID()
+			}
+			proof.SnapshotID = snapshot.ID
+			if _, err := tx.ExecContext(ctx, `INSERT INTO reserve_proof (`+reserveProofColumns+`)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+				objectID(proof.ID), objectID(proof.SnapshotID), proof.WalletAddress, proof.Code, proof.Salt,
+				proof.AccountHash, jsonb{proof.BalancesCents}, proof.SumCents, proof.Leaf, jsonb{proof.Proof}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return failed("add reserve snapshot", err)
+	}
+	return nil
+}
+
+// GetReserveSnapshot fetches a snapshot by its hex ID, or the latest snapshot when id is empty
+func (s *Store) GetReserveSnapshot(ctx context.Context, id string) (*storage.ReserveSnapshot, bool, error) {
+	if id == "" {
+		return queryOne(ctx, s.db, "fetch reserve snapshot", scanReserveSnapshot,
+			`SELECT `+reserveSnapshotColumns+` FROM reserve_snapshot ORDER BY created_at DESC LIMIT 1`)
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	return queryOne(ctx, s.db, "fetch reserve snapshot", scanReserveSnapshot,
+		`SELECT `+reserveSnapshotColumns+` FROM reserve_snapshot WHERE id = $1`, objectID.Hex())
+}
+
+// ListReserveSnapshots fetches the most recent snapshots, newest first
+func (s *Store) ListReserveSnapshots(ctx context.Context, limit int64) ([]storage.ReserveSnapshot, error) {
+	var max any
+	if limit > 0 {
+		max = limit
+	}
+	return queryAll(ctx, s.db, "list reserve snapshots", scanReserveSnapshot,
+		`SELECT `+reserveSnapshotColumns+` FROM reserve_snapshot ORDER BY created_at DESC LIMIT $1`, max)
+}
+
+// GetReserveProofByCode fetches the proof with a verification code
+func (s *Store) GetReserveProofByCode(ctx context.Context, code string) (*storage.ReserveProof, bool, error) {
+	return queryOne(ctx, s.db, "fetch reserve proof", scanReserveProof,
+		`SELECT `+reserveProofColumns+` FROM reserve_proof WHERE code = $1 LIMIT 1`, code)
+}
+
+// GetReserveProofForWallet fetches a wallet's proof in a snapshot
+func (s *Store) GetReserveProofForWallet(ctx context.Context, snapshotID primitive.ObjectID, walletAddress string) (*storage.ReserveProof, bool, error) {
+	return queryOne(ctx, s.db, "fetch reserve proof", scanReserveProof, `SELECT `+reserveProofColumns+` FROM reserve_proof
+		WHERE snapshot_id = $1 AND wallet_address = $2 LIMIT 1`, snapshotID.Hex(), walletAddress)
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const riskDecisionColumns = `id, sender_address, sender_phone, recipient, crypto, amount_usd, signals, fired_rules, score, outcome, created_at`
+
+func scanRiskDecision(row scanner, decision *storage.RiskDecision) error {
+	return row.Scan((*objectID)(&decision.ID), &decision.SenderAddress, &decision.SenderPhone, &decision.Recipient,
+		&decision.Crypto, &decision.AmountUSD, jsonb{&decision.Signals}, jsonb{&decision.FiredRules}, &decision.Score,
+		&decision.Outcome, &decision.CreatedAt)
+}
+
+const riskChallengeColumns = `id, decision_id, code_hash, payment_request_id, sender_address, sender_phone, recipient,
+	crypto, recipient_crypto, network, amount_usd, status, created_at, expires_at`
+
+func scanRiskChallenge(row scanner, challenge *storage.RiskChallenge) error {
+	return row.Scan((*objectID)(&challenge.ID), (*objectID)(&challenge.DecisionID), &challenge.CodeHash,
+		(*objectID)(&challenge.PaymentRequestID), &challenge.SenderAddress,
+		&challenge.SenderPhone, &challenge.Recipient, &challenge.Crypto, &challenge.RecipientCrypto, &challenge.Network,
+		&challenge.AmountUSD, &challenge.Status, &challenge.CreatedAt, &challenge.ExpiresAt)
+}
+
+// CreateRiskDecision stores a risk decision
+func (s *Store) CreateRiskDecision(ctx context.Context, decision *storage.RiskDecision) error {
+	if decision.ID.IsZero() {
+		decision.ID = primitive.NewObjectID()
+	}
+	sealed := *decision
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add risk decision", err)
+	}
+	_, err := exec(ctx, s.db, "add risk decision", `INSERT INTO risk_decision (`+riskDecisionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, objectID(sealed.ID), sealed.SenderAddress,
+		sealed.SenderPhone, sealed.Recipient, sealed.Crypto, sealed.AmountUSD, jsonb{sealed.Signals},
+		jsonb{sealed.FiredRules}, sealed.Score, sealed.Outcome, sealed.CreatedAt)
+	return err
+}
+
+// ListRiskDecisions fetches a wallet's risk decisions, newest first, optionally filtered by outcome
+func (s *Store) ListRiskDecisions(ctx context.Context, senderAddress string, outcome string, limit int64) ([]storage.RiskDecision, error) {
+	var max any
+	if limit > 0 {
+		max = limit
+	}
+	return queryAll(ctx, s.db, "list risk decisions", opening(ctx, s.cipher, scanRiskDecision), `SELECT `+riskDecisionColumns+` FROM risk_decision
+		WHERE sender_address = $1 AND ($2 = '' OR outcome = $2) ORDER BY created_at DESC LIMIT $3`,
+		senderAddress, outcome, max)
+}
+
+// CreateRiskChallenge stores a transfer awaiting an extra 2FA step
+func (s *Store) CreateRiskChallenge(ctx context.Context, challenge *storage.RiskChallenge) error {
+	if challenge.ID.IsZero() {
+		challenge.ID = primitive.NewObjectID()
+	}
+	sealed := *challenge
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add risk challenge", err)
+	}
+	_, err := exec(ctx, s.db, "add risk challenge", `INSERT INTO risk_challenge (`+riskChallengeColumns+`, sender_phone_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`, objectID(sealed.ID),
+		objectID(sealed.DecisionID), sealed.CodeHash, objectID(sealed.PaymentRequestID), sealed.SenderAddress,
+		sealed.SenderPhone, sealed.Recipient, sealed.Crypto, sealed.RecipientCrypto, sealed.Network, sealed.AmountUSD,
+		sealed.Status, sealed.CreatedAt, sealed.ExpiresAt, s.cipher.BlindIndex(challenge.SenderPhone))
+	return err
+}
+
+// GetLatestRiskChallenge fetches the newest pending, unexpired challenge for a phone number
+func (s *Store) GetLatestRiskChallenge(ctx context.Context, senderPhone string) (*storage.RiskChallenge, bool, error) {
+	return queryOne(ctx, s.db, "fetch risk challenge", opening(ctx, s.cipher, scanRiskChallenge), `SELECT `+riskChallengeColumns+` FROM risk_challenge
+		WHERE sender_phone_index = $1 AND status = $2 AND expires_at > $3 ORDER BY created_at DESC LIMIT 1`,
+		s.cipher.BlindIndex(senderPhone), storage.RiskChallengePending, time.Now())
+}
+
+// TransitionRiskChallenge moves a challenge from one status to another. It reports false
+// when the challenge was no longer in the from status.
+func (s *Store) TransitionRiskChallenge(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	return exec(ctx, s.db, "update risk challenge", `UPDATE risk_challenge SET status = $3 WHERE id = $1 AND status = $2`,
+		id.Hex(), from, to)
+}
+
+// ListExpiredRiskChallenges fetches pending challenges whose verification window has passed
+func (s *Store) ListExpiredRiskChallenges(ctx context.Context, now time.Time) ([]storage.RiskChallenge, error) {
+	return queryAll(ctx, s.db, "list expired risk challenges", opening(ctx, s.cipher, scanRiskChallenge), `SELECT `+riskChallengeColumns+`
+		FROM risk_challenge WHERE status = $1 AND expires_at <= $2 ORDER BY created_at DESC`, storage.RiskChallengePending, now)
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const scheduledTransferColumns = `id, reference, sender_address, recipient, crypto, recipient_crypto, network, amount_usd,
+	frequency, weekday, day_of_month, hour, status, next_run_at, reminded_for, last_run_at, last_error, created_at`
+
+func scanScheduledTransfer(row scanner, transfer *storage.ScheduledTransfer) error {
+	return row.Scan((*objectID)(&transfer.ID), &transfer.Reference, &transfer.SenderAddress, &transfer.Recipient,
+		&transfer.Crypto, &transfer.RecipientCrypto, &transfer.Network, &transfer.AmountUSD, &transfer.Frequency,
+		&transfer.Weekday, &transfer.DayOfMonth, &transfer.Hour, &transfer.Status, &transfer.NextRunAt,
+		(*timestamp)(&transfer.RemindedFor), (*timestamp)(&transfer.LastRunAt), &transfer.LastError, &transfer.CreatedAt)
+}
+
+// CreateScheduledTransfer stores a new scheduled transfer
+func (s *Store) CreateScheduledTransfer(ctx context.Context, transfer *storage.ScheduledTransfer) error {
+	if transfer.ID.IsZero() {
+		transfer.ID = primitive.NewObjectID()
+	}
+	_, err := exec(ctx, s.db, "add scheduled transfer", `INSERT INTO scheduled_transfer (`+scheduledTransferColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+		objectID(transfer.ID), transfer.Reference, transfer.SenderAddress, transfer.Recipient, transfer.Crypto,
+		transfer.RecipientCrypto, transfer.Network, transfer.AmountUSD, transfer.Frequency, int(transfer.Weekday),
+		transfer.DayOfMonth, transfer.Hour, transfer.Status, transfer.NextRunAt, timestamp(transfer.RemindedFor),
+		timestamp(transfer.LastRunAt), transfer.LastError, transfer.CreatedAt)
+	return err
+}
+
+// GetActiveScheduledTransfer fetches an active scheduled transfer by sender and reference
+func (s *Store) GetActiveScheduledTransfer(ctx context.Context, senderAddress string, reference string) (*storage.ScheduledTransfer, bool, error) {
+	return queryOne(ctx, s.db, "fetch scheduled transfer", scanScheduledTransfer, `SELECT `+scheduledTransferColumns+`
+		FROM scheduled_transfer WHERE sender_address = $1 AND reference = $2 AND status = $3 LIMIT 1`,
+		senderAddress, reference, storage.ScheduledTransferActive)
+}
+
+// ListScheduledTransfers fetches every scheduled transfer for a sender, next run first
+func (s *Store) ListScheduledTransfers(ctx context.Context, senderAddress string) ([]storage.ScheduledTransfer, error) {
+	return queryAll(ctx, s.db, "list scheduled transfers", scanScheduledTransfer, `SELECT `+scheduledTransferColumns+`
+		FROM scheduled_transfer WHERE sender_address = $1 ORDER BY next_run_at`, senderAddress)
+}
+
+// ListDueScheduledTransfers fetches active scheduled transfers whose next run has arrived
+func (s *Store) ListDueScheduledTransfers(ctx context.Context, now time.Time) ([]storage.ScheduledTransfer, error) {
+	return queryAll(ctx, s.db, "list due scheduled transfers", scanScheduledTransfer, `SELECT `+scheduledTransferColumns+`
+		FROM scheduled_transfer WHERE status = $1 AND next_run_at <= $2 ORDER BY next_run_at`, storage.ScheduledTransferActive, now)
+}
+
+// ListUnremindedScheduledTransfers fetches active scheduled transfers running before the
+// given time that haven't had a reminder for that run yet
+func (s *Store) ListUnremindedScheduledTransfers(ctx context.Context, before time.Time) ([]storage.ScheduledTransfer, error) {
+	return queryAll(ctx, s.db, "list scheduled transfers to remind", scanScheduledTransfer, `SELECT `+scheduledTransferColumns+`
+		FROM scheduled_transfer WHERE status = $1 AND next_run_at <= $2 AND reminded_for IS DISTINCT FROM next_run_at
+		ORDER BY next_run_at`, storage.ScheduledTransferActive, before)
+}
+
+// AdvanceScheduledTransfer moves an active transfer's next run from runAt to next and sets
+// its status. It reports false when the run was already advanced by someone else.
+func (s *Store) AdvanceScheduledTransfer(ctx context.Context, id primitive.ObjectID, runAt time.Time, next time.Time, status string) (bool, error) {
+	return exec(ctx, s.db, "advance scheduled transfer", `UPDATE scheduled_transfer SET next_run_at = $4, status = $5
+		WHERE id = $1 AND status = $2 AND next_run_at = $3`, id.Hex(), storage.ScheduledTransferActive, runAt, next, status)
+}
+
+// RecordScheduledTransferRun stores the outcome of a scheduled run
+func (s *Store) RecordScheduledTransferRun(ctx context.Context, id primitive.ObjectID, runAt time.Time, runErr string) error {
+	_, err := exec(ctx, s.db, "record scheduled transfer run", `UPDATE scheduled_transfer SET last_run_at = $2, last_error = $3
+		WHERE id = $1`, id.Hex(), timestamp(runAt), runErr)
+	return err
+}
+
+// MarkScheduledTransferReminded records that the reminder for the run at runAt was sent
+func (s *Store) MarkScheduledTransferReminded(ctx context.Context, id primitive.ObjectID, runAt time.Time) error {
+	_, err := exec(ctx, s.db, "mark scheduled transfer reminded", `UPDATE scheduled_transfer SET reminded_for = $2 WHERE id = $1`,
+		id.Hex(), timestamp(runAt))
+	return err
+}
+
+// CancelScheduledTransfer cancels an active scheduled transfer
+func (s *Store) CancelScheduledTransfer(ctx context.Context, senderAddress string, reference string) (bool, error) {
+	return exec(ctx, s.db, "cancel scheduled transfer", `UPDATE scheduled_transfer SET status = $4
+		WHERE sender_address = $1 AND reference = $2 AND status = $3`,
+		senderAddress, reference, storage.ScheduledTransferActive, storage.ScheduledTransferCancelled)
+}
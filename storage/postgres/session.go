@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+// StartSmsSession opens (or extends) an authenticated session for a phone number
+func (s *Store) StartSmsSession(ctx context.Context, phoneNumber string, ttl time.Duration) error {
+	sealedPhone, err := s.cipher.Seal(storage.FieldSmsSessionPhone, phoneNumber)
+	if err != nil {
+		return failed("start SMS session", err)
+	}
+	_, err = exec(ctx, s.db, "start SMS session", `INSERT INTO sms_session (phone_number_index, phone_number, expires_at)
+		VALUES ($1, $2, $3) ON CONFLICT (phone_number_index) DO UPDATE SET expires_at = EXCLUDED.expires_at`,
+		s.cipher.BlindIndex(phoneNumber), sealedPhone, time.Now().Add(ttl))
+	return err
+}
+
+// HasActiveSmsSession checks if a phone number has an unexpired session
+func (s *Store) HasActiveSmsSession(ctx context.Context, phoneNumber string) (bool, error) {
+	var active bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM sms_session WHERE phone_number_index = $1 AND expires_at > $2)`,
+		s.cipher.BlindIndex(phoneNumber), time.Now()).Scan(&active)
+	if err != nil {
+		return false, failed("check SMS session", err)
+	}
+	return active, nil
+}
@@ -0,0 +1,215 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"crypto-sms/storage"
+)
+
+const smsServiceColumns = `wallet_address, phone_number, phone_updated_at, alias, language, preferred_networks, passkey, "limit", velocity_limits, guardians, public_key, deposit_index`
+
+func scanSmsService(row scanner, service *storage.SmsService) error {
+	return row.Scan(&service.WalletAddress, &service.PhoneNumber, (*timestamp)(&service.PhoneUpdatedAt), &service.Alias, &service.Language,
+		jsonb{&service.Networks}, &service.Passkey, &service.Limit, jsonb{&service.VelocityLimits}, jsonb{&service.Guardians},
+		&service.PublicKey, &service.DepositIndex)
+}
+
+// getSmsService fetches the wallet matching a condition on one column, decrypting it
+func (s *Store) getSmsService(ctx context.Context, operation string, column string, value string) (*storage.SmsService, bool, error) {
+	service, exists, err := queryOne(ctx, s.db, operation, scanSmsService,
+		`SELECT `+smsServiceColumns+` FROM sms_service WHERE `+column+` = $1`, value)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	if err := s.openSmsService(ctx, service); err != nil {
+		return nil, false, err
+	}
+	return service, true, nil
+}
+
+// CheckWalletExistsInSmsService checks if a wallet address is registered
+func (s *Store) CheckWalletExistsInSmsService(ctx context.Context, walletAddress string) (*storage.SmsService, bool, error) {
+	return s.getSmsService(ctx, "check wallet address", "wallet_address", walletAddress)
+}
+
+// CheckPhoneNumberExistsInSmsService checks if a phone number is used by any wallet address
+func (s *Store) CheckPhoneNumberExistsInSmsService(ctx context.Context, phoneNumber string) (*storage.SmsService, bool, error) {
+	if phoneNumber == "" {
+		return nil, false, nil
+	}
+	return s.getSmsService(ctx, "check phone number", "phone_number_index", s.cipher.BlindIndex(phoneNumber))
+}
+
+// CheckAliasExistsInSmsService checks if an alias is used by any wallet address
+func (s *Store) CheckAliasExistsInSmsService(ctx context.Context, alias string) (*storage.SmsService, bool, error) {
+	if alias == "" {
+		return nil, false, nil
+	}
+	return s.getSmsService(ctx, "check alias", "alias", alias)
+}
+
+// CreateSmsService registers a wallet. It returns ErrDuplicate when the wallet, phone
+// number or alias is already registered.
+func (s *Store) CreateSmsService(ctx context.Context, service storage.SmsService) error {
+	phoneNumber, passkey, err := s.sealSmsServiceFields(service.PhoneNumber, service.Passkey)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO sms_service (`+smsServiceColumns+`, phone_number_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		service.WalletAddress, phoneNumber, timestamp(service.PhoneUpdatedAt), service.Alias, service.Language,
+		jsonb{service.Networks}, passkey, service.Limit, jsonb{service.VelocityLimits}, jsonb{service.Guardians},
+		service.PublicKey, service.DepositIndex, s.cipher.BlindIndex(service.PhoneNumber))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return failed("add SMS service", err)
+	}
+	return nil
+}
+
+// UpdateSmsService sets a wallet's passkey and limit, registering the wallet if needed
+func (s *Store) UpdateSmsService(ctx context.Context, walletAddress string, passkey string, limit float64) error {
+	sealedPasskey, err := s.cipher.Seal(storage.FieldSmsServicePasskey, passkey)
+	if err != nil {
+		log.Printf("Error sealing passkey: %v", err)
+		return errors.New("failed to seal passkey")
+	}
+	_, err = exec(ctx, s.db, "update SMS service", `INSERT INTO sms_service (wallet_address, passkey, "limit") VALUES ($1, $2, $3)
+		ON CONFLICT (wallet_address) DO UPDATE SET passkey = EXCLUDED.passkey, "limit" = EXCLUDED."limit"`,
+		walletAddress, sealedPasskey, limit)
+	return err
+}
+
+// updateSmsService sets one column of a wallet's SMS service
+func (s *Store) updateSmsService(ctx context.Context, operation string, walletAddress string, column string, value any) error {
+	_, err := exec(ctx, s.db, operation, `UPDATE sms_service SET `+column+` = $2 WHERE wallet_address = $1`, walletAddress, value)
+	return err
+}
+
+// UpdateLimit sets the per-transaction limit for a given wallet address
+func (s *Store) UpdateLimit(ctx context.Context, walletAddress string, limit float64) error {
+	return s.updateSmsService(ctx, "update limit", walletAddress, `"limit"`, limit)
+}
+
+// UpdateVelocityLimits replaces the rolling spending limits for a given wallet address
+func (s *Store) UpdateVelocityLimits(ctx context.Context, walletAddress string, limits []storage.VelocityLimit) error {
+	return s.updateSmsService(ctx, "update velocity limits", walletAddress, "velocity_limits", jsonb{limits})
+}
+
+// UpdateLanguage sets the reply language for a given wallet address
+func (s *Store) UpdateLanguage(ctx context.Context, walletAddress string, language string) error {
+	return s.updateSmsService(ctx, "update language", walletAddress, "language", language)
+}
+
+// UpdatePreferredNetworks replaces the preferred network per asset for a given wallet address
+func (s *Store) UpdatePreferredNetworks(ctx context.Context, walletAddress string, networks map[string]string) error {
+	return s.updateSmsService(ctx, "update preferred networks", walletAddress, "preferred_networks", jsonb{networks})
+}
+
+// SetDepositIndex assigns a deposit derivation index to a wallet that has none. It reports
+// false when the wallet already had one.
+func (s *Store) SetDepositIndex(ctx context.Context, walletAddress string, index uint32) (bool, error) {
+	return exec(ctx, s.db, "set deposit index",
+		`UPDATE sms_service SET deposit_index = $2 WHERE wallet_address = $1 AND deposit_index IS NULL`, walletAddress, index)
+}
+
+// UpdateAlias sets the alias for a given wallet address. It returns ErrDuplicate when
+// another wallet has the alias.
+func (s *Store) UpdateAlias(ctx context.Context, walletAddress string, alias string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE sms_service SET alias = $2 WHERE wallet_address = $1`, walletAddress, alias)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		return failed("update alias", err)
+	}
+	return nil
+}
+
+// UpdateGuardians replaces the guardian policy for a given wallet address; nil removes it
+func (s *Store) UpdateGuardians(ctx context.Context, walletAddress string, policy *storage.GuardianPolicy) error {
+	var guardians any
+	if policy != nil {
+		guardians = jsonb{policy}
+	}
+	return s.updateSmsService(ctx, "update guardians", walletAddress, "guardians", guardians)
+}
+
+// UpdatePhoneNumber moves a phone number to a wallet address, clearing it from any other
+// wallet that used it. Both updates run in one transaction.
+func (s *Store) UpdatePhoneNumber(ctx context.Context, walletAddress string, phoneNumber string) error {
+	sealedPhone, err := s.cipher.Seal(storage.FieldSmsServicePhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return errors.New("failed to seal phone number")
+	}
+	index := s.cipher.BlindIndex(phoneNumber)
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if index != "" {
+			if _, err := tx.ExecContext(ctx, `UPDATE sms_service SET phone_number = '', phone_number_index = ''
+				WHERE phone_number_index = $1 AND wallet_address <> $2`, index, walletAddress); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE sms_service SET phone_number = $2, phone_number_index = $3, phone_updated_at = $4
+			WHERE wallet_address = $1`, walletAddress, sealedPhone, index, time.Now())
+		return err
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			// Another wallet claimed the number since it was cleared
+			return storage.ErrDuplicate
+		}
+		return failed("update phone number for wallet address", err)
+	}
+	return nil
+}
+
+// ListSmsServices fetches every registered wallet
+func (s *Store) ListSmsServices(ctx context.Context) ([]storage.SmsService, error) {
+	services, err := queryAll(ctx, s.db, "list SMS services", scanSmsService, `SELECT `+smsServiceColumns+` FROM sms_service`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range services {
+		if err := s.openSmsService(ctx, &services[i]); err != nil {
+			return nil, err
+		}
+	}
+	return services, nil
+}
+
+// sealSmsServiceFields seals a wallet's phone number and passkey
+func (s *Store) sealSmsServiceFields(phoneNumber string, passkey string) (string, string, error) {
+	sealedPhone, err := s.cipher.Seal(storage.FieldSmsServicePhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return "", "", errors.New("failed to seal phone number")
+	}
+	sealedPasskey, err := s.cipher.Seal(storage.FieldSmsServicePasskey, passkey)
+	if err != nil {
+		log.Printf("Error sealing passkey: %v", err)
+		return "", "", errors.New("failed to seal passkey")
+	}
+	return sealedPhone, sealedPasskey, nil
+}
+
+// openSmsService decrypts a stored wallet's phone number and passkey
+func (s *Store) openSmsService(ctx context.Context, service *storage.SmsService) error {
+	var err error
+	if service.PhoneNumber, err = s.cipher.Open(ctx, storage.FieldSmsServicePhone, service.PhoneNumber); err != nil {
+		log.Printf("Error opening phone number of %s: %v", service.WalletAddress, err)
+		return errors.New("failed to decrypt SMS service")
+	}
+	if service.Passkey, err = s.cipher.Open(ctx, storage.FieldSmsServicePasskey, service.Passkey); err != nil {
+		log.Printf("Error opening passkey of %s: %v", service.WalletAddress, err)
+		return errors.New("failed to decrypt SMS service")
+	}
+	return nil
+}
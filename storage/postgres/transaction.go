@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const transactionColumns = `id, kind, sender_address, sender_phone, recipient_address, recipient_phone, crypto, recipient_crypto,
+	network, amount_usd, escrowed, fee_usd, fee_version, refunded_usd, reversal_of, reason, operator, created_at`
+
+func scanTransaction(row scanner, transaction *storage.Transaction) error {
+	return row.Scan((*objectID)(&transaction.ID), &transaction.Kind, &transaction.SenderAddress, &transaction.SenderPhone,
+		&transaction.RecipientAddress, &transaction.RecipientPhone, &transaction.Crypto, &transaction.RecipientCrypto,
+		&transaction.Network, &transaction.AmountUSD, &transaction.Escrowed, &transaction.FeeUSD, &transaction.FeeVersion,
+		&transaction.RefundedUSD, (*objectID)(&transaction.ReversalOf), &transaction.Reason, &transaction.Operator, &transaction.CreatedAt)
+}
+
+// CreateTransaction records a completed transfer, giving it a new ID unless it has one
+func (s *Store) CreateTransaction(ctx context.Context, transaction *storage.Transaction) error {
+	if transaction.ID.IsZero() {
+		transaction.ID = primitive.NewObjectID()
+	}
+	sealed := *transaction
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add transaction", err)
+	}
+	_, err := exec(ctx, s.db, "add transaction", `INSERT INTO transaction (`+transactionColumns+`, recipient_phone_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		objectID(sealed.ID), sealed.Kind, sealed.SenderAddress, sealed.SenderPhone,
+		sealed.RecipientAddress, sealed.RecipientPhone, sealed.Crypto, sealed.RecipientCrypto,
+		sealed.Network, sealed.AmountUSD, sealed.Escrowed, sealed.FeeUSD, sealed.FeeVersion,
+		sealed.RefundedUSD, objectID(sealed.ReversalOf), sealed.Reason, sealed.Operator, sealed.CreatedAt,
+		s.cipher.BlindIndex(transaction.RecipientPhone))
+	return err
+}
+
+// ListTransactionsSince fetches the transfers sent from a wallet address since the given time,
+// oldest first. Reversals, adjustments and fully refunded transfers are excluded.
+func (s *Store) ListTransactionsSince(ctx context.Context, senderAddress string, since time.Time) ([]storage.Transaction, error) {
+	return queryAll(ctx, s.db, "list transactions", opening(ctx, s.cipher, scanTransaction), `SELECT `+transactionColumns+` FROM transaction
+		WHERE sender_address = $1 AND kind NOT IN ($2, $3) AND created_at >= $4 AND refunded_usd < amount_usd ORDER BY created_at`,
+		senderAddress, storage.TransactionReversal, storage.TransactionAdjustment, since)
+}
+
+// ListTransactionsForWallet fetches the transfers a wallet address has sent or received, newest first
+func (s *Store) ListTransactionsForWallet(ctx context.Context, walletAddress string) ([]storage.Transaction, error) {
+	return queryAll(ctx, s.db, "list transactions", opening(ctx, s.cipher, scanTransaction), `SELECT `+transactionColumns+` FROM transaction
+		WHERE sender_address = $1 OR recipient_address = $1 ORDER BY created_at DESC`, walletAddress)
+}
+
+// EachTransaction calls fn with every transaction, oldest first, stopping at the first error
+func (s *Store) EachTransaction(ctx context.Context, fn func(*storage.Transaction) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+transactionColumns+` FROM transaction ORDER BY id`)
+	if err != nil {
+		return failed("list transactions", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transaction storage.Transaction
+		if err := opening(ctx, s.cipher, scanTransaction)(rows, &transaction); err != nil {
+			return failed("list transactions", err)
+		}
+		if err := fn(&transaction); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return failed("list transactions", err)
+	}
+	return nil
+}
+
+// HasTransactionTo reports whether a wallet has ever sent to a recipient address or phone number
+func (s *Store) HasTransactionTo(ctx context.Context, senderAddress string, recipientAddress string, recipientPhone string) (bool, error) {
+	if recipientAddress == "" && recipientPhone == "" {
+		return false, nil
+	}
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM transaction WHERE sender_address = $1 AND kind <> $2
+		AND (($3 <> '' AND recipient_address = $3) OR ($4 <> '' AND recipient_phone_index = $4)))`,
+		senderAddress, storage.TransactionReversal, recipientAddress, s.cipher.BlindIndex(recipientPhone)).Scan(&exists)
+	if err != nil {
+		return false, failed("count transactions", err)
+	}
+	return exists, nil
+}
+
+// GetTransactionByID fetches a transaction by its hex ID
+func (s *Store) GetTransactionByID(ctx context.Context, id string) (*storage.Transaction, bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	return queryOne(ctx, s.db, "fetch transaction", opening(ctx, s.cipher, scanTransaction),
+		`SELECT `+transactionColumns+` FROM transaction WHERE id = $1`, objectID.Hex())
+}
+
+// AddRefundedAmount increases a transaction's refunded amount, provided it still equals
+// expected. It reports false when another refund got there first.
+func (s *Store) AddRefundedAmount(ctx context.Context, id primitive.ObjectID, expected float64, amount float64) (bool, error) {
+	if amount == 0 {
+		return false, nil
+	}
+	return exec(ctx, s.db, "update refunded amount", `UPDATE transaction SET refunded_usd = $3 WHERE id = $1 AND refunded_usd = $2`,
+		id.Hex(), expected, expected+amount)
+}
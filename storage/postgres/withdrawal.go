@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"crypto-sms/storage"
+)
+
+const withdrawalColumns = `id, transaction_id, sender_address, sender_phone, crypto, asset, network, to_address, amount_usd,
+	fee_usd, amount, status, tx_hash, raw_tx, confirmations, attempts, custodian, last_error, created_at, updated_at`
+
+func scanWithdrawal(row scanner, withdrawal *storage.Withdrawal) error {
+	return row.Scan((*objectID)(&withdrawal.ID), (*objectID)(&withdrawal.TransactionID), &withdrawal.SenderAddress,
+		&withdrawal.SenderPhone, &withdrawal.Crypto, &withdrawal.Asset, &withdrawal.Network, &withdrawal.ToAddress,
+		&withdrawal.AmountUSD, &withdrawal.FeeUSD, &withdrawal.Amount, &withdrawal.Status, &withdrawal.TxHash,
+		&withdrawal.RawTx, &withdrawal.Confirmations, &withdrawal.Attempts, &withdrawal.Custodian, &withdrawal.LastError,
+		&withdrawal.CreatedAt, &withdrawal.UpdatedAt)
+}
+
+// CreateWithdrawal stores a new withdrawal
+func (s *Store) CreateWithdrawal(ctx context.Context, withdrawal *storage.Withdrawal) error {
+	if withdrawal.ID.IsZero() {
+		withdrawal.ID = primitive.NewObjectID()
+	}
+	sealed := *withdrawal
+	if err := s.cipher.SealFields(sealed.SealedFields()...); err != nil {
+		return failed("add withdrawal", err)
+	}
+	_, err := exec(ctx, s.db, "add withdrawal", `INSERT INTO withdrawal (`+withdrawalColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`,
+		objectID(sealed.ID), objectID(sealed.TransactionID), sealed.SenderAddress, sealed.SenderPhone,
+		sealed.Crypto, sealed.Asset, sealed.Network, sealed.ToAddress, sealed.AmountUSD,
+		sealed.FeeUSD, sealed.Amount, sealed.Status, sealed.TxHash, sealed.RawTx,
+		sealed.Confirmations, sealed.Attempts, sealed.Custodian, sealed.LastError,
+		sealed.CreatedAt, sealed.UpdatedAt)
+	return err
+}
+
+// GetWithdrawalByID fetches a withdrawal by its hex ID
+func (s *Store) GetWithdrawalByID(ctx context.Context, id string) (*storage.Withdrawal, bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	return queryOne(ctx, s.db, "fetch withdrawal", opening(ctx, s.cipher, scanWithdrawal),
+		`SELECT `+withdrawalColumns+` FROM withdrawal WHERE id = $1`, objectID.Hex())
+}
+
+// ListWithdrawalsByStatus fetches withdrawals in any of the given statuses, oldest first
+func (s *Store) ListWithdrawalsByStatus(ctx context.Context, statuses ...string) ([]storage.Withdrawal, error) {
+	return queryAll(ctx, s.db, "list withdrawals", opening(ctx, s.cipher, scanWithdrawal), `SELECT `+withdrawalColumns+` FROM withdrawal
+		WHERE status = ANY ($1) ORDER BY created_at`, pq.Array(statuses))
+}
+
+// ListWithdrawalsForWallet fetches the withdrawals sent from a wallet address, newest first
+func (s *Store) ListWithdrawalsForWallet(ctx context.Context, senderAddress string) ([]storage.Withdrawal, error) {
+	return queryAll(ctx, s.db, "list withdrawals", opening(ctx, s.cipher, scanWithdrawal), `SELECT `+withdrawalColumns+` FROM withdrawal
+		WHERE sender_address = $1 ORDER BY created_at DESC`, senderAddress)
+}
+
+// TransitionWithdrawal moves a withdrawal from one status to another, setting the given
+// fields. It reports false when the withdrawal was no longer in the from status.
+func (s *Store) TransitionWithdrawal(ctx context.Context, id primitive.ObjectID, from string, to string, fields storage.WithdrawalFields) (bool, error) {
+	return exec(ctx, s.db, "update withdrawal", `UPDATE withdrawal SET status = $3, updated_at = $4,
+		tx_hash = COALESCE($5, tx_hash), raw_tx = COALESCE($6, raw_tx), confirmations = COALESCE($7, confirmations),
+		last_error = COALESCE($8, last_error)
+		WHERE id = $1 AND status = $2`,
+		id.Hex(), from, to, time.Now(), fields.TxHash, fields.RawTx, fields.Confirmations, fields.LastError)
+}
+
+// RecordWithdrawalAttempt counts a failed settlement step and stores its error
+func (s *Store) RecordWithdrawalAttempt(ctx context.Context, id primitive.ObjectID, attemptErr string) error {
+	_, err := exec(ctx, s.db, "update withdrawal", `UPDATE withdrawal SET attempts = attempts + 1, last_error = $2, updated_at = $3
+		WHERE id = $1`, id.Hex(), attemptErr, time.Now())
+	return err
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Wallet freeze statuses
+const (
+	FreezeActive   = "active"
+	FreezeResolved = "resolved"
+)
+
+// Discrepancy is one account balance that disagrees with its ledger postings. Difference is
+// the balance minus the ledger. Persistent discrepancies were found with the same difference
+// by the previous run too, so they aren't an artifact of a transfer in flight.
+type Discrepancy struct {
+	Account       string  `bson:"account" json:"account"`
+	Asset         string  `bson:"asset" json:"asset"`
+	BalanceUSD    float64 `bson:"balance_usd" json:"balance_usd"`
+	LedgerUSD     float64 `bson:"ledger_usd" json:"ledger_usd"`
+	DifferenceUSD float64 `bson:"difference_usd" json:"difference_usd"`
+	Persistent    bool    `bson:"persistent" json:"persistent"`
+}
+
+// AssetTotal compares the balances held by the custody provider across every account
+// against the ledger for one asset
+type AssetTotal struct {
+	Asset         string  `bson:"asset" json:"asset"`
+	BalanceUSD    float64 `bson:"balance_usd" json:"balance_usd"`
+	LedgerUSD     float64 `bson:"ledger_usd" json:"ledger_usd"`
+	DifferenceUSD float64 `bson:"difference_usd" json:"difference_usd"`
+}
+
+// ReconciliationReport represents one reconciliation run in the database. Alerts lists the
+// accounts with persistent discrepancies above the alert threshold, and Frozen the wallets
+// this run froze.
+type ReconciliationReport struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Provider      string             `bson:"provider" json:"provider"`
+	Accounts      int                `bson:"accounts" json:"accounts"`
+	Discrepancies []Discrepancy      `bson:"discrepancies" json:"discrepancies"`
+	Totals        []AssetTotal       `bson:"totals" json:"totals"`
+	Alerts        []string           `bson:"alerts,omitempty" json:"alerts,omitempty"`
+	Frozen        []string           `bson:"frozen,omitempty" json:"frozen,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// WalletFreeze represents a wallet blocked from sending until an operator resolves the
+// discrepancy that froze it
+type WalletFreeze struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WalletAddress string             `bson:"wallet_address" json:"wallet_address"`
+	ReportID      primitive.ObjectID `bson:"report_id" json:"report_id"`
+	Reason        string             `bson:"reason" json:"reason"`
+	Status        string             `bson:"status" json:"status"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	ResolvedBy    string             `bson:"resolved_by,omitempty" json:"resolved_by,omitempty"`
+	Resolution    string             `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	ResolvedAt    time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+}
+
+// ReconciliationRepository stores reconciliation reports and wallet freezes
+type ReconciliationRepository interface {
+	CreateReconciliationReport(ctx context.Context, report *ReconciliationReport) error
+	GetReconciliationReport(ctx context.Context, id string) (*ReconciliationReport, bool, error)
+	FreezeWallet(ctx context.Context, freeze *WalletFreeze) (bool, error)
+	IsWalletFrozen(ctx context.Context, walletAddress string) (bool, error)
+	ListActiveWalletFreezes(ctx context.Context) ([]WalletFreeze, error)
+	ResolveWalletFreeze(ctx context.Context, walletAddress string, operator string, resolution string) (bool, error)
+}
+
+// GetReconciliationReportCollection returns a reference to the reconciliation report collection
+func (m *Mongo) GetReconciliationReportCollection() *mongo.Collection {
+	return m.db.Collection("reconciliation_report")
+}
+
+// GetWalletFreezeCollection returns a reference to the wallet freeze collection
+func (m *Mongo) GetWalletFreezeCollection() *mongo.Collection {
+	return m.db.Collection("wallet_freeze")
+}
+
+// CreateReconciliationReport stores a finished reconciliation run
+func (m *Mongo) CreateReconciliationReport(ctx context.Context, report *ReconciliationReport) error {
+	collection := m.GetReconciliationReportCollection()
+	result, err := collection.InsertOne(ctx, report)
+	if err != nil {
+		log.Printf("Error adding reconciliation report: %v", err)
+		return errors.New("failed to add reconciliation report")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		report.ID = id
+	}
+	return nil
+}
+
+// GetReconciliationReport fetches a report by its hex ID, or the latest report when id is empty
+func (m *Mongo) GetReconciliationReport(ctx context.Context, id string) (*ReconciliationReport, bool, error) {
+	filter := bson.M{}
+	if id != "" {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, false, nil
+		}
+		filter["_id"] = objectID
+	}
+
+	collection := m.GetReconciliationReportCollection()
+	var report ReconciliationReport
+	err := collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"created_at": -1})).Decode(&report)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching reconciliation report: %v", err)
+		return nil, false, errors.New("failed to fetch reconciliation report")
+	}
+	return &report, true, nil
+}
+
+// FreezeWallet freezes a wallet unless it is already frozen. It reports whether a new
+// freeze was created.
+func (m *Mongo) FreezeWallet(ctx context.Context, freeze *WalletFreeze) (bool, error) {
+	collection := m.GetWalletFreezeCollection()
+	freeze.Status = FreezeActive
+	filter := bson.M{"wallet_address": freeze.WalletAddress, "status": FreezeActive}
+	update := bson.M{"$setOnInsert": freeze}
+
+	result, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error freezing wallet: %v", err)
+		return false, errors.New("failed to freeze wallet")
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// IsWalletFrozen reports whether a wallet has an active freeze
+func (m *Mongo) IsWalletFrozen(ctx context.Context, walletAddress string) (bool, error) {
+	collection := m.GetWalletFreezeCollection()
+	count, err := collection.CountDocuments(ctx, bson.M{"wallet_address": walletAddress, "status": FreezeActive}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("Error checking wallet freeze: %v", err)
+		return false, errors.New("failed to check wallet freeze")
+	}
+	return count > 0, nil
+}
+
+// ListActiveWalletFreezes fetches every active freeze, oldest first
+func (m *Mongo) ListActiveWalletFreezes(ctx context.Context) ([]WalletFreeze, error) {
+	collection := m.GetWalletFreezeCollection()
+	cursor, err := collection.Find(ctx, bson.M{"status": FreezeActive}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		log.Printf("Error listing wallet freezes: %v", err)
+		return nil, errors.New("failed to list wallet freezes")
+	}
+	defer cursor.Close(ctx)
+
+	freezes := []WalletFreeze{}
+	if err := cursor.All(ctx, &freezes); err != nil {
+		log.Printf("Error decoding wallet freezes: %v", err)
+		return nil, errors.New("failed to list wallet freezes")
+	}
+	return freezes, nil
+}
+
+// ResolveWalletFreeze lifts a wallet's active freeze. It reports false when the wallet
+// wasn't frozen.
+func (m *Mongo) ResolveWalletFreeze(ctx context.Context, walletAddress string, operator string, resolution string) (bool, error) {
+	collection := m.GetWalletFreezeCollection()
+	filter := bson.M{"wallet_address": walletAddress, "status": FreezeActive}
+	update := bson.M{"$set": bson.M{"status": FreezeResolved, "resolved_by": operator, "resolution": resolution, "resolved_at": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error resolving wallet freeze: %v", err)
+		return false, errors.New("failed to resolve wallet freeze")
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"crypto-sms/utils"
+)
+
+// ReserveSnapshot represents a published proof-of-reserves snapshot in the database. Root
+// is the hex Merkle sum tree root over every account's balances, and TotalLiabilitiesCents
+// the root's sum.
+type ReserveSnapshot struct {
+	ID                    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Root                  string             `bson:"root" json:"root"`
+	TotalLiabilitiesCents uint64             `bson:"total_liabilities_cents" json:"total_liabilities_cents"`
+	AssetTotalsCents      map[string]uint64  `bson:"asset_totals_cents" json:"asset_totals_cents"`
+	Accounts              int                `bson:"accounts" json:"accounts"`
+	CreatedAt             time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ReserveProof represents one account's inclusion proof in a snapshot. The account is
+// committed to as SHA-256(salt || wallet address), so leaves don't reveal who holds them,
+// and Code lets the owner look the proof up without sharing their wallet address.
+type ReserveProof struct {
+	ID            primitive.ObjectID      `bson:"_id,omitempty" json:"-"`
+	SnapshotID    primitive.ObjectID      `bson:"snapshot_id" json:"snapshot_id"`
+	WalletAddress string                  `bson:"wallet_address" json:"-"`
+	Code          string                  `bson:"code" json:"code"`
+	Salt          string                  `bson:"salt" json:"salt"`
+	AccountHash   string                  `bson:"account_hash" json:"account_hash"`
+	BalancesCents map[string]uint64       `bson:"balances_cents" json:"balances_cents"`
+	SumCents      uint64                  `bson:"sum_cents" json:"sum_cents"`
+	Leaf          string                  `bson:"leaf" json:"leaf"`
+	Proof         []utils.MerkleProofStep `bson:"proof" json:"proof"`
+}
+
+// ReserveRepository stores proof-of-reserves snapshots and their proofs
+type ReserveRepository interface {
+	CreateReserveSnapshot(ctx context.Context, snapshot *ReserveSnapshot, proofs []ReserveProof) error
+	GetReserveSnapshot(ctx context.Context, id string) (*ReserveSnapshot, bool, error)
+	ListReserveSnapshots(ctx context.Context, limit int64) ([]ReserveSnapshot, error)
+	GetReserveProofByCode(ctx context.Context, code string) (*ReserveProof, bool, error)
+	GetReserveProofForWallet(ctx context.Context, snapshotID primitive.ObjectID, walletAddress string) (*ReserveProof, bool, error)
+}
+
+// GetReserveSnapshotCollection returns a reference to the reserve snapshot collection
+func (m *Mongo) GetReserveSnapshotCollection() *mongo.Collection {
+	return m.db.Collection("reserve_snapshot")
+}
+
+// GetReserveProofCollection returns a reference to the reserve proof collection
+func (m *Mongo) GetReserveProofCollection() *mongo.Collection {
+	return m.db.Collection("reserve_proof")
+}
+
+// CreateReserveSnapshot stores a snapshot with its proofs. The proofs are written first, so
+// a snapshot is only published once every proof can be fetched.
+func (m *Mongo) CreateReserveSnapshot(ctx context.Context, snapshot *ReserveSnapshot, proofs []ReserveProof) error {
+	if snapshot.ID.IsZero() {
+		snapshot.ID = primitive.NewObjectID()
+	}
+	documents := make([]interface{}, len(proofs))
+	for i := range proofs {
+		proofs[i].SnapshotID = snapshot.ID
+		documents[i] = proofs[i]
+	}
+	if len(documents) > 0 {
+		if _, err := m.GetReserveProofCollection().InsertMany(ctx, documents); err != nil {
+			log.Printf("Error adding reserve proofs: %v", err)
+			return errors.New("failed to add reserve proofs")
+		}
+	}
+
+	if _, err := m.GetReserveSnapshotCollection().InsertOne(ctx, snapshot); err != nil {
+		log.Printf("Error adding reserve snapshot: %v", err)
+		return errors.New("failed to add reserve snapshot")
+	}
+	return nil
+}
+
+// GetReserveSnapshot fetches a snapshot by its hex ID, or the latest snapshot when id is empty
+func (m *Mongo) GetReserveSnapshot(ctx context.Context, id string) (*ReserveSnapshot, bool, error) {
+	filter := bson.M{}
+	if id != "" {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, false, nil
+		}
+		filter["_id"] = objectID
+	}
+
+	collection := m.GetReserveSnapshotCollection()
+	var snapshot ReserveSnapshot
+	err := collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"created_at": -1})).Decode(&snapshot)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching reserve snapshot: %v", err)
+		return nil, false, errors.New("failed to fetch reserve snapshot")
+	}
+	return &snapshot, true, nil
+}
+
+// ListReserveSnapshots fetches the most recent snapshots, newest first
+func (m *Mongo) ListReserveSnapshots(ctx context.Context, limit int64) ([]ReserveSnapshot, error) {
+	collection := m.GetReserveSnapshotCollection()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit))
+	if err != nil {
+		log.Printf("Error listing reserve snapshots: %v", err)
+		return nil, errors.New("failed to list reserve snapshots")
+	}
+	defer cursor.Close(ctx)
+
+	snapshots := []ReserveSnapshot{}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		log.Printf("Error decoding reserve snapshots: %v", err)
+		return nil, errors.New("failed to list reserve snapshots")
+	}
+	return snapshots, nil
+}
+
+// GetReserveProofByCode fetches the proof with a verification code
+func (m *Mongo) GetReserveProofByCode(ctx context.Context, code string) (*ReserveProof, bool, error) {
+	return m.getReserveProof(ctx, bson.M{"code": code})
+}
+
+// GetReserveProofForWallet fetches a wallet's proof in a snapshot
+func (m *Mongo) GetReserveProofForWallet(ctx context.Context, snapshotID primitive.ObjectID, walletAddress string) (*ReserveProof, bool, error) {
+	return m.getReserveProof(ctx, bson.M{"snapshot_id": snapshotID, "wallet_address": walletAddress})
+}
+
+func (m *Mongo) getReserveProof(ctx context.Context, filter bson.M) (*ReserveProof, bool, error) {
+	collection := m.GetReserveProofCollection()
+	var proof ReserveProof
+	err := collection.FindOne(ctx, filter).Decode(&proof)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching reserve proof: %v", err)
+		return nil, false, errors.New("failed to fetch reserve proof")
+	}
+	return &proof, true, nil
+}
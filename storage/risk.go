@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Risk outcomes
+const (
+	RiskOutcomeAllow     = "allow"
+	RiskOutcomeChallenge = "challenge"
+	RiskOutcomeBlock     = "block"
+)
+
+// Risk challenge statuses
+const (
+	RiskChallengePending  = "pending"
+	RiskChallengeVerified = "verified"
+	RiskChallengeExpired  = "expired"
+)
+
+// RiskDecision records how the risk engine scored a transfer, for later review
+type RiskDecision struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SenderAddress string             `bson:"sender_address" json:"sender_address"`
+	SenderPhone   string             `bson:"sender_phone" json:"sender_phone"`
+	Recipient     string             `bson:"recipient" json:"recipient"`
+	Crypto        string             `bson:"crypto" json:"crypto"`
+	AmountUSD     float64            `bson:"amount_usd" json:"amount_usd"`
+	Signals       map[string]float64 `bson:"signals" json:"signals"`
+	FiredRules    []FiredRiskRule    `bson:"fired_rules" json:"fired_rules"`
+	Score         float64            `bson:"score" json:"score"`
+	Outcome       string             `bson:"outcome" json:"outcome"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// FiredRiskRule is a rule that contributed to a risk decision
+type FiredRiskRule struct {
+	Name   string  `bson:"name" json:"name"`
+	Signal string  `bson:"signal" json:"signal"`
+	Value  float64 `bson:"value" json:"value"`
+	Score  float64 `bson:"score" json:"score"`
+	Action string  `bson:"action,omitempty" json:"action,omitempty"`
+}
+
+// RiskChallenge represents a transfer held until the sender completes an extra 2FA step.
+// Only a hash of the code texted to the sender is stored.
+type RiskChallenge struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty"`
+	DecisionID       primitive.ObjectID `bson:"decision_id"`
+	CodeHash         string             `bson:"code_hash"`
+	PaymentRequestID primitive.ObjectID `bson:"payment_request_id,omitempty"`
+	SenderAddress    string             `bson:"sender_address"`
+	SenderPhone      string             `bson:"sender_phone"`
+	Recipient        string             `bson:"recipient"`
+	Crypto           string             `bson:"crypto"`
+	RecipientCrypto  string             `bson:"recipient_crypto"`
+	Network          string             `bson:"network,omitempty"`
+	AmountUSD        float64            `bson:"amount_usd"`
+	Status           string             `bson:"status"`
+	CreatedAt        time.Time          `bson:"created_at"`
+	ExpiresAt        time.Time          `bson:"expires_at"`
+}
+
+// SealedFields returns the decision's sender phone number, which is stored sealed
+func (d *RiskDecision) SealedFields() []SealedField {
+	return []SealedField{{FieldRiskDecisionSenderPhone, &d.SenderPhone}}
+}
+
+// SealedFields returns the challenge's sender phone number, which is stored sealed
+func (c *RiskChallenge) SealedFields() []SealedField {
+	return []SealedField{{FieldRiskChallengeSenderPhone, &c.SenderPhone}}
+}
+
+// sealedRiskChallenge is a RiskChallenge as stored, with the blind index of the sender's
+// phone, which verification replies match on
+type sealedRiskChallenge struct {
+	RiskChallenge    `bson:",inline"`
+	SenderPhoneIndex string `bson:"sender_phone_index,omitempty"`
+}
+
+// RiskRepository stores risk decisions and challenges
+type RiskRepository interface {
+	CreateRiskDecision(ctx context.Context, decision *RiskDecision) error
+	ListRiskDecisions(ctx context.Context, senderAddress string, outcome string, limit int64) ([]RiskDecision, error)
+	CreateRiskChallenge(ctx context.Context, challenge *RiskChallenge) error
+	GetLatestRiskChallenge(ctx context.Context, senderPhone string) (*RiskChallenge, bool, error)
+	TransitionRiskChallenge(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error)
+	ListExpiredRiskChallenges(ctx context.Context, now time.Time) ([]RiskChallenge, error)
+}
+
+// GetRiskDecisionCollection returns a reference to the risk_decision collection
+func (m *Mongo) GetRiskDecisionCollection() *mongo.Collection {
+	return m.db.Collection("risk_decision")
+}
+
+// GetRiskChallengeCollection returns a reference to the risk_challenge collection
+func (m *Mongo) GetRiskChallengeCollection() *mongo.Collection {
+	return m.db.Collection("risk_challenge")
+}
+
+// CreateRiskDecision stores a risk decision
+func (m *Mongo) CreateRiskDecision(ctx context.Context, decision *RiskDecision) error {
+	collection := m.GetRiskDecisionCollection()
+	sealed := *decision
+	if err := m.sealRecord("risk decision", &sealed); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding risk decision: %v", err)
+		return errors.New("failed to add risk decision")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		decision.ID = id
+	}
+	return nil
+}
+
+// ListRiskDecisions fetches a wallet's risk decisions, newest first, optionally filtered by outcome
+func (m *Mongo) ListRiskDecisions(ctx context.Context, senderAddress string, outcome string, limit int64) ([]RiskDecision, error) {
+	collection := m.GetRiskDecisionCollection()
+	filter := bson.M{"sender_address": senderAddress}
+	if outcome != "" {
+		filter["outcome"] = outcome
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error listing risk decisions: %v", err)
+		return nil, errors.New("failed to list risk decisions")
+	}
+	defer cursor.Close(ctx)
+
+	decisions := []RiskDecision{}
+	if err := cursor.All(ctx, &decisions); err != nil {
+		log.Printf("Error decoding risk decisions: %v", err)
+		return nil, errors.New("failed to list risk decisions")
+	}
+	if err := openRecords(ctx, m, "risk decisions", decisions); err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// CreateRiskChallenge stores a transfer awaiting an extra 2FA step
+func (m *Mongo) CreateRiskChallenge(ctx context.Context, challenge *RiskChallenge) error {
+	collection := m.GetRiskChallengeCollection()
+	sealed := sealedRiskChallenge{RiskChallenge: *challenge, SenderPhoneIndex: m.cipher.BlindIndex(challenge.SenderPhone)}
+	if err := m.sealRecord("risk challenge", &sealed.RiskChallenge); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding risk challenge: %v", err)
+		return errors.New("failed to add risk challenge")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		challenge.ID = id
+	}
+	return nil
+}
+
+// GetLatestRiskChallenge fetches the newest pending, unexpired challenge for a phone number
+func (m *Mongo) GetLatestRiskChallenge(ctx context.Context, senderPhone string) (*RiskChallenge, bool, error) {
+	collection := m.GetRiskChallengeCollection()
+	var challenge RiskChallenge
+	filter := bson.M{"sender_phone_index": m.cipher.BlindIndex(senderPhone), "status": RiskChallengePending, "expires_at": bson.M{"$gt": time.Now()}}
+	opts := options.FindOne().SetSort(bson.M{"created_at": -1})
+	err := collection.FindOne(ctx, filter, opts).Decode(&challenge)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching risk challenge: %v", err)
+		return nil, false, errors.New("failed to fetch risk challenge")
+	}
+	if err := m.openRecord(ctx, "risk challenge", &challenge); err != nil {
+		return nil, false, err
+	}
+	return &challenge, true, nil
+}
+
+// TransitionRiskChallenge moves a challenge from one status to another. It reports false
+// when the challenge was no longer in the from status.
+func (m *Mongo) TransitionRiskChallenge(ctx context.Context, id primitive.ObjectID, from string, to string) (bool, error) {
+	collection := m.GetRiskChallengeCollection()
+	filter := bson.M{"_id": id, "status": from}
+	update := bson.M{"$set": bson.M{"status": to}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating risk challenge: %v", err)
+		return false, errors.New("failed to update risk challenge")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ListExpiredRiskChallenges fetches pending challenges whose verification window has passed
+func (m *Mongo) ListExpiredRiskChallenges(ctx context.Context, now time.Time) ([]RiskChallenge, error) {
+	collection := m.GetRiskChallengeCollection()
+	filter := bson.M{"status": RiskChallengePending, "expires_at": bson.M{"$lte": now}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		log.Printf("Error listing risk challenges: %v", err)
+		return nil, errors.New("failed to list risk challenges")
+	}
+	defer cursor.Close(ctx)
+
+	challenges := []RiskChallenge{}
+	if err := cursor.All(ctx, &challenges); err != nil {
+		log.Printf("Error decoding risk challenges: %v", err)
+		return nil, errors.New("failed to list risk challenges")
+	}
+	if err := openRecords(ctx, m, "risk challenges", challenges); err != nil {
+		return nil, err
+	}
+	return challenges, nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scheduled transfer statuses
+const (
+	ScheduledTransferActive    = "active"
+	ScheduledTransferCompleted = "completed"
+	ScheduledTransferCancelled = "cancelled"
+)
+
+// Scheduled transfer frequencies
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// ScheduledTransfer represents a one-off or recurring transfer in the database
+type ScheduledTransfer struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Reference       string             `bson:"reference" json:"reference"`
+	SenderAddress   string             `bson:"sender_address" json:"sender_address"`
+	Recipient       string             `bson:"recipient" json:"recipient"`
+	Crypto          string             `bson:"crypto" json:"crypto"`
+	RecipientCrypto string             `bson:"recipient_crypto" json:"recipient_crypto"`
+	Network         string             `bson:"network,omitempty" json:"network,omitempty"`
+	AmountUSD       float64            `bson:"amount_usd" json:"amount_usd"`
+	Frequency       string             `bson:"frequency" json:"frequency"`
+	Weekday         time.Weekday       `bson:"weekday" json:"weekday"`
+	DayOfMonth      int                `bson:"day_of_month" json:"day_of_month"`
+	Hour            int                `bson:"hour" json:"hour"`
+	Status          string             `bson:"status" json:"status"`
+	NextRunAt       time.Time          `bson:"next_run_at" json:"next_run_at"`
+	RemindedFor     time.Time          `bson:"reminded_for,omitempty" json:"-"`
+	LastRunAt       time.Time          `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+	LastError       string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ScheduledTransferRepository stores one-off and recurring transfers
+type ScheduledTransferRepository interface {
+	CreateScheduledTransfer(ctx context.Context, transfer *ScheduledTransfer) error
+	GetActiveScheduledTransfer(ctx context.Context, senderAddress string, reference string) (*ScheduledTransfer, bool, error)
+	ListScheduledTransfers(ctx context.Context, senderAddress string) ([]ScheduledTransfer, error)
+	ListDueScheduledTransfers(ctx context.Context, now time.Time) ([]ScheduledTransfer, error)
+	ListUnremindedScheduledTransfers(ctx context.Context, before time.Time) ([]ScheduledTransfer, error)
+	AdvanceScheduledTransfer(ctx context.Context, id primitive.ObjectID, runAt time.Time, next time.Time, status string) (bool, error)
+	RecordScheduledTransferRun(ctx context.Context, id primitive.ObjectID, runAt time.Time, runErr string) error
+	MarkScheduledTransferReminded(ctx context.Context, id primitive.ObjectID, runAt time.Time) error
+	CancelScheduledTransfer(ctx context.Context, senderAddress string, reference string) (bool, error)
+}
+
+// GetScheduledTransferCollection returns a reference to the scheduled_transfer collection
+func (m *Mongo) GetScheduledTransferCollection() *mongo.Collection {
+	return m.db.Collection("scheduled_transfer")
+}
+
+// CreateScheduledTransfer stores a new scheduled transfer
+func (m *Mongo) CreateScheduledTransfer(ctx context.Context, transfer *ScheduledTransfer) error {
+	collection := m.GetScheduledTransferCollection()
+	result, err := collection.InsertOne(ctx, transfer)
+	if err != nil {
+		log.Printf("Error adding scheduled transfer: %v", err)
+		return errors.New("failed to add scheduled transfer")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		transfer.ID = id
+	}
+	return nil
+}
+
+// GetActiveScheduledTransfer fetches an active scheduled transfer by sender and reference
+func (m *Mongo) GetActiveScheduledTransfer(ctx context.Context, senderAddress string, reference string) (*ScheduledTransfer, bool, error) {
+	collection := m.GetScheduledTransferCollection()
+	var transfer ScheduledTransfer
+	filter := bson.M{"sender_address": senderAddress, "reference": reference, "status": ScheduledTransferActive}
+	err := collection.FindOne(ctx, filter).Decode(&transfer)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching scheduled transfer: %v", err)
+		return nil, false, errors.New("failed to fetch scheduled transfer")
+	}
+	return &transfer, true, nil
+}
+
+// ListScheduledTransfers fetches every scheduled transfer for a sender, next run first
+func (m *Mongo) ListScheduledTransfers(ctx context.Context, senderAddress string) ([]ScheduledTransfer, error) {
+	return m.listScheduledTransfers(ctx, bson.M{"sender_address": senderAddress})
+}
+
+// ListDueScheduledTransfers fetches active scheduled transfers whose next run has arrived
+func (m *Mongo) ListDueScheduledTransfers(ctx context.Context, now time.Time) ([]ScheduledTransfer, error) {
+	return m.listScheduledTransfers(ctx, bson.M{"status": ScheduledTransferActive, "next_run_at": bson.M{"$lte": now}})
+}
+
+// ListUnremindedScheduledTransfers fetches active scheduled transfers running before the
+// given time that haven't had a reminder for that run yet
+func (m *Mongo) ListUnremindedScheduledTransfers(ctx context.Context, before time.Time) ([]ScheduledTransfer, error) {
+	return m.listScheduledTransfers(ctx, bson.M{
+		"status":      ScheduledTransferActive,
+		"next_run_at": bson.M{"$lte": before},
+		"$expr":       bson.M{"$ne": bson.A{"$reminded_for", "$next_run_at"}},
+	})
+}
+
+func (m *Mongo) listScheduledTransfers(ctx context.Context, filter bson.M) ([]ScheduledTransfer, error) {
+	collection := m.GetScheduledTransferCollection()
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"next_run_at": 1}))
+	if err != nil {
+		log.Printf("Error listing scheduled transfers: %v", err)
+		return nil, errors.New("failed to list scheduled transfers")
+	}
+	defer cursor.Close(ctx)
+
+	transfers := []ScheduledTransfer{}
+	if err := cursor.All(ctx, &transfers); err != nil {
+		log.Printf("Error decoding scheduled transfers: %v", err)
+		return nil, errors.New("failed to list scheduled transfers")
+	}
+	return transfers, nil
+}
+
+// AdvanceScheduledTransfer moves an active transfer's next run from runAt to next and sets
+// its status. It reports false when the run was already advanced by someone else.
+func (m *Mongo) AdvanceScheduledTransfer(ctx context.Context, id primitive.ObjectID, runAt time.Time, next time.Time, status string) (bool, error) {
+	collection := m.GetScheduledTransferCollection()
+	filter := bson.M{"_id": id, "status": ScheduledTransferActive, "next_run_at": runAt}
+	update := bson.M{"$set": bson.M{"next_run_at": next, "status": status}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error advancing scheduled transfer: %v", err)
+		return false, errors.New("failed to advance scheduled transfer")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// RecordScheduledTransferRun stores the outcome of a scheduled run
+func (m *Mongo) RecordScheduledTransferRun(ctx context.Context, id primitive.ObjectID, runAt time.Time, runErr string) error {
+	collection := m.GetScheduledTransferCollection()
+	update := bson.M{"$set": bson.M{"last_run_at": runAt, "last_error": runErr}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		log.Printf("Error recording scheduled transfer run: %v", err)
+		return errors.New("failed to record scheduled transfer run")
+	}
+	return nil
+}
+
+// MarkScheduledTransferReminded records that the reminder for the run at runAt was sent
+func (m *Mongo) MarkScheduledTransferReminded(ctx context.Context, id primitive.ObjectID, runAt time.Time) error {
+	collection := m.GetScheduledTransferCollection()
+	update := bson.M{"$set": bson.M{"reminded_for": runAt}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		log.Printf("Error marking scheduled transfer reminded: %v", err)
+		return errors.New("failed to mark scheduled transfer reminded")
+	}
+	return nil
+}
+
+// CancelScheduledTransfer cancels an active scheduled transfer
+func (m *Mongo) CancelScheduledTransfer(ctx context.Context, senderAddress string, reference string) (bool, error) {
+	collection := m.GetScheduledTransferCollection()
+	filter := bson.M{"sender_address": senderAddress, "reference": reference, "status": ScheduledTransferActive}
+	update := bson.M{"$set": bson.M{"status": ScheduledTransferCancelled}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error cancelling scheduled transfer: %v", err)
+		return false, errors.New("failed to cancel scheduled transfer")
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SmsSession represents an authenticated SMS session document in the database. The phone
+// number is stored sealed, and sessions are looked up by its blind index.
+type SmsSession struct {
+	PhoneNumber      string    `bson:"phone_number"`
+	PhoneNumberIndex string    `bson:"phone_number_index"`
+	ExpiresAt        time.Time `bson:"expires_at"`
+}
+
+// SmsSessionRepository stores authenticated SMS sessions
+type SmsSessionRepository interface {
+	StartSmsSession(ctx context.Context, phoneNumber string, ttl time.Duration) error
+	HasActiveSmsSession(ctx context.Context, phoneNumber string) (bool, error)
+}
+
+// GetSmsSessionCollection returns a reference to the sms_session collection
+func (m *Mongo) GetSmsSessionCollection() *mongo.Collection {
+	return m.db.Collection("sms_session")
+}
+
+// StartSmsSession opens (or extends) an authenticated session for a phone number
+func (m *Mongo) StartSmsSession(ctx context.Context, phoneNumber string, ttl time.Duration) error {
+	collection := m.GetSmsSessionCollection()
+	sealedPhone, err := m.cipher.Seal(FieldSmsSessionPhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return errors.New("failed to seal phone number")
+	}
+	filter := bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber)}
+	update := bson.M{"$set": bson.M{"phone_number": sealedPhone, "expires_at": time.Now().Add(ttl)}}
+
+	_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error starting SMS session: %v", err)
+		return errors.New("failed to start SMS session")
+	}
+	return nil
+}
+
+// HasActiveSmsSession checks if a phone number has an unexpired session
+func (m *Mongo) HasActiveSmsSession(ctx context.Context, phoneNumber string) (bool, error) {
+	collection := m.GetSmsSessionCollection()
+	var session SmsSession
+	err := collection.FindOne(ctx, bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber)}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		log.Printf("Error checking SMS session: %v", err)
+		return false, errors.New("failed to check SMS session")
+	}
+	return time.Now().Before(session.ExpiresAt), nil
+}
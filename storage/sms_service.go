@@ -4,29 +4,79 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// SmsService represents an SMS service document in the database
+// SmsService represents an SMS service document in the database. Networks maps asset
+// symbols to the network used when a transfer doesn't name one. DepositIndex is the HD
+// derivation index of the wallet's deposit addresses, set when one is first requested.
 type SmsService struct {
-	WalletAddress string  `bson:"wallet_address"`
-	PhoneNumber   string  `bson:"phone_number"`
-	Passkey       string  `bson:"passkey"`
-	Limit         float64 `bson:"limit"`
-	PublicKey     string  `bson:"public_key"`
+	WalletAddress  string            `bson:"wallet_address"`
+	PhoneNumber    string            `bson:"phone_number"`
+	PhoneUpdatedAt time.Time         `bson:"phone_updated_at,omitempty"`
+	Alias          string            `bson:"alias,omitempty"`
+	Language       string            `bson:"language,omitempty"`
+	Networks       map[string]string `bson:"preferred_networks,omitempty"`
+	Passkey        string            `bson:"passkey"`
+	Limit          float64           `bson:"limit"`
+	VelocityLimits []VelocityLimit   `bson:"velocity_limits,omitempty"`
+	Guardians      *GuardianPolicy   `bson:"guardians,omitempty"`
+	PublicKey      string            `bson:"public_key"`
+	DepositIndex   *uint32           `bson:"deposit_index,omitempty"`
+}
+
+// GuardianPolicy requires RequiredApprovals of the guardian phones to approve any
+// transfer above ThresholdUSD
+type GuardianPolicy struct {
+	Phones            []string `bson:"phones" json:"phones"`
+	ThresholdUSD      float64  `bson:"threshold_usd" json:"threshold_usd"`
+	RequiredApprovals int      `bson:"required_approvals" json:"required_approvals"`
+}
+
+// VelocityLimit caps the total USD sent over a rolling window, optionally for a single asset
+type VelocityLimit struct {
+	Window    string  `bson:"window" json:"window"`
+	Asset     string  `bson:"asset,omitempty" json:"asset,omitempty"`
+	AmountUSD float64 `bson:"amount_usd" json:"amount_usd"`
+}
+
+// SmsServiceRepository stores the wallets registered for SMS transfers
+type SmsServiceRepository interface {
+	CheckWalletExistsInSmsService(ctx context.Context, walletAddress string) (*SmsService, bool, error)
+	CheckPhoneNumberExistsInSmsService(ctx context.Context, phoneNumber string) (*SmsService, bool, error)
+	CheckAliasExistsInSmsService(ctx context.Context, alias string) (*SmsService, bool, error)
+	CreateSmsService(ctx context.Context, service SmsService) error
+	UpdateSmsService(ctx context.Context, walletAddress string, passkey string, limit float64) error
+	UpdateLimit(ctx context.Context, walletAddress string, limit float64) error
+	UpdateVelocityLimits(ctx context.Context, walletAddress string, limits []VelocityLimit) error
+	UpdateLanguage(ctx context.Context, walletAddress string, language string) error
+	UpdatePreferredNetworks(ctx context.Context, walletAddress string, networks map[string]string) error
+	SetDepositIndex(ctx context.Context, walletAddress string, index uint32) (bool, error)
+	UpdateAlias(ctx context.Context, walletAddress string, alias string) error
+	UpdateGuardians(ctx context.Context, walletAddress string, policy *GuardianPolicy) error
+	UpdatePhoneNumber(ctx context.Context, walletAddress string, phoneNumber string) error
+	ListSmsServices(ctx context.Context) ([]SmsService, error)
+}
+
+// sealedSmsService is an SmsService as stored, with its phone number and passkey sealed and
+// the phone number's blind index, which phone lookups match on
+type sealedSmsService struct {
+	SmsService       `bson:",inline"`
+	PhoneNumberIndex string `bson:"phone_number_index,omitempty"`
 }
 
 // GetSmsServiceCollection returns a reference to the sms_service collection
-func GetSmsServiceCollection() *mongo.Collection {
-	return db.Collection("sms_service")
+func (m *Mongo) GetSmsServiceCollection() *mongo.Collection {
+	return m.db.Collection("sms_service")
 }
 
 // CheckWalletExistsInSmsService checks if a wallet address exists in the sms_service collection
-func CheckWalletExistsInSmsService(ctx context.Context, walletAddress string) (*SmsService, bool, error) {
-	collection := GetSmsServiceCollection()
+func (m *Mongo) CheckWalletExistsInSmsService(ctx context.Context, walletAddress string) (*SmsService, bool, error) {
+	collection := m.GetSmsServiceCollection()
 	var service SmsService
 	err := collection.FindOne(ctx, bson.M{"wallet_address": walletAddress}).Decode(&service)
 	if err != nil {
@@ -36,14 +86,17 @@ func CheckWalletExistsInSmsService(ctx context.Context, walletAddress string) (*
 		log.Printf("Error checking wallet address: %v", err)
 		return nil, false, errors.New("failed to check wallet address")
 	}
+	if err := m.openSmsService(ctx, &service); err != nil {
+		return nil, false, err
+	}
 	return &service, true, nil
 }
 
 // CheckPhoneNumberExistsInSmsService checks if a phone number is used by any wallet address
-func CheckPhoneNumberExistsInSmsService(ctx context.Context, phoneNumber string) (*SmsService, bool, error) {
-	collection := GetSmsServiceCollection()
+func (m *Mongo) CheckPhoneNumberExistsInSmsService(ctx context.Context, phoneNumber string) (*SmsService, bool, error) {
+	collection := m.GetSmsServiceCollection()
 	var service SmsService
-	err := collection.FindOne(ctx, bson.M{"phone_number": phoneNumber}).Decode(&service)
+	err := collection.FindOne(ctx, bson.M{"phone_number_index": m.cipher.BlindIndex(phoneNumber)}).Decode(&service)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, false, nil
@@ -51,14 +104,48 @@ func CheckPhoneNumberExistsInSmsService(ctx context.Context, phoneNumber string)
 		log.Printf("Error checking phone number: %v", err)
 		return nil, false, errors.New("failed to check phone number")
 	}
+	if err := m.openSmsService(ctx, &service); err != nil {
+		return nil, false, err
+	}
+	return &service, true, nil
+}
+
+// CheckAliasExistsInSmsService checks if an alias is used by any wallet address
+func (m *Mongo) CheckAliasExistsInSmsService(ctx context.Context, alias string) (*SmsService, bool, error) {
+	collection := m.GetSmsServiceCollection()
+	var service SmsService
+	err := collection.FindOne(ctx, bson.M{"alias": alias}).Decode(&service)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error checking alias: %v", err)
+		return nil, false, errors.New("failed to check alias")
+	}
+	if err := m.openSmsService(ctx, &service); err != nil {
+		return nil, false, err
+	}
 	return &service, true, nil
 }
 
 // CreateSmsService creates a new SMS service document in the sms_service collection
-func CreateSmsService(ctx context.Context, service SmsService) error {
-	collection := GetSmsServiceCollection()
-	_, err := collection.InsertOne(ctx, service)
+func (m *Mongo) CreateSmsService(ctx context.Context, service SmsService) error {
+	collection := m.GetSmsServiceCollection()
+	sealed := sealedSmsService{SmsService: service, PhoneNumberIndex: m.cipher.BlindIndex(service.PhoneNumber)}
+	var err error
+	if sealed.PhoneNumber, err = m.cipher.Seal(FieldSmsServicePhone, service.PhoneNumber); err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return errors.New("failed to seal phone number")
+	}
+	if sealed.Passkey, err = m.cipher.Seal(FieldSmsServicePasskey, service.Passkey); err != nil {
+		log.Printf("Error sealing passkey: %v", err)
+		return errors.New("failed to seal passkey")
+	}
+	_, err = collection.InsertOne(ctx, sealed)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicate
+		}
 		log.Printf("Error adding SMS service: %v", err)
 		return errors.New("failed to add SMS service")
 	}
@@ -66,13 +153,18 @@ func CreateSmsService(ctx context.Context, service SmsService) error {
 }
 
 // UpdateSmsService updates an existing SMS service document in the sms_service collection
-func UpdateSmsService(ctx context.Context, walletAddress string, passkey string, limit float64) error {
-	collection := GetSmsServiceCollection()
+func (m *Mongo) UpdateSmsService(ctx context.Context, walletAddress string, passkey string, limit float64) error {
+	collection := m.GetSmsServiceCollection()
 	filter := bson.M{"wallet_address": walletAddress}
-	update := bson.M{"$set": bson.M{"passkey": passkey, "limit": limit}}
+	sealedPasskey, err := m.cipher.Seal(FieldSmsServicePasskey, passkey)
+	if err != nil {
+		log.Printf("Error sealing passkey: %v", err)
+		return errors.New("failed to seal passkey")
+	}
+	update := bson.M{"$set": bson.M{"passkey": sealedPasskey, "limit": limit}}
 	options := options.Update().SetUpsert(true)
 
-	_, err := collection.UpdateOne(ctx, filter, update, options)
+	_, err = collection.UpdateOne(ctx, filter, update, options)
 	if err != nil {
 		log.Printf("Error updating SMS service: %v", err)
 		return errors.New("failed to update SMS service")
@@ -80,19 +172,124 @@ func UpdateSmsService(ctx context.Context, walletAddress string, passkey string,
 	return nil
 }
 
+// UpdateLimit sets the per-transaction limit for a given wallet address
+func (m *Mongo) UpdateLimit(ctx context.Context, walletAddress string, limit float64) error {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$set": bson.M{"limit": limit}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating limit: %v", err)
+		return errors.New("failed to update limit")
+	}
+	return nil
+}
+
+// UpdateVelocityLimits replaces the rolling spending limits for a given wallet address
+func (m *Mongo) UpdateVelocityLimits(ctx context.Context, walletAddress string, limits []VelocityLimit) error {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$set": bson.M{"velocity_limits": limits}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating velocity limits: %v", err)
+		return errors.New("failed to update velocity limits")
+	}
+	return nil
+}
+
+// UpdateLanguage sets the reply language for a given wallet address
+func (m *Mongo) UpdateLanguage(ctx context.Context, walletAddress string, language string) error {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$set": bson.M{"language": language}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating language: %v", err)
+		return errors.New("failed to update language")
+	}
+	return nil
+}
+
+// UpdatePreferredNetworks replaces the preferred network per asset for a given wallet address
+func (m *Mongo) UpdatePreferredNetworks(ctx context.Context, walletAddress string, networks map[string]string) error {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$set": bson.M{"preferred_networks": networks}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating preferred networks: %v", err)
+		return errors.New("failed to update preferred networks")
+	}
+	return nil
+}
+
+// SetDepositIndex assigns a deposit derivation index to a wallet that has none. It reports
+// false when the wallet already had one.
+func (m *Mongo) SetDepositIndex(ctx context.Context, walletAddress string, index uint32) (bool, error) {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress, "deposit_index": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"deposit_index": index}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error setting deposit index: %v", err)
+		return false, errors.New("failed to set deposit index")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// UpdateAlias sets the alias for a given wallet address
+func (m *Mongo) UpdateAlias(ctx context.Context, walletAddress string, alias string) error {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$set": bson.M{"alias": alias}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicate
+		}
+		log.Printf("Error updating alias: %v", err)
+		return errors.New("failed to update alias")
+	}
+	return nil
+}
+
+// UpdateGuardians replaces the guardian policy for a given wallet address; nil removes it
+func (m *Mongo) UpdateGuardians(ctx context.Context, walletAddress string, policy *GuardianPolicy) error {
+	collection := m.GetSmsServiceCollection()
+	filter := bson.M{"wallet_address": walletAddress}
+	update := bson.M{"$set": bson.M{"guardians": policy}}
+	if policy == nil {
+		update = bson.M{"$unset": bson.M{"guardians": ""}}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating guardians: %v", err)
+		return errors.New("failed to update guardians")
+	}
+	return nil
+}
+
 // UpdatePhoneNumber updates the phone number for a given wallet address
-func UpdatePhoneNumber(ctx context.Context, walletAddress string, phoneNumber string) error {
-	collection := GetSmsServiceCollection()
+func (m *Mongo) UpdatePhoneNumber(ctx context.Context, walletAddress string, phoneNumber string) error {
+	collection := m.GetSmsServiceCollection()
 
 	// Check if the phone number is already used by another wallet address
-	existingService, exists, err := CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
+	existingService, exists, err := m.CheckPhoneNumberExistsInSmsService(ctx, phoneNumber)
 	if err != nil {
 		return err
 	}
 	if exists {
 		// If the phone number is used by another wallet address, make it empty in the existing record
 		filter := bson.M{"wallet_address": existingService.WalletAddress}
-		update := bson.M{"$set": bson.M{"phone_number": ""}}
+		update := bson.M{"$set": bson.M{"phone_number": ""}, "$unset": bson.M{"phone_number_index": ""}}
 		_, err := collection.UpdateOne(ctx, filter, update)
 		if err != nil {
 			log.Printf("Error updating existing phone number: %v", err)
@@ -102,17 +299,30 @@ func UpdatePhoneNumber(ctx context.Context, walletAddress string, phoneNumber st
 
 	// Update the provided wallet address with the phone number
 	filter := bson.M{"wallet_address": walletAddress}
-	update := bson.M{"$set": bson.M{"phone_number": phoneNumber}}
+	sealedPhone, err := m.cipher.Seal(FieldSmsServicePhone, phoneNumber)
+	if err != nil {
+		log.Printf("Error sealing phone number: %v", err)
+		return errors.New("failed to seal phone number")
+	}
+	update := bson.M{"$set": bson.M{
+		"phone_number":       sealedPhone,
+		"phone_number_index": m.cipher.BlindIndex(phoneNumber),
+		"phone_updated_at":   time.Now(),
+	}}
 	_, err = collection.UpdateOne(ctx, filter, update)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Another wallet claimed the number since it was cleared
+			return ErrDuplicate
+		}
 		log.Printf("Error updating phone number for wallet address: %v", err)
 		return errors.New("failed to update phone number for wallet address")
 	}
 
 	return nil
 }
-func ListSmsServices(ctx context.Context) ([]SmsService, error) {
-	collection := GetSmsServiceCollection()
+func (m *Mongo) ListSmsServices(ctx context.Context) ([]SmsService, error) {
+	collection := m.GetSmsServiceCollection()
 	cursor, err := collection.Find(ctx, bson.M{})
 	if err != nil {
 		return nil, err
@@ -123,5 +333,24 @@ func ListSmsServices(ctx context.Context) ([]SmsService, error) {
 	if err := cursor.All(ctx, &services); err != nil {
 		return nil, err
 	}
+	for i := range services {
+		if err := m.openSmsService(ctx, &services[i]); err != nil {
+			return nil, err
+		}
+	}
 	return services, nil
 }
+
+// openSmsService decrypts a stored wallet's phone number and passkey
+func (m *Mongo) openSmsService(ctx context.Context, service *SmsService) error {
+	var err error
+	if service.PhoneNumber, err = m.cipher.Open(ctx, FieldSmsServicePhone, service.PhoneNumber); err != nil {
+		log.Printf("Error opening phone number of %s: %v", service.WalletAddress, err)
+		return errors.New("failed to decrypt SMS service")
+	}
+	if service.Passkey, err = m.cipher.Open(ctx, FieldSmsServicePasskey, service.Passkey); err != nil {
+		log.Printf("Error opening passkey of %s: %v", service.WalletAddress, err)
+		return errors.New("failed to decrypt SMS service")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Package storage defines the records Crypto-SMS keeps and the repositories that keep them.
+// Mongo implements every repository over MongoDB, the postgres package implements them over
+// PostgreSQL, and the memory package implements them in process for development and tests.
+package storage
+
+import "errors"
+
+// ErrDuplicate is returned when a write would break a unique index, such as registering a
+// wallet twice or taking another wallet's alias
+var ErrDuplicate = errors.New("record already exists")
+
+// Store gathers one repository per collection. Each repository is embedded, so its methods
+// can be called on the store directly, and can be swapped for another implementation of the
+// same repository, such as a decorator over it.
+type Store struct {
+	SmsServiceRepository
+	TwoFactorAuthRepository
+	SmsSessionRepository
+	AuthFailureRepository
+	CustodianRepository
+	AssetRepository
+	FeeScheduleRepository
+	CounterRepository
+	LeaseRepository
+	TransactionRepository
+	EscrowRepository
+	PendingTransferRepository
+	PaymentRequestRepository
+	ScheduledTransferRepository
+	LimitChangeRepository
+	RiskRepository
+	DepositRepository
+	WithdrawalRepository
+	ReconciliationRepository
+	ReserveRepository
+	MigrationRepository
+	SecretRepository
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Transaction kinds
+const (
+	TransactionTransfer   = "transfer"
+	TransactionReversal   = "reversal"
+	TransactionWithdrawal = "withdrawal"
+	TransactionDeposit    = "deposit"
+	TransactionAdjustment = "adjustment"
+)
+
+// Transaction represents a completed transfer document in the database. Reversals are
+// compensating entries that point back at the transfer they undo. Operator reversals don't
+// refund fees and leave FeeUSD zero; refunds of failed withdrawals include the fee in
+// AmountUSD and set FeeUSD to the part taken back from fee revenue. Adjustments are operator
+// corrections recorded by reconciliation.
+type Transaction struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind             string             `bson:"kind,omitempty" json:"kind,omitempty"`
+	SenderAddress    string             `bson:"sender_address" json:"sender_address"`
+	SenderPhone      string             `bson:"sender_phone" json:"sender_phone"`
+	RecipientAddress string             `bson:"recipient_address" json:"recipient_address"`
+	RecipientPhone   string             `bson:"recipient_phone,omitempty" json:"recipient_phone,omitempty"`
+	Crypto           string             `bson:"crypto" json:"crypto"`
+	RecipientCrypto  string             `bson:"recipient_crypto" json:"recipient_crypto"`
+	Network          string             `bson:"network,omitempty" json:"network,omitempty"`
+	AmountUSD        float64            `bson:"amount_usd" json:"amount_usd"`
+	Escrowed         bool               `bson:"escrowed,omitempty" json:"escrowed,omitempty"`
+	FeeUSD           float64            `bson:"fee_usd" json:"fee_usd"`
+	FeeVersion       int                `bson:"fee_version,omitempty" json:"fee_version,omitempty"`
+	RefundedUSD      float64            `bson:"refunded_usd" json:"refunded_usd"`
+	ReversalOf       primitive.ObjectID `bson:"reversal_of,omitempty" json:"reversal_of,omitempty"`
+	Reason           string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	Operator         string             `bson:"operator,omitempty" json:"operator,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// SealedFields returns the transaction's phone numbers, which are stored sealed
+func (t *Transaction) SealedFields() []SealedField {
+	return []SealedField{
+		{FieldTransactionSenderPhone, &t.SenderPhone},
+		{FieldTransactionRecipientPhone, &t.RecipientPhone},
+	}
+}
+
+// sealedTransaction is a Transaction as stored, with the blind index of its recipient phone
+type sealedTransaction struct {
+	Transaction         `bson:",inline"`
+	RecipientPhoneIndex string `bson:"recipient_phone_index,omitempty"`
+}
+
+// TransactionRepository stores completed transfers and their compensating entries
+type TransactionRepository interface {
+	CreateTransaction(ctx context.Context, transaction *Transaction) error
+	ListTransactionsSince(ctx context.Context, senderAddress string, since time.Time) ([]Transaction, error)
+	ListTransactionsForWallet(ctx context.Context, walletAddress string) ([]Transaction, error)
+	EachTransaction(ctx context.Context, fn func(*Transaction) error) error
+	HasTransactionTo(ctx context.Context, senderAddress string, recipientAddress string, recipientPhone string) (bool, error)
+	GetTransactionByID(ctx context.Context, id string) (*Transaction, bool, error)
+	AddRefundedAmount(ctx context.Context, id primitive.ObjectID, expected float64, amount float64) (bool, error)
+}
+
+// GetTransactionCollection returns a reference to the transaction collection
+func (m *Mongo) GetTransactionCollection() *mongo.Collection {
+	return m.db.Collection("transaction")
+}
+
+// CreateTransaction records a completed transfer in the transaction collection
+func (m *Mongo) CreateTransaction(ctx context.Context, transaction *Transaction) error {
+	collection := m.GetTransactionCollection()
+	sealed := sealedTransaction{Transaction: *transaction, RecipientPhoneIndex: m.cipher.BlindIndex(transaction.RecipientPhone)}
+	if err := m.sealRecord("transaction", &sealed.Transaction); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding transaction: %v", err)
+		return errors.New("failed to add transaction")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		transaction.ID = id
+	}
+	return nil
+}
+
+// ListTransactionsSince fetches the transfers sent from a wallet address since the given time,
+// oldest first. Reversals, adjustments and fully refunded transfers are excluded.
+func (m *Mongo) ListTransactionsSince(ctx context.Context, senderAddress string, since time.Time) ([]Transaction, error) {
+	collection := m.GetTransactionCollection()
+	filter := bson.M{
+		"sender_address": senderAddress,
+		"kind":           bson.M{"$nin": []string{TransactionReversal, TransactionAdjustment}},
+		"created_at":     bson.M{"$gte": since},
+		"$expr":          bson.M{"$lt": bson.A{"$refunded_usd", "$amount_usd"}},
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		log.Printf("Error listing transactions: %v", err)
+		return nil, errors.New("failed to list transactions")
+	}
+	defer cursor.Close(ctx)
+
+	var transactions []Transaction
+	if err := cursor.All(ctx, &transactions); err != nil {
+		log.Printf("Error decoding transactions: %v", err)
+		return nil, errors.New("failed to list transactions")
+	}
+	if err := openRecords(ctx, m, "transactions", transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// ListTransactionsForWallet fetches the transfers a wallet address has sent or received, newest first
+func (m *Mongo) ListTransactionsForWallet(ctx context.Context, walletAddress string) ([]Transaction, error) {
+	collection := m.GetTransactionCollection()
+	filter := bson.M{"$or": []bson.M{{"sender_address": walletAddress}, {"recipient_address": walletAddress}}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		log.Printf("Error listing transactions: %v", err)
+		return nil, errors.New("failed to list transactions")
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		log.Printf("Error decoding transactions: %v", err)
+		return nil, errors.New("failed to list transactions")
+	}
+	if err := openRecords(ctx, m, "transactions", transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// EachTransaction calls fn with every transaction, oldest first, stopping at the first error
+func (m *Mongo) EachTransaction(ctx context.Context, fn func(*Transaction) error) error {
+	collection := m.GetTransactionCollection()
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.Printf("Error listing transactions: %v", err)
+		return errors.New("failed to list transactions")
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var transaction Transaction
+		if err := cursor.Decode(&transaction); err != nil {
+			log.Printf("Error decoding transaction: %v", err)
+			return errors.New("failed to list transactions")
+		}
+		if err := m.openRecord(ctx, "transaction", &transaction); err != nil {
+			return err
+		}
+		if err := fn(&transaction); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error listing transactions: %v", err)
+		return errors.New("failed to list transactions")
+	}
+	return nil
+}
+
+// HasTransactionTo reports whether a wallet has ever sent to a recipient address or phone number
+func (m *Mongo) HasTransactionTo(ctx context.Context, senderAddress string, recipientAddress string, recipientPhone string) (bool, error) {
+	collection := m.GetTransactionCollection()
+	recipients := []bson.M{}
+	if recipientAddress != "" {
+		recipients = append(recipients, bson.M{"recipient_address": recipientAddress})
+	}
+	if recipientPhone != "" {
+		recipients = append(recipients, bson.M{"recipient_phone_index": m.cipher.BlindIndex(recipientPhone)})
+	}
+	if len(recipients) == 0 {
+		return false, nil
+	}
+
+	filter := bson.M{"sender_address": senderAddress, "kind": bson.M{"$ne": TransactionReversal}, "$or": recipients}
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("Error counting transactions: %v", err)
+		return false, errors.New("failed to count transactions")
+	}
+	return count > 0, nil
+}
+
+// GetTransactionByID fetches a transaction by its hex ID
+func (m *Mongo) GetTransactionByID(ctx context.Context, id string) (*Transaction, bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	collection := m.GetTransactionCollection()
+	var transaction Transaction
+	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&transaction)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching transaction: %v", err)
+		return nil, false, errors.New("failed to fetch transaction")
+	}
+	if err := m.openRecord(ctx, "transaction", &transaction); err != nil {
+		return nil, false, err
+	}
+	return &transaction, true, nil
+}
+
+// AddRefundedAmount increases a transaction's refunded amount, provided it still equals
+// expected. It reports false when another refund got there first.
+func (m *Mongo) AddRefundedAmount(ctx context.Context, id primitive.ObjectID, expected float64, amount float64) (bool, error) {
+	collection := m.GetTransactionCollection()
+	filter := bson.M{"_id": id, "refunded_usd": expected}
+	update := bson.M{"$set": bson.M{"refunded_usd": expected + amount}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Error updating refunded amount: %v", err)
+		return false, errors.New("failed to update refunded amount")
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Withdrawal statuses
+const (
+	WithdrawalQueued    = "queued"
+	WithdrawalSigned    = "signed"
+	WithdrawalBroadcast = "broadcast"
+	WithdrawalConfirmed = "confirmed"
+	WithdrawalRefunded  = "refunded"
+)
+
+// Withdrawal represents a transfer to an external address being settled on-chain. Amount is
+// the payout in the asset's base units, and RawTx holds the signed transaction so it can be
+// rebroadcast without signing again. Custodian names the custody provider paying the
+// withdrawal out; it is empty for withdrawals signed and broadcast through chain adapters.
+type Withdrawal struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TransactionID primitive.ObjectID `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	SenderAddress string             `bson:"sender_address" json:"sender_address"`
+	SenderPhone   string             `bson:"sender_phone" json:"sender_phone"`
+	Crypto        string             `bson:"crypto" json:"crypto"`
+	Asset         string             `bson:"asset" json:"asset"`
+	Network       string             `bson:"network" json:"network"`
+	ToAddress     string             `bson:"to_address" json:"to_address"`
+	AmountUSD     float64            `bson:"amount_usd" json:"amount_usd"`
+	FeeUSD        float64            `bson:"fee_usd" json:"fee_usd"`
+	Amount        string             `bson:"amount" json:"amount"`
+	Status        string             `bson:"status" json:"status"`
+	TxHash        string             `bson:"tx_hash,omitempty" json:"tx_hash,omitempty"`
+	RawTx         []byte             `bson:"raw_tx,omitempty" json:"-"`
+	Confirmations int64              `bson:"confirmations" json:"confirmations"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	Custodian     string             `bson:"custodian,omitempty" json:"custodian,omitempty"`
+	LastError     string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SealedFields returns the withdrawal's sender phone number, which is stored sealed
+func (w *Withdrawal) SealedFields() []SealedField {
+	return []SealedField{{FieldWithdrawalSenderPhone, &w.SenderPhone}}
+}
+
+// WithdrawalFields are the fields TransitionWithdrawal may set along with the status. Nil
+// fields are left unchanged.
+type WithdrawalFields struct {
+	TxHash        *string
+	RawTx         []byte
+	Confirmations *int64
+	LastError     *string
+}
+
+// set adds the fields that are present to a $set update
+func (fields WithdrawalFields) set(set bson.M) {
+	if fields.TxHash != nil {
+		set["tx_hash"] = *fields.TxHash
+	}
+	if fields.RawTx != nil {
+		set["raw_tx"] = fields.RawTx
+	}
+	if fields.Confirmations != nil {
+		set["confirmations"] = *fields.Confirmations
+	}
+	if fields.LastError != nil {
+		set["last_error"] = *fields.LastError
+	}
+}
+
+// WithdrawalRepository stores withdrawals to external addresses
+type WithdrawalRepository interface {
+	CreateWithdrawal(ctx context.Context, withdrawal *Withdrawal) error
+	GetWithdrawalByID(ctx context.Context, id string) (*Withdrawal, bool, error)
+	ListWithdrawalsByStatus(ctx context.Context, statuses ...string) ([]Withdrawal, error)
+	ListWithdrawalsForWallet(ctx context.Context, senderAddress string) ([]Withdrawal, error)
+	TransitionWithdrawal(ctx context.Context, id primitive.ObjectID, from string, to string, fields WithdrawalFields) (bool, error)
+	RecordWithdrawalAttempt(ctx context.Context, id primitive.ObjectID, attemptErr string) error
+}
+
+// GetWithdrawalCollection returns a reference to the withdrawal collection
+func (m *Mongo) GetWithdrawalCollection() *mongo.Collection {
+	return m.db.Collection("withdrawal")
+}
+
+// CreateWithdrawal stores a new withdrawal
+func (m *Mongo) CreateWithdrawal(ctx context.Context, withdrawal *Withdrawal) error {
+	collection := m.GetWithdrawalCollection()
+	sealed := *withdrawal
+	if err := m.sealRecord("withdrawal", &sealed); err != nil {
+		return err
+	}
+	result, err := collection.InsertOne(ctx, sealed)
+	if err != nil {
+		log.Printf("Error adding withdrawal: %v", err)
+		return errors.New("failed to add withdrawal")
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		withdrawal.ID = id
+	}
+	return nil
+}
+
+// GetWithdrawalByID fetches a withdrawal by its hex ID
+func (m *Mongo) GetWithdrawalByID(ctx context.Context, id string) (*Withdrawal, bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	collection := m.GetWithdrawalCollection()
+	var withdrawal Withdrawal
+	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&withdrawal)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		log.Printf("Error fetching withdrawal: %v", err)
+		return nil, false, errors.New("failed to fetch withdrawal")
+	}
+	if err := m.openRecord(ctx, "withdrawal", &withdrawal); err != nil {
+		return nil, false, err
+	}
+	return &withdrawal, true, nil
+}
+
+// ListWithdrawalsByStatus fetches withdrawals in any of the given statuses, oldest first
+func (m *Mongo) ListWithdrawalsByStatus(ctx context.Context, statuses ...string) ([]Withdrawal, error) {
+	return m.listWithdrawals(ctx, bson.M{"status": bson.M{"$in": statuses}}, 1)
+}
+
+// ListWithdrawalsForWallet fetches the withdrawals sent from a wallet address, newest first
+func (m *Mongo) ListWithdrawalsForWallet(ctx context.Context, senderAddress string) ([]Withdrawal, error) {
+	return m.listWithdrawals(ctx, bson.M{"sender_address": senderAddress}, -1)
+}
+
+func (m *Mongo) listWithdrawals(ctx context.Context, filter bson.M, order int) ([]Withdrawal, error) {
+	collection := m.GetWithdrawalCollection()
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": order}))
+	if err != nil {
+		log.Printf("Error listing withdrawals: %v", err)
+		return nil, errors.New("failed to list withdrawals")
+	}
+	defer cursor.Close(ctx)
+
+	withdrawals := []Withdrawal{}
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		log.Printf("Error decoding withdrawals: %v", err)
+		return nil, errors.New("failed to list withdrawals")
+	}
+	if err := openRecords(ctx, m, "withdrawals", withdrawals); err != nil {
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+// TransitionWithdrawal moves a withdrawal from one status to another, setting the given
+// fields. It reports false when the withdrawal was no longer in the from status.
+func (m *Mongo) TransitionWithdrawal(ctx context.Context, id primitive.ObjectID, from string, to string, fields WithdrawalFields) (bool, error) {
+	collection := m.GetWithdrawalCollection()
+	set := bson.M{"status": to, "updated_at": time.Now()}
+	fields.set(set)
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": set})
+	if err != nil {
+		log.Printf("Error updating withdrawal: %v", err)
+		return false, errors.New("failed to update withdrawal")
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// RecordWithdrawalAttempt counts a failed settlement step and stores its error
+func (m *Mongo) RecordWithdrawalAttempt(ctx context.Context, id primitive.ObjectID, attemptErr string) error {
+	collection := m.GetWithdrawalCollection()
+	update := bson.M{"$inc": bson.M{"attempts": 1}, "$set": bson.M{"last_error": attemptErr, "updated_at": time.Now()}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		log.Printf("Error recording withdrawal attempt: %v", err)
+		return errors.New("failed to record withdrawal attempt")
+	}
+	return nil
+}
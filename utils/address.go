@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// AddressError describes why an address failed validation
+type AddressError struct {
+	// Position is the 1-based index of the character that looks wrong, or 0 when it can't be told
+	Position int
+	// Checksum is set when the address is well formed but its checksum doesn't match
+	Checksum bool
+	Reason   string
+}
+
+func (e *AddressError) Error() string {
+	if e.Position > 0 {
+		return fmt.Sprintf("%s at character %d", e.Reason, e.Position)
+	}
+	return e.Reason
+}
+
+// ValidateEVMAddress checks a 0x-prefixed hex address, verifying its EIP-55 checksum when it
+// has one. All-lowercase and all-uppercase addresses carry no checksum.
+func ValidateEVMAddress(address string) error {
+	if !strings.HasPrefix(address, "0x") {
+		return &AddressError{Position: 1, Reason: "address must start with 0x"}
+	}
+	digits := address[2:]
+	for i, c := range digits {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return &AddressError{Position: i + 3, Reason: fmt.Sprintf("invalid character %q", c)}
+		}
+	}
+	if len(digits) != 40 {
+		return &AddressError{Reason: fmt.Sprintf("address has %d hex digits, want 40", len(digits))}
+	}
+	if digits == strings.ToLower(digits) || digits == strings.ToUpper(digits) {
+		return nil
+	}
+
+	checksummed := ChecksumEVMAddress(address)
+	for i := 2; i < len(address); i++ {
+		if address[i] != checksummed[i] {
+			return &AddressError{Position: i + 1, Checksum: true, Reason: "EIP-55 checksum mismatch"}
+		}
+	}
+	return nil
+}
+
+// ChecksumEVMAddress returns the EIP-55 form of a valid 0x-prefixed hex address
+func ChecksumEVMAddress(address string) string {
+	lower := strings.ToLower(strings.TrimPrefix(address, "0x"))
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(lower))
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	out := []byte(lower)
+	for i, c := range out {
+		if c >= 'a' && sum[i] >= '8' {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(out)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// DecodeBase58Check decodes a base58check string into its version byte and payload
+func DecodeBase58Check(s string) (byte, []byte, error) {
+	decoded, err := decodeBase58(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(decoded) < 5 {
+		return 0, nil, &AddressError{Reason: "address is too short"}
+	}
+	if !base58ChecksumValid(decoded) {
+		return 0, nil, &AddressError{Position: locateBadCharacter(s, base58Alphabet, func(candidate string) bool {
+			decoded, err := decodeBase58(candidate)
+			return err == nil && len(decoded) >= 5 && base58ChecksumValid(decoded)
+		}), Checksum: true, Reason: "base58check checksum mismatch"}
+	}
+	return decoded[0], decoded[1 : len(decoded)-4], nil
+}
+
+// EncodeBase58Check encodes a version byte and payload as a base58check string
+func EncodeBase58Check(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	data = append(data, second[:4]...)
+
+	value := new(big.Int).SetBytes(data)
+	radix, remainder := big.NewInt(58), new(big.Int)
+	var out []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, radix, remainder)
+		out = append(out, base58Alphabet[remainder.Int64()])
+	}
+	for i := 0; i < len(data) && data[i] == 0; i++ {
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	value := new(big.Int)
+	radix := big.NewInt(58)
+	for i, c := range s {
+		index := strings.IndexRune(base58Alphabet, c)
+		if index < 0 {
+			return nil, &AddressError{Position: i + 1, Reason: fmt.Sprintf("invalid character %q", c)}
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(index)))
+	}
+
+	// Each leading '1' encodes a leading zero byte
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), value.Bytes()...), nil
+}
+
+func base58ChecksumValid(decoded []byte) bool {
+	payload, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return bytes.Equal(second[:4], checksum)
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Checksum constants distinguishing bech32 (BIP-173) from bech32m (BIP-350)
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// DecodeSegwitAddress decodes a bech32 or bech32m segwit address into its human-readable
+// part, witness version and program. Version 0 must use bech32 and later versions bech32m.
+func DecodeSegwitAddress(address string) (string, int, []byte, error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return "", 0, nil, &AddressError{Reason: "address mixes upper and lower case"}
+	}
+	address = strings.ToLower(address)
+	separator := strings.LastIndexByte(address, '1')
+	if separator < 1 || separator+7 > len(address) || len(address) > 90 {
+		return "", 0, nil, &AddressError{Reason: "address is not bech32"}
+	}
+	hrp := address[:separator]
+	data, err := bech32Data(address, separator)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	checksum := bech32Polymod(append(bech32ExpandHRP(hrp), data...))
+	if checksum != bech32Const && checksum != bech32mConst {
+		// Only the data part is searched, the human-readable part is checked by the caller
+		position := locateBadCharacter(address[separator+1:], bech32Charset, func(candidate string) bool {
+			data, err := bech32Data(hrp+"1"+candidate, separator)
+			if err != nil {
+				return false
+			}
+			checksum := bech32Polymod(append(bech32ExpandHRP(hrp), data...))
+			return checksum == bech32Const || checksum == bech32mConst
+		})
+		if position > 0 {
+			position += separator + 1
+		}
+		return "", 0, nil, &AddressError{Position: position, Checksum: true, Reason: "bech32 checksum mismatch"}
+	}
+
+	data = data[:len(data)-6]
+	if len(data) == 0 {
+		return "", 0, nil, &AddressError{Reason: "address has no witness version"}
+	}
+	version := int(data[0])
+	if version > 16 {
+		return "", 0, nil, &AddressError{Position: separator + 2, Reason: "invalid witness version"}
+	}
+	if (version == 0) != (checksum == bech32Const) {
+		return "", 0, nil, &AddressError{Reason: "wrong checksum variant for witness version"}
+	}
+	program, ok := convertBits(data[1:], 5, 8, false)
+	if !ok || len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return "", 0, nil, &AddressError{Reason: "invalid witness program length"}
+	}
+	return hrp, version, program, nil
+}
+
+// EncodeSegwitAddress encodes a witness program as a segwit address, using bech32 for
+// version 0 and bech32m for later versions
+func EncodeSegwitAddress(hrp string, version int, program []byte) string {
+	words, _ := convertBits(program, 8, 5, true)
+	data := append([]byte{byte(version)}, words...)
+	constant := uint32(bech32Const)
+	if version > 0 {
+		constant = bech32mConst
+	}
+	checksum := bech32Polymod(append(append(bech32ExpandHRP(hrp), data...), 0, 0, 0, 0, 0, 0)) ^ constant
+	for i := 0; i < 6; i++ {
+		data = append(data, byte(checksum>>(5*(5-i))&31))
+	}
+
+	out := []byte(hrp + "1")
+	for _, word := range data {
+		out = append(out, bech32Charset[word])
+	}
+	return string(out)
+}
+
+// bech32Data maps the characters after the separator to 5-bit words
+func bech32Data(address string, separator int) ([]byte, error) {
+	data := make([]byte, 0, len(address)-separator-1)
+	for i := separator + 1; i < len(address); i++ {
+		index := strings.IndexByte(bech32Charset, address[i])
+		if index < 0 {
+			return nil, &AddressError{Position: i + 1, Reason: fmt.Sprintf("invalid character %q", address[i])}
+		}
+		data = append(data, byte(index))
+	}
+	return data, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(value)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+func bech32ExpandHRP(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// convertBits regroups words of from bits into words of to bits. With pad, leftover bits are
+// zero-padded into a final word; without it, non-zero padding is rejected.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	var out []byte
+	acc, bits := uint(0), uint(0)
+	maxValue := uint(1)<<to - 1
+	for _, value := range data {
+		acc = acc<<from | uint(value)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxValue))
+		}
+		return out, true
+	}
+	if bits >= from || (acc<<(to-bits))&maxValue != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// locateBadCharacter finds the single character whose substitution makes s valid. It returns
+// the 1-based position, or 0 when no single substitution (or more than one) fixes s.
+func locateBadCharacter(s string, alphabet string, valid func(candidate string) bool) int {
+	position := 0
+	candidate := []byte(s)
+	for i := range candidate {
+		original := candidate[i]
+		for j := 0; j < len(alphabet); j++ {
+			if alphabet[j] == original {
+				continue
+			}
+			candidate[i] = alphabet[j]
+			if valid(string(candidate)) {
+				if position != 0 && position != i+1 {
+					return 0
+				}
+				position = i + 1
+				break
+			}
+		}
+		candidate[i] = original
+	}
+	return position
+}
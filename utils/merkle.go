@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Merkle sum tree node hashes are domain separated so a leaf can't pose as a node
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleSumNode is a node of a Merkle sum tree: a hash committing to its subtree and the
+// sum of the subtree's leaf values
+type MerkleSumNode struct {
+	Hash []byte
+	Sum  uint64
+}
+
+// MerkleProofStep is one sibling on the path from a leaf to the root. Left is set when the
+// sibling sits to the left of the path.
+type MerkleProofStep struct {
+	Hash string `bson:"hash" json:"hash"`
+	Sum  uint64 `bson:"sum" json:"sum"`
+	Left bool   `bson:"left" json:"left"`
+}
+
+// MerkleSumLeaf hashes a leaf: SHA-256(0x00 || uint64be(sum) || data)
+func MerkleSumLeaf(data []byte, sum uint64) MerkleSumNode {
+	buf := make([]byte, 0, 9+len(data))
+	buf = append(buf, merkleLeafPrefix)
+	buf = binary.BigEndian.AppendUint64(buf, sum)
+	buf = append(buf, data...)
+	hash := sha256.Sum256(buf)
+	return MerkleSumNode{Hash: hash[:], Sum: sum}
+}
+
+// merkleSumParent hashes two children:
+// SHA-256(0x01 || left hash || uint64be(left sum) || right hash || uint64be(right sum))
+func merkleSumParent(left MerkleSumNode, right MerkleSumNode) (MerkleSumNode, error) {
+	sum := left.Sum + right.Sum
+	if sum < left.Sum {
+		return MerkleSumNode{}, fmt.Errorf("merkle sum overflows")
+	}
+	buf := make([]byte, 0, 81)
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left.Hash...)
+	buf = binary.BigEndian.AppendUint64(buf, left.Sum)
+	buf = append(buf, right.Hash...)
+	buf = binary.BigEndian.AppendUint64(buf, right.Sum)
+	hash := sha256.Sum256(buf)
+	return MerkleSumNode{Hash: hash[:], Sum: sum}, nil
+}
+
+// BuildMerkleSumTree returns the root over leaves and the proof of every leaf, in order. A
+// node left without a sibling moves up a level unchanged, so no leaf is counted twice.
+func BuildMerkleSumTree(leaves []MerkleSumNode) (MerkleSumNode, [][]MerkleProofStep, error) {
+	if len(leaves) == 0 {
+		return MerkleSumNode{}, nil, fmt.Errorf("no leaves")
+	}
+	proofs := make([][]MerkleProofStep, len(leaves))
+	// members[i] lists the leaves under the i-th node of the current level
+	members := make([][]int, len(leaves))
+	for i := range leaves {
+		members[i] = []int{i}
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		var next []MerkleSumNode
+		var nextMembers [][]int
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				nextMembers = append(nextMembers, members[i])
+				continue
+			}
+			left, right := level[i], level[i+1]
+			parent, err := merkleSumParent(left, right)
+			if err != nil {
+				return MerkleSumNode{}, nil, err
+			}
+			for _, leaf := range members[i] {
+				proofs[leaf] = append(proofs[leaf], MerkleProofStep{Hash: hex.EncodeToString(right.Hash), Sum: right.Sum})
+			}
+			for _, leaf := range members[i+1] {
+				proofs[leaf] = append(proofs[leaf], MerkleProofStep{Hash: hex.EncodeToString(left.Hash), Sum: left.Sum, Left: true})
+			}
+			next = append(next, parent)
+			nextMembers = append(nextMembers, append(members[i], members[i+1]...))
+		}
+		level, members = next, nextMembers
+	}
+	return level[0], proofs, nil
+}
+
+// VerifyMerkleSumProof reports whether leaf and its proof lead to the root hash and sum
+func VerifyMerkleSumProof(leaf MerkleSumNode, proof []MerkleProofStep, rootHash []byte, rootSum uint64) bool {
+	node := leaf
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		siblingNode := MerkleSumNode{Hash: sibling, Sum: step.Sum}
+		if step.Left {
+			node, err = merkleSumParent(siblingNode, node)
+		} else {
+			node, err = merkleSumParent(node, siblingNode)
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return bytes.Equal(node.Hash, rootHash) && node.Sum == rootSum
+}
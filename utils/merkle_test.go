@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func testLeaves(count int) []MerkleSumNode {
+	leaves := make([]MerkleSumNode, count)
+	for i := range leaves {
+		leaves[i] = MerkleSumLeaf([]byte(fmt.Sprintf("account-%d", i)), uint64(100*(i+1)))
+	}
+	return leaves
+}
+
+func TestMerkleSumTreeProofs(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 4, 5, 7, 8, 9, 16, 33} {
+		leaves := testLeaves(count)
+		root, proofs, err := BuildMerkleSumTree(leaves)
+		if err != nil {
+			t.Fatalf("%d leaves: building: %v", count, err)
+		}
+		if want := uint64(100 * count * (count + 1) / 2); root.Sum != want {
+			t.Errorf("%d leaves: root sum %d, want %d", count, root.Sum, want)
+		}
+		if len(proofs) != count {
+			t.Fatalf("%d leaves: %d proofs", count, len(proofs))
+		}
+		// A tree of n leaves is ceil(log2 n) levels deep
+		depth := int(math.Ceil(math.Log2(float64(count))))
+		for i, proof := range proofs {
+			if len(proof) > depth {
+				t.Errorf("%d leaves: proof %d has %d steps, want at most %d", count, i, len(proof), depth)
+			}
+			if !VerifyMerkleSumProof(leaves[i], proof, root.Hash, root.Sum) {
+				t.Errorf("%d leaves: proof %d doesn't verify", count, i)
+			}
+		}
+	}
+}
+
+func TestMerkleSumTreeHashes(t *testing.T) {
+	leaf := func(data string, sum uint64) []byte {
+		buf := append([]byte{0x00}, binary.BigEndian.AppendUint64(nil, sum)...)
+		hash := sha256.Sum256(append(buf, data...))
+		return hash[:]
+	}
+	parent := func(left []byte, leftSum uint64, right []byte, rightSum uint64) []byte {
+		buf := append([]byte{0x01}, left...)
+		buf = binary.BigEndian.AppendUint64(buf, leftSum)
+		buf = append(buf, right...)
+		buf = binary.BigEndian.AppendUint64(buf, rightSum)
+		hash := sha256.Sum256(buf)
+		return hash[:]
+	}
+	a, b, c := leaf("a", 1), leaf("b", 2), leaf("c", 3)
+	ab := parent(a, 1, b, 2)
+
+	tests := []struct {
+		name   string
+		leaves []MerkleSumNode
+		want   []byte
+		sum    uint64
+	}{
+		{"single leaf is the root", []MerkleSumNode{MerkleSumLeaf([]byte("a"), 1)}, a, 1},
+		{"pair", []MerkleSumNode{MerkleSumLeaf([]byte("a"), 1), MerkleSumLeaf([]byte("b"), 2)}, ab, 3},
+		{"odd leaf moves up unchanged", []MerkleSumNode{MerkleSumLeaf([]byte("a"), 1), MerkleSumLeaf([]byte("b"), 2), MerkleSumLeaf([]byte("c"), 3)},
+			parent(ab, 3, c, 3), 6},
+	}
+	for _, test := range tests {
+		root, _, err := BuildMerkleSumTree(test.leaves)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(root.Hash, test.want) || root.Sum != test.sum {
+			t.Errorf("%s: root %x with sum %d, want %x with sum %d", test.name, root.Hash, root.Sum, test.want, test.sum)
+		}
+	}
+}
+
+func TestMerkleSumTreeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		leaves []MerkleSumNode
+	}{
+		{"no leaves", nil},
+		{"sum overflows", []MerkleSumNode{MerkleSumLeaf([]byte("a"), math.MaxUint64), MerkleSumLeaf([]byte("b"), 1)}},
+	}
+	for _, test := range tests {
+		if _, _, err := BuildMerkleSumTree(test.leaves); err == nil {
+			t.Errorf("%s: built a tree, want an error", test.name)
+		}
+	}
+}
+
+func TestVerifyMerkleSumProofRejectsTampering(t *testing.T) {
+	leaves := testLeaves(5)
+	root, proofs, err := BuildMerkleSumTree(leaves)
+	if err != nil {
+		t.Fatalf("building: %v", err)
+	}
+	leaf, proof := leaves[2], proofs[2]
+	tampered := func(change func([]MerkleProofStep)) []MerkleProofStep {
+		steps := append([]MerkleProofStep(nil), proof...)
+		change(steps)
+		return steps
+	}
+
+	tests := []struct {
+		name     string
+		leaf     MerkleSumNode
+		proof    []MerkleProofStep
+		rootHash []byte
+		rootSum  uint64
+	}{
+		{"other leaf data", MerkleSumLeaf([]byte("account-9"), leaf.Sum), proof, root.Hash, root.Sum},
+		{"understated balance", MerkleSumLeaf([]byte("account-2"), leaf.Sum-1), proof, root.Hash, root.Sum},
+		{"another leaf's proof", leaf, proofs[3], root.Hash, root.Sum},
+		{"sibling sum lowered", leaf, tampered(func(steps []MerkleProofStep) { steps[0].Sum-- }), root.Hash, root.Sum},
+		{"sibling hash changed", leaf, tampered(func(steps []MerkleProofStep) { steps[0].Hash = hex.EncodeToString(make([]byte, sha256.Size)) }), root.Hash, root.Sum},
+		{"sibling side flipped", leaf, tampered(func(steps []MerkleProofStep) { steps[0].Left = !steps[0].Left }), root.Hash, root.Sum},
+		{"sibling hash not hex", leaf, tampered(func(steps []MerkleProofStep) { steps[0].Hash = "zz" }), root.Hash, root.Sum},
+		{"sibling hash truncated", leaf, tampered(func(steps []MerkleProofStep) { steps[0].Hash = steps[0].Hash[:32] }), root.Hash, root.Sum},
+		{"step dropped", leaf, proof[1:], root.Hash, root.Sum},
+		{"no steps", leaf, nil, root.Hash, root.Sum},
+		{"other root hash", leaf, proof, leaves[0].Hash, root.Sum},
+		{"other root sum", leaf, proof, root.Hash, root.Sum + 1},
+	}
+	for _, test := range tests {
+		if VerifyMerkleSumProof(test.leaf, test.proof, test.rootHash, test.rootSum) {
+			t.Errorf("%s: proof verified", test.name)
+		}
+	}
+}
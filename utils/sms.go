@@ -0,0 +1,48 @@
+package utils
+
+import "fmt"
+
+// SMSMaxLength is the number of characters that fit in a single GSM-7 SMS segment
+const SMSMaxLength = 160
+
+// SplitSMS packs lines into as few messages as possible, each within SMSMaxLength.
+// When more than one message is needed each is prefixed with a "(n/m) " page marker.
+// Lines longer than a whole message are truncated.
+func SplitSMS(lines []string) []string {
+	// Reserve room for the page marker, assuming fewer than 10 pages
+	const markerLength = len("(1/9) ")
+
+	pack := func(limit int) []string {
+		var messages []string
+		current := ""
+		for _, line := range lines {
+			if len(line) > limit {
+				line = line[:limit]
+			}
+			switch {
+			case current == "":
+				current = line
+			case len(current)+1+len(line) <= limit:
+				current += "\n" + line
+			default:
+				messages = append(messages, current)
+				current = line
+			}
+		}
+		if current != "" {
+			messages = append(messages, current)
+		}
+		return messages
+	}
+
+	messages := pack(SMSMaxLength)
+	if len(messages) <= 1 {
+		return messages
+	}
+
+	messages = pack(SMSMaxLength - markerLength)
+	for i, message := range messages {
+		messages[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(messages), message)
+	}
+	return messages
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/pem"
 	
 	"fmt"
+	"math/big"
 	"os"
 	
 
@@ -32,11 +33,22 @@ func SendSMS(to, from, body string) error {
 	}
 	return nil
 }
-// Generate2FACode generates a 5-digit 2FA code
-func Generate2FACode() string {
-	// rand.Seed(time.Now().UnixNano())
-	// code := fmt.Sprintf("%05d", rand.Intn(100000))
-	return ""
+// Generate2FACode generates a random 6-digit 2FA code
+func Generate2FACode() (string, error) {
+	return GenerateNumericCode(6)
+}
+
+// GenerateNumericCode generates a random code of the given number of digits
+func GenerateNumericCode(digits int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
 }
 
 // GenerateKeyPair generates a real RSA key pair